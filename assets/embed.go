@@ -1,6 +1,92 @@
 package assets
 
-import "embed"
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
 
 //go:embed static/*
 var FS embed.FS
+
+// A hash of all embedded assets. Changes whenever a template, stylesheet or
+// script does, so it can be used to invalidate anything rendered with them.
+var Hash = sync.OnceValue(func() string {
+	h := sha256.New()
+	err := fs.WalkDir(FS, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := FS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write(b)
+		h.Write([]byte{0})
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+})
+
+// Assets are served under names with a hash of their content in them, so
+// that they can be cached forever: when an asset changes, so does its name.
+type fingerprints struct {
+	names map[string]string // name : fingerprinted name
+	files map[string]string // fingerprinted name : name
+}
+
+var loadFingerprints = sync.OnceValue(func() fingerprints {
+	f := fingerprints{names: map[string]string{}, files: map[string]string{}}
+	err := fs.WalkDir(FS, "static", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := FS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		name = strings.TrimPrefix(name, "static/")
+		sum := sha256.Sum256(b)
+		ext := path.Ext(name)
+		fingerprinted := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:8]) + ext
+		f.names[name] = fingerprinted
+		f.files[fingerprinted] = name
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return f
+})
+
+// The fingerprinted name of the asset `name`, relative to static/: e.g.
+// "css/layout.0c4f5e2b9a1d7f36.css" for "css/layout.css". Panics if there's
+// no such asset, as that's a bug in whoever asks for it.
+func Fingerprint(name string) string {
+	fingerprinted, ok := loadFingerprints().names[name]
+	if !ok {
+		panic("no such asset: " + name)
+	}
+	return fingerprinted
+}
+
+// The content of the asset with the fingerprinted name `fingerprinted`, as
+// returned by Fingerprint. Reports false if there's no such asset, including
+// for names that aren't fingerprinted, or not with the current content.
+func ReadFingerprinted(fingerprinted string) ([]byte, bool) {
+	name, ok := loadFingerprints().files[fingerprinted]
+	if !ok {
+		return nil, false
+	}
+	b, err := FS.ReadFile("static/" + name)
+	return b, err == nil
+}
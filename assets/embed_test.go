@@ -0,0 +1,57 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	err := fs.WalkDir(FS, "static", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := FS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		name = strings.TrimPrefix(name, "static/")
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:8])
+
+		fingerprinted := Fingerprint(name)
+		if Fingerprint(name) != fingerprinted {
+			t.Errorf("%s: got %s, then %s", name, fingerprinted, Fingerprint(name))
+		}
+		base, ext, _ := strings.Cut(name, ".")
+		if want := base + "." + hash + "." + ext; fingerprinted != want {
+			t.Errorf("%s: got %s, want %s", name, fingerprinted, want)
+		}
+		if b, ok := ReadFingerprinted(fingerprinted); !ok || !bytes.Equal(b, content) {
+			t.Errorf("%s: can't read it back as %s", name, fingerprinted)
+		}
+
+		stale := strings.Replace(fingerprinted, hash, hex.EncodeToString(make([]byte, 8)), 1)
+		for _, wrong := range []string{name, stale, base + ".nothex." + ext, fingerprinted + ".map"} {
+			if _, ok := ReadFingerprinted(wrong); ok {
+				t.Errorf("%s: read it as %s", name, wrong)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFingerprintMissing(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for an asset that doesn't exist")
+		}
+	}()
+	Fingerprint("css/missing.css")
+}
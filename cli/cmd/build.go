@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/site"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	buildCmd.Flags().StringP("output", "o", "public", "Output directory")
+	buildCmd.Flags().IntP("jobs", "j", 0, "Render this many pages in parallel (default: one per CPU)")
+	buildCmd.Flags().Bool("strict", false, "Exit with an error if any note contains a broken link")
+	buildCmd.Flags().String("base-url", "", "URL the site will be hosted at; enables feeds and the sitemap")
+	buildCmd.Flags().Bool("drafts", false, "Include drafts and notes scheduled to be published later")
+	buildCmd.Flags().Bool("force", false, "Rebuild every page, even if it hasn't changed since the last build")
+	rootCmd.AddCommand(buildCmd)
+}
+
+var buildCmd = &cobra.Command{
+	Use:   "build [vault]",
+	Short: "Render a vault to a static website",
+	Long: `Render every note in the given vault (default: the current directory) to a static website.
+
+Every note gets its own page with a clean URL (dir/note.md ends up at dir/note/index.html), and
+so does every folder and tag. Wikilinks and links to other notes become relative links, and every
+attachment a note links to is copied along. Links are relative, so the output can be hosted anywhere.
+
+Builds are incremental: only pages whose note, or the notes linking to or from it, changed since
+the last build are rendered again, and pages of deleted notes are removed.
+
+With --base-url, the build also writes Atom and RSS feeds of recently created or updated notes
+(feed.atom and feed.rss, and tags/<tag>/feed.atom etc. per tag) and a sitemap.xml. Dates come
+from the "date" and "updated" keys in a note's front matter, and otherwise from git history or
+the file's modification time.
+
+Notes with "draft: true" in their front matter, or a "publish" date in the future, are left out
+until they're published, unless --drafts is given. Links to them are rendered as plain text.
+
+So are notes with "visibility: private" in their front matter, or in a .mdbuddy file in their
+folder or a parent folder. Notes with "visibility: unlisted" get a page, but aren't listed in
+folders, tags, backlinks, feeds or the sitemap.`,
+	Example: `  mdbuddy build
+  mdbuddy build ~/notes -o /var/www/notes
+  mdbuddy build ~/notes --strict
+  mdbuddy build ~/notes --base-url https://example.com/notes`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runBuild,
+}
+
+func runBuild(cmd *cobra.Command, args []string) error {
+	outDir, _ := cmd.Flags().GetString("output")
+	jobs, _ := cmd.Flags().GetInt("jobs")
+	strict, _ := cmd.Flags().GetBool("strict")
+	force, _ := cmd.Flags().GetBool("force")
+	baseURL, _ := cmd.Flags().GetString("base-url")
+	drafts, _ := cmd.Flags().GetBool("drafts")
+
+	v, err := vault.Open(vaultArg(args))
+	if err != nil {
+		return err
+	}
+
+	result, err := site.Build(v, outDir, site.BuildOptions{Jobs: jobs, Force: force, BaseURL: baseURL, Drafts: drafts})
+	if err != nil {
+		return fmt.Errorf("failed to build site: %w", err)
+	}
+
+	for _, broken := range result.BrokenLinks {
+		fmt.Fprintln(os.Stderr, broken)
+	}
+	fmt.Printf("✅ Rendered %d pages and copied %d attachments → %s", result.Pages, result.Attachments, outDir)
+	if result.Unchanged > 0 || result.Removed > 0 {
+		fmt.Printf(" (%d unchanged, %d removed)", result.Unchanged, result.Removed)
+	}
+	fmt.Println()
+
+	if strict && len(result.BrokenLinks) > 0 {
+		return fmt.Errorf("found %d broken links", len(result.BrokenLinks))
+	}
+	return nil
+}
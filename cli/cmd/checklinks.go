@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/linkcheck"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	checkLinksCmd.Flags().Bool("external", false, "Also request every http(s) link to check that it works")
+	checkLinksCmd.Flags().Int("concurrency", 8, "Check at most this many external links at once")
+	checkLinksCmd.Flags().Duration("host-interval", time.Second, "Wait this long between requests to the same host")
+	checkLinksCmd.Flags().Duration("timeout", 10*time.Second, "Give up on an external link after this long")
+	checkLinksCmd.Flags().String("cache", linkcheck.DefaultCacheFile(), "Remember working external links in this file; empty to not remember them")
+	checkLinksCmd.Flags().Duration("cache-ttl", 24*time.Hour, "Don't check external links again that worked this recently")
+	checkLinksCmd.Flags().Bool("json", false, "Print the broken links as JSON")
+	rootCmd.AddCommand(checkLinksCmd)
+}
+
+var checkLinksCmd = &cobra.Command{
+	Use:   "check-links [vault]",
+	Short: "Find broken links in a vault",
+	Long: `Check every link in the notes of the given vault (default: the current directory), and print
+the broken ones with their position. Exits with an error if there are any.
+
+Checked are wikilinks, relative markdown links and images, and their #fragments: links to notes
+or attachments that don't exist are broken, and so are fragments that don't match a heading of
+the note they point to, by its text ([[note#My Heading]]) or its ID ([text](note.md#my-heading)).
+
+With --external, http and https links are checked too, by requesting them. To go easy on the
+servers, every URL is requested once, requests to the same host are spaced out by
+--host-interval, and working URLs are remembered for --cache-ttl.`,
+	Example: `  mdbuddy check-links
+  mdbuddy check-links ~/notes --external
+  mdbuddy check-links ~/notes --external --cache "" --json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runCheckLinks,
+}
+
+func runCheckLinks(cmd *cobra.Command, args []string) error {
+	external, _ := cmd.Flags().GetBool("external")
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	v, err := vault.Open(vaultArg(args))
+	if err != nil {
+		return err
+	}
+	broken := linkcheck.Check(v)
+
+	if external {
+		checker := &linkcheck.ExternalChecker{}
+		checker.Concurrency, _ = cmd.Flags().GetInt("concurrency")
+		checker.HostInterval, _ = cmd.Flags().GetDuration("host-interval")
+		checker.CacheFile, _ = cmd.Flags().GetString("cache")
+		checker.CacheTTL, _ = cmd.Flags().GetDuration("cache-ttl")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		checker.Client = &http.Client{Timeout: timeout}
+
+		// Ctrl-C reports what's been found so far
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+		externalBroken, err := checker.Check(ctx, v.Notes())
+		broken = append(broken, externalBroken...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Stopped checking external links: %v\n", err)
+		}
+	}
+	slices.SortStableFunc(broken, func(a, b linkcheck.Broken) int {
+		return cmp.Or(strings.Compare(a.Note, b.Note), cmp.Compare(a.Line, b.Line), cmp.Compare(a.Col, b.Col))
+	})
+
+	if asJSON {
+		if broken == nil {
+			broken = []linkcheck.Broken{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(broken); err != nil {
+			return err
+		}
+	} else {
+		for _, b := range broken {
+			fmt.Println(b)
+		}
+	}
+
+	if len(broken) > 0 {
+		cmd.SilenceUsage = true // The broken links are the output, not a usage mistake
+		return fmt.Errorf("found %d broken links", len(broken))
+	}
+	if !asJSON {
+		fmt.Fprintln(os.Stderr, "✅ No broken links")
+	}
+	return nil
+}
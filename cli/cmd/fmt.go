@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/flonle/mdbuddy/internal/atomicfile"
+	"github.com/flonle/mdbuddy/renderer/format"
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	fmtCmd.Flags().Bool("check", false, "Don't write anything; list the files that aren't formatted, and fail if there are any")
+	rootCmd.AddCommand(fmtCmd)
+}
+
+var fmtCmd = &cobra.Command{
+	Use:   "fmt [path...]",
+	Short: "Format markdown notes",
+	Long: `Format the given notes, and the notes in the given folders (default: the current directory),
+in place. With "-" as the path, format stdin to stdout instead, for editors to format on save.
+
+Formatted notes use '-' for bullet lists and '.' after numbers, '*' for emphasis, and ATX
+headings without closing #'s; their tables are aligned, their YAML front matter starts with the
+keys MDBuddy reads (title, aliases, tags, dates, publish, draft, visibility), followed by the
+others in alphabetical order, and they end in a single newline. Everything else stays as written.
+
+Formatting never changes how a note renders: the formatted note is rendered and compared to the
+original, and what would render differently is left alone.
+
+With --check, nothing is written: the files that aren't formatted are listed, and the command
+fails if there are any.`,
+	Example: `  mdbuddy fmt
+  mdbuddy fmt ~/notes --check
+  mdbuddy fmt - < note.md`,
+	RunE: runFmt,
+}
+
+func runFmt(cmd *cobra.Command, args []string) error {
+	check, _ := cmd.Flags().GetBool("check")
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	unformatted := 0
+	var failed []error // One file failing doesn't stop the others
+	for _, arg := range args {
+		if arg == "-" {
+			source, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			formatted, err := format.Source(source)
+			if err != nil {
+				return fmt.Errorf("<stdin>: %w", err)
+			}
+			if check {
+				if !bytes.Equal(formatted, source) {
+					fmt.Println("<stdin>")
+					unformatted++
+				}
+			} else if _, err := os.Stdout.Write(formatted); err != nil {
+				return err
+			}
+			continue
+		}
+
+		files, err := markdownFiles(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = append(failed, err)
+			continue
+		}
+		for _, file := range files {
+			changed, err := formatFile(file, !check)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed = append(failed, err)
+				continue
+			}
+			if changed {
+				unformatted++
+				if check {
+					fmt.Println(file)
+				} else {
+					fmt.Fprintf(os.Stderr, "Formatted %s\n", file)
+				}
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		cmd.SilenceUsage = true // The errors are printed above, and aren't usage mistakes
+		return fmt.Errorf("failed to format %d files", len(failed))
+	}
+	if check && unformatted > 0 {
+		cmd.SilenceUsage = true // The files are the output, not a usage mistake
+		return fmt.Errorf("%d files aren't formatted; run mdbuddy fmt", unformatted)
+	}
+	return nil
+}
+
+// The markdown files at `p`: `p` itself if it's a file, or the notes of the
+// vault it is if it's a folder.
+func markdownFiles(p string) ([]string, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{p}, nil
+	}
+	v, err := vault.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, note := range v.Notes() {
+		files = append(files, filepath.Join(p, filepath.FromSlash(note.Path)))
+	}
+	return files, nil
+}
+
+// Format the markdown file at `p`, writing the result back if `write`, and
+// report whether formatting changed it.
+func formatFile(p string, write bool) (bool, error) {
+	source, err := os.ReadFile(p)
+	if err != nil {
+		return false, err
+	}
+	formatted, err := format.Source(source)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", p, err)
+	}
+	if bytes.Equal(formatted, source) {
+		return false, nil
+	}
+	if write {
+		if err := replaceFile(p, formatted); err != nil {
+			return false, fmt.Errorf("failed to write %s: %w", p, err)
+		}
+	}
+	return true, nil
+}
+
+// Replace the content of the file at `p` with `content`, keeping its
+// permissions, so that it's never half-written. Symlinks are followed, so
+// they stay symlinks.
+func replaceFile(p string, content []byte) error {
+	p, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(p, content, info.Mode().Perm())
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/grep"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	grepCmd.Flags().String("vault", ".", "The vault to search")
+	grepCmd.Flags().StringSliceP("select", "s", nil, "The nodes to look in, like heading:2 or task:unchecked; repeatable, or comma-separated")
+	grepCmd.Flags().String("under", "", "Only look in the sections of headings that match this regular expression")
+	grepCmd.Flags().BoolP("ignore-case", "i", false, "Match letters regardless of case")
+	grepCmd.Flags().Bool("json", false, "Print matches as JSON")
+	rootCmd.AddCommand(grepCmd)
+}
+
+var grepCmd = &cobra.Command{
+	Use:   "grep [pattern]",
+	Short: "Find nodes of notes by kind, and matches of a pattern in them",
+	Long: `Find the nodes of the notes of a vault that --select selects, or the matches of a regular
+expression in their source, and print where they are as path:line:col, followed by the line.
+
+Selectors are a kind of node, optionally narrowed down after a colon:
+  heading       Headings; heading:2 for those of level 2, heading:1-3 for levels 1 to 3
+  code          Code blocks; code:go for those in Go
+  task          Task list items; task:checked or task:unchecked
+  callout       Callouts; callout:warning for those of type warning
+  link          Links, images and wikilinks; the pattern is matched against their target
+  hashtag       Hashtags; hashtag:idea for #idea and the tags nested in it, like #idea/app
+  text          Paragraphs, the text of list items other than tasks, and table cells
+
+Nodes of any of the selectors are searched; without one, headings, code, tasks and text. The
+pattern is matched against the source of each line of a node on its own, markup included.
+Without a pattern, every node is a match.
+
+With --under, only nodes in the sections of matching headings are searched, however deep.
+
+Like grep, the command fails if nothing matches, so that scripts and hooks can tell.`,
+	Example: `  mdbuddy grep -s task:unchecked --under Ideas
+  mdbuddy grep 'http\.Client' -s code:go
+  mdbuddy grep -s heading:1-2 --json
+  mdbuddy grep -i deadline -s callout:warning,text --vault ~/notes`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runGrep,
+}
+
+func runGrep(cmd *cobra.Command, args []string) error {
+	vaultDir, _ := cmd.Flags().GetString("vault")
+	selectors, _ := cmd.Flags().GetStringSlice("select")
+	under, _ := cmd.Flags().GetString("under")
+	ignoreCase, _ := cmd.Flags().GetBool("ignore-case")
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	compile := func(what, pattern string) (*regexp.Regexp, error) {
+		if ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", what, err)
+		}
+		return re, nil
+	}
+
+	var q grep.Query
+	for _, s := range selectors {
+		sel, err := grep.ParseSelector(s)
+		if err != nil {
+			return err
+		}
+		q.Select = append(q.Select, sel)
+	}
+	if len(args) > 0 {
+		if args[0] == "" {
+			return fmt.Errorf("empty pattern")
+		}
+		var err error
+		if q.Pattern, err = compile("pattern", args[0]); err != nil {
+			return err
+		}
+	} else if len(q.Select) == 0 {
+		return fmt.Errorf("nothing to search for: give a pattern, or select nodes with --select")
+	}
+	if strings.TrimSpace(under) != "" {
+		var err error
+		if q.Under, err = compile("--under", under); err != nil {
+			return err
+		}
+	}
+
+	v, err := vault.Open(vaultDir)
+	if err != nil {
+		return err
+	}
+	matches := grep.Search(v, q)
+
+	if asJSON {
+		if matches == nil {
+			matches = []grep.Match{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(matches); err != nil {
+			return err
+		}
+	} else {
+		for _, m := range matches {
+			fmt.Println(m)
+		}
+	}
+
+	if len(matches) == 0 {
+		cmd.SilenceUsage = true // Not a usage mistake
+		return fmt.Errorf("no matches")
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Marks hooks written by `mdbuddy hooks install`, so that other hooks are
+// never overwritten or removed.
+const hookMarker = "# Installed by `mdbuddy hooks install`"
+
+func init() {
+	hooksInstallCmd.Flags().Bool("force", false, "Replace a pre-commit hook that wasn't installed by MDBuddy")
+	hooksCmd.AddCommand(hooksInstallCmd)
+	hooksCmd.AddCommand(hooksUninstallCmd)
+	rootCmd.AddCommand(hooksCmd)
+}
+
+var hooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "Manage the git hooks that check a vault",
+	Long: `Manage the git pre-commit hook that checks a vault before every commit.
+
+The hook runs ` + "`mdbuddy lint --staged`" + ` on the vault, so commits that break its rules (see
+` + "`mdbuddy lint --help`" + `) are refused. It checks what's being committed, not what's in the working
+tree. Skip it for a single commit with ` + "`git commit --no-verify`" + `.`,
+}
+
+var hooksInstallCmd = &cobra.Command{
+	Use:   "install [vault]",
+	Short: "Install the pre-commit hook into the vault's git repository",
+	Long: `Install a pre-commit hook into the git repository of the given vault (default: the current
+directory). The vault may be a folder within the repository.
+
+The hook runs the mdbuddy binary that installed it, so reinstall it after moving that.`,
+	Example: `  mdbuddy hooks install
+  mdbuddy hooks install ~/notes`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runHooksInstall,
+}
+
+var hooksUninstallCmd = &cobra.Command{
+	Use:   "uninstall [vault]",
+	Short: "Remove the pre-commit hook from the vault's git repository",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runHooksUninstall,
+}
+
+func runHooksInstall(cmd *cobra.Command, args []string) error {
+	force, _ := cmd.Flags().GetBool("force")
+	dir := vaultArg(args)
+	hook, err := preCommitHook(dir)
+	if err != nil {
+		return err
+	}
+	if existing, err := os.ReadFile(hook); err == nil && !bytes.Contains(existing, []byte(hookMarker)) && !force {
+		return fmt.Errorf("%s already exists; use --force to replace it", hook)
+	}
+
+	// The hook runs in the top level of the working tree
+	prefix, err := git(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return err
+	}
+	vaultDir := strings.TrimSuffix(prefix, "/")
+	if vaultDir == "" {
+		vaultDir = "."
+	}
+	mdbuddy, err := os.Executable()
+	if err != nil {
+		mdbuddy = "mdbuddy"
+	}
+
+	script := fmt.Sprintf("#!/bin/sh\n%s; remove with `mdbuddy hooks uninstall`.\nexec %s lint --staged %s\n",
+		hookMarker, shellQuote(mdbuddy), shellQuote(vaultDir))
+	if err := os.MkdirAll(filepath.Dir(hook), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(hook, []byte(script), 0o755); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Installed %s\n", hook)
+	return nil
+}
+
+func runHooksUninstall(cmd *cobra.Command, args []string) error {
+	hook, err := preCommitHook(vaultArg(args))
+	if err != nil {
+		return err
+	}
+	existing, err := os.ReadFile(hook)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("No pre-commit hook installed")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(existing, []byte(hookMarker)) {
+		return fmt.Errorf("%s wasn't installed by MDBuddy; leaving it alone", hook)
+	}
+	if err := os.Remove(hook); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Removed %s\n", hook)
+	return nil
+}
+
+// The path of the pre-commit hook of the git repository `dir` is in,
+// respecting core.hooksPath.
+func preCommitHook(dir string) (string, error) {
+	hooks, err := git(dir, "rev-parse", "--path-format=absolute", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	if hooksPath, err := git(dir, "config", "core.hooksPath"); err == nil && hooksPath != "" {
+		top, err := git(dir, "rev-parse", "--show-toplevel")
+		if err != nil {
+			return "", err
+		}
+		hooks = hooksPath
+		if !filepath.IsAbs(hooks) {
+			hooks = filepath.Join(top, hooks)
+		}
+	}
+	return filepath.Join(hooks, "pre-commit"), nil
+}
+
+// Run git in `dir` and return its trimmed output.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Quote `s` for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/lint"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	lintCmd.Flags().StringP("format", "f", "text", "Output format: text, json or sarif")
+	lintCmd.Flags().StringArray("severity", nil, "Set the severity of a rule for every file, as rule=error|warning|off; repeatable")
+	lintCmd.Flags().Bool("fix", false, "Rename files whose names can safely be fixed")
+	lintCmd.Flags().Bool("staged", false, "Check what's staged in git instead of the working tree, as for a commit")
+	rootCmd.AddCommand(lintCmd)
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [vault]",
+	Short: "Check a vault's filenames and wikilinks",
+	Long: `Check the given vault (default: the current directory) against the rules every vault follows,
+and print every problem with its position. Exits with an error if any problem is an error.
+
+Rules:
+  unique-name         ` + lint.UniqueName.Description() + `
+                      (ignoring case, and .md for notes)
+  filename            ` + lint.Filename.Description() + `
+  markdown-extension  ` + lint.MarkdownExtension.Description() + `
+  wikilink            ` + lint.Wikilink.Description() + `
+
+Every rule is an error by default. A .mdbuddy file can change that for its folder and the
+folders below it, e.g.
+
+  lint:
+    filename: warning
+    wikilink: off
+
+and --severity overrides both for the whole vault.
+
+With --fix, files whose names have whitespace (which becomes '-') or a markdown extension
+other than .md are renamed, but only if nothing links to them, and the new name isn't taken.
+Everything else has to be fixed by hand.
+
+--format sarif writes SARIF 2.1.0, for code scanning tools.
+
+With --staged, the vault is checked as it's staged in its git repository's index, i.e. as it
+would be committed: unstaged changes and untracked files are left out. That's what the
+pre-commit hook of ` + "`mdbuddy hooks install`" + ` runs.`,
+	Example: `  mdbuddy lint
+  mdbuddy lint ~/notes --fix
+  mdbuddy lint ~/notes --severity filename=warning
+  mdbuddy lint ~/notes --format sarif > lint.sarif
+  mdbuddy lint --staged`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runLint,
+}
+
+func runLint(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	fix, _ := cmd.Flags().GetBool("fix")
+	staged, _ := cmd.Flags().GetBool("staged")
+	if fix && staged {
+		return fmt.Errorf("--fix can't fix what's staged; fix the working tree and stage that instead")
+	}
+	if format != "text" && format != "json" && format != "sarif" {
+		return fmt.Errorf("invalid format %q; expected text, json or sarif", format)
+	}
+
+	var opts lint.Options
+	severities, _ := cmd.Flags().GetStringArray("severity")
+	for _, s := range severities {
+		rule, level, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("invalid --severity %q, expected rule=severity", s)
+		}
+		if !slices.Contains(lint.Rules, lint.Rule(rule)) {
+			return fmt.Errorf("unknown rule %q", rule)
+		}
+		severity, err := lint.ParseSeverity(level)
+		if err != nil {
+			return err
+		}
+		if opts.Severities == nil {
+			opts.Severities = map[lint.Rule]lint.Severity{}
+		}
+		opts.Severities[lint.Rule(rule)] = severity
+	}
+
+	open := vault.Open
+	if staged {
+		open = vault.OpenStaged
+	}
+	v, err := open(vaultArg(args))
+	if err != nil {
+		return err
+	}
+	problems := lint.Lint(v, opts)
+
+	if fix {
+		fixed, err := lint.Fix(v, problems)
+		for _, p := range fixed {
+			fmt.Fprintf(os.Stderr, "Fixed %s: renamed to %s\n", p.Path, p.Fix)
+		}
+		if err != nil {
+			return err
+		}
+		if len(fixed) > 0 {
+			// Renames change what the other rules see, so start over
+			if v, err = vault.Open(v.Root); err != nil {
+				return err
+			}
+			problems = lint.Lint(v, opts)
+		}
+	}
+
+	switch format {
+	case "json":
+		if problems == nil {
+			problems = []lint.Problem{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(problems); err != nil {
+			return err
+		}
+	case "sarif":
+		if err := lint.WriteSARIF(os.Stdout, problems); err != nil {
+			return err
+		}
+	default:
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		errors := lint.Errors(problems)
+		if len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "%d errors, %d warnings\n", errors, len(problems)-errors)
+		}
+	}
+
+	if errors := lint.Errors(problems); errors > 0 {
+		cmd.SilenceUsage = true // The problems are the output, not a usage mistake
+		return fmt.Errorf("found %d errors", errors)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/flonle/mdbuddy/server"
+	"github.com/spf13/cobra"
+)
+
+// How to tell the servers where to listen; see listenOptions.
+const listenHelp = `By default, the server listens on --bind and --port. With --socket, it listens on a Unix domain
+socket instead, e.g. for a reverse proxy. When started by systemd socket activation, it listens
+on the socket systemd passes it, and ignores all of those.
+
+Behind a reverse proxy, every client has the address of the proxy, or none on a socket, which
+makes limiting failed logins per address and logging who viewed a share link useless, and uses
+whatever the proxy uses, so it can't tell HTTPS clients apart. With --trust-proxy, the server
+takes client addresses from the X-Forwarded-For or X-Real-IP header instead, and the scheme
+from X-Forwarded-Proto; only use it if nothing but the proxy can reach the server.
+
+With --tls-cert and --tls-key, the server serves HTTPS. --tls-self-signed does so with a
+self-signed certificate for this machine's names and addresses, for use on a LAN; it's kept in
+%s and reused, so browsers only need to be told to trust it once.`
+
+func addListenFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("bind", "b", "", "Bind to this address (default: all interfaces)")
+	cmd.Flags().StringP("port", "p", "", "Bind to this port (default: 3000)")
+	cmd.Flags().String("socket", "", "Listen on this Unix domain socket instead")
+	cmd.Flags().String("tls-cert", "", "Serve HTTPS with this certificate (PEM)")
+	cmd.Flags().String("tls-key", "", "Private key of --tls-cert (PEM)")
+	cmd.Flags().Bool("tls-self-signed", false, "Serve HTTPS with a generated self-signed certificate")
+	cmd.Flags().Bool("trust-proxy", false, "Take client addresses and schemes from the X-Forwarded-* headers of a reverse proxy")
+	cmd.MarkFlagsRequiredTogether("tls-cert", "tls-key")
+	cmd.MarkFlagsMutuallyExclusive("tls-cert", "tls-self-signed")
+	cmd.MarkFlagsMutuallyExclusive("socket", "bind")
+	cmd.MarkFlagsMutuallyExclusive("socket", "port")
+	cmd.Long += "\n\n" + fmt.Sprintf(listenHelp, server.DefaultTLSDir())
+}
+
+func listenOptions(cmd *cobra.Command) server.ListenOptions {
+	bind, _ := cmd.Flags().GetString("bind") // If not set, we get "", which is fine
+	port, _ := cmd.Flags().GetString("port")
+	if port == "" {
+		port = "3000"
+	}
+	opts := server.ListenOptions{Addr: fmt.Sprintf("%s:%s", bind, port)}
+	opts.Socket, _ = cmd.Flags().GetString("socket")
+	opts.TLSCert, _ = cmd.Flags().GetString("tls-cert")
+	opts.TLSKey, _ = cmd.Flags().GetString("tls-key")
+	opts.SelfSigned, _ = cmd.Flags().GetBool("tls-self-signed")
+	opts.TrustProxy, _ = cmd.Flags().GetBool("trust-proxy")
+	return opts
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/flonle/mdbuddy/vault/lsp"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	// Some clients, like VS Code's, pass --stdio; it's the only transport
+	lspCmd.Flags().Bool("stdio", true, "Speak LSP over stdin and stdout")
+	lspCmd.Flags().MarkHidden("stdio")
+	rootCmd.AddCommand(lspCmd)
+}
+
+var lspCmd = &cobra.Command{
+	Use:   "lsp [vault]",
+	Short: "Run a language server for editors",
+	Long: `Run a Language Server Protocol server over stdin and stdout, for editors like Helix, Neovim
+and VS Code. It serves the given vault, or else the folder the editor opens.
+
+The server completes wikilinks, headings after [[note# and hashtags; goes to the note or heading
+a link points to, and finds the links to a note or heading; renames notes and headings, fixing
+links to them across the vault; previews linked notes on hover; lists the headings of a note as
+symbols; and reports broken links and callouts the renderer wouldn't show as meant.
+
+Logs go to stderr.`,
+	Example: `  # Helix, in languages.toml
+  [language-server.mdbuddy]
+  command = "mdbuddy"
+  args = ["lsp"]
+
+  # Neovim
+  vim.lsp.start({ name = "mdbuddy", cmd = { "mdbuddy", "lsp" }, root_dir = vim.fs.root(0, ".git") })`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runLSP,
+}
+
+func runLSP(cmd *cobra.Command, args []string) error {
+	root := ""
+	if len(args) > 0 {
+		root = args[0]
+	}
+	return lsp.Serve(os.Stdin, os.Stdout, lsp.Options{Root: root})
+}
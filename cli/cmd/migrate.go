@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/migrate"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	migrateCmd.Flags().StringArrayP("rule", "r", nil, "Only apply this rule; repeatable (default: all of them)")
+	migrateCmd.Flags().BoolP("dry-run", "n", false, "Print what would change as a unified diff, without changing anything")
+	rootCmd.AddCommand(migrateCmd)
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [vault]",
+	Short: "Rewrite a vault to follow its syntax and structure rules",
+	Long: `Rewrite the notes of the given vault (default: the current directory) to follow the rules
+for their syntax and structure. Only the bits a rule is about are touched; code blocks and the
+like are left alone.
+
+Rules, applied in this order:
+  filenames  ` + migrate.Filenames.Description() + `
+  wikilinks  ` + migrate.Wikilinks.Description() + `
+  callouts   ` + migrate.Callouts.Description() + `
+  tags       ` + migrate.Tags.Description() + `
+
+With --dry-run, the changes are printed as a unified diff instead, which git apply understands.
+Otherwise they're applied all at once: if anything fails, nothing changes. What a rule couldn't
+rewrite, like links with formatted labels, is printed for you to fix by hand.`,
+	Example: `  mdbuddy migrate --dry-run
+  mdbuddy migrate ~/notes --rule callouts --rule tags
+  mdbuddy migrate ~/notes -n | less`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runMigrate,
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	rules := migrate.Rules
+	if names, _ := cmd.Flags().GetStringArray("rule"); len(names) > 0 {
+		rules = nil
+		for _, name := range names {
+			if !slices.Contains(migrate.Rules, migrate.Rule(name)) {
+				return fmt.Errorf("unknown rule %q", name)
+			}
+			rules = append(rules, migrate.Rule(name))
+		}
+	}
+
+	v, err := vault.Open(vaultArg(args))
+	if err != nil {
+		return err
+	}
+	plan := migrate.New(v, rules)
+	for _, s := range plan.Skipped {
+		fmt.Fprintf(os.Stderr, "Skipped %s\n", s)
+	}
+
+	if !dryRun {
+		if err := plan.Apply(); err != nil {
+			return err
+		}
+	}
+	reportChanges(plan, dryRun)
+	if len(plan.Changes) == 0 {
+		fmt.Fprintln(os.Stderr, "✅ Nothing to migrate")
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/migrate"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	mvCmd.Flags().String("vault", ".", "The vault the files are in")
+	mvCmd.Flags().Bool("alias", false, "Add the old name to the front matter aliases of renamed notes")
+	mvCmd.Flags().BoolP("dry-run", "n", false, "Print what would change as a unified diff, without changing anything")
+	mvCmd.Flags().Bool("undo", false, "Undo the last move in the vault")
+	rootCmd.AddCommand(mvCmd)
+}
+
+var mvCmd = &cobra.Command{
+	Use:   "mv <old> <new>",
+	Short: "Move or rename a note, attachment or folder, and fix the links to it",
+	Long: `Move or rename a note, attachment or folder in a vault, and rewrite every wikilink, embed
+and relative link to it across the vault, and the relative links in it, so that none break.
+#heading fragments are kept. Like with mv, if <new> is a folder, <old> moves into it.
+
+With --alias, renamed notes get their old name in the aliases of their front matter, which
+wikilinks find notes by too, so that links from elsewhere keep working.
+
+Every file that changes is listed. Moves are applied all at once: if anything fails, nothing
+changes. They're also recorded in a journal in the user's cache folder, so that --undo can
+undo the last one, as long as the files involved haven't changed since.`,
+	Example: `  mdbuddy mv "Old Name.md" new-name.md
+  mdbuddy mv projects/idea.md archive/ --alias
+  mdbuddy mv --vault ~/notes ~/notes/drafts ~/notes/posts -n
+  mdbuddy mv --undo`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if undo, _ := cmd.Flags().GetBool("undo"); undo {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(2)(cmd, args)
+	},
+	RunE: runMv,
+}
+
+func runMv(cmd *cobra.Command, args []string) error {
+	vaultDir, _ := cmd.Flags().GetString("vault")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	undo, _ := cmd.Flags().GetBool("undo")
+	var opts migrate.MoveOptions
+	opts.Alias, _ = cmd.Flags().GetBool("alias")
+
+	v, err := vault.Open(vaultDir)
+	if err != nil {
+		return err
+	}
+	journal, err := migrate.DefaultJournal(v.Root)
+	if err != nil {
+		return err
+	}
+
+	if undo {
+		entry, err := journal.Last()
+		if err != nil {
+			return err
+		}
+		plan := entry.Undo()
+		if !dryRun {
+			if err := plan.Apply(); err != nil {
+				return fmt.Errorf("failed to undo %q: %w", entry.Command, err)
+			}
+			if err := entry.Forget(); err != nil {
+				return err
+			}
+		}
+		reportChanges(plan, dryRun)
+		return nil
+	}
+
+	from, err := vaultPath(v, args[0])
+	if err != nil {
+		return err
+	}
+	to, err := vaultPath(v, args[1])
+	if err != nil {
+		return err
+	}
+	plan, err := migrate.Move(v, from, to, opts)
+	if err != nil {
+		return err
+	}
+	for _, s := range plan.Skipped {
+		fmt.Fprintf(os.Stderr, "Skipped %s\n", s)
+	}
+	if !dryRun {
+		if err := plan.Apply(); err != nil {
+			return err
+		}
+		if err := journal.Record(plan, "mv "+strings.Join(args, " ")); err != nil {
+			fmt.Fprintf(os.Stderr, "Moved, but can't be undone: %v\n", err)
+		}
+	}
+	reportChanges(plan, dryRun)
+	return nil
+}
+
+// The vault-relative path of the file or folder at `p`, relative to the
+// working directory.
+func vaultPath(v *vault.Vault, p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	if abs == v.Root {
+		return "", nil
+	}
+	relPath, ok := v.Rel(abs)
+	if !ok {
+		return "", fmt.Errorf("%s isn't in the vault at %s", p, v.Root)
+	}
+	return relPath, nil
+}
+
+// Print the changes of `plan`: as a diff before it's applied, as a list of
+// the files touched after.
+func reportChanges(plan *migrate.Plan, dryRun bool) {
+	for _, c := range plan.Changes {
+		switch {
+		case dryRun:
+			fmt.Print(c.Diff())
+		case c.NewPath != c.Path:
+			fmt.Fprintf(os.Stderr, "Renamed %s to %s\n", c.Path, c.NewPath)
+		default:
+			fmt.Fprintf(os.Stderr, "Rewrote %s\n", c.Path)
+		}
+	}
+	if dryRun {
+		fmt.Fprintf(os.Stderr, "%d files would change\n", len(plan.Changes))
+	}
+}
@@ -64,7 +64,7 @@ func runRender(cmd *cobra.Command, args []string) error {
 	}
 
 	// Render input to output
-	renderer.RenderBareNote(input, output)
+	renderer.RenderBareNote(input, output, renderer.BareNoteOptions{})
 
 	// Some extra info on stdin, if it isn't already used to print the HTML
 	if output != os.Stdout {
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/migrate"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	replaceCmd.Flags().String("vault", ".", "The vault to replace in")
+	replaceCmd.Flags().StringSlice("in", []string{string(migrate.InText)}, "Where to replace: "+scopeNames()+"; repeatable, or comma-separated")
+	replaceCmd.Flags().BoolP("literal", "F", false, "Match the pattern, and insert the replacement, as is rather than as a regular expression")
+	replaceCmd.Flags().BoolP("ignore-case", "i", false, "Match letters regardless of case")
+	replaceCmd.Flags().BoolP("dry-run", "n", false, "Print what would change as a unified diff, without changing anything")
+	rootCmd.AddCommand(replaceCmd)
+}
+
+var replaceCmd = &cobra.Command{
+	Use:   "replace <pattern> <replacement>",
+	Short: "Search and replace in the text of notes, leaving markup alone",
+	Long: `Replace every match of a regular expression in the notes of a vault, like sed would, but only
+in the text of the kinds of nodes --in selects:
+  text         Text of paragraphs, lists, tables and block quotes
+  headings     Text of headings
+  link-text    Labels of links and wikilinks, and the alt text of images
+  link-target  Destinations of links and images, and targets of wikilinks
+  code         Code spans and code blocks
+
+Everything else is left alone: front matter, raw HTML, math, hashtags, URLs, and the markup
+itself. Matches don't cross from one node into the next, like from one line of a paragraph to
+the next. The replacement may refer to submatches of the pattern as $1, or ${name} for
+(?P<name>...); with --literal, neither is special.
+
+With --dry-run, the changes are printed as a unified diff instead, which git apply understands.
+Otherwise they're applied all at once: if anything fails, nothing changes.`,
+	Example: `  mdbuddy replace 'colour' 'color' -n
+  mdbuddy replace '(\w+)@example\.com' '$1@example.org' --in text,link-target
+  mdbuddy replace --literal 'old_name()' 'new_name()' --in code --vault ~/notes`,
+	Args: cobra.ExactArgs(2),
+	RunE: runReplace,
+}
+
+func runReplace(cmd *cobra.Command, args []string) error {
+	vaultDir, _ := cmd.Flags().GetString("vault")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	var opts migrate.ReplaceOptions
+	opts.Literal, _ = cmd.Flags().GetBool("literal")
+	opts.IgnoreCase, _ = cmd.Flags().GetBool("ignore-case")
+	in, _ := cmd.Flags().GetStringSlice("in")
+	for _, scope := range in {
+		opts.Scopes = append(opts.Scopes, migrate.Scope(strings.TrimSpace(scope)))
+	}
+
+	v, err := vault.Open(vaultDir)
+	if err != nil {
+		return err
+	}
+	plan, err := migrate.Replace(v, args[0], args[1], opts)
+	if err != nil {
+		return err
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Fprintln(os.Stderr, "No matches")
+		return nil
+	}
+	if !dryRun {
+		if err := plan.Apply(); err != nil {
+			return err
+		}
+	}
+	reportChanges(plan, dryRun)
+	return nil
+}
+
+// The names of the scopes of replace, for humans.
+func scopeNames() string {
+	names := make([]string, len(migrate.Scopes))
+	for i, scope := range migrate.Scopes {
+		names[i] = string(scope)
+	}
+	return strings.Join(names, ", ")
+}
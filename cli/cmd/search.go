@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/search"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	searchCmd.Flags().StringP("vault", "v", ".", "Vault to search")
+	searchCmd.Flags().IntP("limit", "n", 20, "Show at most this many results (0: all)")
+	searchCmd.Flags().Bool("json", false, "Print results as JSON")
+	rootCmd.AddCommand(searchCmd)
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search the notes in a vault",
+	Long: `Search all notes in a vault for the given query, and print the best matches first.
+
+Words must all occur in a note for it to match. Matches in headings count for more
+than matches in body text, which count for more than matches in code. Also supported:
+
+  "exact phrase"   the words must occur right after one another
+  tag:name         the note must contain the hashtag #name
+  path:dir/        the note's path must start with dir/ (or match a glob like path:*/todo*)`,
+	Example: `  mdbuddy search interfaces
+  mdbuddy search '"method signatures"' tag:go
+  mdbuddy search -v ~/notes path:work/ meeting --json`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runSearch,
+}
+
+func runSearch(cmd *cobra.Command, args []string) error {
+	vaultDir, _ := cmd.Flags().GetString("vault")
+	limit, _ := cmd.Flags().GetInt("limit")
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	query := search.ParseQuery(strings.Join(args, " "))
+	if query.IsEmpty() {
+		return fmt.Errorf("nothing to search for")
+	}
+
+	v, err := vault.Open(vaultDir)
+	if err != nil {
+		return err
+	}
+	results := search.IndexVault(v).Search(query, limit)
+
+	if asJSON {
+		if results == nil {
+			results = []search.Result{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	if len(results) == 0 {
+		fmt.Fprintln(os.Stderr, "No results")
+		return nil
+	}
+	for _, result := range results {
+		fmt.Printf("%s  %s (%.2f)\n", result.Path, result.Title, result.Score)
+		if result.Snippet != "" {
+			fmt.Printf("    %s\n", result.Snippet)
+		}
+	}
+	return nil
+}
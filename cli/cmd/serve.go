@@ -1 +1,130 @@
 package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flonle/mdbuddy/renderer"
+	"github.com/flonle/mdbuddy/server"
+	"github.com/flonle/mdbuddy/server/auth"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addListenFlags(serveCmd)
+	serveCmd.Flags().Bool("drafts", false, "Also serve drafts and notes scheduled to be published later")
+	serveCmd.Flags().Bool("auth", false, "Require logging in; see `mdbuddy user`")
+	serveCmd.Flags().String("users", auth.DefaultUsersPath(), "Users file, for --auth")
+	serveCmd.Flags().Duration("session-ttl", auth.DefaultSessionTTL, "How long a login lasts, for --auth or --oidc-issuer")
+	serveCmd.Flags().String("oidc-issuer", "", "Let users log in with this OpenID Connect provider")
+	serveCmd.Flags().String("oidc-client-id", "", "Client ID registered with the OIDC provider")
+	serveCmd.Flags().String("oidc-redirect-url", "", "Callback URL registered with the OIDC provider (default: /login/oidc/callback on the requested host)")
+	serveCmd.Flags().String("oidc-user-claim", auth.DefaultUserClaim, "ID token claim holding the username")
+	serveCmd.Flags().StringArray("oidc-allow", nil, "Only let in OIDC users with this claim, e.g. groups=notes; repeatable, any one suffices")
+	serveCmd.Flags().String("sanitize", server.SanitizeAnonymous, "Strip HTML that isn't on the allowlist from notes: anonymous, always or never")
+	serveCmd.Flags().StringArray("allow-element", nil, "Also allow this HTML element in sanitized notes, e.g. mark; repeatable")
+	serveCmd.Flags().StringArray("allow-attr", nil, "Also allow this attribute in sanitized notes, as element:attr or *:attr; repeatable")
+	serveCmd.Flags().Int("render-cache-size", 64, "Keep up to this many MiB of rendered notes in memory; 0 to not cache them")
+	serveCmd.Flags().String("render-cache-dir", "", "Also cache rendered notes in this directory, so they survive restarts, e.g. "+renderer.DefaultCacheDir())
+	serveCmd.Flags().Bool("debug", false, "Serve the render cache counters and memory statistics at /debug/vars")
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [vault]",
+	Short: "Serve all notes in a vault",
+	Long: `Serve every markdown note in the given directory (default: the current one), with search.
+Notes are served at their path without the .md extension, so dir/note.md ends up at /dir/note.
+The vault is watched for changes, so there's no need to restart after editing. Linux only ¯\_(ツ)_/¯
+
+Notes with "draft: true" in their front matter, or a "publish" date in the future, are left out
+until they're published, unless --drafts is given or the user logged in.
+
+A note's visibility comes from "visibility: public|private|unlisted" in its front matter, or
+else from a .mdbuddy file in its folder or a parent folder (with e.g. "visibility: private").
+Private notes are only served to logged-in users, and unlisted ones are served to anyone with
+the URL, but only listed (in folders, search, tags, backlinks and feeds) for logged-in users.
+
+With --auth, users log in at /login with one of the users managed by ` + "`mdbuddy user`" + `, and notes
+without a visibility are private; the same goes for --oidc-issuer, below. Without either, every
+visitor is anonymous, and notes are public unless they say otherwise.
+
+With --oidc-issuer, users can (also) log in with an OpenID Connect provider instead, using the
+authorization code flow with PKCE. Register /login/oidc/callback with the provider as the
+redirect URL. The client secret, if any, is read from $MDBUDDY_OIDC_CLIENT_SECRET. By default
+anyone the provider lets in is logged in, as the value of --oidc-user-claim; use --oidc-allow to
+only let in users with certain claims, like a group.
+
+Raw HTML in notes is only served as-is to logged-in users. For everyone else, including share
+links, it's run through an allowlist that removes scripts, event handlers, iframes, forms and
+javascript: URLs, but keeps everything markdown renders to, including Web Awesome elements.
+Use --sanitize always for vaults whose authors can't be trusted, and --allow-element and
+--allow-attr to extend the allowlist.
+
+Rendered notes are cached, and only rendered again when they or the notes they link to change.
+With --debug, hit and miss counts of the cache and memory statistics are served as JSON at
+/debug/vars (to logged-in users only, if logging in is enabled).`,
+	Example: `  mdbuddy serve
+  mdbuddy serve ~/notes --port 8080
+  mdbuddy serve ~/notes --auth
+  mdbuddy serve ~/notes --auth --tls-self-signed
+  mdbuddy serve ~/notes --socket /run/mdbuddy/http.sock
+  mdbuddy serve ~/notes --oidc-issuer https://id.example.com --oidc-client-id mdbuddy --oidc-allow groups=notes`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runServe,
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	drafts, _ := cmd.Flags().GetBool("drafts")
+	opts := server.VaultOptions{Drafts: drafts}
+	if useAuth, _ := cmd.Flags().GetBool("auth"); useAuth {
+		opts.Users, _ = cmd.Flags().GetString("users")
+	}
+	if issuer, _ := cmd.Flags().GetString("oidc-issuer"); issuer != "" {
+		oidc := &auth.OIDCConfig{Issuer: issuer, ClientSecret: os.Getenv("MDBUDDY_OIDC_CLIENT_SECRET")}
+		oidc.ClientID, _ = cmd.Flags().GetString("oidc-client-id")
+		oidc.RedirectURL, _ = cmd.Flags().GetString("oidc-redirect-url")
+		oidc.UserClaim, _ = cmd.Flags().GetString("oidc-user-claim")
+		allow, _ := cmd.Flags().GetStringArray("oidc-allow")
+		for _, s := range allow {
+			claim, err := auth.ParseClaim(s)
+			if err != nil {
+				return err
+			}
+			oidc.Allow = append(oidc.Allow, claim)
+		}
+		opts.OIDC = oidc
+	}
+	if opts.Users != "" || opts.OIDC != nil {
+		opts.SessionTTL, _ = cmd.Flags().GetDuration("session-ttl")
+	}
+	opts.Sanitize, _ = cmd.Flags().GetString("sanitize")
+	opts.AllowHTML.Elements, _ = cmd.Flags().GetStringArray("allow-element")
+	allowAttrs, _ := cmd.Flags().GetStringArray("allow-attr")
+	for _, s := range allowAttrs {
+		element, attr, ok := strings.Cut(s, ":")
+		if !ok || element == "" || attr == "" {
+			return fmt.Errorf("invalid --allow-attr %q, expected element:attr or *:attr", s)
+		}
+		if opts.AllowHTML.Attributes == nil {
+			opts.AllowHTML.Attributes = map[string][]string{}
+		}
+		opts.AllowHTML.Attributes[element] = append(opts.AllowHTML.Attributes[element], attr)
+	}
+
+	cacheMiB, _ := cmd.Flags().GetInt("render-cache-size")
+	opts.RenderCacheSize = cacheMiB << 20
+	opts.RenderCacheDir, _ = cmd.Flags().GetString("render-cache-dir")
+	opts.Debug, _ = cmd.Flags().GetBool("debug")
+
+	return server.ServeVault(listenOptions(cmd), vaultArg(args), opts)
+}
+
+// The vault directory given as the first argument, or the current directory.
+func vaultArg(args []string) string {
+	if len(args) == 0 {
+		return "."
+	}
+	return args[0]
+}
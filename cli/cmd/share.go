@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flonle/mdbuddy/server/share"
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	shareCmd.PersistentFlags().StringP("vault", "v", ".", "Vault the shares belong to")
+	shareCmd.PersistentFlags().String("store", "", "Share store, outside the vault (default: in the user's config folder, per vault)")
+	shareCreateCmd.Flags().String("expires", "", "Stop working after this long, e.g. 7d or 12h (default: never)")
+	shareCreateCmd.Flags().Int("max-views", 0, "Stop working after this many page views (default: unlimited)")
+	shareCreateCmd.Flags().String("base-url", "http://localhost:3000", "URL the vault is served at, to print the full link")
+	shareCmd.AddCommand(shareCreateCmd, shareListCmd, shareRevokeCmd, shareLogCmd)
+	rootCmd.AddCommand(shareCmd)
+}
+
+var shareCmd = &cobra.Command{
+	Use:   "share",
+	Short: "Manage links that share a note or folder with anyone",
+	Long: `Manage share links: unguessable URLs (/share/<token>) that let anyone who has them see a
+single note, or a folder and everything in it, without logging in to the vault server.
+
+Only a hash of each token is stored, so a link can't be shown again after it's created.
+Shares are referred to by their ID instead, or any unique prefix of it.`,
+}
+
+var shareCreateCmd = &cobra.Command{
+	Use:   "create <note or folder/>",
+	Short: "Create a share link",
+	Example: `  mdbuddy share create dir/note.md
+  mdbuddy share create dir/ --expires 7d
+  mdbuddy share create note --max-views 3 --base-url https://notes.example.com`,
+	Args: cobra.ExactArgs(1),
+	RunE: runShareCreate,
+}
+
+var shareListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all share links",
+	Args:  cobra.NoArgs,
+	RunE:  runShareList,
+}
+
+var shareRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke a share link",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runShareRevoke,
+}
+
+var shareLogCmd = &cobra.Command{
+	Use:   "log <id>",
+	Short: "Show the most recent accesses of a share link",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runShareLog,
+}
+
+func shareStore(cmd *cobra.Command) (*share.Store, *vault.Vault, error) {
+	vaultDir, _ := cmd.Flags().GetString("vault")
+	v, err := vault.Open(vaultDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	path, _ := cmd.Flags().GetString("store")
+	store, err := share.Open(v.Root, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, v, nil
+}
+
+func runShareCreate(cmd *cobra.Command, args []string) error {
+	expiresIn, _ := cmd.Flags().GetString("expires")
+	maxViews, _ := cmd.Flags().GetInt("max-views")
+	baseURL, _ := cmd.Flags().GetString("base-url")
+
+	store, v, err := shareStore(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Notes may be given with or without .md, folders need a trailing slash
+	target := strings.TrimPrefix(args[0], "./")
+	switch {
+	case target == "/" || target == "":
+		target = "/"
+	case strings.HasSuffix(target, "/"):
+		if folders, notes := v.Folder(target); len(folders) == 0 && len(notes) == 0 {
+			return fmt.Errorf("no notes in folder: %s", target)
+		}
+	default:
+		if !vault.IsNote(target) {
+			target += ".md"
+		}
+		if v.Note(target) == nil {
+			return fmt.Errorf("no such note: %s", args[0])
+		}
+	}
+
+	var expires time.Time
+	if expiresIn != "" {
+		d, err := parseDays(expiresIn)
+		if err != nil {
+			return err
+		}
+		expires = time.Now().UTC().Add(d).Truncate(time.Second)
+	}
+
+	token, sh, err := store.Create(target, expires, maxViews, os.Getenv("USER"))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ Shared %s as %s\n", sh.Path, sh.ID)
+	fmt.Printf("%s/share/%s\n", strings.TrimSuffix(baseURL, "/"), token)
+	return nil
+}
+
+func runShareList(cmd *cobra.Command, args []string) error {
+	store, _, err := shareStore(cmd)
+	if err != nil {
+		return err
+	}
+	shares, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPATH\tCREATED\tEXPIRES\tVIEWS\tSTATUS")
+	now := time.Now()
+	for _, sh := range shares {
+		expires, views, status := "never", strconv.Itoa(sh.Views), "active"
+		if !sh.Expires.IsZero() {
+			expires = sh.Expires.Local().Format(time.DateTime)
+		}
+		if sh.MaxViews > 0 {
+			views += "/" + strconv.Itoa(sh.MaxViews)
+		}
+		if !sh.Active(now) {
+			status = "expired"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", sh.ID, sh.Path, sh.Created.Local().Format(time.DateTime), expires, views, status)
+	}
+	return w.Flush()
+}
+
+func runShareRevoke(cmd *cobra.Command, args []string) error {
+	store, _, err := shareStore(cmd)
+	if err != nil {
+		return err
+	}
+	sh, err := store.Revoke(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ Revoked share %s of %s\n", sh.ID, sh.Path)
+	return nil
+}
+
+func runShareLog(cmd *cobra.Command, args []string) error {
+	store, _, err := shareStore(cmd)
+	if err != nil {
+		return err
+	}
+	sh, err := store.Get(args[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tPATH\tADDRESS\tUSER AGENT")
+	for _, access := range sh.Log {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", access.Time.Local().Format(time.DateTime), access.Path, cmp.Or(access.Addr, "-"), access.UserAgent)
+	}
+	return w.Flush()
+}
+
+// Parse a duration like time.ParseDuration, but also accept days ("7d").
+func parseDays(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, errors.New("invalid number of days: " + s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/tangle"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	tangleCmd.Flags().StringP("out", "o", "", "The folder to tangle files into (default: the vault)")
+	tangleCmd.Flags().Bool("check", false, "Don't write anything; list the files that are out of sync with the notes, and fail if there are any")
+	rootCmd.AddCommand(tangleCmd)
+}
+
+var tangleCmd = &cobra.Command{
+	Use:   "tangle [vault]",
+	Short: "Extract code blocks from notes into files",
+	Long: `Write the code of the fenced code blocks in the notes of a vault (default: the current directory)
+to files, for scripts and config kept in notes. Attributes after the language of a block say
+where its code goes:
+
+  file=path    Append the code to the file at path, relative to --out
+  name=chunk   Make the block a chunk, which a line of just <<chunk>> in other blocks refers to:
+               it's replaced by the code of the blocks of that name, at its indentation
+  order=n      Put the block before those with a higher n (default 0) in its file or chunk;
+               blocks are in the order of the notes, and of the blocks in a note, otherwise
+  mode=755     The permissions of the file, in octal (default 644)
+
+Values with spaces are quoted, name="two words". Files are only written when their content
+changed, and never over notes, or over files that weren't tangled before and have other content:
+a hidden .mdbuddy-tangled.json in --out lists the files tangled there.
+
+With --check, nothing is written: the files whose content or permissions are out of sync with
+the notes are listed, and the command fails if there are any.`,
+	Example: `  mdbuddy tangle
+  mdbuddy tangle ~/notes --out ~/dotfiles
+  mdbuddy tangle --check`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runTangle,
+}
+
+func runTangle(cmd *cobra.Command, args []string) error {
+	vaultDir := vaultArg(args)
+	outDir, _ := cmd.Flags().GetString("out")
+	if outDir == "" {
+		outDir = vaultDir
+	}
+	check, _ := cmd.Flags().GetBool("check")
+
+	v, err := vault.Open(vaultDir)
+	if err != nil {
+		return err
+	}
+	cmd.SilenceUsage = true // From here on, errors are about the notes, not usage mistakes
+	files, err := tangle.Tangle(v)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "No code blocks to tangle")
+		return nil
+	}
+
+	if !check {
+		written, err := tangle.Write(v, outDir, files)
+		for _, f := range written {
+			fmt.Fprintf(os.Stderr, "Tangled %s from %s\n", f.Path, strings.Join(f.Sources, ", "))
+		}
+		return err
+	}
+
+	outOfSync := 0
+	for _, f := range files {
+		upToDate, err := f.Check(outDir)
+		if err != nil {
+			return err
+		}
+		if !upToDate {
+			fmt.Println(f.Path)
+			outOfSync++
+		}
+	}
+	if outOfSync > 0 {
+		return fmt.Errorf("%d files are out of sync with the notes; run mdbuddy tangle", outOfSync)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flonle/mdbuddy/server/auth"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func init() {
+	userCmd.PersistentFlags().String("users", auth.DefaultUsersPath(), "Users file")
+	userCmd.AddCommand(userAddCmd, userPasswdCmd, userRemoveCmd, userListCmd)
+	rootCmd.AddCommand(userCmd)
+}
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage who can log in to the vault server",
+	Long: `Manage the users that can log in to the vault server (mdbuddy serve --auth).
+
+Users are kept in a file with one name:hash line per user, hashed with argon2id. Changes
+take effect right away, even while the server is running; removing a user or changing
+their password ends their sessions.`,
+}
+
+var userAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a user",
+	Example: `  mdbuddy user add alice
+  echo "$PASSWORD" | mdbuddy user add alice`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setPassword(cmd, args[0], false)
+	},
+}
+
+var userPasswdCmd = &cobra.Command{
+	Use:   "passwd <name>",
+	Short: "Change the password of a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setPassword(cmd, args[0], true)
+	},
+}
+
+var userRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a user",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runUserRemove,
+}
+
+var userListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all users",
+	Args:  cobra.NoArgs,
+	RunE:  runUserList,
+}
+
+func loadUsers(cmd *cobra.Command) (*auth.Users, error) {
+	path, _ := cmd.Flags().GetString("users")
+	return auth.LoadUsers(path)
+}
+
+// Add a user, or change the password of an existing one.
+func setPassword(cmd *cobra.Command, name string, exists bool) error {
+	users, err := loadUsers(cmd)
+	if err != nil {
+		return err
+	}
+	switch {
+	case exists && !users.Has(name):
+		return fmt.Errorf("no such user: %s", name)
+	case !exists && users.Has(name):
+		return fmt.Errorf("user already exists: %s; use `mdbuddy user passwd` to change their password", name)
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if err := users.Set(name, password); err != nil {
+		return err
+	}
+
+	if exists {
+		fmt.Printf("✅ Changed the password of %s in %s\n", name, users.Path)
+	} else {
+		fmt.Printf("✅ Added %s to %s\n", name, users.Path)
+	}
+	return nil
+}
+
+func runUserRemove(cmd *cobra.Command, args []string) error {
+	users, err := loadUsers(cmd)
+	if err != nil {
+		return err
+	}
+	if err := users.Remove(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Removed %s from %s\n", args[0], users.Path)
+	return nil
+}
+
+func runUserList(cmd *cobra.Command, args []string) error {
+	users, err := loadUsers(cmd)
+	if err != nil {
+		return err
+	}
+	for _, name := range users.Names() {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// Prompt for a new password twice, or read it from the first line of stdin
+// if that's not a terminal.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n') // No newline at EOF is fine
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return "", errors.New("no password on stdin")
+		}
+		return password, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	repeated, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case len(password) == 0:
+		return "", errors.New("password can't be empty")
+	case string(password) != string(repeated):
+		return "", errors.New("passwords don't match")
+	}
+	return string(password), nil
+}
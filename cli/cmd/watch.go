@@ -1,15 +1,12 @@
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/flonle/mdbuddy/server"
 	"github.com/spf13/cobra"
 )
 
 func init() {
-	watchCmd.Flags().StringP("bind", "b", "", "Bind to this address (default: all interfaces)")
-	watchCmd.Flags().StringP("port", "p", "", "Bind to this port (default: 3000)")
+	addListenFlags(watchCmd)
 	rootCmd.AddCommand(watchCmd)
 }
 
@@ -25,11 +22,5 @@ Supplying a directory is the same as supplying all files in it. Files without th
 }
 
 func runWatch(cmd *cobra.Command, args []string) error {
-	bind, _ := cmd.Flags().GetString("bind") // If not set, we get "", which is fine
-	port, _ := cmd.Flags().GetString("port")
-	if port == "" {
-		port = "3000"
-	}
-
-	return server.ServePreview(fmt.Sprintf("%s:%s", bind, port), args)
+	return server.ServePreview(listenOptions(cmd), args)
 }
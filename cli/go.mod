@@ -5,6 +5,7 @@ go 1.25.2
 require (
 	github.com/flonle/mdbuddy/renderer v0.0.0
 	github.com/spf13/cobra v1.10.1
+	golang.org/x/term v0.37.0
 )
 
 require (
@@ -21,4 +22,5 @@ require (
 	go.abhg.dev/goldmark/hashtag v0.4.0 // indirect
 	go.abhg.dev/goldmark/toc v0.12.0 // indirect
 	go.abhg.dev/goldmark/wikilink v0.6.0 // indirect
+	golang.org/x/sys v0.38.0 // indirect
 )
@@ -0,0 +1,51 @@
+// Package atomicfile writes files so that they're never half-written: the
+// content goes into a temporary file next to the file first, which is synced
+// and then renamed over it, so that a crash or a full disk leaves either the
+// old content or the new one behind.
+package atomicfile
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Replace the content of the file `name`, or create it, with `data` and the
+// permissions `perm`.
+func Write(name string, data []byte, perm fs.FileMode) error {
+	tmp, err := Temp(filepath.Dir(name), "."+filepath.Base(name)+"-*", data, perm)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Write `data` to a new temporary file in `dir`, named after `pattern` as by
+// os.CreateTemp, with the permissions `perm`, and sync it. Returns its path,
+// for the caller to rename into place, or remove. There's no file left behind
+// on errors.
+func Temp(dir, pattern string, data []byte, perm fs.FileMode) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
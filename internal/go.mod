@@ -0,0 +1,3 @@
+module github.com/flonle/mdbuddy/internal
+
+go 1.25.4
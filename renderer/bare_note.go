@@ -1,75 +1,50 @@
 package renderer
 
 import (
-	"bytes"
 	"fmt"
 	"html/template"
 	"io"
 
 	"github.com/flonle/mdbuddy/assets"
-	customExtensions "github.com/flonle/mdbuddy/renderer/goldmark-extensions"
-
-	treeblood "github.com/wyatt915/goldmark-treeblood"
-	"github.com/yuin/goldmark"
-	highlighting "github.com/yuin/goldmark-highlighting/v2"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	gmHtml "github.com/yuin/goldmark/renderer/html"
-	gmText "github.com/yuin/goldmark/text"
-	anchor "go.abhg.dev/goldmark/anchor"
-	toc "go.abhg.dev/goldmark/toc"
-	"go.abhg.dev/goldmark/wikilink"
 )
 
 var tmpl = template.Must(template.ParseFS(assets.FS, "static/templates/*.html"))
 
 type BareNotePage struct {
-	Title   string
-	Content template.HTML // Main Content
-	TOC     template.HTML // Table Of Contents
-	CSS     template.CSS
-	JS      template.JS
+	Title       string
+	Content     template.HTML // Main Content
+	TOC         template.HTML // Table Of Contents
+	CSS         template.CSS
+	JS          template.JS
+	Stylesheets []string // URLs, instead of CSS; see Page.Static
+	Scripts     []string // URLs, instead of JS
+	Nonce       string   // For the scripts and styles; see Page.Nonce
 }
 
-func RenderBareNote(input []byte, output io.Writer) error {
-	// Goldmark
-	md := goldmark.New(
-		goldmark.WithExtensions(
-			extension.GFM,
-			extension.Footnote,
-			treeblood.MathML(),
-			highlighting.NewHighlighting(
-				highlighting.WithCustomStyle(catpuccinFrappeNoBg),
-			),
-			&wikilink.Extender{},
-			&customExtensions.HashtagExtension{},
-			&anchor.Extender{
-				Texter: anchor.Text("#"),
-			},
-			&customExtensions.CalloutExtender{},
-		),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-		goldmark.WithRendererOptions(
-			gmHtml.WithHardWraps(),
-			gmHtml.WithXHTML(),
-			gmHtml.WithUnsafe(),
-		),
-	)
+// The stylesheets and scripts of a bare note, relative to static/.
+var (
+	bareNoteStylesheets = []string{"css/bare_note_layout.css", "css/table_of_contents.css"}
+	bareNoteScripts     = []string{"js/table_of_contents.js", "js/sse_refresh.js"} // TODO make sse_refresh.js conditional ofcourse, we don't always want this
+)
+
+// How RenderBareNote renders a page. The zero value gives a page that works
+// on its own, with everything inlined.
+type BareNoteOptions struct {
+	// Put this nonce on the scripts and styles, for serving with a
+	// Content-Security-Policy
+	Nonce string
 
-	// Render note
-	noteRootNode := md.Parser().Parse(gmText.NewReader(input))
-	var noteBuf bytes.Buffer
-	md.Renderer().Render(&noteBuf, input, noteRootNode)
+	// Link the stylesheets and scripts from here instead of inlining them; see
+	// Page.Static
+	Static string
+}
 
-	// Render TOC
-	tocTree, err := toc.Inspect(noteRootNode, input, toc.Compact(true))
+// Render a note as a standalone page.
+func RenderBareNote(input []byte, output io.Writer, opts BareNoteOptions) error {
+	content, tocHTML, err := RenderNoteContent(input, Options{})
 	if err != nil {
-		return fmt.Errorf("failed to create table of contents: %w", err)
+		return err
 	}
-	var tocBuf bytes.Buffer
-	md.Renderer().Render(&tocBuf, input, toc.RenderList(tocTree))
 
 	// hashtags := make(map[string]struct{})
 	// ast.Walk(doc, func(node ast.Node, enter bool) (ast.WalkStatus, error) {
@@ -114,28 +89,18 @@ func RenderBareNote(input []byte, output io.Writer) error {
 	// if err != nil {
 	// 	log.Fatal(err)
 	// }
-	css, err := assets.FS.ReadFile("static/css/bare_note_layout.css")
-	if err != nil {
-		panic(err)
-	}
-	tocCSS, err := assets.FS.ReadFile("static/css/table_of_contents.css")
-	if err != nil {
-		panic(err)
-	}
-	tocJS, err := assets.FS.ReadFile("static/js/table_of_contents.js")
-	if err != nil {
-		panic(err)
-	}
-	sseRefreshJS, err := assets.FS.ReadFile("static/js/sse_refresh.js") // TODO make this conditional ofcourse, we don't always want this
-	if err != nil {
-		panic(err)
-	}
 	note := BareNotePage{
 		Title:   "My Note",
-		Content: template.HTML(noteBuf.String()),
-		TOC:     template.HTML(tocBuf.String()),
-		CSS:     template.CSS(append(css, tocCSS...)),
-		JS:      template.JS(append(tocJS, sseRefreshJS...)),
+		Content: content,
+		TOC:     tocHTML,
+		Nonce:   opts.Nonce,
+	}
+	if opts.Static != "" {
+		note.Stylesheets = staticURLs(opts.Static, bareNoteStylesheets)
+		note.Scripts = staticURLs(opts.Static, bareNoteScripts)
+	} else {
+		note.CSS = template.CSS(concatAssets(bareNoteStylesheets...))
+		note.JS = template.JS(concatAssets(bareNoteScripts...))
 	}
 	err = tmpl.ExecuteTemplate(output, "bare_note.html", note)
 	if err != nil {
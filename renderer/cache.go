@@ -0,0 +1,203 @@
+package renderer
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flonle/mdbuddy/assets"
+	"github.com/flonle/mdbuddy/internal/atomicfile"
+)
+
+// Bump whenever the output for the same input and Options changes in a way
+// the assets hash doesn't capture, e.g. a different goldmark configuration, so
+// that renders cached on disk, and pages of incremental builds, aren't reused.
+const RenderVersion = 1
+
+// Renders cached on disk that haven't been used for this long are deleted.
+const diskCacheMaxAge = 30 * 24 * time.Hour
+
+// A cache of rendered notes, so that viewing the same unchanged note again
+// doesn't render it again; see Options.Cache. Renders are kept in memory,
+// least recently used first out, and optionally on disk too, so they survive
+// restarts.
+//
+// Renders are keyed by a hash of everything that goes into them: the
+// markdown, the renderer version, the embedded assets, the Sanitizer and
+// Options.CacheKey.
+type Cache struct {
+	maxBytes int    // Of the renders in memory
+	dir      string // "" to only cache in memory
+
+	entries map[string]*list.Element // key : element of lru
+	lru     *list.List               // Of *cacheEntry; most recently used at the front
+	size    int                      // Bytes of all entries
+	mx      sync.Mutex               // Protects entries, lru and size
+
+	hits, diskHits, misses atomic.Int64
+}
+
+type cacheEntry struct {
+	key     string
+	Content template.HTML `json:"content"`
+	TOC     template.HTML `json:"toc"`
+}
+
+func (e *cacheEntry) size() int {
+	return len(e.key) + len(e.Content) + len(e.TOC)
+}
+
+// How well a Cache is doing, for monitoring.
+type CacheStats struct {
+	Hits     int64 `json:"hits"`      // Renders found in memory
+	DiskHits int64 `json:"disk_hits"` // Renders found on disk
+	Misses   int64 `json:"misses"`    // Renders that had to be done
+	Entries  int   `json:"entries"`   // Renders in memory
+	Bytes    int   `json:"bytes"`     // Size of the renders in memory
+}
+
+// Create a cache that keeps up to `maxBytes` of renders in memory, and all of
+// them in `dir` if it isn't "". Renders in `dir` that haven't been used for a
+// while are deleted in the background.
+func NewCache(maxBytes int, dir string) *Cache {
+	c := &Cache{
+		maxBytes: maxBytes,
+		dir:      dir,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+	if dir != "" {
+		go c.pruneDisk()
+	}
+	return c
+}
+
+// The directory renders are cached in on disk, unless told otherwise:
+// $XDG_CACHE_HOME/mdbuddy/render, or the equivalent on other platforms.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "mdbuddy-render-cache"
+	}
+	return filepath.Join(dir, "mdbuddy", "render")
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return CacheStats{
+		Hits:     c.hits.Load(),
+		DiskHits: c.diskHits.Load(),
+		Misses:   c.misses.Load(),
+		Entries:  c.lru.Len(),
+		Bytes:    c.size,
+	}
+}
+
+// The key for rendering `input` with `opts`, by the renderer of `version`
+// (RenderVersion).
+func cacheKey(version int, input []byte, opts Options) string {
+	h := sha256.New()
+	for _, part := range []string{strconv.Itoa(version), assets.Hash(), opts.CacheKey, opts.Sanitizer.key()} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(input)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Look up a render in memory, then on disk.
+func (c *Cache) get(key string) (content, toc template.HTML, ok bool) {
+	c.mx.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		entry := elem.Value.(*cacheEntry)
+		c.mx.Unlock()
+		c.hits.Add(1)
+		return entry.Content, entry.TOC, true
+	}
+	c.mx.Unlock()
+
+	if c.dir != "" {
+		if b, err := os.ReadFile(c.diskPath(key)); err == nil {
+			entry := &cacheEntry{key: key}
+			if json.Unmarshal(b, entry) == nil {
+				now := time.Now()
+				os.Chtimes(c.diskPath(key), now, now) // Keeps pruneDisk away
+				c.add(entry)
+				c.diskHits.Add(1)
+				return entry.Content, entry.TOC, true
+			}
+		}
+	}
+	c.misses.Add(1)
+	return "", "", false
+}
+
+// Store a render in memory and on disk. Failing to write it to disk isn't
+// worth failing the render for, so that's silently skipped.
+func (c *Cache) put(key string, content, toc template.HTML) {
+	entry := &cacheEntry{key: key, Content: content, TOC: toc}
+	c.add(entry)
+	if c.dir == "" {
+		return
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	path := c.diskPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	atomicfile.Write(path, b, 0o600)
+}
+
+// Add an entry to memory, evicting the least recently used ones if it's full.
+func (c *Cache) add(entry *cacheEntry) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if elem, ok := c.entries[entry.key]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+	if entry.size() > c.maxBytes {
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size()
+	for c.size > c.maxBytes {
+		oldest := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.entries, oldest.key)
+		c.size -= oldest.size()
+	}
+}
+
+// Renders are spread over subdirectories by the first byte of their key, so
+// that no directory gets too big.
+func (c *Cache) diskPath(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// Delete the renders on disk that haven't been used for diskCacheMaxAge.
+func (c *Cache) pruneDisk() {
+	cutoff := time.Now().Add(-diskCacheMaxAge)
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
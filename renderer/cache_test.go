@@ -0,0 +1,103 @@
+package renderer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Entries are the size of their key and content, so "k1" with 8 bytes of
+// content is 10 bytes.
+func TestCacheEviction(t *testing.T) {
+	c := NewCache(30, "")
+	c.put("k1", "content1", "")
+	c.put("k2", "content2", "")
+	c.get("k1") // Now more recently used than k2
+	c.put("k3", "content3", "")
+	c.put("k4", "content4", "")
+	c.put("k5", "far too big to fit in the cache at all", "")
+
+	for _, key := range []string{"k2", "k5"} {
+		if _, _, ok := c.get(key); ok {
+			t.Errorf("%s is still cached", key)
+		}
+	}
+	for _, key := range []string{"k1", "k3", "k4"} {
+		if _, _, ok := c.get(key); !ok {
+			t.Errorf("%s isn't cached", key)
+		}
+	}
+	if stats := c.Stats(); stats.Entries != 3 || stats.Bytes != 30 {
+		t.Errorf("got %d entries of %d bytes, want 3 of 30", stats.Entries, stats.Bytes)
+	}
+}
+
+func TestCacheDisk(t *testing.T) {
+	dir := t.TempDir()
+	note := []byte("# Note\n\nSome text.\n")
+	opts := Options{CacheKey: "test"}
+	want, wantTOC, err := RenderNoteContent(note, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func(c *Cache) {
+		t.Helper()
+		opts.Cache = c
+		content, toc, err := RenderNoteContent(note, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content != want || toc != wantTOC {
+			t.Errorf("got %q and TOC %q, want %q and %q", content, toc, want, wantTOC)
+		}
+	}
+
+	render(NewCache(1<<20, dir))
+
+	// After a restart, from disk, then from memory
+	c := NewCache(1<<20, dir)
+	render(c)
+	render(c)
+	if stats := c.Stats(); stats.DiskHits != 1 || stats.Hits != 1 || stats.Misses != 0 {
+		t.Errorf("got %+v, want a render from disk, then one from memory", stats)
+	}
+
+	// A corrupt entry is rendered again, and replaced
+	path := filepath.Join(dir, cacheKey(RenderVersion, note, opts)[:2], cacheKey(RenderVersion, note, opts))
+	if err := os.WriteFile(path, []byte(`{"content": "<p>Cut o`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c = NewCache(1<<20, dir)
+	render(c)
+	if stats := c.Stats(); stats.DiskHits != 0 || stats.Misses != 1 {
+		t.Errorf("got %+v, want the corrupt entry to be a miss", stats)
+	}
+	c = NewCache(1<<20, dir)
+	render(c)
+	if stats := c.Stats(); stats.DiskHits != 1 {
+		t.Errorf("got %+v, want the replaced entry from disk", stats)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	note := []byte("# Note\n")
+	opts := Options{CacheKey: "a"}
+	keys := map[string]string{
+		"base":      cacheKey(RenderVersion, note, opts),
+		"version":   cacheKey(RenderVersion+1, note, opts),
+		"source":    cacheKey(RenderVersion, []byte("# Note!\n"), opts),
+		"options":   cacheKey(RenderVersion, note, Options{CacheKey: "b"}),
+		"sanitized": cacheKey(RenderVersion, note, Options{CacheKey: "a", Sanitizer: NewSanitizer(Allowlist{})}),
+		"allowlist": cacheKey(RenderVersion, note, Options{CacheKey: "a", Sanitizer: NewSanitizer(Allowlist{Elements: []string{"kbd"}})}),
+	}
+	seen := map[string]string{}
+	for name, key := range keys {
+		if other, ok := seen[key]; ok {
+			t.Errorf("%s and %s have the same key", name, other)
+		}
+		seen[key] = name
+	}
+	if cacheKey(RenderVersion, note, Options{CacheKey: "a"}) != keys["base"] {
+		t.Error("the same render has a different key")
+	}
+}
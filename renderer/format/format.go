@@ -0,0 +1,106 @@
+// Package format normalises the markdown of notes: bullet list markers become
+// '-', ordered list markers use '.', emphasis uses '*', headings are ATX
+// headings without closing #'s, tables are aligned, YAML front matter keys
+// come in a fixed order, and files end in a single newline.
+//
+// Formatting edits the source rather than printing the AST anew, so whatever
+// isn't normalised stays exactly as written. Every change is checked against
+// the renderer too: changes that would make a note render differently are
+// left out.
+package format
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/flonle/mdbuddy/renderer"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// Replace source[start:stop] with text.
+type edit struct {
+	start, stop int
+	text        string
+}
+
+// Edits that only make sense together, like those to the rows of one table.
+type change []edit
+
+// The goldmark instance formatted notes must render the same with.
+var markdown = renderer.NewMarkdown(renderer.Options{})
+
+// Format the markdown `source`. The result renders to the same HTML, with the
+// same front matter, as `source` does.
+func Source(source []byte) ([]byte, error) {
+	want := render(source)
+	doc := renderer.Parse(source)
+
+	var changes []change
+	changes = append(changes, frontMatter(source)...)
+	changes = append(changes, listMarkers(source, doc)...)
+	changes = append(changes, emphasis(source, doc)...)
+	changes = append(changes, headings(source, doc)...)
+	changes = append(changes, tables(source, doc)...)
+	changes = append(changes, finalNewline(source)...)
+	if len(changes) == 0 {
+		return source, nil
+	}
+
+	formatted := apply(source, changes)
+	if bytes.Equal(render(formatted), want) {
+		return formatted, nil
+	}
+
+	// Some change breaks the note; keep the ones that don't, one by one
+	var kept []change
+	for _, c := range changes {
+		candidate := append(kept[:len(kept):len(kept)], c)
+		if bytes.Equal(render(apply(source, candidate)), want) {
+			kept = candidate
+		}
+	}
+	formatted = apply(source, kept)
+	if !bytes.Equal(render(formatted), want) {
+		return nil, fmt.Errorf("formatting would change how the note renders")
+	}
+	return formatted, nil
+}
+
+// Apply the edits of `changes` to `source`. Edits that overlap earlier ones
+// are dropped.
+func apply(source []byte, changes []change) []byte {
+	edits := slices.Concat(changes...)
+	slices.SortStableFunc(edits, func(a, b edit) int {
+		return cmp.Or(cmp.Compare(a.start, b.start), cmp.Compare(a.stop, b.stop))
+	})
+	var out bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		if e.start < pos {
+			continue
+		}
+		out.Write(source[pos:e.start])
+		out.WriteString(e.text)
+		pos = e.stop
+	}
+	out.Write(source[pos:])
+	return out.Bytes()
+}
+
+// What `source` renders to, followed by its front matter: what formatting
+// must not change.
+func render(source []byte) []byte {
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	var out bytes.Buffer
+	if err := markdown.Renderer().Render(&out, source, doc); err != nil {
+		// Compared as is, so that formatting can't make it fail either
+		fmt.Fprintf(&out, "\n%v", err)
+	}
+	// Maps print sorted by key, so key order doesn't matter
+	fmt.Fprintf(&out, "\n%#v", doc.(*ast.Document).Meta())
+	return out.Bytes()
+}
@@ -0,0 +1,95 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flonle/mdbuddy/renderer"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// Notes that every rule has something to change in, and ways to get them
+// wrong, and what they're formatted as. Changes that would make a note render
+// differently are left out, which leaves some notes, and parts of others, as
+// they are.
+var testNotes = []struct {
+	name, source, want string
+}{
+	{"lists",
+		"* one\n* two\n  + nested\n  + nested\n\n1) first\n2) second\n\n+ loose\n\n+ list\n",
+		"- one\n- two\n  - nested\n  - nested\n\n1. first\n2. second\n\n- loose\n\n- list\n"},
+	{"lists next to each other",
+		"* one\n* two\n\n- other\n- list\n\n1. first\n\n1) other\n",
+		"* one\n* two\n\n- other\n- list\n\n1. first\n\n1) other\n"},
+	{"emphasis",
+		"Some _emphasis_, __strong__ text, and snake_case_words.\n\n_Nested __strong__ inside_, `_code_` and a [_link_](https://example.com).\n",
+		"Some *emphasis*, **strong** text, and snake_case_words.\n\n*Nested **strong** inside*, `_code_` and a [*link*](https://example.com).\n"},
+	{"emphasis next to punctuation",
+		"__init__ is *fine*, but _**both**_ and *_mixed_* are tricky.\n",
+		"**init** is *fine*, but ***both*** and *_mixed_* are tricky.\n"},
+	// The spaces inside "###   Spaced   ###" end up in the HTML
+	{"headings",
+		"Title\n=====\n\nSection\n-------\n\n## Closed ##\n\n###   Spaced   ###\n\n####  Indented\n\n#hashtag, not a heading\n",
+		"# Title\n\n## Section\n\n## Closed\n\n###   Spaced   ###\n\n#### Indented\n\n#hashtag, not a heading\n"},
+	{"table",
+		"| a | longer header |\n|:-|-:|\n| wider cell | b |\n| c | `d \\| e` |\n",
+		"| a          | longer header |\n| :--------- | ------------: |\n| wider cell |             b |\n| c          |      `d \\| e` |\n"},
+	{"front matter",
+		"---\n# A comment\ntags: [a, b]\ntitle: Front matter\nzeta: 1\nalpha: 2\ndate: 2024-01-02\n---\n\n# Body\n",
+		"---\n# A comment\ntitle: Front matter\ntags: [a, b]\ndate: 2024-01-02\nalpha: 2\nzeta: 1\n---\n\n# Body\n"},
+	{"final newline",
+		"# No final newline\n\ntext",
+		"# No final newline\n\ntext\n"},
+	{"extra final newlines",
+		"# Extra final newlines\n\ntext\n\n\n",
+		"# Extra final newlines\n\ntext\n"},
+	{"callouts and wikilinks",
+		"> [!note] Title\n> * a [[link|label]]\n> * b _c_\n\n> [!tip]\n> text\n",
+		"> [!note] Title\n> - a [[link|label]]\n> - b *c*\n\n> [!tip]\n> text\n"},
+	{"code",
+		"```md\n* not a list\n_not emphasis_\nNot a heading\n---\n```\n\n    * indented code\n",
+		"```md\n* not a list\n_not emphasis_\nNot a heading\n---\n```\n\n    * indented code\n"},
+	{"html",
+		"<div>\n\n* list in html\n\n</div>\n\n<em>_raw_</em>\n",
+		"<div>\n\n- list in html\n\n</div>\n\n<em>*raw*</em>\n"},
+}
+
+// What a note renders to: its HTML, table of contents and front matter.
+type rendered struct {
+	content, toc string
+	meta         map[string]any
+}
+
+func renderNote(t *testing.T, source []byte) rendered {
+	t.Helper()
+	content, toc, err := renderer.RenderNoteContent(source, renderer.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rendered{string(content), string(toc), renderer.Parse(source).(*ast.Document).Meta()}
+}
+
+func TestSource(t *testing.T) {
+	for _, tt := range testNotes {
+		formatted, err := Source([]byte(tt.source))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(formatted) != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, formatted, tt.want)
+		}
+		before, after := renderNote(t, []byte(tt.source)), renderNote(t, formatted)
+		if !reflect.DeepEqual(before, after) {
+			t.Errorf("%s: formatting\n%s\nas\n%s\nchanged how it renders from\n%+v\nto\n%+v", tt.name, tt.source, formatted, before, after)
+		}
+
+		again, err := Source(formatted)
+		if err != nil {
+			t.Errorf("%s: formatting again: %v", tt.name, err)
+		} else if string(again) != string(formatted) {
+			t.Errorf("%s: formatting again changed\n%s\nto\n%s", tt.name, formatted, again)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package format
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+
+	"github.com/rivo/uniseg"
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"gopkg.in/yaml.v3"
+)
+
+// Bullet list markers become '-', and ordered list markers '.': "1." rather
+// than "1)".
+func listMarkers(source []byte, doc ast.Node) []change {
+	var changes []change
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		list, ok := n.(*ast.List)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		want := byte('-')
+		if list.IsOrdered() {
+			want = '.'
+		}
+		if list.Marker == want {
+			return ast.WalkContinue, nil
+		}
+		// All items at once, or they'd make lists of their own
+		var c change
+		for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+			at := markerOffset(source, item.(*ast.ListItem))
+			if at < 0 {
+				return ast.WalkContinue, nil
+			}
+			c = append(c, edit{at, at + 1, string(want)})
+		}
+		changes = append(changes, c)
+		return ast.WalkContinue, nil
+	})
+	return changes
+}
+
+// The offset of the marker of `item` in `source`: its bullet, or the '.' or
+// ')' after its number. -1 if it can't be told.
+func markerOffset(source []byte, item *ast.ListItem) int {
+	// Down to the first line of the item, counting the items that start on
+	// it too, like the inner one of "- - item"
+	nested := 0
+	n := item.FirstChild()
+	for n != nil && n.Lines().Len() == 0 {
+		if _, ok := n.(*ast.ListItem); ok {
+			nested++
+		}
+		n = n.FirstChild()
+	}
+	if _, fenced := n.(*ast.FencedCodeBlock); n == nil || fenced {
+		return -1 // Empty, or its lines start below the fence
+	}
+
+	// The markers in front of it, among the '>'s of block quotes
+	start := n.Lines().At(0).Start
+	lineStart := bytes.LastIndexByte(source[:start], '\n') + 1
+	var markers []int
+scan:
+	for i := lineStart; i < start; i++ {
+		switch c := source[i]; {
+		case c == ' ' || c == '\t' || c == '>':
+		case c == '-' || c == '*' || c == '+':
+			markers = append(markers, i)
+		case '0' <= c && c <= '9':
+			for i < start && '0' <= source[i] && source[i] <= '9' {
+				i++
+			}
+			if i == start || (source[i] != '.' && source[i] != ')') {
+				return -1
+			}
+			markers = append(markers, i)
+		default:
+			break scan
+		}
+	}
+	i := len(markers) - 1 - nested
+	if i < 0 || source[markers[i]] != item.Parent().(*ast.List).Marker {
+		return -1
+	}
+	return markers[i]
+}
+
+// Emphasis uses '*' rather than '_': *emphasis* and **strong emphasis**.
+func emphasis(source []byte, doc ast.Node) []change {
+	var changes []change
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		e, ok := n.(*ast.Emphasis)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		start, stop := inlineStart(e), inlineStop(source, e)
+		if start < 0 || stop < 0 {
+			return ast.WalkContinue, nil
+		}
+		underscores := strings.Repeat("_", e.Level)
+		if string(source[start:start+e.Level]) == underscores && string(source[stop-e.Level:stop]) == underscores {
+			stars := strings.Repeat("*", e.Level)
+			changes = append(changes, change{{start, start + e.Level, stars}, {stop - e.Level, stop, stars}})
+		}
+		return ast.WalkContinue, nil
+	})
+	return changes
+}
+
+// The offset where the inline `n` starts in the source, delimiters included,
+// or -1 if it can't be told.
+func inlineStart(n ast.Node) int {
+	switch n := n.(type) {
+	case *ast.Text:
+		return n.Segment.Start
+	case *ast.Emphasis:
+		if start := inlineStart(n.FirstChild()); start >= n.Level {
+			return start - n.Level
+		}
+	}
+	return -1
+}
+
+// The offset where the inline `n` stops in `source`, delimiters included, or
+// -1 if it can't be told.
+func inlineStop(source []byte, n ast.Node) int {
+	switch n := n.(type) {
+	case *ast.Text:
+		return n.Segment.Stop
+	case *ast.Emphasis:
+		if stop := inlineStop(source, n.LastChild()); stop >= 0 && stop+n.Level <= len(source) {
+			return stop + n.Level
+		}
+	}
+	return -1
+}
+
+// Headings are ATX headings, with one space after the #'s and no closing
+// sequence: "## Heading" rather than "##  Heading ##", or "Heading" underlined
+// with "-".
+func headings(source []byte, doc ast.Node) []change {
+	var changes []change
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok || h.Lines().Len() == 0 {
+			return ast.WalkContinue, nil
+		}
+		content := h.Lines().At(0)
+		lineStart := bytes.LastIndexByte(source[:content.Start], '\n') + 1
+		hashes := strings.Repeat("#", h.Level) + " "
+
+		if i := bytes.IndexByte(source[lineStart:content.Start], '#'); i >= 0 {
+			var c change
+			if start := lineStart + i; string(source[start:content.Start]) != hashes {
+				c = append(c, edit{start, content.Start, hashes})
+			}
+			stop := content.Stop
+			for stop > content.Start && (source[stop-1] == ' ' || source[stop-1] == '\t') {
+				stop--
+			}
+			if end := lineEnd(source, content.Start); stop < end {
+				c = append(c, edit{stop, end, ""})
+			}
+			if c != nil {
+				changes = append(changes, c)
+			}
+			return ast.WalkContinue, nil
+		}
+
+		// Setext headings of one line, outside of lists and block quotes;
+		// ones ending in '#' would lose it as a closing sequence
+		title := bytes.TrimSpace(content.Value(source))
+		if h.Lines().Len() > 1 || h.Parent().Kind() != ast.KindDocument || len(title) == 0 || title[len(title)-1] == '#' {
+			return ast.WalkContinue, nil
+		}
+		underline := lineEnd(source, nextLine(source, content.Start))
+		changes = append(changes, change{{lineStart, underline, hashes + string(title)}})
+		return ast.WalkContinue, nil
+	})
+	return changes
+}
+
+// The offset of the end of the line `offset` is on, before its line ending.
+func lineEnd(source []byte, offset int) int {
+	end := offset + bytes.IndexByte(source[offset:], '\n')
+	if end < offset {
+		return len(source)
+	}
+	if end > offset && source[end-1] == '\r' {
+		end--
+	}
+	return end
+}
+
+// The offset of the start of the line after the one `offset` is on, or the
+// end of `source` if there's none.
+func nextLine(source []byte, offset int) int {
+	if i := bytes.IndexByte(source[offset:], '\n'); i >= 0 {
+		return offset + i + 1
+	}
+	return len(source)
+}
+
+// Tables are aligned: cells are padded to the width of their column, as
+// their alignment says, between "| " and " |".
+func tables(source []byte, doc ast.Node) []change {
+	var changes []change
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := n.(*extast.Table); entering && ok {
+			if c := table(source, t); c != nil {
+				changes = append(changes, c)
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return changes
+}
+
+func table(source []byte, t *extast.Table) change {
+	// The cells of every row, and where the row starts and stops, after the
+	// prefix of whatever it's in; the delimiter row is rows[1]
+	type row struct {
+		cells       []string
+		start, stop int
+	}
+	var rows []row
+	for r := t.FirstChild(); r != nil; r = r.NextSibling() {
+		var cells []string
+		first, last := -1, -1
+		for cell := r.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			if cell.Lines().Len() == 0 {
+				cells = append(cells, "") // Missing from the source
+				continue
+			}
+			segment := cell.Lines().At(0)
+			if first < 0 {
+				first = segment.Start
+			}
+			last = segment.Stop
+			cells = append(cells, string(segment.Value(source)))
+		}
+		if first < 0 {
+			return nil
+		}
+		lineStart := bytes.LastIndexByte(source[:first], '\n') + 1
+		start := first
+		for start > lineStart && (source[start-1] == ' ' || source[start-1] == '\t') {
+			start--
+		}
+		if start > lineStart && source[start-1] == '|' {
+			start--
+		} else {
+			start = first
+		}
+		stop := lineEnd(source, first)
+		if rest := strings.TrimSpace(string(source[last:stop])); rest != "" && rest != "|" {
+			return nil // Cells past the header's, which aren't rendered
+		}
+		rows = append(rows, row{cells, start, stop})
+
+		if len(rows) == 1 {
+			// The delimiter row
+			next := nextLine(source, stop)
+			start := next + bytes.IndexAny(source[next:lineEnd(source, next)], "|-:")
+			if start < next {
+				return nil
+			}
+			rows = append(rows, row{nil, start, lineEnd(source, next)})
+		}
+	}
+
+	// Rows are on consecutive lines
+	for i := 1; i < len(rows); i++ {
+		if bytes.Count(source[rows[i-1].stop:rows[i].start], []byte("\n")) != 1 {
+			return nil
+		}
+	}
+
+	widths := make([]int, len(t.Alignments))
+	for _, r := range rows {
+		for i, cell := range r.cells {
+			if i < len(widths) {
+				widths[i] = max(widths[i], 3, uniseg.StringWidth(cell))
+			}
+		}
+	}
+	var c change
+	for i, r := range rows {
+		cells := make([]string, len(widths))
+		for col, a := range t.Alignments {
+			if i == 1 {
+				cells[col] = delimiter(a, widths[col])
+			} else if col < len(r.cells) {
+				cells[col] = pad(r.cells[col], a, widths[col])
+			} else {
+				cells[col] = pad("", a, widths[col])
+			}
+		}
+		line := "| " + strings.Join(cells, " | ") + " |"
+		if line != string(source[r.start:r.stop]) {
+			c = append(c, edit{r.start, r.stop, line})
+		}
+	}
+	return c
+}
+
+// A cell of the delimiter row of a column.
+func delimiter(a extast.Alignment, width int) string {
+	switch a {
+	case extast.AlignLeft:
+		return ":" + strings.Repeat("-", width-1)
+	case extast.AlignRight:
+		return strings.Repeat("-", width-1) + ":"
+	case extast.AlignCenter:
+		return ":" + strings.Repeat("-", width-2) + ":"
+	}
+	return strings.Repeat("-", width)
+}
+
+// Pad `cell` to `width` as its column's alignment says.
+func pad(cell string, a extast.Alignment, width int) string {
+	gap := width - uniseg.StringWidth(cell)
+	switch a {
+	case extast.AlignRight:
+		return strings.Repeat(" ", gap) + cell
+	case extast.AlignCenter:
+		return strings.Repeat(" ", gap/2) + cell + strings.Repeat(" ", gap-gap/2)
+	}
+	return cell + strings.Repeat(" ", gap)
+}
+
+// The front matter keys formatted notes start with, in this order: the ones
+// MDBuddy reads. Other keys follow in alphabetical order.
+var frontMatterKeys = []string{
+	"title", "aliases", "alias", "tags",
+	"date", "created", "updated", "modified", "lastmod",
+	"publish", "draft", "visibility",
+}
+
+// YAML front matter keys come in the order of frontMatterKeys. Every key
+// moves with the lines below it, comments and all; lines above the first key
+// stay on top. TOML front matter is left alone.
+func frontMatter(source []byte) []change {
+	// Between two lines of the same number of '-'s, like goldmark's
+	// frontmatter extension wants
+	open := lineEnd(source, 0)
+	if open < 3 || strings.Trim(string(source[:open]), "-") != "" {
+		return nil
+	}
+	bodyStart := nextLine(source, open)
+	bodyStop := -1
+	for i := bodyStart; i < len(source); {
+		stop := lineEnd(source, i)
+		if string(source[i:stop]) == string(source[:open]) {
+			bodyStop = i
+			break
+		}
+		i = nextLine(source, stop)
+	}
+	if bodyStop < 0 {
+		return nil
+	}
+	body := source[bodyStart:bodyStop]
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	mapping := doc.Content[0]
+	if mapping.Kind != yaml.MappingNode || mapping.Style&yaml.FlowStyle != 0 {
+		return nil
+	}
+	lines := []int{0}
+	for i, b := range body {
+		if b == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	type entry struct {
+		key         string
+		start, stop int // Of its lines in body
+	}
+	var entries []entry
+	for i := 0; i < len(mapping.Content); i += 2 {
+		key := mapping.Content[i]
+		if key.Kind != yaml.ScalarNode || key.Column != 1 || key.Line > len(lines) {
+			return nil
+		}
+		if len(entries) > 0 {
+			entries[len(entries)-1].stop = lines[key.Line-1]
+		}
+		entries = append(entries, entry{key.Value, lines[key.Line-1], len(body)})
+	}
+
+	rank := func(key string) int {
+		if i := slices.Index(frontMatterKeys, key); i >= 0 {
+			return i
+		}
+		return len(frontMatterKeys)
+	}
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, func(a, b entry) int {
+		if ra, rb := rank(a.key), rank(b.key); ra != rb {
+			return ra - rb
+		}
+		if rank(a.key) < len(frontMatterKeys) {
+			return 0
+		}
+		return strings.Compare(a.key, b.key)
+	})
+	if slices.Equal(sorted, entries) || len(entries) == 0 {
+		return nil
+	}
+	var text strings.Builder
+	for _, e := range sorted {
+		block := string(body[e.start:e.stop])
+		if !strings.HasSuffix(block, "\n") {
+			block += "\n"
+		}
+		text.WriteString(block)
+	}
+	return []change{{{bodyStart + entries[0].start, bodyStop, text.String()}}}
+}
+
+// Files end in exactly one newline.
+func finalNewline(source []byte) []change {
+	content := len(bytes.TrimRight(source, " \t\r\n"))
+	if content == 0 {
+		return nil
+	}
+	end := lineEnd(source, content)
+	newline := "\n"
+	if end < len(source) && source[end] == '\r' {
+		newline = "\r\n"
+	}
+	if string(source[end:]) == newline {
+		return nil
+	}
+	return []change{{{end, len(source), newline}}}
+}
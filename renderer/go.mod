@@ -5,18 +5,25 @@ go 1.25.4
 require (
 	github.com/alecthomas/chroma/v2 v2.20.0
 	github.com/flonle/mdbuddy/assets v0.0.0
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/rivo/uniseg v0.4.7
 	github.com/wyatt915/goldmark-treeblood v0.0.1
 	github.com/yuin/goldmark v1.7.13
 	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20230729083705-37449abec8cc
 	go.abhg.dev/goldmark/anchor v0.2.0
+	go.abhg.dev/goldmark/frontmatter v0.2.0
 	go.abhg.dev/goldmark/hashtag v0.4.0
 	go.abhg.dev/goldmark/toc v0.12.0
 	go.abhg.dev/goldmark/wikilink v0.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/BurntSushi/toml v1.2.1 // indirect
+	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/dlclark/regexp2 v1.11.5 // indirect
 	github.com/forPelevin/gomoji v1.3.0 // indirect
-	github.com/rivo/uniseg v0.4.7 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
 	github.com/wyatt915/treeblood v0.1.16 // indirect
+	golang.org/x/net v0.26.0 // indirect
 )
@@ -2,6 +2,7 @@ package goldmarkextension
 
 import (
 	"regexp"
+	"strings"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
@@ -32,7 +33,36 @@ func (n *CalloutNode) Dump(source []byte, level int) {
 // Transformer that converts blockquotes starting with [!type] into callouts
 type CalloutTransformer struct{}
 
-var calloutRegex = regexp.MustCompile(`^\[!([a-zA-Z-]+)\](?:\s+(.*))?`)
+// [!type], a fold marker, the space before the title, and the title.
+var calloutRegex = regexp.MustCompile(`^\[!([a-zA-Z-]+)\]([+-]?)([ \t]*)(.*?)\s*$`)
+
+// The parts of the first line of a callout, as segments of the source. Parts
+// that are missing are empty, and start where they would be.
+type CalloutLine struct {
+	Type  text.Segment // Between "[!" and "]"
+	Fold  text.Segment // "+" or "-" right after "]"
+	Space text.Segment // Between the type, or fold marker, and the title
+	Title text.Segment // The rest of the line, without trailing space
+}
+
+// Match `line`, the first line of a blockquote in `source`, as the first line
+// of a callout. Callouts can't be folded, so the renderer drops a fold marker
+// along with the title after it, and a title right after "]" too.
+func MatchCallout(source []byte, line text.Segment) (CalloutLine, bool) {
+	match := calloutRegex.FindSubmatchIndex(line.Value(source))
+	if match == nil {
+		return CalloutLine{}, false
+	}
+	segment := func(group int) text.Segment {
+		return text.NewSegment(line.Start+match[2*group], line.Start+match[2*group+1])
+	}
+	return CalloutLine{Type: segment(1), Fold: segment(2), Space: segment(3), Title: segment(4)}, true
+}
+
+// Report whether the renderer shows the title of the callout.
+func (l CalloutLine) Titled() bool {
+	return l.Fold.IsEmpty() && !l.Space.IsEmpty() && !l.Title.IsEmpty()
+}
 
 func (t *CalloutTransformer) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
 	source := reader.Source()
@@ -64,19 +94,18 @@ func (t *CalloutTransformer) Transform(node *ast.Document, reader text.Reader, p
 		}
 
 		firstLine := para.Lines().At(0)
-		lineText := firstLine.Value(source)
-
-		matches := calloutRegex.FindSubmatch(lineText)
-		if matches == nil {
+		match, ok := MatchCallout(source, firstLine)
+		if !ok {
 			return ast.WalkContinue, nil
 		}
 
 		// Create callout node
 		callout := &CalloutNode{
-			CalloutType: string(matches[1]),
+			CalloutType: string(match.Type.Value(source)),
 		}
-		if len(matches) > 2 {
-			callout.Title = string(matches[2])
+		if match.Titled() {
+			// The title as written, trailing space and all
+			callout.Title = strings.TrimSuffix(string(source[match.Title.Start:firstLine.Stop]), "\n")
 		}
 
 		// Remove inline nodes belonging to first line (title)
@@ -184,6 +213,13 @@ var calloutMapping = map[string]struct {
 	"cite":      {"neutral", "quote-left"},
 }
 
+// Report whether `calloutType` has a look of its own. Others, including
+// types that aren't lowercase, look like notes.
+func IsCalloutType(calloutType string) bool {
+	_, ok := calloutMapping[calloutType]
+	return ok
+}
+
 func (r *CalloutHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
 	reg.Register(KindCallout, r.renderCallout)
 }
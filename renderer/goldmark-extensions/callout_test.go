@@ -0,0 +1,106 @@
+package goldmarkextension
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+var testMarkdown = goldmark.New(goldmark.WithExtensions(&CalloutExtender{}))
+
+// The callouts in `source`, in order, as "type: title".
+func callouts(source string) []string {
+	doc := testMarkdown.Parser().Parse(text.NewReader([]byte(source)))
+	var found []string
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if callout, ok := n.(*CalloutNode); ok && entering {
+			found = append(found, callout.CalloutType+": "+callout.Title)
+		}
+		return ast.WalkContinue, nil
+	})
+	return found
+}
+
+func TestCalloutTransformer(t *testing.T) {
+	tests := []struct {
+		name, source string
+		want         []string
+	}{
+		{"title", "> [!note] Title\n> body\n", []string{"note: Title"}},
+		{"no title", "> [!tip]\n> body\n", []string{"tip: "}},
+		{"in a list", "* item\n\n  > [!bug] In a list\n", []string{"bug: In a list"}},
+		{"title as written", "> [!info]   Spaced   title \t\n> body\n", []string{"info: Spaced   title \t"}},
+		{"folded", "> [!warning]- Folded\n> body\n", []string{"warning: "}},
+		{"unfolded", "> [!note]+ Open\n", []string{"note: "}},
+		{"title right after ]", "> [!note]Glued\n", []string{"note: "}},
+		{"type case kept", "> [!NOTE] Upper\n", []string{"NOTE: Upper"}},
+		{"not a callout", "> [!not a type] text\n\n> [note] text\n\n> text [!note]\n", nil},
+	}
+	for _, tt := range tests {
+		if got := callouts(tt.source); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCalloutRenderer(t *testing.T) {
+	tests := []struct{ source, want string }{
+		{"> [!tip] A *title* & more\n> body\n",
+			`<wa-callout variant="success"><wa-icon slot="icon" name="lightbulb" variant="regular"></wa-icon>` +
+				`<strong>A *title* &amp; more</strong><br /><p>body</p>`},
+		// Unknown types look like notes
+		{"> [!custom]\n> text\n",
+			`<wa-callout variant="brand"><wa-icon slot="icon" name="circle-info" variant="regular"></wa-icon><p>text</p>`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := testMarkdown.Convert([]byte(tt.source), &buf); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), tt.want) {
+			t.Errorf("got\n%s\nwant it to contain\n%s", buf.String(), tt.want)
+		}
+	}
+}
+
+func TestMatchCallout(t *testing.T) {
+	tests := []struct {
+		line                     string
+		ok                       bool
+		kind, fold, space, title string
+		titled                   bool
+	}{
+		{"[!note] Title", true, "note", "", " ", "Title", true},
+		{"[!note]", true, "note", "", "", "", false},
+		{"[!my-type]-  Folded ", true, "my-type", "-", "  ", "Folded", false},
+		{"[!note]+", true, "note", "+", "", "", false},
+		{"[!note]Glued", true, "note", "", "", "Glued", false},
+		{"[!note] ", true, "note", "", " ", "", false},
+		{"[!no te] Title", false, "", "", "", "", false},
+		{"text [!note]", false, "", "", "", "", false},
+	}
+	for _, tt := range tests {
+		// Somewhere in the middle of the source, as lines are
+		source := []byte("> " + tt.line + "\n")
+		match, ok := MatchCallout(source, text.NewSegment(2, len(source)))
+		if ok != tt.ok {
+			t.Errorf("%q: got ok %t", tt.line, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		got := []string{
+			string(match.Type.Value(source)), string(match.Fold.Value(source)),
+			string(match.Space.Value(source)), string(match.Title.Value(source)),
+		}
+		if want := []string{tt.kind, tt.fold, tt.space, tt.title}; !slices.Equal(got, want) || match.Titled() != tt.titled {
+			t.Errorf("%q: got parts %q and titled %t, want %q and %t", tt.line, got, match.Titled(), want, tt.titled)
+		}
+	}
+}
@@ -62,7 +62,9 @@ func (s *hashtagParser) Parse(parent ast.Node, block text.Reader, pc parser.Cont
 }
 
 // Create Renderer
-type hashtagHTMLRenderer struct{}
+type hashtagHTMLRenderer struct {
+	url func(tag []byte) []byte
+}
 
 func (r *hashtagHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
 	reg.Register(KindHashtag, r.renderHashtag)
@@ -70,10 +72,19 @@ func (r *hashtagHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegiste
 
 func (r *hashtagHTMLRenderer) renderHashtag(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if entering {
-		escapedTag := util.EscapeHTML(node.Text(source))
-
-		_, _ = w.WriteString(`<wa-tag size="small" appearance="filled" pill><a href="/tags/"`)
-		_, _ = w.Write(escapedTag)
+		tag := node.Text(source)
+		escapedTag := util.EscapeHTML(tag)
+
+		url := r.url(tag)
+		if url == nil {
+			_, _ = w.WriteString(`<wa-tag size="small" appearance="filled" pill>#`)
+			_, _ = w.Write(escapedTag)
+			_, _ = w.WriteString(`</wa-tag>`)
+			return ast.WalkSkipChildren, nil
+		}
+
+		_, _ = w.WriteString(`<wa-tag size="small" appearance="filled" pill><a href="`)
+		_, _ = w.Write(util.EscapeHTML(util.URLEscape(url, true)))
 		_, _ = w.WriteString(`">#`)
 		_, _ = w.Write(escapedTag)
 		_, _ = w.WriteString(`</a></wa-tag>`)
@@ -84,9 +95,22 @@ func (r *hashtagHTMLRenderer) renderHashtag(w util.BufWriter, source []byte, nod
 }
 
 // Create Extension
-type HashtagExtension struct{}
+type HashtagExtension struct {
+	// Returns the URL a hashtag links to, or nil to not link it. Defaults to
+	// "/tags/<tag>".
+	URL func(tag []byte) []byte
+}
+
+func defaultHashtagURL(tag []byte) []byte {
+	return append([]byte("/tags/"), tag...)
+}
 
 func (e *HashtagExtension) Extend(m goldmark.Markdown) {
+	url := e.URL
+	if url == nil {
+		url = defaultHashtagURL
+	}
+
 	m.Parser().AddOptions(
 		parser.WithInlineParsers(
 			util.Prioritized(&hashtagParser{}, 500),
@@ -94,7 +118,7 @@ func (e *HashtagExtension) Extend(m goldmark.Markdown) {
 	)
 	m.Renderer().AddOptions(
 		renderer.WithNodeRenderers(
-			util.Prioritized(&hashtagHTMLRenderer{}, 500),
+			util.Prioritized(&hashtagHTMLRenderer{url: url}, 500),
 		),
 	)
 }
@@ -0,0 +1,138 @@
+package renderer
+
+import (
+	customExtensions "github.com/flonle/mdbuddy/renderer/goldmark-extensions"
+
+	treeblood "github.com/wyatt915/goldmark-treeblood"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	gmHtml "github.com/yuin/goldmark/renderer/html"
+	gmText "github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	anchor "go.abhg.dev/goldmark/anchor"
+	"go.abhg.dev/goldmark/frontmatter"
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// Options tweak the goldmark configuration returned by NewMarkdown.
+// The zero value gives the same output as `mdbuddy render`.
+type Options struct {
+	// Resolves wikilink targets to URLs. Defaults to wikilink.DefaultResolver,
+	// which just appends ".html" to the target.
+	WikilinkResolver wikilink.Resolver
+
+	// Rewrites the destinations of regular links and images, e.g. to turn
+	// links to other notes into links to their rendered pages. Links for which
+	// it returns nil are rendered as plain text. Destinations are left as-is if
+	// LinkResolver is nil.
+	LinkResolver func(destination []byte) []byte
+
+	// Returns the URL a hashtag links to, or nil to not link it. Defaults to
+	// "/tags/<tag>".
+	HashtagURL func(tag []byte) []byte
+
+	// Run the output of RenderNoteContent through this, for notes that may
+	// not contain arbitrary HTML. Raw HTML is passed through as-is if nil.
+	Sanitizer *Sanitizer
+
+	// Look RenderNoteContent's output up in, and store it in, this cache.
+	// Only used if CacheKey is set too.
+	Cache *Cache
+
+	// Identifies the output of the functions above, so that notes rendered
+	// with different ones aren't mixed up in Cache: two renders of the same
+	// input with the same CacheKey must have the same output.
+	CacheKey string
+}
+
+// Create the goldmark instance that every part of MDBuddy uses to parse and
+// render notes, so that the CLI, the servers and the vault tooling all agree
+// on what a note looks like.
+func NewMarkdown(opts Options) goldmark.Markdown {
+	md := goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.Footnote,
+			treeblood.MathML(),
+			highlighting.NewHighlighting(
+				highlighting.WithCustomStyle(catpuccinFrappeNoBg),
+			),
+			&wikilink.Extender{
+				Resolver: opts.WikilinkResolver,
+			},
+			&customExtensions.HashtagExtension{
+				URL: opts.HashtagURL,
+			},
+			&anchor.Extender{
+				Texter: anchor.Text("#"),
+			},
+			&customExtensions.CalloutExtender{},
+			// YAML (---) or TOML (+++) front matter ends up in the document's
+			// metadata instead of the output.
+			&frontmatter.Extender{
+				Mode: frontmatter.SetMetadata,
+			},
+		),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+		),
+		goldmark.WithRendererOptions(
+			gmHtml.WithHardWraps(),
+			gmHtml.WithXHTML(),
+			gmHtml.WithUnsafe(),
+		),
+	)
+	if opts.LinkResolver != nil {
+		md.Parser().AddOptions(parser.WithASTTransformers(
+			util.Prioritized(&linkTransformer{resolve: opts.LinkResolver}, 999),
+		))
+	}
+	return md
+}
+
+// Rewrites the destinations of all links and images in a document.
+type linkTransformer struct {
+	resolve func(destination []byte) []byte
+}
+
+func (t *linkTransformer) Transform(doc *ast.Document, reader gmText.Reader, pc parser.Context) {
+	var unlinked []*ast.Link
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			if n.Destination = t.resolve(n.Destination); n.Destination == nil {
+				unlinked = append(unlinked, n)
+			}
+		case *ast.Image:
+			if dest := t.resolve(n.Destination); dest != nil {
+				n.Destination = dest
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	// Replace links with their text; not while walking the tree
+	for _, link := range unlinked {
+		parent := link.Parent()
+		for child := link.FirstChild(); child != nil; child = link.FirstChild() {
+			parent.InsertBefore(parent, link, child)
+		}
+		parent.RemoveChild(parent, link)
+	}
+}
+
+// The goldmark instance used by Parse. Parsing doesn't depend on any of the
+// Options, so one shared instance suffices.
+var parseMarkdown = NewMarkdown(Options{})
+
+// Parse the given markdown source into a goldmark AST, with all of MDBuddy's
+// extensions enabled. Segments in the returned tree point into `source`.
+func Parse(source []byte) ast.Node {
+	return parseMarkdown.Parser().Parse(gmText.NewReader(source))
+}
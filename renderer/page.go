@@ -0,0 +1,171 @@
+package renderer
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"path"
+
+	"github.com/flonle/mdbuddy/assets"
+
+	gmText "github.com/yuin/goldmark/text"
+	toc "go.abhg.dev/goldmark/toc"
+)
+
+// Every page in static/templates/pages extends layout.html, with the blocks
+// shared between pages filled in by static/templates/partials.
+var baseTmpl = template.Must(template.Must(
+	template.ParseFS(assets.FS, "static/templates/layout.html")).
+	ParseFS(assets.FS, "static/templates/partials/*.html"))
+
+// page filename : template
+var pageTmpls = parsePageTemplates()
+
+func parsePageTemplates() map[string]*template.Template {
+	names, err := fs.Glob(assets.FS, "static/templates/pages/*.html")
+	if err != nil {
+		panic(err)
+	}
+	tmpls := map[string]*template.Template{}
+	for _, name := range names {
+		tmpls[path.Base(name)] = template.Must(template.Must(baseTmpl.Clone()).ParseFS(assets.FS, name))
+	}
+	return tmpls
+}
+
+// The stylesheets and scripts of every page, relative to static/.
+var (
+	pageStylesheets = []string{"css/layout.css", "css/content.css", "css/table_of_contents.css"}
+	pageScripts     = []string{"js/table_of_contents.js"}
+
+	pageCSS = template.CSS(concatAssets(pageStylesheets...))
+	pageJS  = template.JS(concatAssets(pageScripts...))
+)
+
+// Data for the pages in static/templates/pages.
+type Page struct {
+	Title   string
+	Content template.HTML // Main content
+	TOC     template.HTML // Table of contents, shown in the outline
+	Nav     []NavLink     // Links shown in the sidebar
+	Root    string        // URL of the site root: "/" when served, relative in static builds
+	Search  bool          // Whether to show the search box in the header
+	Query   string        // Prefills the search box
+	Feed    string        // URL of the page's Atom feed, if it has one
+	User    string        // Name of the logged-in user, if any
+	CSRF    string        // Token to include in forms posted back to the server
+	Share   string        // Vault-relative path the page can be shared as; empty if it can't
+	Data    any           // Anything specific to the page template
+	Nonce   string        // For the scripts and styles, if served with a Content-Security-Policy
+
+	// URL the embedded assets are served at under their fingerprinted names
+	// (see assets.Fingerprint), e.g. "/static/". Stylesheets and scripts are
+	// linked from there instead of inlined into the page if set.
+	Static string
+
+	// Set by RenderPage: the stylesheets and scripts, either inline or as URLs
+	CSS         template.CSS
+	JS          template.JS
+	Stylesheets []string
+	Scripts     []string
+}
+
+type NavLink struct {
+	Title   string
+	URL     string
+	Current bool // Whether this link points to the page it's shown on
+}
+
+// Render the page template `name` (e.g. "note.html") to `output`.
+func RenderPage(output io.Writer, name string, page Page) error {
+	t, ok := pageTmpls[name]
+	if !ok {
+		return fmt.Errorf("no such page template: %s", name)
+	}
+	if page.Root == "" {
+		page.Root = "/"
+	}
+	if page.Static != "" {
+		page.Stylesheets = staticURLs(page.Static, pageStylesheets)
+		page.Scripts = staticURLs(page.Static, pageScripts)
+	} else {
+		page.CSS = pageCSS
+		page.JS = pageJS
+	}
+	if err := t.ExecuteTemplate(output, "layout.html", page); err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+	return nil
+}
+
+// Render a note to HTML, along with its table of contents. The TOC is empty
+// if the note has no headings.
+func RenderNoteContent(input []byte, opts Options) (content, tocHTML template.HTML, err error) {
+	if opts.Cache == nil || opts.CacheKey == "" {
+		return renderNoteContent(input, opts)
+	}
+	key := cacheKey(RenderVersion, input, opts)
+	if content, tocHTML, ok := opts.Cache.get(key); ok {
+		return content, tocHTML, nil
+	}
+	content, tocHTML, err = renderNoteContent(input, opts)
+	if err == nil {
+		opts.Cache.put(key, content, tocHTML)
+	}
+	return content, tocHTML, err
+}
+
+func renderNoteContent(input []byte, opts Options) (content, tocHTML template.HTML, err error) {
+	md := NewMarkdown(opts)
+
+	// Render note
+	noteRootNode := md.Parser().Parse(gmText.NewReader(input))
+	var noteBuf bytes.Buffer
+	if err := md.Renderer().Render(&noteBuf, input, noteRootNode); err != nil {
+		return "", "", fmt.Errorf("failed to render note: %w", err)
+	}
+
+	// Render TOC
+	tocTree, err := toc.Inspect(noteRootNode, input, toc.Compact(true))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create table of contents: %w", err)
+	}
+	var tocBuf bytes.Buffer
+	if tocList := toc.RenderList(tocTree); tocList != nil {
+		if err := md.Renderer().Render(&tocBuf, input, tocList); err != nil {
+			return "", "", fmt.Errorf("failed to render table of contents: %w", err)
+		}
+	}
+
+	if opts.Sanitizer != nil {
+		return template.HTML(opts.Sanitizer.Sanitize(noteBuf.Bytes())), template.HTML(opts.Sanitizer.Sanitize(tocBuf.Bytes())), nil
+	}
+	return template.HTML(noteBuf.String()), template.HTML(tocBuf.String()), nil
+}
+
+// Read and concatenate the given files from assets.FS, relative to static/.
+// Panics if one doesn't exist, as that's a bug in the embedded assets.
+func concatAssets(names ...string) []byte {
+	var buf bytes.Buffer
+	for _, name := range names {
+		b, err := assets.FS.ReadFile("static/" + name)
+		if err != nil {
+			panic(err)
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// The URLs of the assets `names` when served at `static`, under their
+// fingerprinted names.
+func staticURLs(static string, names []string) []string {
+	urls := make([]string, len(names))
+	for i, name := range names {
+		urls[i] = static + assets.Fingerprint(name)
+	}
+	return urls
+}
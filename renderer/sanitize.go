@@ -0,0 +1,115 @@
+package renderer
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Cleans up rendered notes whose authors aren't trusted to write raw HTML,
+// like the notes of a vault with many authors served to the public. Raw HTML
+// in the notes is kept, but only elements and attributes on an allowlist
+// survive: no scripts, event handlers, iframes or forms, and only http,
+// https and mailto URLs (no javascript:).
+//
+// Everything the renderer itself outputs is allowed: MathML, the Web Awesome
+// ("wa-*") elements of callouts and hashtags, task list checkboxes and the
+// inline styles of code highlighting.
+type Sanitizer struct {
+	policy *bluemonday.Policy
+	extra  string // The Allowlist, for telling sanitizers apart; see key
+}
+
+// HTML allowed on top of what a Sanitizer allows anyway.
+type Allowlist struct {
+	Elements   []string
+	Attributes map[string][]string // Element ("*" for any) : attributes
+}
+
+// Web Awesome elements, and the attributes they may have; enough for the
+// components notes use, without allowing anything that runs script.
+var (
+	waElements   = regexp.MustCompile(`^wa-[a-z-]+$`)
+	waAttributes = []string{
+		"appearance", "variant", "size", "pill", "slot", "name", "label",
+		"open", "summary", "disabled", "with-caret", "orientation", "placement",
+	}
+)
+
+var mathMLElements = []string{
+	"math", "semantics", "annotation", "mrow", "mi", "mn", "mo", "ms", "mtext",
+	"mspace", "msub", "msup", "msubsup", "munder", "mover", "munderover",
+	"mmultiscripts", "mprescripts", "none", "mfrac", "msqrt", "mroot", "mstyle",
+	"mpadded", "mphantom", "menclose", "merror", "mtable", "mtr", "mtd",
+}
+
+var mathMLAttributes = []string{
+	"xmlns", "display", "encoding", "mathvariant", "mathsize", "displaystyle",
+	"scriptlevel", "stretchy", "fence", "separator", "accent", "accentunder",
+	"movablelimits", "largeop", "symmetric", "form", "lspace", "rspace",
+	"minsize", "maxsize", "width", "height", "depth", "voffset",
+	"linethickness", "notation", "columnalign", "rowalign", "columnspacing",
+	"rowspacing", "columnlines", "rowlines", "frame",
+}
+
+// Create a sanitizer that allows `extra` on top of the defaults.
+func NewSanitizer(extra Allowlist) *Sanitizer {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("id", "class", "role").Globally()
+
+	// Code highlighting, see chroma.go
+	p.AllowStyles("color", "background-color", "font-weight", "font-style", "text-decoration", "display").
+		OnElements("pre", "code", "span")
+
+	// Task lists
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+
+	p.AllowNoAttrs().OnElements(mathMLElements...)
+	p.AllowAttrs(mathMLAttributes...).OnElements(mathMLElements...)
+
+	p.AllowNoAttrs().OnElementsMatching(waElements)
+	p.AllowAttrs(waAttributes...).OnElementsMatching(waElements)
+
+	p.AllowNoAttrs().OnElements(extra.Elements...)
+	for element, attrs := range extra.Attributes {
+		// Never event handlers, whatever the allowlist says
+		attrs = slices.DeleteFunc(slices.Clone(attrs), func(attr string) bool {
+			return strings.HasPrefix(strings.ToLower(attr), "on")
+		})
+		if element == "*" {
+			p.AllowAttrs(attrs...).Globally()
+		} else {
+			p.AllowAttrs(attrs...).OnElements(element)
+		}
+	}
+	return &Sanitizer{policy: p, extra: extra.String()}
+}
+
+// The allowlist in a canonical form, e.g. "abbr time *:lang time:datetime".
+func (a Allowlist) String() string {
+	parts := slices.Sorted(slices.Values(a.Elements))
+	for _, element := range slices.Sorted(maps.Keys(a.Attributes)) {
+		for _, attr := range slices.Sorted(slices.Values(a.Attributes[element])) {
+			parts = append(parts, element+":"+attr)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Strip everything not on the allowlist from `html`.
+func (s *Sanitizer) Sanitize(html []byte) []byte {
+	return s.policy.SanitizeBytes(html)
+}
+
+// Identifies what s lets through, for keying cached renders; sanitizers with
+// the same key sanitize the same. No sanitizer at all has a key of its own.
+func (s *Sanitizer) key() string {
+	if s == nil {
+		return "none"
+	}
+	return "sanitized " + s.extra
+}
@@ -0,0 +1,95 @@
+package renderer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	s := NewSanitizer(Allowlist{})
+	tests := []struct {
+		name, html, want string
+	}{
+		{"script", `<p>a<script>alert(1)</script>b</p>`, `<p>ab</p>`},
+		{"event handler", `<img src="a.png" onerror="alert(1)">`, `<img src="a.png">`},
+		{"javascript: link", `<a href="javascript:alert(1)">x</a>`, `x`},
+		{"encoded javascript: link", `<a href="jav&#x09;ascript:alert(1)">x</a>`, `x`},
+		{"data: link", `<a href="data:text/html,<script>alert(1)</script>">x</a>`, `x`},
+		{"http link", `<a href="https://example.com/">x</a>`, `<a href="https://example.com/" rel="nofollow">x</a>`},
+		{"style attribute", `<p style="background: url(javascript:alert(1))">x</p>`, `<p>x</p>`},
+		{"style element", `<style>body { display: none }</style><p>x</p>`, `<p>x</p>`},
+		{"iframe", `<iframe src="https://example.com/"></iframe>`, ``},
+		{"form", `<form action="/logout" method="post"><button>x</button></form>`, `x`},
+		{"highlighting style on other elements", `<div style="color: red">x</div>`, `<div>x</div>`},
+		{"wa-* attribute that isn't allowed", `<wa-callout variant="brand" onclick="alert(1)">x</wa-callout>`, `<wa-callout variant="brand">x</wa-callout>`},
+		{"not a task list", `<input type="text" value="x">`, ``},
+	}
+	for _, tt := range tests {
+		if got := string(s.Sanitize([]byte(tt.html))); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// What the renderer outputs itself goes through unchanged.
+func TestSanitizeKeepsRendererOutput(t *testing.T) {
+	note := strings.Join([]string{
+		"# Heading",
+		"> [!tip] A callout\n> With text. #tag",
+		"- [x] Done\n- [ ] Not done",
+		"Inline $x^2 + 1$ and $\\frac{a}{b}$ math.",
+		"```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```",
+		"| a | b |\n|---|---|\n| 1 | 2 |",
+		"A [link](https://example.com/) and a footnote.[^1]\n\n[^1]: The footnote.",
+	}, "\n\n") + "\n"
+
+	plain, _, err := RenderNoteContent([]byte(note), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sanitized, _, err := RenderNoteContent([]byte(note), Options{Sanitizer: NewSanitizer(Allowlist{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<wa-callout", "<wa-tag", "<math", "<msup", `type="checkbox"`, `style="color:`, "<table"} {
+		if !strings.Contains(string(plain), want) {
+			t.Errorf("rendered note doesn't contain %q, so it isn't tested:\n%s", want, plain)
+		}
+		if !strings.Contains(string(sanitized), want) {
+			t.Errorf("sanitized note lost %q:\n%s", want, sanitized)
+		}
+	}
+	if strings.Count(string(sanitized), "<") != strings.Count(string(plain), "<") {
+		t.Errorf("sanitizing dropped elements of the renderer's output:\n%s\nbecame\n%s", plain, sanitized)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	html := []byte(`<kbd>k</kbd> <span translate="no" data-x="1" onclick="alert(1)" onmouseover="alert(2)">s</span>`)
+	tests := []struct {
+		name  string
+		extra Allowlist
+		want  string
+	}{
+		{"defaults", Allowlist{}, `k <span>s</span>`},
+		{"element", Allowlist{Elements: []string{"kbd"}}, `<kbd>k</kbd> <span>s</span>`},
+		{"attributes", Allowlist{Attributes: map[string][]string{"span": {"data-x"}, "*": {"translate"}}},
+			`k <span translate="no" data-x="1">s</span>`},
+		// Allowing them doesn't make them safe
+		{"event handlers", Allowlist{Attributes: map[string][]string{"*": {"onclick"}, "span": {"OnMouseOver"}}},
+			`k <span>s</span>`},
+	}
+	for _, tt := range tests {
+		if got := string(NewSanitizer(tt.extra).Sanitize(html)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	a := Allowlist{Elements: []string{"time", "abbr"}, Attributes: map[string][]string{"time": {"datetime"}, "*": {"lang"}}}
+	if got, want := a.String(), "abbr time *:lang time:datetime"; got != want {
+		t.Errorf("got allowlist %q, want %q", got, want)
+	}
+	if NewSanitizer(a).key() == NewSanitizer(Allowlist{}).key() {
+		t.Error("sanitizers with different allowlists have the same cache key")
+	}
+}
@@ -0,0 +1,340 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/flonle/mdbuddy/renderer"
+	"github.com/flonle/mdbuddy/server/csp"
+)
+
+// How long a login lasts, unless told otherwise.
+const DefaultSessionTTL = 7 * 24 * time.Hour
+
+// Failed logins allowed per username and per client address within
+// loginWindow, before further attempts are refused.
+const (
+	loginAttempts = 5
+	loginWindow   = 15 * time.Minute
+)
+
+const (
+	sessionCookie = "mdbuddy_session"
+	csrfCookie    = "mdbuddy_csrf"
+	csrfField     = "csrf" // Form field holding the CSRF token
+)
+
+// Requests to these paths don't need a session.
+var publicPaths = []string{"/login", "/logout", "/login/oidc", oidcCallback}
+
+type Options struct {
+	SessionTTL time.Duration // Defaults to DefaultSessionTTL
+	Public     []string      // Path prefixes anyone may access, e.g. "/share/"
+
+	// Let anonymous requests through everywhere, and leave it to the handlers
+	// to decide what they may see; see User and LoginRequired.
+	Anonymous bool
+
+	// Also let users log in with an OpenID Connect provider, at /login/oidc.
+	OIDC *OIDC
+
+	// Where the login page links its stylesheets and scripts from, which
+	// anyone may access; see renderer.Page.Static. Inlined if empty.
+	Static string
+}
+
+// Auth guards a server behind a login page. See Auth.Middleware.
+type Auth struct {
+	users     *Users   // nil if users can't log in with a password
+	oidc      *OIDC    // See Options.OIDC
+	public    []string // See Options.Public
+	anonymous bool     // See Options.Anonymous
+	static    string   // See Options.Static
+	sessions  *sessions
+	byUser    *limiter // Failed logins per username
+	byAddr    *limiter // Failed logins per client address
+}
+
+// Let the users in `users` log in with their password, and/or those of
+// opts.OIDC through their provider. `users` may be nil if opts.OIDC is set.
+func New(users *Users, opts Options) *Auth {
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = DefaultSessionTTL
+	}
+	public := opts.Public
+	if opts.Static != "" {
+		public = append(slices.Clip(public), opts.Static) // The login page needs them
+	}
+	return &Auth{
+		users:     users,
+		oidc:      opts.OIDC,
+		public:    public,
+		anonymous: opts.Anonymous,
+		static:    opts.Static,
+		sessions:  newSessions(opts.SessionTTL),
+		byUser:    newLimiter(loginAttempts, loginWindow),
+		byAddr:    newLimiter(loginAttempts*4, loginWindow),
+	}
+}
+
+type contextKey struct{}
+
+// The name of the user that made the request, or "" for anonymous requests.
+// Only set for requests that went through Auth.Middleware.
+func User(r *http.Request) string {
+	user, _ := r.Context().Value(contextKey{}).(string)
+	return user
+}
+
+// Wrap `next` so that only logged-in users get through, except for the
+// login and logout pages, Options.Public and Options.Anonymous. Other
+// anonymous requests get LoginRequired.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			if sess, ok := a.sessions.get(cookie.Value, a.passwordHash); ok {
+				r = r.WithContext(context.WithValue(r.Context(), contextKey{}, sess.user))
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		for _, path := range publicPaths {
+			if r.URL.Path == path {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		for _, prefix := range a.public {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if a.anonymous {
+			next.ServeHTTP(w, r)
+			return
+		}
+		LoginRequired(w, r)
+	})
+}
+
+// Respond to an anonymous request for something that needs logging in.
+// Requests for pages are redirected to the login page; anything else gets a
+// 401.
+func LoginRequired(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && !strings.HasPrefix(r.URL.Path, "/api/") {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	http.Error(w, "login required", http.StatusUnauthorized)
+}
+
+// What login.html needs, besides the page itself.
+type loginForm struct {
+	CSRF     string
+	Next     string // Where to go after logging in
+	Username string
+	Error    string
+	Password bool   // Whether to show the username and password form
+	OIDC     string // Name of the OIDC provider to offer, if any
+}
+
+// Serve the login page.
+func (a *Auth) HandleLoginPage(w http.ResponseWriter, r *http.Request) {
+	if User(r) != "" {
+		http.Redirect(w, r, safeNext(r.URL.Query().Get("next")), http.StatusSeeOther)
+		return
+	}
+	a.renderLogin(w, r, http.StatusOK, loginForm{Next: r.URL.Query().Get("next")})
+}
+
+// Handle the login form: check the credentials and start a session.
+func (a *Auth) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if a.users == nil {
+		http.NotFound(w, r)
+		return
+	}
+	form := loginForm{
+		Next:     r.PostFormValue("next"),
+		Username: r.PostFormValue("username"),
+	}
+	if !CheckCSRF(r) {
+		form.Error = "Your session expired, please try again."
+		a.renderLogin(w, r, http.StatusForbidden, form)
+		return
+	}
+
+	// Every attempt counts as a failure until it succeeds. Clients of unknown
+	// address would all share one limit, which anyone could use up for
+	// everyone, so they're only limited per user.
+	addr := ClientAddr(r)
+	userOK, userWait := a.byUser.reserve(form.Username)
+	addrOK, addrWait := true, time.Duration(0)
+	if addr != "" {
+		addrOK, addrWait = a.byAddr.reserve(addr)
+	}
+	if !userOK || !addrOK {
+		if userOK {
+			a.byUser.release(form.Username)
+		}
+		if addrOK && addr != "" {
+			a.byAddr.release(addr)
+		}
+		wait := (max(userWait, addrWait) + time.Minute - 1).Truncate(time.Minute)
+		w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())))
+		form.Error = fmt.Sprintf("Too many failed attempts, try again in %d minutes.", int(wait.Minutes()))
+		a.renderLogin(w, r, http.StatusTooManyRequests, form)
+		return
+	}
+
+	hash := a.users.Verify(form.Username, r.PostFormValue("password"))
+	if hash == "" {
+		log.Printf("Failed login for %q from %s", form.Username, addr)
+		form.Error = "Wrong username or password."
+		a.renderLogin(w, r, http.StatusUnauthorized, form)
+		return
+	}
+	// Only this attempt is forgiven for the address: one account someone
+	// knows the password of mustn't let them guess at the others
+	a.byUser.reset(form.Username)
+	if addr != "" {
+		a.byAddr.release(addr)
+	}
+
+	a.startSession(w, r, form.Username, hash)
+	http.Redirect(w, r, safeNext(form.Next), http.StatusSeeOther)
+}
+
+// Log `user` in, who logged in with the password hashed as `hash`, or through
+// OIDC if "".
+func (a *Auth) startSession(w http.ResponseWriter, r *http.Request, user string, hash string) {
+	token, expires := a.sessions.create(user, hash)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// The current password hash of `user`, or "" if there's no such user.
+func (a *Auth) passwordHash(user string) string {
+	if a.users == nil {
+		return ""
+	}
+	return a.users.hash(user)
+}
+
+// End the session of the user making the request.
+func (a *Auth) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if !CheckCSRF(r) {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		a.sessions.delete(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (a *Auth) renderLogin(w http.ResponseWriter, r *http.Request, status int, form loginForm) {
+	form.CSRF = CSRFToken(w, r)
+	form.Password = a.users != nil
+	if a.oidc != nil {
+		form.OIDC = a.oidc.Name()
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	err := renderer.RenderPage(w, "login.html", renderer.Page{
+		Title:  "Log in",
+		Data:   form,
+		Nonce:  csp.Nonce(r),
+		Static: a.static,
+	})
+	if err != nil {
+		log.Printf("Failed to render login.html: %v", err)
+	}
+}
+
+// Return the CSRF token to include in forms, as the "csrf" field. The token
+// is kept in a cookie, and CheckCSRF compares the two ("double submit").
+// Must be called before the response header is written.
+func CSRFToken(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	token := rand.Text()
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// Report whether a posted form carries the CSRF token from CSRFToken, and
+// didn't come from another site.
+func CheckCSRF(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			return false
+		}
+	}
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue(csrfField))) == 1
+}
+
+// Only redirect to paths on this server after logging in.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// Report whether the client made the request over HTTPS, to the server or to
+// a reverse proxy it trusts; see server.ListenOptions.TrustProxy.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.URL.Scheme == "https"
+}
+
+// The address of the client, without the port, or "" if it's unknown, like
+// for clients on Unix domain sockets. Behind a reverse proxy, that of the
+// proxy, unless the server trusts it to say; see server.ListenOptions.
+func ClientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCSRF = "csrf-token"
+
+// A posted login form for `username` and `password`, with a matching CSRF
+// token, from `addr`.
+func loginRequest(username, password, addr string) *http.Request {
+	form := url.Values{"username": {username}, "password": {password}, "next": {"/notes"}, csrfField: {testCSRF}}
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: testCSRF})
+	r.RemoteAddr = addr
+	return r
+}
+
+// The value of the cookie `name` set by `w`, or "".
+func setCookie(w *httptest.ResponseRecorder, name string) string {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func TestCheckCSRF(t *testing.T) {
+	tests := []struct {
+		name    string
+		cookie  string
+		field   string
+		headers map[string]string
+		want    bool
+	}{
+		{"matching", testCSRF, testCSRF, nil, true},
+		{"same origin", testCSRF, testCSRF, map[string]string{"Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"}, true},
+		{"no cookie", "", testCSRF, nil, false},
+		{"no field", testCSRF, "", nil, false},
+		{"neither", "", "", nil, false},
+		{"mismatched", testCSRF, "other-token", nil, false},
+		{"cross-site", testCSRF, testCSRF, map[string]string{"Sec-Fetch-Site": "cross-site"}, false},
+		{"other origin", testCSRF, testCSRF, map[string]string{"Origin": "https://evil.example"}, false},
+	}
+	for _, tt := range tests {
+		form := url.Values{}
+		if tt.field != "" {
+			form.Set(csrfField, tt.field)
+		}
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/logout", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+		}
+		for name, value := range tt.headers {
+			r.Header.Set(name, value)
+		}
+		if got := CheckCSRF(r); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Cookies are only marked Secure when the request came over HTTPS, not when
+// anyone just claims it did; the server sets r.URL.Scheme for proxies it
+// trusts.
+func TestSecureCookies(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(r *http.Request)
+		want   bool
+	}{
+		{"http", func(r *http.Request) {}, false},
+		{"X-Forwarded-Proto", func(r *http.Request) { r.Header.Set("X-Forwarded-Proto", "https") }, false},
+		{"trusted proxy", func(r *http.Request) { r.URL.Scheme = "https" }, true},
+		{"https", func(r *http.Request) { r.TLS = &tls.ConnectionState{} }, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/login", nil)
+		tt.modify(r)
+		w := httptest.NewRecorder()
+		CSRFToken(w, r)
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Secure != tt.want {
+			t.Errorf("%s: got cookies %v, want one that's Secure: %t", tt.name, cookies, tt.want)
+		}
+	}
+}
+
+func TestHandleLogin(t *testing.T) {
+	users, err := LoadUsers(filepath.Join(t.TempDir(), "users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Set("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	a := New(users, Options{})
+	login := func(username, password, addr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.HandleLogin(w, loginRequest(username, password, addr))
+		return w
+	}
+	// The user the session of `token` is logged in as, or "".
+	loggedIn := func(token string) string {
+		var user string
+		r := httptest.NewRequest(http.MethodGet, "/notes", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+		a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user = User(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		return user
+	}
+
+	w := login("alice", "secret", "192.0.2.1:1234")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/notes" {
+		t.Errorf("logging in: got %d to %q, want a redirect to /notes", w.Code, w.Header().Get("Location"))
+	}
+	token := setCookie(w, sessionCookie)
+	if user := loggedIn(token); user != "alice" {
+		t.Errorf("got logged in as %q, want alice", user)
+	}
+
+	tests := []struct {
+		name               string
+		username, password string
+		csrf               bool
+		want               int
+	}{
+		{"wrong password", "alice", "wrong", true, http.StatusUnauthorized},
+		{"unknown user", "bob", "secret", true, http.StatusUnauthorized},
+		{"no CSRF token", "alice", "secret", false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := loginRequest(tt.username, tt.password, "192.0.2.2:1234")
+		if !tt.csrf {
+			r.Header.Del("Cookie")
+		}
+		w := httptest.NewRecorder()
+		a.HandleLogin(w, r)
+		if w.Code != tt.want || setCookie(w, sessionCookie) != "" {
+			t.Errorf("%s: got %d with session %q, want %d and no session", tt.name, w.Code, setCookie(w, sessionCookie), tt.want)
+		}
+	}
+
+	// Changing the password ends the session
+	if err := users.Set("alice", "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	if user := loggedIn(token); user != "" {
+		t.Errorf("still logged in as %q after the password changed", user)
+	}
+}
+
+func TestHandleLoginRateLimit(t *testing.T) {
+	users, err := LoadUsers(filepath.Join(t.TempDir(), "users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Set("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	a := New(users, Options{})
+	login := func(password, addr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.HandleLogin(w, loginRequest("alice", password, addr))
+		return w
+	}
+
+	for i := range loginAttempts {
+		if w := login("wrong", "192.0.2.1:1234"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+	// Even with the right password, and from elsewhere
+	w := login("secret", "198.51.100.1:1234")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "900" {
+		t.Errorf("got %d with Retry-After %q, want %d after 900 seconds", w.Code, w.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+	if setCookie(w, sessionCookie) != "" {
+		t.Error("logged in while locked out")
+	}
+}
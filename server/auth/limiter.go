@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits failed login attempts per key (a username or a client address):
+// after `max` failures within `window`, further attempts are refused until
+// the window has passed.
+type limiter struct {
+	max        int
+	window     time.Duration
+	attempts   map[string]*attempts
+	attemptsMx sync.Mutex // Protects attempts
+}
+
+type attempts struct {
+	failures int       // Including the attempts in progress
+	reset    time.Time // When failures goes back to 0
+}
+
+func newLimiter(max int, window time.Duration) *limiter {
+	return &limiter{max: max, window: window, attempts: map[string]*attempts{}}
+}
+
+// Reserve an attempt for `key`, if it may try again, or report how long
+// until it may. The attempt counts as a failure until it's released, so that
+// concurrent attempts can't get past the limit together.
+func (l *limiter) reserve(key string) (bool, time.Duration) {
+	l.attemptsMx.Lock()
+	defer l.attemptsMx.Unlock()
+	now := time.Now()
+	a, ok := l.attempts[key]
+	if !ok || now.After(a.reset) {
+		// Forget about keys that stopped trying, so the map doesn't grow forever
+		for key, a := range l.attempts {
+			if now.After(a.reset) {
+				delete(l.attempts, key)
+			}
+		}
+		a = &attempts{reset: now.Add(l.window)}
+		l.attempts[key] = a
+	}
+	if a.failures >= l.max {
+		return false, a.reset.Sub(now)
+	}
+	a.failures++
+	return true, 0
+}
+
+// Release the attempt reserved for `key`, which succeeded.
+func (l *limiter) release(key string) {
+	l.attemptsMx.Lock()
+	defer l.attemptsMx.Unlock()
+	if a, ok := l.attempts[key]; ok && a.failures > 0 {
+		a.failures--
+	}
+}
+
+// Forget the failures of `key`, after it succeeded.
+func (l *limiter) reset(key string) {
+	l.attemptsMx.Lock()
+	defer l.attemptsMx.Unlock()
+	delete(l.attempts, key)
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(2, time.Hour)
+	steps := []struct {
+		do   string // "reserve", "release" or "reset"
+		key  string
+		want bool // For "reserve"
+	}{
+		{"reserve", "a", true},
+		{"reserve", "a", true},
+		{"reserve", "a", false}, // Locked out
+		{"reserve", "b", true},  // Other keys aren't
+		{"release", "a", false}, // The second attempt succeeded after all
+		{"reserve", "a", true},
+		{"reserve", "a", false},
+		{"reset", "a", false},
+		{"reserve", "a", true},
+		{"reserve", "a", true},
+		{"reserve", "b", true},
+		{"reserve", "b", false},
+	}
+	for i, step := range steps {
+		switch step.do {
+		case "reserve":
+			ok, wait := l.reserve(step.key)
+			if ok != step.want {
+				t.Errorf("step %d: reserving %q got %t, want %t", i+1, step.key, ok, step.want)
+			}
+			if !ok && (wait <= 59*time.Minute || wait > time.Hour) {
+				t.Errorf("step %d: got to wait %v, want the rest of the hour", i+1, wait)
+			}
+		case "release":
+			l.release(step.key)
+		case "reset":
+			l.reset(step.key)
+		}
+	}
+}
+
+func TestLimiterWindow(t *testing.T) {
+	// Every window is over as soon as it starts
+	l := newLimiter(1, -time.Second)
+	for i := range 3 {
+		if ok, _ := l.reserve("a"); !ok {
+			t.Errorf("attempt %d: locked out after the window passed", i+1)
+		}
+	}
+	if len(l.attempts) != 1 {
+		t.Errorf("kept %d keys, want the ones of past windows forgotten", len(l.attempts))
+	}
+}
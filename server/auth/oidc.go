@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcCookie   = "mdbuddy_oidc" // State of a login in progress; see oidcLogin
+	oidcCallback = "/login/oidc/callback"
+	oidcTimeout  = 10 * time.Minute // To finish logging in at the provider
+)
+
+// The claim usernames are taken from, unless told otherwise.
+const DefaultUserClaim = "preferred_username"
+
+type OIDCConfig struct {
+	Issuer       string // URL of the provider, e.g. "https://id.example.com/realms/main"
+	ClientID     string
+	ClientSecret string // Empty for public clients, which rely on PKCE alone
+
+	// Where the provider sends users back to. Defaults to /login/oidc/callback
+	// on the host the login started from; it has to be registered with the
+	// provider either way.
+	RedirectURL string
+	Scopes      []string // On top of "openid"; defaults to "profile" and "email"
+	UserClaim   string   // Claim holding the username; defaults to DefaultUserClaim
+	Name        string   // Shown on the login page; defaults to the issuer's host
+
+	// Who may log in: users with any of these claims. Everyone the provider
+	// lets through if empty.
+	Allow []Claim
+}
+
+// A claim and a value, like "groups=notes". Matches ID tokens where the claim
+// is the value, or a list that contains it.
+type Claim struct {
+	Name  string
+	Value string
+}
+
+// Parse a claim written as "name=value".
+func ParseClaim(s string) (Claim, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return Claim{}, fmt.Errorf("invalid claim %q, expected name=value", s)
+	}
+	return Claim{Name: name, Value: value}, nil
+}
+
+func (c Claim) String() string {
+	return c.Name + "=" + c.Value
+}
+
+// Report whether the claims of an ID token include c.
+func (c Claim) matches(claims map[string]any) bool {
+	switch value := claims[c.Name].(type) {
+	case nil:
+		return false
+	case []any:
+		return slices.ContainsFunc(value, func(v any) bool { return fmt.Sprint(v) == c.Value })
+	default:
+		return fmt.Sprint(value) == c.Value
+	}
+}
+
+// Logging in with an OpenID Connect provider, using the authorization code
+// flow with PKCE.
+type OIDC struct {
+	config   OIDCConfig
+	oauth2   oauth2.Config // Without RedirectURL; see redirectURL
+	verifier *oidc.IDTokenVerifier
+}
+
+// Set up logging in with the provider at config.Issuer, fetching its
+// configuration from the discovery document.
+func NewOIDC(ctx context.Context, config OIDCConfig) (*OIDC, error) {
+	if config.Issuer == "" || config.ClientID == "" {
+		return nil, errors.New("OIDC needs an issuer and a client ID")
+	}
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+
+	if config.Scopes == nil {
+		config.Scopes = []string{"profile", "email"}
+	}
+	if config.UserClaim == "" {
+		config.UserClaim = DefaultUserClaim
+	}
+	if config.Name == "" {
+		config.Name = config.Issuer
+		if u, err := url.Parse(config.Issuer); err == nil && u.Host != "" {
+			config.Name = u.Host
+		}
+	}
+	return &OIDC{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, config.Scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// The name of the provider, for the login page.
+func (o *OIDC) Name() string {
+	return o.config.Name
+}
+
+// A login in progress, kept in a cookie between sending the user to the
+// provider and them coming back. Tying it to the browser like this means
+// nobody can make someone else log in as them ("login CSRF").
+type oidcLogin struct {
+	state    string
+	nonce    string
+	verifier string // PKCE code verifier
+	next     string // Where to go after logging in
+}
+
+func (l oidcLogin) encode() string {
+	return url.Values{
+		"state":    {l.state},
+		"nonce":    {l.nonce},
+		"verifier": {l.verifier},
+		"next":     {l.next},
+	}.Encode()
+}
+
+func decodeOIDCLogin(s string) (oidcLogin, bool) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return oidcLogin{}, false
+	}
+	l := oidcLogin{
+		state:    values.Get("state"),
+		nonce:    values.Get("nonce"),
+		verifier: values.Get("verifier"),
+		next:     values.Get("next"),
+	}
+	return l, l.state != "" && l.nonce != "" && l.verifier != ""
+}
+
+// The URL the provider sends users back to after logging in there.
+func (o *OIDC) redirectURL(r *http.Request) string {
+	if o.config.RedirectURL != "" {
+		return o.config.RedirectURL
+	}
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + oidcCallback
+}
+
+// Send the user to the provider to log in.
+func (a *Auth) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	login := oidcLogin{
+		state:    rand.Text(),
+		nonce:    rand.Text(),
+		verifier: oauth2.GenerateVerifier(),
+		next:     r.URL.Query().Get("next"),
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    login.encode(),
+		Path:     oidcCallback,
+		MaxAge:   int(oidcTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode, // The provider redirects back with a top-level GET
+	})
+
+	config := a.oidc.oauth2
+	config.RedirectURL = a.oidc.redirectURL(r)
+	http.Redirect(w, r, config.AuthCodeURL(login.state,
+		oauth2.S256ChallengeOption(login.verifier),
+		oidc.Nonce(login.nonce),
+	), http.StatusFound)
+}
+
+// Handle the user coming back from the provider: exchange the code for an ID
+// token, check that they may log in and start a session.
+func (a *Auth) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Path:     oidcCallback,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	var login oidcLogin
+	ok := false
+	if cookie, err := r.Cookie(oidcCookie); err == nil {
+		login, ok = decodeOIDCLogin(cookie.Value)
+	}
+	query := r.URL.Query()
+	if !ok || subtle.ConstantTimeCompare([]byte(login.state), []byte(query.Get("state"))) != 1 {
+		a.renderLogin(w, r, http.StatusBadRequest, loginForm{Error: "Your login expired, please try again."})
+		return
+	}
+	form := loginForm{Next: login.next}
+	if errCode := query.Get("error"); errCode != "" {
+		log.Printf("OIDC login failed: %s: %s", errCode, query.Get("error_description"))
+		form.Error = fmt.Sprintf("Logging in with %s failed.", a.oidc.Name())
+		a.renderLogin(w, r, http.StatusUnauthorized, form)
+		return
+	}
+
+	user, err := a.oidc.exchange(r, query.Get("code"), login)
+	if err != nil {
+		log.Printf("OIDC login from %s failed: %v", ClientAddr(r), err)
+		form.Error = fmt.Sprintf("Logging in with %s failed.", a.oidc.Name())
+		a.renderLogin(w, r, http.StatusUnauthorized, form)
+		return
+	}
+	if user == "" {
+		form.Error = "You're not allowed to log in here."
+		a.renderLogin(w, r, http.StatusForbidden, form)
+		return
+	}
+
+	a.startSession(w, r, user, "")
+	http.Redirect(w, r, safeNext(login.next), http.StatusSeeOther)
+}
+
+// Exchange `code` for an ID token and verify it. Returns the username, or ""
+// if the user may not log in.
+func (o *OIDC) exchange(r *http.Request, code string, login oidcLogin) (string, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	config := o.oauth2
+	config.RedirectURL = o.redirectURL(r)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange code: %w", err)
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", errors.New("no ID token in token response")
+	}
+	idToken, err := o.verifier.Verify(ctx, raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid ID token: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(login.nonce)) != 1 {
+		return "", errors.New("invalid ID token: nonce doesn't match")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return "", fmt.Errorf("invalid ID token: %w", err)
+	}
+	user, _ := claims[o.config.UserClaim].(string)
+	if err := validateName(user); err != nil {
+		return "", fmt.Errorf("invalid %s claim %q for subject %s: %w", o.config.UserClaim, user, idToken.Subject, err)
+	}
+	if len(o.config.Allow) > 0 && !slices.ContainsFunc(o.config.Allow, func(c Claim) bool { return c.matches(claims) }) {
+		log.Printf("OIDC user %s isn't allowed to log in: none of %v", user, o.config.Allow)
+		return "", nil
+	}
+	return user, nil
+}
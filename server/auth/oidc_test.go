@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID    = "mdbuddy"
+	testRedirectURL = "http://notes.example/login/oidc/callback"
+)
+
+// An OpenID Connect provider for tests: it serves discovery, its keys and a
+// token endpoint, and hands out the ID tokens the test registers codes for.
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	codes   map[string]mockCode
+	codesMx sync.Mutex
+}
+
+// What the token endpoint hands out for a code.
+type mockCode struct {
+	challenge string         // PKCE code challenge the login started with
+	claims    map[string]any // Of the ID token, on top of iss, aud, exp and iat
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, codes: map[string]mockCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", p.serveToken)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *mockProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	p.codesMx.Lock()
+	code, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.codesMx.Unlock()
+
+	switch {
+	case !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != testRedirectURL:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case s256(r.PostFormValue("code_verifier")) != code.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss": p.URL,
+		"aud": testClientID,
+		"sub": "subject",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range code.claims {
+		claims[name] = value
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.sign(claims),
+	})
+}
+
+// An RS256 JWT of `claims`.
+func (p *mockProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Let the user log in at the provider: register a code for the login that
+// `authURL` started, with an ID token of the claims `claims` returns for its
+// nonce. Returns the code and the state to come back with.
+func (p *mockProvider) authorize(t *testing.T, authURL string, claims func(nonce string) map[string]any) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("login doesn't use PKCE: %s", authURL)
+	}
+	code = rand.Text()
+	p.codesMx.Lock()
+	p.codes[code] = mockCode{challenge: query.Get("code_challenge"), claims: claims(query.Get("nonce"))}
+	p.codesMx.Unlock()
+	return code, query.Get("state")
+}
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Start logging in to `a`, and return the provider's URL it redirects to and
+// the cookie that keeps the login.
+func startOIDCLogin(t *testing.T, a *Auth) (string, *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	a.HandleOIDCLogin(w, httptest.NewRequest(http.MethodGet, "http://notes.example/login/oidc?next=/dir/note", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: got status %d, want %d", w.Code, http.StatusFound)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcCookie {
+			return w.Header().Get("Location"), cookie
+		}
+	}
+	t.Fatal("login: no login cookie")
+	return "", nil
+}
+
+// Come back from the provider to `a` with `code` and `state`.
+func finishOIDCLogin(a *Auth, cookie *http.Cookie, code, state string) *httptest.ResponseRecorder {
+	query := url.Values{"code": {code}, "state": {state}}
+	r := httptest.NewRequest(http.MethodGet, "http://notes.example"+oidcCallback+"?"+query.Encode(), nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	a.HandleOIDCCallback(w, r)
+	return w
+}
+
+func sessionOf(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookie && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockProvider(t)
+	newAuth := func(t *testing.T, allow ...Claim) *Auth {
+		t.Helper()
+		o, err := NewOIDC(context.Background(), OIDCConfig{
+			Issuer:      provider.URL,
+			ClientID:    testClientID,
+			RedirectURL: testRedirectURL,
+			Allow:       allow,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return New(nil, Options{OIDC: o})
+	}
+	allowNotes := Claim{Name: "groups", Value: "notes"}
+
+	tests := []struct {
+		name   string
+		allow  []Claim
+		claims func(nonce string) map[string]any
+		tamper func(login *oidcLogin, state *string)
+		status int
+	}{
+		{
+			name:  "success",
+			allow: []Claim{allowNotes},
+			claims: func(nonce string) map[string]any {
+				return map[string]any{"nonce": nonce, "preferred_username": "alice", "groups": []string{"staff", "notes"}}
+			},
+			status: http.StatusSeeOther,
+		},
+		{
+			name: "state mismatch",
+			claims: func(nonce string) map[string]any {
+				return map[string]any{"nonce": nonce, "preferred_username": "alice"}
+			},
+			tamper: func(login *oidcLogin, state *string) { *state = "forged" },
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "nonce mismatch",
+			claims: func(nonce string) map[string]any {
+				return map[string]any{"nonce": "replayed", "preferred_username": "alice"}
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "bad code verifier",
+			claims: func(nonce string) map[string]any {
+				return map[string]any{"nonce": nonce, "preferred_username": "alice"}
+			},
+			tamper: func(login *oidcLogin, state *string) { login.verifier = "not-the-verifier-the-login-started-with-0123" },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:  "denied group",
+			allow: []Claim{allowNotes},
+			claims: func(nonce string) map[string]any {
+				return map[string]any{"nonce": nonce, "preferred_username": "mallory", "groups": []string{"staff"}}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name:  "missing group claim",
+			allow: []Claim{allowNotes},
+			claims: func(nonce string) map[string]any {
+				return map[string]any{"nonce": nonce, "preferred_username": "mallory"}
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "invalid username",
+			claims: func(nonce string) map[string]any {
+				return map[string]any{"nonce": nonce, "preferred_username": "../alice"}
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAuth(t, tt.allow...)
+			authURL, cookie := startOIDCLogin(t, a)
+			code, state := provider.authorize(t, authURL, tt.claims)
+			if tt.tamper != nil {
+				login, ok := decodeOIDCLogin(cookie.Value)
+				if !ok {
+					t.Fatalf("invalid login cookie %q", cookie.Value)
+				}
+				tt.tamper(&login, &state)
+				cookie.Value = login.encode()
+			}
+
+			w := finishOIDCLogin(a, cookie, code, state)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			session := sessionOf(w)
+			if tt.status != http.StatusSeeOther {
+				if session != nil {
+					t.Error("started a session for a failed login")
+				}
+				return
+			}
+			if got := w.Header().Get("Location"); got != "/dir/note" {
+				t.Errorf("redirected to %q, want /dir/note", got)
+			}
+			if session == nil {
+				t.Fatal("no session cookie")
+			}
+			if sess, ok := a.sessions.get(session.Value, a.passwordHash); !ok || sess.user != "alice" {
+				t.Errorf("session is for %q, want alice", sess.user)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackWithoutLogin(t *testing.T) {
+	provider := newMockProvider(t)
+	o, err := NewOIDC(context.Background(), OIDCConfig{Issuer: provider.URL, ClientID: testClientID, RedirectURL: testRedirectURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(nil, Options{OIDC: o})
+
+	// A code and state from someone else's login, without their cookie
+	authURL, _ := startOIDCLogin(t, a)
+	code, state := provider.authorize(t, authURL, func(nonce string) map[string]any {
+		return map[string]any{"nonce": nonce, "preferred_username": "alice"}
+	})
+	r := httptest.NewRequest(http.MethodGet, "http://notes.example"+oidcCallback+"?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	w := httptest.NewRecorder()
+	a.HandleOIDCCallback(w, r)
+	if w.Code != http.StatusBadRequest || sessionOf(w) != nil {
+		t.Fatalf("got status %d, want %d and no session", w.Code, http.StatusBadRequest)
+	}
+}
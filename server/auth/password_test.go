@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPassword(t *testing.T) {
+	argon, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(argon, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Errorf("got hash %q, want an argon2id hash in the PHC format", argon)
+	}
+	if other, _ := HashPassword("secret"); other == argon {
+		t.Error("hashed the same password twice to the same hash, without a random salt")
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash := string(b)
+
+	tests := []struct {
+		name, hash, password string
+		want                 bool
+	}{
+		{"argon2id", argon, "secret", true},
+		{"argon2id, wrong password", argon, "Secret", false},
+		{"argon2id, empty password", argon, "", false},
+		{"bcrypt", bcryptHash, "secret", true},
+		{"bcrypt, wrong password", bcryptHash, "wrong", false},
+		{"other version", strings.Replace(argon, "v=19", "v=16", 1), "secret", false},
+		{"truncated", argon[:strings.LastIndex(argon, "$")], "secret", false},
+		{"no key", argon[:strings.LastIndex(argon, "$")+1], "secret", false},
+		{"argon2i", strings.Replace(argon, "argon2id", "argon2i", 1), "secret", false},
+		{"plain text", "secret", "secret", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		if got := CheckPassword(tt.hash, tt.password); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
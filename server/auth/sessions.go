@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// A logged-in user.
+type session struct {
+	user    string
+	hash    string // The user's password hash at login, "" for OIDC logins; see sessions.get
+	expires time.Time
+}
+
+// Sessions of logged-in users, by token. Sessions live in memory only, so
+// restarting the server logs everyone out.
+type sessions struct {
+	ttl        time.Duration
+	sessions   map[string]session // token : session
+	sessionsMx sync.Mutex         // Protects sessions
+}
+
+func newSessions(ttl time.Duration) *sessions {
+	return &sessions{ttl: ttl, sessions: map[string]session{}}
+}
+
+// Start a session for `user`, who logged in with the password hashed as
+// `hash`, or through OIDC if "". Returns the token to hand to the browser.
+func (s *sessions) create(user string, hash string) (token string, expires time.Time) {
+	token = rand.Text()
+	expires = time.Now().Add(s.ttl)
+
+	s.sessionsMx.Lock()
+	defer s.sessionsMx.Unlock()
+	s.prune()
+	s.sessions[token] = session{user: user, hash: hash, expires: expires}
+	return token, expires
+}
+
+// Look up the session for `token`. `currentHash` returns the user's current
+// password hash; sessions of users whose password changed or who no longer
+// exist have ended. OIDC sessions only end when they expire or on logout:
+// the provider is only asked at login.
+func (s *sessions) get(token string, currentHash func(user string) string) (session, bool) {
+	s.sessionsMx.Lock()
+	sess, ok := s.sessions[token]
+	s.sessionsMx.Unlock()
+	if !ok {
+		return session{}, false
+	}
+	if time.Now().After(sess.expires) || sess.hash != "" && currentHash(sess.user) != sess.hash {
+		s.delete(token)
+		return session{}, false
+	}
+	return sess, true
+}
+
+func (s *sessions) delete(token string) {
+	s.sessionsMx.Lock()
+	defer s.sessionsMx.Unlock()
+	delete(s.sessions, token)
+}
+
+// Drop expired sessions.
+//
+// Caller must hold sessionsMx.
+func (s *sessions) prune() {
+	now := time.Now()
+	for token, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, token)
+		}
+	}
+}
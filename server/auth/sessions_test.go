@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		hash        string // At login
+		currentHash string
+		want        bool
+	}{
+		{"valid", time.Hour, "hash", "hash", true},
+		{"expired", -time.Second, "hash", "hash", false},
+		{"password changed", time.Hour, "hash", "new hash", false},
+		{"user removed", time.Hour, "hash", "", false},
+		{"OIDC", time.Hour, "", "", true},
+		{"OIDC, expired", -time.Second, "", "", false},
+	}
+	for _, tt := range tests {
+		s := newSessions(tt.ttl)
+		token, _ := s.create("alice", tt.hash)
+		current := func(user string) string {
+			if user != "alice" {
+				t.Errorf("%s: asked for the hash of %q", tt.name, user)
+			}
+			return tt.currentHash
+		}
+		sess, ok := s.get(token, current)
+		if ok != tt.want {
+			t.Errorf("%s: got session %t, want %t", tt.name, ok, tt.want)
+		}
+		if ok && sess.user != "alice" {
+			t.Errorf("%s: got user %q, want alice", tt.name, sess.user)
+		}
+		// Ended sessions stay ended
+		if _, ok := s.get(token, func(string) string { return tt.hash }); ok != tt.want {
+			t.Errorf("%s: got session %t the second time, want %t", tt.name, ok, tt.want)
+		}
+	}
+}
+
+func TestSessionsDelete(t *testing.T) {
+	s := newSessions(time.Hour)
+	token, _ := s.create("alice", "hash")
+	other, _ := s.create("alice", "hash")
+	if token == other {
+		t.Fatal("got the same token for two sessions")
+	}
+	s.delete(token)
+	same := func(string) string { return "hash" }
+	if _, ok := s.get(token, same); ok {
+		t.Error("session still there after logging out")
+	}
+	if _, ok := s.get(other, same); !ok {
+		t.Error("logging out ended the user's other session")
+	}
+	if _, ok := s.get("made up", same); ok {
+		t.Error("got a session for a token that was never handed out")
+	}
+}
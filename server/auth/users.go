@@ -0,0 +1,205 @@
+// Package auth implements logging in to the vault server: a file of users
+// with password hashes or an OpenID Connect provider, sessions kept in
+// cookies, CSRF protection for forms and rate limiting of login attempts.
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flonle/mdbuddy/internal/atomicfile"
+)
+
+// The file users and their password hashes are read from, unless told
+// otherwise: $XDG_CONFIG_HOME/mdbuddy/users, or the equivalent on other
+// platforms.
+func DefaultUsersPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "mdbuddy-users"
+	}
+	return filepath.Join(dir, "mdbuddy", "users")
+}
+
+// The users allowed to log in, stored in a file with one "name:hash" line per
+// user, like htpasswd. See HashPassword for the hash format. Blank lines and
+// lines starting with '#' are ignored.
+//
+// The file is read again whenever it changes, so users can be added or
+// removed while the server is running.
+type Users struct {
+	Path    string
+	hashes  map[string]string // name : password hash
+	modTime time.Time         // Of the file when it was last read
+	mx      sync.Mutex        // Protects hashes and modTime
+}
+
+// Read the users in the file at `path`. A missing file means there are no
+// users (yet).
+func LoadUsers(path string) (*Users, error) {
+	u := &Users{Path: path, hashes: map[string]string{}}
+	if err := u.reload(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Read the file again if it changed since the last time.
+//
+// Caller must hold mx, or be the only one with access to u.
+func (u *Users) reload() error {
+	info, err := os.Stat(u.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		u.hashes, u.modTime = map[string]string{}, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read users: %w", err)
+	}
+	if info.ModTime().Equal(u.modTime) {
+		return nil
+	}
+
+	b, err := os.ReadFile(u.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read users: %w", err)
+	}
+	hashes := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		name, hash, ok := strings.Cut(text, ":")
+		if !ok || name == "" || hash == "" {
+			return fmt.Errorf("%s:%d: expected name:hash", u.Path, line)
+		}
+		hashes[name] = hash
+	}
+	u.hashes, u.modTime = hashes, info.ModTime()
+	return nil
+}
+
+// The names of all users, sorted.
+func (u *Users) Names() []string {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	u.reload()
+	names := make([]string, 0, len(u.hashes))
+	for name := range u.hashes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Report whether the user `name` exists.
+func (u *Users) Has(name string) bool {
+	return u.hash(name) != ""
+}
+
+// The password hash of the user `name`, or "" if there's no such user.
+func (u *Users) hash(name string) string {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	if err := u.reload(); err != nil {
+		return "" // Nobody gets in until the file is fixed
+	}
+	return u.hashes[name]
+}
+
+// Check the password of the user `name`. Returns the user's password hash
+// if it's correct, "" otherwise.
+func (u *Users) Verify(name string, password string) string {
+	hash := u.hash(name)
+	if hash == "" {
+		CheckPassword(dummyHash(), password)
+		return ""
+	}
+	if !CheckPassword(hash, password) {
+		return ""
+	}
+	return hash
+}
+
+// Add the user `name`, or change their password if they exist, and save.
+func (u *Users) Set(name string, password string) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	if err := u.reload(); err != nil {
+		return err
+	}
+	u.hashes[name] = hash
+	return u.save()
+}
+
+// Remove the user `name` and save. Their sessions end with their next request.
+func (u *Users) Remove(name string) error {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	if err := u.reload(); err != nil {
+		return err
+	}
+	if _, ok := u.hashes[name]; !ok {
+		return fmt.Errorf("no such user: %s", name)
+	}
+	delete(u.hashes, name)
+	return u.save()
+}
+
+// Write the users to the file, replacing it atomically. The file is only
+// readable by its owner; password hashes are secrets too.
+//
+// Caller must hold mx.
+func (u *Users) save() error {
+	var buf bytes.Buffer
+	buf.WriteString("# MDBuddy users; manage with `mdbuddy user`\n")
+	names := make([]string, 0, len(u.hashes))
+	for name := range u.hashes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s:%s\n", name, u.hashes[name])
+	}
+
+	if err := os.MkdirAll(filepath.Dir(u.Path), 0o700); err != nil {
+		return fmt.Errorf("failed to save users: %w", err)
+	}
+	if err := atomicfile.Write(u.Path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to save users: %w", err)
+	}
+	u.modTime = time.Time{} // Read it back next time, to pick up the new mtime
+	return nil
+}
+
+// Usernames end up in the file, in cookies and in the UI, so keep them simple.
+func validateName(name string) error {
+	if name == "" || len(name) > 64 {
+		return errors.New("username must be between 1 and 64 characters")
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.@", r)) {
+			return fmt.Errorf("username may only contain letters, digits and -_.@, not %q", r)
+		}
+	}
+	return nil
+}
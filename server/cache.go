@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/flonle/mdbuddy/server/csp"
+)
+
+// Cache-Control policies.
+const (
+	// Rendered pages and other generated responses: caches may keep them, but
+	// have to check they're still current (see writeCached) before using them.
+	cachePage = "no-cache"
+
+	// The same, for responses that depend on who's asking: only the browser
+	// may keep them.
+	cachePrivatePage = "private, no-cache"
+
+	// Responses at URLs that change whenever their content does, like
+	// fingerprinted assets: caches may keep them forever.
+	cacheImmutable = "public, max-age=31536000, immutable"
+)
+
+// Responses smaller than this aren't worth compressing.
+const minCompressSize = 1024
+
+// Send a generated response, like a rendered page, with the given status and
+// Cache-Control policy. The response gets an ETag based on its content, and
+// a Last-Modified if `modTime` isn't zero, and conditional GETs for it that
+// are still current get a 304 Not Modified. It's compressed with brotli or
+// gzip if the client accepts that.
+//
+// `body` has the nonce of `r` (see csp.Nonce), which is left out of its ETag,
+// so that the ETag doesn't change with every response. The Content-Type
+// header must be set.
+func writeCached(w http.ResponseWriter, r *http.Request, status int, body []byte, modTime time.Time, cacheControl string) {
+	h := w.Header()
+	h.Set("Cache-Control", cacheControl)
+	h.Add("Vary", "Accept-Encoding")
+	encoding := negotiateEncoding(r, len(body))
+
+	if status == http.StatusOK {
+		etag := responseETag(r, body, encoding)
+		h.Set("ETag", etag)
+		if !modTime.IsZero() {
+			h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		}
+		if notModified(r, etag, modTime) {
+			// Leave the policy the client got with its cached copy alone: it
+			// has the nonces of that copy, not of this response
+			h.Del("Content-Security-Policy")
+			h.Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if encoding == "" {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write(body)
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	switch encoding {
+	case "br":
+		zw = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	case "gzip":
+		zw = gzip.NewWriter(&buf)
+	}
+	zw.Write(body)
+	if err := zw.Close(); err != nil {
+		log.Printf("Failed to compress response: %v", err)
+		http.Error(w, "failed to compress response", http.StatusInternalServerError)
+		return
+	}
+	h.Set("Content-Encoding", encoding)
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+}
+
+// Report whether writeCached answers `r` with a 304 Not Modified for a 200
+// response of `body` and `modTime`, e.g. to only count what's actually sent.
+func cachedByClient(r *http.Request, body []byte, modTime time.Time) bool {
+	return notModified(r, responseETag(r, body, negotiateEncoding(r, len(body))), modTime)
+}
+
+// The quoted ETag of the response of `body` to `r`, compressed with
+// `encoding`.
+func responseETag(r *http.Request, body []byte, encoding string) string {
+	sum := sha256.Sum256(csp.WithoutNonce(body, r))
+	etag := hex.EncodeToString(sum[:16])
+	if encoding != "" {
+		etag += "-" + encoding // Different bytes, so a different ETag
+	}
+	return `"` + etag + `"`
+}
+
+// The content coding to compress a response of `size` bytes to `r` with:
+// "br", "gzip" or "" for none.
+func negotiateEncoding(r *http.Request, size int) string {
+	if size < minCompressSize {
+		return ""
+	}
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(coding)] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"br", "gzip"} { // In order of preference
+		q, ok := accepted[coding]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Report whether the conditional GET `r` is for the current version of a
+// response with `etag` and `modTime`. If-None-Match takes precedence over
+// If-Modified-Since, as in RFC 9110.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if modTime.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modTime.Truncate(time.Second).After(since)
+}
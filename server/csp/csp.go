@@ -0,0 +1,92 @@
+// Package csp sets a strict Content Security Policy on every response, with a
+// fresh nonce for the inline <script> and <style> blocks of the page
+// templates, along with the other usual security headers.
+//
+// Notes are rendered with raw HTML allowed, so without it a note could run
+// whatever script it likes in the browser of whoever views it. With it, only
+// scripts carrying the nonce run (and the scripts they load, for the Web
+// Awesome kit), so script in notes doesn't.
+package csp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Where the Web Awesome kit the templates load comes from; see layout.html.
+const (
+	kitScripts = "https://kit.webawesome.com"
+	kitAssets  = "https://ka-f.webawesome.com"
+)
+
+// How long browsers should stick to HTTPS after seeing the server over TLS.
+const hstsMaxAge = 365 * 24 * 60 * 60 // Seconds
+
+// A stand-in for the nonce in responses that are compared to earlier ones,
+// like for ETags: a page with its nonce in it is different every time. See
+// WithoutNonce.
+const Placeholder = "MDBUDDYNONCEPLACEHOLDER"
+
+type contextKey struct{}
+
+// The nonce to put on the inline scripts and styles of the response to `r`,
+// or "" for requests that didn't go through Middleware.
+func Nonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(contextKey{}).(string)
+	return nonce
+}
+
+// Replace the nonce for `r` in `body` with the Placeholder, to compare it to
+// earlier responses. Never the other way around: a note can contain the
+// Placeholder, but not a nonce nobody knew before the request.
+func WithoutNonce(body []byte, r *http.Request) []byte {
+	nonce := Nonce(r)
+	if nonce == "" {
+		return body
+	}
+	return bytes.ReplaceAll(body, []byte(nonce), []byte(Placeholder))
+}
+
+// Wrap `next` so that every response gets the security headers, and every
+// request a nonce; see Nonce. Handlers may still override the headers, like
+// a stricter Referrer-Policy.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := rand.Text()
+		h := w.Header()
+		h.Set("Content-Security-Policy", policy(nonce))
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY") // For browsers that don't know frame-ancestors
+		h.Set("Referrer-Policy", "same-origin")
+		if r.TLS != nil || r.URL.Scheme == "https" { // See server.ListenOptions.TrustProxy
+			h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", hstsMaxAge))
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, nonce)))
+	})
+}
+
+// The Content-Security-Policy for a response with the given nonce.
+//
+// Scripts need the nonce, or to be loaded by a script that has it
+// ('strict-dynamic'). <style> blocks need it as well, but style attributes
+// are allowed: code highlighting uses them, and they can't run script.
+// Images may come from anywhere on HTTPS, as notes link to them all the time.
+func policy(nonce string) string {
+	return strings.Join([]string{
+		"default-src 'self'",
+		fmt.Sprintf("script-src 'nonce-%s' 'strict-dynamic'", nonce),
+		fmt.Sprintf("style-src 'self' 'nonce-%s' %s", nonce, kitAssets),
+		"style-src-attr 'unsafe-inline'",
+		"img-src 'self' data: https:",
+		"font-src 'self' data: " + kitAssets,
+		fmt.Sprintf("connect-src 'self' %s %s", kitScripts, kitAssets),
+		"object-src 'none'",
+		"base-uri 'none'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}, "; ")
+}
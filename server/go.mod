@@ -3,7 +3,11 @@ module github.com/flonle/mdbuddy/server
 go 1.25.4
 
 require (
+	github.com/andybalholm/brotli v1.2.6
+	github.com/coreos/go-oidc/v3 v3.17.0
 	github.com/flonle/mdbuddy/renderer v0.0.0
+	golang.org/x/crypto v0.45.0
+	golang.org/x/oauth2 v0.33.0
 	golang.org/x/sys v0.38.0
 )
 
@@ -12,6 +16,7 @@ require (
 	github.com/dlclark/regexp2 v1.11.5 // indirect
 	github.com/flonle/mdbuddy/assets v0.0.0 // indirect
 	github.com/forPelevin/gomoji v1.3.0 // indirect
+	github.com/go-jose/go-jose/v4 v4.1.3 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/wyatt915/goldmark-treeblood v0.0.1 // indirect
 	github.com/wyatt915/treeblood v0.1.16 // indirect
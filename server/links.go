@@ -0,0 +1,75 @@
+package server
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/site"
+)
+
+// What links to what in a vault: the link graph between its notes, and which
+// notes link to which attachments. Both take the whole vault to work out, so
+// they're built when they're first needed, and again only after the watcher
+// saw the vault change.
+type links struct {
+	vault       *vault.Vault
+	graph       *site.Graph         // nil until it's needed
+	attachments map[string][]string // attachment path : paths of the notes linking to it
+	mx          sync.Mutex          // Protects graph and attachments
+}
+
+func newLinks(v *vault.Vault) *links {
+	return &links{vault: v}
+}
+
+// Forget everything, after the vault changed.
+func (x *links) invalidate() {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+	x.graph = nil
+	x.attachments = nil
+}
+
+// The paths of the notes linking to the note at `notePath`; see
+// site.Graph.Backlinks.
+func (x *links) backlinks(notePath string) []string {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+	x.build()
+	return x.graph.Backlinks(notePath)
+}
+
+// Report whether `attachment` is linked from any note the linker can view.
+// Anonymous users only get the attachments of the notes they may see.
+func (x *links) linksTo(l *site.Linker, attachment string) bool {
+	if !l.Vault.HasAttachment(attachment) {
+		return false
+	}
+	x.mx.Lock()
+	x.build()
+	notes := x.attachments[attachment]
+	x.mx.Unlock()
+	return slices.ContainsFunc(notes, func(notePath string) bool {
+		return l.CanView(l.Vault.Note(notePath))
+	})
+}
+
+// Build what's missing. Must hold x.mx.
+func (x *links) build() {
+	if x.graph != nil {
+		return
+	}
+	x.graph = site.NewGraph(x.vault)
+	x.attachments = map[string][]string{}
+	for _, note := range x.vault.Notes() {
+		// Which attachments a link resolves to doesn't depend on who's
+		// asking, so a linker that sees everything will do
+		_, attachments := site.CheckLinks(site.NewVaultLinker(x.vault, note))
+		for _, attachment := range attachments {
+			if !slices.Contains(x.attachments[attachment], note.Path) {
+				x.attachments[attachment] = append(x.attachments[attachment], note.Path)
+			}
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flonle/mdbuddy/internal/atomicfile"
+)
+
+// The first file descriptor passed by systemd socket activation; see
+// sd_listen_fds(3).
+const listenFDsStart = 3
+
+// How long generated self-signed certificates are valid for. They're renewed
+// when they have less than a month left.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// Where and how a server listens.
+//
+// If the server was started by systemd socket activation (LISTEN_FDS), it
+// listens on the socket it was passed, and Addr and Socket are ignored.
+type ListenOptions struct {
+	Addr   string // TCP address, e.g. ":3000"
+	Socket string // Path of a Unix domain socket to listen on instead of Addr
+
+	// Serve HTTPS with this certificate and key, in PEM files.
+	TLSCert string
+	TLSKey  string
+
+	// Serve HTTPS with a self-signed certificate for this machine's names and
+	// addresses, for use on a LAN. It's kept in DefaultTLSDir, so browsers
+	// only need to be told to trust it once.
+	SelfSigned bool
+
+	// Take the address of clients from the X-Forwarded-For or X-Real-IP
+	// header, and whether they used HTTPS from X-Forwarded-Proto, for servers
+	// behind a reverse proxy: otherwise, every client has the address of the
+	// proxy, or none on Unix domain sockets, and uses whatever the proxy
+	// does. Only safe if nothing but the proxy can reach the server, as
+	// anyone else could claim any address.
+	TrustProxy bool
+}
+
+// The directory self-signed certificates are kept in:
+// $XDG_CONFIG_HOME/mdbuddy/tls, or the equivalent on other platforms.
+func DefaultTLSDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "mdbuddy-tls"
+	}
+	return filepath.Join(dir, "mdbuddy", "tls")
+}
+
+// Serve `handler` as described by `opts`, logging where as the `name` server.
+// Only returns on failure.
+func listenAndServe(name string, opts ListenOptions, handler http.Handler) error {
+	srv := &http.Server{Handler: opts.wrap(handler)}
+	scheme := "http"
+	if opts.TLSCert != "" || opts.TLSKey != "" || opts.SelfSigned {
+		cert, err := opts.certificate()
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		scheme = "https"
+	}
+
+	ln, where, err := opts.listen(scheme)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	log.Printf("%s server running on %s\n", name, where)
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
+
+// Wrap `handler` in what the options ask of every request.
+func (opts ListenOptions) wrap(handler http.Handler) http.Handler {
+	handler = forwardedProto(handler, opts.TrustProxy)
+	if opts.TrustProxy {
+		handler = forwardedFor(handler)
+	}
+	return handler
+}
+
+// Wrap `next` so that requests come from the address the reverse proxy in
+// front of the server got them from, rather than from the proxy; see
+// ListenOptions.TrustProxy.
+func forwardedFor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+
+		// Proxies append the address they got the request from, so only the
+		// last one is theirs; the ones before it are whatever the client said
+		addr := ""
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			addr = last[strings.LastIndexByte(last, ',')+1:]
+		} else {
+			addr = r.Header.Get("X-Real-IP")
+		}
+		if ip, err := netip.ParseAddr(strings.TrimSpace(addr)); err == nil {
+			r.RemoteAddr = net.JoinHostPort(ip.Unmap().String(), "0")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Wrap `next` so that r.URL.Scheme is the scheme the client made the request
+// with, as the reverse proxy in front of the server says in
+// X-Forwarded-Proto if `trustProxy`, or else "". Whatever the client put in
+// the request line doesn't count; see ListenOptions.TrustProxy.
+func forwardedProto(next http.Handler, trustProxy bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		r.URL.Scheme = ""
+		if proto := r.Header.Get("X-Forwarded-Proto"); trustProxy && (proto == "http" || proto == "https") {
+			r.URL.Scheme = proto
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Open the listener. Also returns where it listens, for the logs.
+func (opts ListenOptions) listen(scheme string) (net.Listener, string, error) {
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		return ln, "the socket passed by systemd (" + scheme + ")", err
+	}
+
+	if opts.Socket != "" {
+		// A socket left behind by a server that didn't shut down cleanly
+		// would make listening fail
+		if info, err := os.Lstat(opts.Socket); err == nil && info.Mode().Type() == fs.ModeSocket {
+			os.Remove(opts.Socket)
+		}
+		ln, err := net.Listen("unix", opts.Socket)
+		if err != nil {
+			return nil, "", err
+		}
+		return ln, "unix:" + opts.Socket + " (" + scheme + ")", nil
+	}
+
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return nil, "", err
+	}
+	host, port, _ := net.SplitHostPort(opts.Addr)
+	if host == "" {
+		host = "localhost"
+	}
+	return ln, scheme + "://" + net.JoinHostPort(host, port), nil
+}
+
+// The listener passed by systemd socket activation, or nil if there is none.
+// Only the first socket is used; units should only have one.
+func systemdListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// Don't pass them on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n > 1 {
+		log.Printf("systemd passed %d sockets; only using the first\n", n)
+	}
+
+	f := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer f.Close() // FileListener dups it
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the socket passed by systemd: %w", err)
+	}
+	return ln, nil
+}
+
+// The certificate to serve HTTPS with: the configured one, or a self-signed
+// one.
+func (opts ListenOptions) certificate() (tls.Certificate, error) {
+	switch {
+	case opts.TLSCert != "" && opts.TLSKey != "":
+		cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		return cert, nil
+	case opts.TLSCert != "" || opts.TLSKey != "":
+		return tls.Certificate{}, errors.New("both a TLS certificate and a key are needed")
+	default:
+		return selfSignedCertificate(DefaultTLSDir())
+	}
+}
+
+// Load the self-signed certificate in `dir`, or generate a new one if there
+// is none, it's about to expire, or it doesn't cover this machine's current
+// names. Addresses don't count: those of the network interfaces come and go
+// (DHCP, VPNs, ...), and every new certificate has to be trusted again.
+func selfSignedCertificate(dir string) (tls.Certificate, error) {
+	certPath, keyPath := filepath.Join(dir, "self-signed.crt"), filepath.Join(dir, "self-signed.key")
+	names, ips := localNames()
+
+	if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil && coversAll(cert.Leaf, names, loopbackIPs) {
+		if time.Until(cert.Leaf.NotAfter) > 30*24*time.Hour {
+			return cert, nil
+		}
+	}
+
+	// The same key as before, if there is one
+	var key *ecdsa.PrivateKey
+	if keyPEM, err := os.ReadFile(keyPath); err == nil {
+		if block, _ := pem.Decode(keyPEM); block != nil && block.Type == "EC PRIVATE KEY" {
+			key, _ = x509.ParseECPrivateKey(block.Bytes)
+		}
+	}
+	certPEM, keyPEM, err := generateCertificate(key, names, ips)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to save self-signed certificate: %w", err)
+	}
+	if err := atomicfile.Write(keyPath, keyPEM, 0o600); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to save self-signed certificate: %w", err)
+	}
+	if err := atomicfile.Write(certPath, certPEM, 0o644); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to save self-signed certificate: %w", err)
+	}
+	log.Printf("Generated a self-signed certificate for %s in %s\n", strings.Join(names, ", "), certPath)
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// Generate a self-signed certificate for `names` and `ips`, with `key`, or a
+// new key if nil. Returns the certificate and the key, PEM encoded.
+func generateCertificate(key *ecdsa.PrivateKey, names []string, ips []net.IP) (certPEM, keyPEM []byte, err error) {
+	if key == nil {
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, nil, err
+		}
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: names[0], Organization: []string{"MDBuddy self-signed"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              names,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+var loopbackIPs = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+
+// The names and addresses this machine can be reached at: localhost, its
+// hostname, and the addresses of its network interfaces.
+func localNames() ([]string, []net.IP) {
+	names := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		names = append(names, hostname)
+		if !strings.Contains(hostname, ".") {
+			names = append(names, hostname+".local") // mDNS
+		}
+	}
+	ips := slices.Clone(loopbackIPs)
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return names, ips
+}
+
+// Report whether `cert` is valid for all of `names` and `ips`.
+func coversAll(cert *x509.Certificate, names []string, ips []net.IP) bool {
+	if cert == nil {
+		return false
+	}
+	for _, name := range names {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if cert.VerifyHostname(ip.String()) != nil {
+			return false
+		}
+	}
+	return true
+}
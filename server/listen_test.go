@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flonle/mdbuddy/server/auth"
+	"github.com/flonle/mdbuddy/server/csp"
+)
+
+func TestTrustProxy(t *testing.T) {
+	s := newTestVaultServer(t, map[string]string{"note.md": "# Note\n"})
+	tests := []struct {
+		name       string
+		trustProxy bool
+		target     string // Absolute-form request lines carry a scheme of their own
+		header     map[string]string
+		https      bool
+		remoteAddr string
+	}{
+		{"plain", false, "/sitemap.xml", nil, false, "192.0.2.1"},
+		{"spoofed", false, "/sitemap.xml", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-For": "198.51.100.7"}, false, "192.0.2.1"},
+		{"scheme in the request line", false, "https://notes.example.com/sitemap.xml", nil, false, "192.0.2.1"},
+		{"trusted", true, "/sitemap.xml", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-For": "198.51.100.7, 203.0.113.9"}, true, "203.0.113.9"},
+		{"trusted plain", true, "/sitemap.xml", map[string]string{"X-Forwarded-Proto": "http"}, false, "192.0.2.1"},
+		{"trusted, no header", true, "https://notes.example.com/sitemap.xml", nil, false, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		var remoteAddr string
+		handler := ListenOptions{TrustProxy: tt.trustProxy}.wrap(csp.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remoteAddr = auth.ClientAddr(r)
+			s.serveSitemap(w, r)
+		})))
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.TLS = nil // httptest sets it for https targets
+		r.Host = "notes.example.com"
+		r.RemoteAddr = "192.0.2.1:1234"
+		for key, value := range tt.header {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		scheme := "http"
+		if tt.https {
+			scheme = "https"
+		}
+		if loc := "<loc>" + scheme + "://notes.example.com/note</loc>"; !strings.Contains(w.Body.String(), loc) {
+			t.Errorf("%s: sitemap doesn't have %s:\n%s", tt.name, loc, w.Body)
+		}
+		if hsts := w.Header().Get("Strict-Transport-Security") != ""; hsts != tt.https {
+			t.Errorf("%s: got HSTS %t, want %t", tt.name, hsts, tt.https)
+		}
+		if remoteAddr != tt.remoteAddr {
+			t.Errorf("%s: got client address %q, want %q", tt.name, remoteAddr, tt.remoteAddr)
+		}
+	}
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "self-signed.crt"), filepath.Join(dir, "self-signed.key")
+	names, _ := localNames()
+	write := func(names []string) []byte {
+		t.Helper()
+		certPEM, keyPEM, err := generateCertificate(nil, names, loopbackIPs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(certPEM)
+		return block.Bytes
+	}
+
+	// Without the addresses of the network interfaces, which change
+	existing := write(names)
+	cert, err := selfSignedCertificate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.Certificate[0], existing) {
+		t.Error("generated a new certificate, though the existing one covers this machine's names")
+	}
+
+	// For another machine: a new certificate, with the same key
+	elsewhere, err := x509.ParseCertificate(write([]string{"elsewhere.example"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = selfSignedCertificate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !coversAll(cert.Leaf, names, loopbackIPs) {
+		t.Errorf("kept the certificate for %q, on a machine called %q", cert.Leaf.DNSNames, names)
+	}
+	if !elsewhere.PublicKey.(*ecdsa.PublicKey).Equal(cert.Leaf.PublicKey) {
+		t.Error("generated a new key")
+	}
+	if saved, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil || !bytes.Equal(saved.Certificate[0], cert.Certificate[0]) {
+		t.Errorf("didn't save the new certificate: %v", err)
+	}
+}
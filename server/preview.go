@@ -6,49 +6,48 @@ package server
 // They all emit IN_CLOSE_WRITE on save, too. This is also triggered when creating, although we should probably handle that separately with IN_CREATE
 
 import (
-	"fmt"
-	"io/fs"
+	"bytes"
+	"crypto/rand"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
-	// "time"
-	"unsafe"
+	"time"
 
 	"golang.org/x/sys/unix"
 
 	"github.com/flonle/mdbuddy/renderer"
+	"github.com/flonle/mdbuddy/server/csp"
 )
 
 type previewServer struct {
-	inotifyInstanceFD int            // The inotify instance file descriptor
-	watches           map[int]string // watch descriptor : (absolute) path
-	previewFile       string         // The last changed markdown file
-	previewFileMx     sync.RWMutex   // Protects previewFile
-	refreshClients    []chan string  // Connected SSE clients
-	refreshClientsMx  sync.Mutex     // Protects refreshClients
+	watcher          *watcher      // Watches all given files
+	previewFile      string        // The last changed markdown file
+	previewFileMx    sync.RWMutex  // Protects previewFile
+	rendered         renderedFile  // The last rendered file
+	renderedMx       sync.Mutex    // Protects rendered
+	refreshClients   []chan string // Connected SSE clients
+	refreshClientsMx sync.Mutex    // Protects refreshClients
 }
 
 // Start a server that serves a preview of the last changed file
-// amongst all the given files. Binds to `addr`.
+// amongst all the given files. Listens as described by `listen`.
 //
 // The server will also *watch* all given file for write events. When
 // detected, the server will (re)render the affected file, and show that instead.
-func ServePreview(addr string, paths []string) error {
+func ServePreview(listen ListenOptions, paths []string) error {
 	// Initialize inotify instance
-	inotifyfd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	watcher, err := newWatcher(unix.IN_CLOSE_WRITE)
 	if err != nil {
-		return fmt.Errorf("Failed to initialize inotify: %v", err)
+		return err
 	}
-	defer unix.Close(inotifyfd)
+	defer watcher.Close()
 
 	// Create previewServer
 	server := &previewServer{
-		inotifyInstanceFD: inotifyfd,
-		watches:           map[int]string{},
-		previewFile:       "",
+		watcher:     watcher,
+		previewFile: "",
 	}
 
 	// Add watches to inotify instance
@@ -57,76 +56,102 @@ func ServePreview(addr string, paths []string) error {
 		return err
 	}
 	for _, absPath := range absPaths {
-		server.addWatchRecursively(absPath)
+		server.watcher.addWatchRecursively(absPath)
 	}
 
 	// Start watching inotify events
-	go server.watchInotify()
+	go server.watcher.run(server.handleWatchEvent)
 
 	// Start HTTP server
-	http.HandleFunc("/", server.servePreview)
-	http.HandleFunc("/sse-refresh", server.handleSSERefresh)
-	addr_, port, _ := strings.Cut(addr, ":")
-	if addr_ == "" {
-		addr_ = "localhost"
-	}
-	log.Printf("Preview server running on http://%s:%s\n", addr_, port)
-	return http.ListenAndServe(addr, nil)
+	return listenAndServe("Preview", listen, csp.Middleware(server.mux()))
+}
+
+// The routes of the preview server. Not those of http.DefaultServeMux, which
+// packages like expvar register theirs on.
+func (s *previewServer) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.servePreview)
+	mux.HandleFunc("/sse-refresh", s.handleSSERefresh)
+	mux.HandleFunc("GET "+staticPrefix+"{path...}", serveStatic(http.NotFound))
+	return mux
+}
+
+// Previews are served with a Content-Security-Policy, so they need a nonce,
+// and link the assets they need, so only the note itself changes on refresh.
+// Rendered previews are kept for more than one request, so they're rendered
+// with a stand-in for the nonce, which is random, so that the note can't
+// contain it.
+func bareNoteOptions(placeholder string) renderer.BareNoteOptions {
+	return renderer.BareNoteOptions{Nonce: placeholder, Static: staticPrefix}
+}
+
+// A rendered preview, and the version of the file it was rendered from.
+type renderedFile struct {
+	path        string
+	modTime     time.Time
+	size        int64
+	html        []byte // With placeholder for the nonce
+	placeholder string
+}
+
+// The preview with the nonce for `r`.
+func (f renderedFile) withNonce(r *http.Request) []byte {
+	return bytes.ReplaceAll(f.html, []byte(f.placeholder), []byte(csp.Nonce(r)))
 }
 
 func (s *previewServer) servePreview(w http.ResponseWriter, r *http.Request) {
+	s.previewFileMx.RLock()
+	previewFile := s.previewFile
+	s.previewFileMx.RUnlock()
+
+	rendered, err := s.render(previewFile)
+	if err != nil {
+		log.Printf("Failed to render %s: %v", previewFile, err)
+		http.Error(w, "failed to render preview", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "text/html")
+	writeCached(w, r, http.StatusOK, rendered.withNonce(r), rendered.modTime, cachePage)
+}
+
+// Render the file at `path`, unless it didn't change since the last time.
+func (s *previewServer) render(path string) (renderedFile, error) {
+	placeholder := rand.Text()
+	info, err := os.Stat(path)
+	if err != nil {
+		var buf bytes.Buffer
+		input := []byte("# Live Preview\n\nPlease write to a watched file to see its preview.")
+		err := renderer.RenderBareNote(input, &buf, bareNoteOptions(placeholder))
+		return renderedFile{html: buf.Bytes(), placeholder: placeholder}, err
+	}
 
-	input, err := os.ReadFile(s.previewFile)
+	s.renderedMx.Lock()
+	defer s.renderedMx.Unlock()
+	if s.rendered.path == path && s.rendered.modTime.Equal(info.ModTime()) && s.rendered.size == info.Size() {
+		return s.rendered, nil
+	}
+	input, err := os.ReadFile(path)
 	if err != nil {
-		input = []byte("# Live Preview\n\nPlease write to a watched file to see its preview.")
+		return renderedFile{}, err
+	}
+	var buf bytes.Buffer
+	if err := renderer.RenderBareNote(input, &buf, bareNoteOptions(placeholder)); err != nil {
+		return renderedFile{}, err
 	}
-	renderer.RenderBareNote(input, w)
+	s.rendered = renderedFile{path: path, modTime: info.ModTime(), size: info.Size(), html: buf.Bytes(), placeholder: placeholder}
+	return s.rendered, nil
 }
 
-// Start watching the Inotify instance and broadcast file change to all SSE clients.
-// Blocking!
-func (s *previewServer) watchInotify() {
-	buf := make([]byte, unix.SizeofInotifyEvent*4096)
-	for {
-		n, err := unix.Read(s.inotifyInstanceFD, buf)
-		if err != nil {
-			log.Fatalf("Failed to read events: %v", err)
-		}
+// Show the changed file and broadcast the change to all SSE clients.
+func (s *previewServer) handleWatchEvent(event watchEvent) {
+	if strings.HasSuffix(event.Path, ".md") {
+		log.Println("sending refresh signal")
 
-		offset := 0
-		for offset < n {
-			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
-			path := s.watches[int(event.Wd)]
-
-			// Extract filename if present
-			nameLen := int(event.Len)
-			var filename string
-			if nameLen > 0 {
-				nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+nameLen]
-				filename = string(nameBytes[:clen(nameBytes)])
-			}
-
-			if strings.HasSuffix(filename, ".md") {
-				fullPath := filepath.Join(path, filename)
-				log.Println("sending refresh signal")
-
-				s.previewFileMx.Lock()
-				s.previewFile = fullPath
-				s.previewFileMx.Unlock()
-
-				go s.broadcastRefresh(fullPath)
-			}
-
-			// Print event description
-			// fmt.Printf("[%s] ", path)
-			// if filename != "" {
-			// 	fmt.Printf("%s: ", filename)
-			// }
-			// fmt.Println(describeEvent(event.Mask))
-
-			offset += unix.SizeofInotifyEvent + nameLen
-		}
+		s.previewFileMx.Lock()
+		s.previewFile = event.Path
+		s.previewFileMx.Unlock()
+
+		go s.broadcastRefresh(event.Path)
 	}
 }
 
@@ -227,71 +252,3 @@ func (s *previewServer) handleSSERefresh(w http.ResponseWriter, r *http.Request)
 	// 	}
 	// }
 }
-
-func clen(b []byte) int {
-	for i, c := range b {
-		if c == 0 {
-			return i
-		}
-	}
-	return len(b)
-}
-
-// Add a new inotify watch for the given path. If that path is a directory,
-// traverse it recursively to add a watch for all subdirectories, too.
-//
-// Ignores directories that start with '.' !
-func (s *previewServer) addWatchRecursively(path string) error {
-	info, err := os.Stat(path)
-	if err != nil {
-		return fmt.Errorf("Failed to stat %s: %v", path, err)
-	}
-
-	if info.IsDir() {
-		err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if !d.IsDir() {
-				return nil
-			}
-
-			name := d.Name()
-			if len(name) > 0 && name[0] == '.' {
-				return fs.SkipDir // Skip directories starting with .
-			}
-			s.addWatch(path)
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-	} else {
-		s.addWatch(path)
-	}
-
-	return nil
-}
-
-// Add a new inotify watch for a single given path.
-func (s *previewServer) addWatch(path string) error {
-	wd, err := unix.InotifyAddWatch(s.inotifyInstanceFD, path, unix.IN_CLOSE_WRITE)
-	if err != nil {
-		return err
-	}
-	s.watches[wd] = path
-	return nil
-}
-
-// Return a []string with the absolute version of all paths in `paths`.
-func normalizePaths(paths []string) ([]string, error) {
-	absPaths := make([]string, len(paths))
-	for i, path := range paths {
-		absPath, err := filepath.Abs(path)
-		if err != nil {
-			return absPaths, fmt.Errorf("failed to get absolute path for %s: %v", path, err)
-		}
-		absPaths[i] = absPath
-	}
-	return absPaths, nil
-}
@@ -1 +1,588 @@
 package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/flonle/mdbuddy/renderer"
+	"github.com/flonle/mdbuddy/server/auth"
+	"github.com/flonle/mdbuddy/server/csp"
+	"github.com/flonle/mdbuddy/server/share"
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/search"
+	"github.com/flonle/mdbuddy/vault/site"
+)
+
+// Events the vault server needs to keep its view of the vault up to date.
+const vaultWatchMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// How many results /search and /api/search return when not told otherwise.
+const defaultSearchLimit = 50
+
+// When to strip raw HTML that isn't on the allowlist from notes; see
+// VaultOptions.Sanitize.
+const (
+	SanitizeAnonymous = "anonymous" // For visitors that aren't logged in, including share links
+	SanitizeAlways    = "always"
+	SanitizeNever     = "never"
+)
+
+type VaultOptions struct {
+	Drafts bool // Serve drafts and notes scheduled to be published later
+
+	// Path to the users file (see auth.Users). If set, logged-in users see
+	// everything, including drafts, and anonymous visitors only public and
+	// unlisted notes. Notes without a visibility are private then; see
+	// vault.Visibility. Without it, every visitor is anonymous, and notes
+	// are public unless they say otherwise.
+	Users      string
+	SessionTTL time.Duration // How long a login lasts; see auth.Options
+
+	// Also, or instead, let users log in with an OpenID Connect provider.
+	// Anyone it lets in (see auth.OIDCConfig.Allow) counts as logged in, as
+	// above.
+	OIDC *auth.OIDCConfig
+
+	Shares string // Path to the share link store, outside the vault; defaults to share.DefaultPath
+
+	// When to sanitize the HTML of notes (one of the Sanitize* constants),
+	// for vaults whose authors can't all be trusted with raw HTML. Defaults to
+	// SanitizeAnonymous. AllowHTML is allowed on top of the defaults; see
+	// renderer.Sanitizer.
+	Sanitize  string
+	AllowHTML renderer.Allowlist
+
+	// Keep up to this many bytes of rendered notes in memory, so that notes
+	// that haven't changed aren't rendered again. Also keep them in
+	// RenderCacheDir if set, so they survive restarts. No caching if 0. See
+	// renderer.Cache; its counters are served at /debug/vars with Debug.
+	RenderCacheSize int
+	RenderCacheDir  string
+
+	// Serve the expvar counters, like those of the render cache, and memory
+	// statistics at /debug/vars; to logged-in users only if logging in is
+	// enabled.
+	Debug bool
+}
+
+type vaultServer struct {
+	vault   *vault.Vault
+	index   *search.Index // Full-text index of all notes
+	watcher *watcher      // Watches the whole vault
+	links   *links        // What links to what, for backlinks and attachments
+	shares  *share.Store
+	auth    *auth.Auth          // nil if logging in is disabled
+	html    *renderer.Sanitizer // nil if notes are never sanitized
+	renders *renderer.Cache     // nil if rendered notes aren't cached
+	opts    VaultOptions
+
+	// For Last-Modified; see modTime. The vault may have changed in any way
+	// before the server started, and what's on a page depends on more than
+	// the modification times of notes when notes and folders come and go.
+	started  time.Time
+	reshaped atomic.Int64 // Unix nanoseconds of when notes or folders last appeared or disappeared
+}
+
+// Start a server that serves every note in the vault at `root`. Listens as
+// described by `listen`.
+//
+// The server watches the vault, so edits, new notes and deleted notes show up
+// without a restart. Drafts and notes scheduled for later are left out of
+// everything unless opts.Drafts is set, or the user is logged in; see
+// opts.Users. So are notes the user may not see, or that are unlisted.
+func ServeVault(listen ListenOptions, root string, opts VaultOptions) error {
+	switch opts.Sanitize {
+	case "":
+		opts.Sanitize = SanitizeAnonymous
+	case SanitizeAnonymous, SanitizeAlways, SanitizeNever:
+	default:
+		return fmt.Errorf("invalid sanitize mode %q; expected %s, %s or %s", opts.Sanitize, SanitizeAnonymous, SanitizeAlways, SanitizeNever)
+	}
+
+	v, err := vault.Open(root)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := newWatcher(vaultWatchMask)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	shares, err := share.Open(v.Root, opts.Shares)
+	if err != nil {
+		return err
+	}
+
+	server := &vaultServer{
+		vault:   v,
+		index:   search.IndexVault(v),
+		watcher: watcher,
+		links:   newLinks(v),
+		shares:  shares,
+		opts:    opts,
+		started: time.Now(),
+	}
+	if opts.Sanitize != SanitizeNever {
+		server.html = renderer.NewSanitizer(opts.AllowHTML)
+	}
+	if opts.RenderCacheSize > 0 {
+		server.renders = renderer.NewCache(opts.RenderCacheSize, opts.RenderCacheDir)
+		expvar.Publish("render_cache", expvar.Func(func() any { return server.renders.Stats() }))
+	}
+	log.Printf("Indexed %d notes in %s\n", server.index.Len(), v.Root)
+
+	if err := server.watcher.addWatchRecursively(v.Root); err != nil {
+		return err
+	}
+	go server.watcher.run(server.handleWatchEvent)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /search", server.serveSearch)
+	mux.HandleFunc("GET /api/search", server.serveSearchJSON)
+	mux.HandleFunc("GET /tags", server.serveTags)
+	mux.HandleFunc("GET /tags/{tag}", server.serveTag)
+	mux.HandleFunc("GET /feed.atom", server.serveFeed(site.Atom))
+	mux.HandleFunc("GET /feed.rss", server.serveFeed(site.RSS))
+	mux.HandleFunc("GET /tags/{tag}/feed.atom", server.serveFeed(site.Atom))
+	mux.HandleFunc("GET /tags/{tag}/feed.rss", server.serveFeed(site.RSS))
+	mux.HandleFunc("GET /"+site.SitemapPath, server.serveSitemap)
+	mux.HandleFunc("GET /share/{token}", server.serveShare)
+	mux.HandleFunc("GET /share/{token}/{path...}", server.serveShare)
+	mux.HandleFunc("POST /share", server.createShare)
+	if opts.Debug {
+		mux.HandleFunc("GET /debug/vars", server.serveVars)
+	}
+	mux.HandleFunc("GET "+staticPrefix+"{path...}", serveStatic(server.serveVaultPath))
+	mux.HandleFunc("GET /", server.serveVaultPath)
+
+	var handler http.Handler = mux
+	if opts.Users != "" || opts.OIDC != nil {
+		authOpts := auth.Options{SessionTTL: opts.SessionTTL, Anonymous: true, Static: staticPrefix}
+		var users *auth.Users
+		if opts.Users != "" {
+			users, err = auth.LoadUsers(opts.Users)
+			if err != nil {
+				return err
+			}
+			if len(users.Names()) == 0 {
+				return fmt.Errorf("no users in %s; add one with `mdbuddy user add`", opts.Users)
+			}
+			log.Printf("Login required; %d users in %s\n", len(users.Names()), opts.Users)
+		}
+		if opts.OIDC != nil {
+			authOpts.OIDC, err = auth.NewOIDC(context.Background(), *opts.OIDC)
+			if err != nil {
+				return err
+			}
+			if len(opts.OIDC.Allow) == 0 {
+				log.Printf("Login required; anyone %s lets in may log in\n", opts.OIDC.Issuer)
+			} else {
+				log.Printf("Login required; users of %s with any of %v may log in\n", opts.OIDC.Issuer, opts.OIDC.Allow)
+			}
+		}
+
+		server.auth = auth.New(users, authOpts)
+		mux.HandleFunc("GET /login", server.auth.HandleLoginPage)
+		mux.HandleFunc("POST /login", server.auth.HandleLogin)
+		mux.HandleFunc("GET /login/oidc", server.auth.HandleOIDCLogin)
+		mux.HandleFunc("GET /login/oidc/callback", server.auth.HandleOIDCCallback)
+		mux.HandleFunc("POST /logout", server.auth.HandleLogout)
+		handler = server.auth.Middleware(mux)
+	}
+
+	return listenAndServe("Vault", listen, csp.Middleware(handler))
+}
+
+// Keep the vault and the search index in sync with the filesystem.
+func (s *vaultServer) handleWatchEvent(event watchEvent) {
+	relPath, ok := s.vault.Rel(event.Path)
+	if !ok {
+		return
+	}
+	if path.Base(relPath) == vault.ConfigName && !isHidden(path.Dir(relPath)) {
+		s.vault.ForgetConfig(path.Dir(relPath))
+		s.reshaped.Store(time.Now().UnixNano()) // Notes may have become private, or public
+		return
+	}
+	if isHidden(relPath) {
+		return
+	}
+	s.links.invalidate()
+
+	appeared := event.Mask&(unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO) != 0
+	disappeared := event.Mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0
+	if event.IsDir() || disappeared || appeared && s.vault.Note(relPath) == nil {
+		s.reshaped.Store(time.Now().UnixNano())
+	}
+
+	if event.IsDir() {
+		switch {
+		case event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+			s.watcher.addWatchRecursively(event.Path)
+			s.reloadFolder(relPath)
+		case disappeared:
+			s.watcher.removeWatchesUnder(event.Path)
+			for _, relPath := range s.vault.Files(relPath) {
+				s.forget(relPath)
+			}
+		}
+		return
+	}
+
+	switch {
+	case appeared && vault.IsNote(relPath):
+		s.reload(relPath)
+	case appeared:
+		s.vault.AddAttachment(relPath)
+	case disappeared:
+		s.forget(relPath)
+	}
+}
+
+// (Re)load a single note from disk.
+func (s *vaultServer) reload(relPath string) {
+	note, err := s.vault.Load(relPath)
+	if err != nil {
+		log.Printf("Failed to load note: %v", err)
+		return
+	}
+	s.index.Add(note)
+}
+
+// (Re)load every note in the folder `dir`, recursively.
+func (s *vaultServer) reloadFolder(dir string) {
+	s.vault.Walk(func(relPath string, d fs.DirEntry) error {
+		if !strings.HasPrefix(relPath, dir+"/") {
+			return nil
+		}
+		if vault.IsNote(relPath) {
+			s.reload(relPath)
+		} else {
+			s.vault.AddAttachment(relPath)
+		}
+		return nil
+	})
+}
+
+// Drop a note or attachment that no longer exists.
+func (s *vaultServer) forget(relPath string) {
+	s.vault.Remove(relPath)
+	s.index.Remove(relPath)
+}
+
+// Serve the note, folder or attachment at the request path.
+func (s *vaultServer) serveVaultPath(w http.ResponseWriter, r *http.Request) {
+	relPath := strings.Trim(path.Clean(r.URL.Path), "/")
+	if isHidden(relPath) {
+		s.serveNotFound(w, r)
+		return
+	}
+
+	l := s.linker(r, nil)
+	if note := s.vault.Note(relPath + ".md"); note != nil {
+		if l.CanView(note) {
+			s.serveNote(w, r, note)
+		} else {
+			s.serveNotFound(w, r)
+		}
+		return
+	}
+
+	info, err := os.Stat(s.vault.Abs(relPath))
+	switch {
+	case err != nil:
+		s.serveNotFound(w, r)
+	case info.IsDir():
+		s.serveFolder(w, r, relPath)
+	case l.LoggedIn || s.links.linksTo(l, relPath):
+		http.ServeFile(w, r, s.vault.Abs(relPath))
+	default:
+		s.serveNotFound(w, r)
+	}
+}
+
+func (s *vaultServer) serveNote(w http.ResponseWriter, r *http.Request, note *vault.Note) {
+	l := s.linker(r, note)
+	backlinks := s.links.backlinks(note.Path)
+	page := site.NotePage(l, note, backlinks)
+	if err := site.RenderNote(l, note, &page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if s.canShare(r) {
+		page.Share = note.Path
+	}
+	s.renderPage(w, r, http.StatusOK, "note.html", page, s.noteModTime(l, note, backlinks))
+}
+
+func (s *vaultServer) serveFolder(w http.ResponseWriter, r *http.Request, dir string) {
+	page, ok := site.FolderPage(s.linker(r, nil), dir)
+	if !ok {
+		s.serveNotFound(w, r)
+		return
+	}
+	if s.canShare(r) {
+		page.Share = dir + "/" // "/" for the root
+	}
+	s.renderPage(w, r, http.StatusOK, "list.html", page, s.modTime(s.vault.Notes()...))
+}
+
+func (s *vaultServer) serveTags(w http.ResponseWriter, r *http.Request) {
+	s.renderPage(w, r, http.StatusOK, "list.html", site.TagsPage(s.linker(r, nil)), s.modTime(s.vault.Notes()...))
+}
+
+func (s *vaultServer) serveTag(w http.ResponseWriter, r *http.Request) {
+	l := s.linker(r, nil)
+	page, ok := site.TagPage(l, r.PathValue("tag"))
+	if !ok {
+		s.serveNotFound(w, r)
+		return
+	}
+	page.Feed = l.URL(site.FeedPath(r.PathValue("tag"), site.Atom))
+	s.renderPage(w, r, http.StatusOK, "list.html", page, s.modTime(s.vault.Notes()...))
+}
+
+// Serve the feed of all notes, or of a tag's notes, in the given format.
+func (s *vaultServer) serveFeed(format string) http.HandlerFunc {
+	contentType := map[string]string{
+		site.Atom: "application/atom+xml; charset=utf-8",
+		site.RSS:  "application/rss+xml; charset=utf-8",
+	}[format]
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := s.linker(r, nil)
+		l.BaseURL = baseURL(r)
+		feed, ok := site.NewFeed(l, r.PathValue("tag"), format)
+		if !ok {
+			s.serveNotFound(w, r)
+			return
+		}
+		var buf bytes.Buffer
+		if err := feed.Write(&buf, format); err != nil {
+			log.Printf("Failed to write feed: %v", err)
+			http.Error(w, "failed to write feed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		writeCached(w, r, http.StatusOK, buf.Bytes(), s.modTime(s.vault.Notes()...), s.cachePolicy(r))
+	}
+}
+
+func (s *vaultServer) serveSitemap(w http.ResponseWriter, r *http.Request) {
+	l := s.linker(r, nil)
+	l.BaseURL = baseURL(r)
+	var buf bytes.Buffer
+	if err := site.WriteSitemap(&buf, l); err != nil {
+		log.Printf("Failed to write sitemap: %v", err)
+		http.Error(w, "failed to write sitemap", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	writeCached(w, r, http.StatusOK, buf.Bytes(), s.modTime(s.vault.Notes()...), s.cachePolicy(r))
+}
+
+// The URL the server was reached at, for the absolute URLs in feeds and the
+// sitemap. Behind a reverse proxy trusted to say, the scheme it was reached
+// with; see ListenOptions.TrustProxy.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if r.URL.Scheme == "http" || r.URL.Scheme == "https" {
+		scheme = r.URL.Scheme
+	}
+	return scheme + "://" + r.Host
+}
+
+// Serve the 404 page. Anonymous users are sent to the login page instead,
+// if there is one: what they asked for may well exist, but be private.
+func (s *vaultServer) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	if s.auth != nil && auth.User(r) == "" {
+		auth.LoginRequired(w, r)
+		return
+	}
+	s.renderPage(w, r, http.StatusNotFound, "404.html", site.NotFoundPage(s.linker(r, nil)), time.Time{})
+}
+
+// The linker for pages served in response to `r`: for `note`, or for a page
+// that isn't a note if nil.
+func (s *vaultServer) linker(r *http.Request, note *vault.Note) *site.Linker {
+	l := site.NewLinker(s.vault, note, false)
+	l.LoggedIn = auth.User(r) != ""
+	l.Drafts = s.opts.Drafts || l.LoggedIn
+	if s.auth != nil {
+		l.DefaultVisibility = vault.Private
+	}
+	l.Sanitizer = s.sanitizer(r)
+	l.RenderCache = s.renders
+	return l
+}
+
+// The sanitizer for notes served in response to `r`, or nil to serve their
+// HTML as-is; see VaultOptions.Sanitize.
+func (s *vaultServer) sanitizer(r *http.Request) *renderer.Sanitizer {
+	if s.opts.Sanitize == SanitizeAnonymous && auth.User(r) != "" {
+		return nil
+	}
+	return s.html
+}
+
+// Serve the expvar counters, like those of the render cache, for monitoring;
+// see VaultOptions.Debug. Only to logged-in users if logging in is enabled.
+func (s *vaultServer) serveVars(w http.ResponseWriter, r *http.Request) {
+	if s.auth != nil && auth.User(r) == "" {
+		s.serveNotFound(w, r)
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
+
+// Report whether the user making `r` may create share links.
+func (s *vaultServer) canShare(r *http.Request) bool {
+	return s.auth == nil || auth.User(r) != ""
+}
+
+// Render a page template with the chrome every vault server page shares.
+// `modTime` is when what's on it last changed, if known; see modTime.
+func (s *vaultServer) renderPage(w http.ResponseWriter, r *http.Request, status int, name string, page renderer.Page, modTime time.Time) {
+	page.Search = true
+	page.Nonce = csp.Nonce(r)
+	page.Static = staticPrefix
+	if page.User = auth.User(r); page.User != "" || page.Share != "" {
+		page.CSRF = auth.CSRFToken(w, r)
+	}
+	if page.Feed == "" {
+		page.Feed = "/" + site.FeedPath("", site.Atom)
+	}
+
+	var buf bytes.Buffer
+	if err := renderer.RenderPage(&buf, name, page); err != nil {
+		log.Printf("Failed to render %s: %v", name, err)
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+	policy := s.cachePolicy(r)
+	if page.CSRF != "" {
+		policy = cachePrivatePage // It's in a cookie, too
+	}
+	w.Header().Set("Content-Type", "text/html")
+	writeCached(w, r, status, buf.Bytes(), modTime, policy)
+}
+
+// When what the server shows of `notes` last changed, for Last-Modified: the
+// newest of their modification times, of when notes or folders last came or
+// went, and of when the server started.
+func (s *vaultServer) modTime(notes ...*vault.Note) time.Time {
+	modTime := s.started
+	if reshaped := time.Unix(0, s.reshaped.Load()); reshaped.After(modTime) {
+		modTime = reshaped
+	}
+	for _, note := range notes {
+		if note != nil && note.ModTime.After(modTime) {
+			modTime = note.ModTime
+		}
+	}
+	return modTime
+}
+
+// When the page of `note` last changed, for Last-Modified: like modTime,
+// for the note, the notes it links to, the notes linking to it (`backlinks`)
+// and the notes next to it in the sidebar.
+func (s *vaultServer) noteModTime(l *site.Linker, note *vault.Note, backlinks []string) time.Time {
+	_, notes := l.Folder(strings.Trim(path.Dir(note.Path), "."))
+	notes = append(notes, note)
+	for _, link := range note.Links {
+		notes = append(notes, l.ResolveNote(link))
+	}
+	for _, backlink := range backlinks {
+		notes = append(notes, s.vault.Note(backlink))
+	}
+	return s.modTime(notes...)
+}
+
+// The Cache-Control policy for pages served in response to `r`. What logged-in
+// users see is for them only.
+func (s *vaultServer) cachePolicy(r *http.Request) string {
+	if auth.User(r) != "" {
+		return cachePrivatePage
+	}
+	return cachePage
+}
+
+func (s *vaultServer) serveSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	var results []search.Result
+	if q := search.ParseQuery(query); !q.IsEmpty() {
+		q.Filter = s.searchFilter(r)
+		results = s.index.Search(q, searchLimit(r))
+	}
+
+	s.renderPage(w, r, http.StatusOK, "search.html", renderer.Page{
+		Title: "Search",
+		Query: query,
+		Data:  results,
+	}, s.modTime(s.vault.Notes()...))
+}
+
+func (s *vaultServer) serveSearchJSON(w http.ResponseWriter, r *http.Request) {
+	query := search.ParseQuery(r.URL.Query().Get("q"))
+	results := []search.Result{}
+	if !query.IsEmpty() {
+		query.Filter = s.searchFilter(r)
+		results = append(results, s.index.Search(query, searchLimit(r))...)
+	}
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		log.Printf("Failed to write search results: %v", err)
+		http.Error(w, "failed to write search results", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeCached(w, r, http.StatusOK, body, s.modTime(s.vault.Notes()...), s.cachePolicy(r))
+}
+
+// Hides search results the linker for `r` doesn't show.
+func (s *vaultServer) searchFilter(r *http.Request) func(path string) bool {
+	l := s.linker(r, nil)
+	return func(path string) bool {
+		return l.Shows(s.vault.Note(path))
+	}
+}
+
+// The `limit` query parameter, or defaultSearchLimit.
+func searchLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return defaultSearchLimit
+	}
+	return limit
+}
+
+// Report whether any element of the slash-separated `relPath` starts with a
+// '.', like the directories the watcher skips.
+func isHidden(relPath string) bool {
+	for _, elem := range strings.Split(relPath, "/") {
+		if len(elem) > 0 && elem[0] == '.' {
+			return true
+		}
+	}
+	return false
+}
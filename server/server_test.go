@@ -0,0 +1,263 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flonle/mdbuddy/assets"
+	"github.com/flonle/mdbuddy/server/csp"
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/search"
+	"github.com/flonle/mdbuddy/vault/site"
+)
+
+// A vault server for the notes `notes` (path : source) that serves their HTML
+// as-is, and no login.
+func newTestVaultServer(t *testing.T, notes map[string]string) *vaultServer {
+	t.Helper()
+	root := t.TempDir()
+	for relPath, source := range notes {
+		abs := filepath.Join(root, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := vault.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &vaultServer{
+		vault:   v,
+		index:   search.IndexVault(v),
+		links:   newLinks(v),
+		opts:    VaultOptions{Sanitize: SanitizeNever},
+		started: time.Now(),
+	}
+}
+
+// Serve `r` with `handler` behind the CSP middleware, as the servers do.
+func serveCSP(handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	csp.Middleware(handler).ServeHTTP(w, r)
+	return w
+}
+
+// The nonce the Content-Security-Policy of `w` allows scripts with.
+func scriptNonce(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	match := regexp.MustCompile(`script-src 'nonce-([^']+)'`).FindStringSubmatch(w.Header().Get("Content-Security-Policy"))
+	if match == nil {
+		t.Fatalf("no script nonce in policy %q", w.Header().Get("Content-Security-Policy"))
+	}
+	return match[1]
+}
+
+// Raw HTML in a note is served as-is when it isn't sanitized, but the
+// nonce only goes where the templates put it, so scripts of the note don't
+// run, even with the placeholder the nonce used to be substituted for.
+const placeholderNote = "# Sneaky\n\n<script nonce=\"" + csp.Placeholder + "\">alert(1)</script>\n"
+
+func TestNoteCannotUseNonce(t *testing.T) {
+	s := newTestVaultServer(t, map[string]string{"sneaky.md": placeholderNote})
+
+	var etag string
+	var cookies []*http.Cookie // Of the CSRF token, which is on the page too
+	get := func(header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/sneaky", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		if len(header) == 2 {
+			r.Header.Set(header[0], header[1])
+		}
+		w := serveCSP(s.serveVaultPath, r)
+		cookies = append(cookies, w.Result().Cookies()...)
+		return w
+	}
+	for range 2 {
+		w := get()
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d", w.Code)
+		}
+		nonce := scriptNonce(t, w)
+		body := w.Body.String()
+		if !strings.Contains(body, `nonce="`+nonce+`"`) {
+			t.Error("the page's own scripts don't have the nonce")
+		}
+		if strings.Contains(body, nonce+`">alert(1)`) {
+			t.Error("the note's script got the nonce")
+		}
+		if !strings.Contains(body, `<script nonce="`+csp.Placeholder+`">alert(1)`) {
+			t.Error("the note's script isn't served as written")
+		}
+		if etag != "" && w.Header().Get("ETag") != etag {
+			t.Errorf("got ETag %s, then %s: it changes with the nonce", etag, w.Header().Get("ETag"))
+		}
+		etag = w.Header().Get("ETag")
+	}
+
+	if w := get("If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("got status %d for the current ETag, want %d", w.Code, http.StatusNotModified)
+	}
+}
+
+func TestPreviewCannotUseNonce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sneaky.md")
+	if err := os.WriteFile(path, []byte(placeholderNote), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &previewServer{previewFile: path}
+
+	for range 2 { // Rendered, then from the last render
+		w := serveCSP(s.servePreview, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d", w.Code)
+		}
+		nonce := scriptNonce(t, w)
+		body := w.Body.String()
+		if !strings.Contains(body, `nonce="`+nonce+`"`) {
+			t.Error("the preview's own scripts don't have the nonce")
+		}
+		if strings.Contains(body, nonce+`">alert(1)`) {
+			t.Error("the note's script got the nonce")
+		}
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	s := newTestVaultServer(t, map[string]string{
+		"note.md":  "# Note\n",
+		"draft.md": "---\ndraft: true\n---\n# Draft\n",
+	})
+	r := httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil)
+	r.Host = "notes.example.com:8080"
+	w := httptest.NewRecorder()
+	s.serveSitemap(w, r)
+
+	body := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/xml; charset=utf-8" {
+		t.Fatalf("got %d %q, want the sitemap", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(body, "<loc>http://notes.example.com:8080/note</loc>") {
+		t.Errorf("sitemap doesn't list the note at an absolute URL:\n%s", body)
+	}
+	if strings.Contains(body, "draft") {
+		t.Errorf("sitemap lists the draft:\n%s", body)
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	s := newTestVaultServer(t, map[string]string{
+		"a.md":     "# A\n\nSee [[draft]] and [[later]]. #tag\n",
+		"draft.md": "---\ndraft: true\n---\n# Draft\n\nSecret plans. #tag\n",
+		"later.md": "---\npublish: 2999-01-01\n---\n# Later\n\nSecret plans.\n",
+	})
+	tests := []struct {
+		handler    http.HandlerFunc
+		target     string
+		wantStatus int
+	}{
+		{s.serveVaultPath, "/draft", http.StatusNotFound},
+		{s.serveVaultPath, "/later", http.StatusNotFound},
+		{s.serveVaultPath, "/a", http.StatusOK},
+		{s.serveSearch, "/search?q=secret", http.StatusOK},
+		{s.serveSearchJSON, "/api/search?q=secret", http.StatusOK},
+		{s.serveFeed(site.Atom), "/feed.atom", http.StatusOK},
+		{s.serveSitemap, "/sitemap.xml", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := serveCSP(tt.handler, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.target, w.Code, tt.wantStatus)
+		}
+		body := w.Body.String()
+		if strings.Contains(body, "Secret plans") || strings.Contains(body, `/draft"`) || strings.Contains(body, `/later"`) ||
+			strings.Contains(body, "/draft<") || strings.Contains(body, "/later<") {
+			t.Errorf("%s links to or shows a draft:\n%s", tt.target, body)
+		}
+	}
+}
+
+func TestPrivateNotesAreNotSearched(t *testing.T) {
+	s := newTestVaultServer(t, map[string]string{
+		"public.md":         "# Public\n\nNothing secret here.\n",
+		"unlisted.md":       "---\nvisibility: unlisted\n---\n# Unlisted\n\nA secret.\n",
+		"private/.mdbuddy":  "visibility: private\n",
+		"private/secret.md": "# Secret\n\nThe secret.\n",
+	})
+	w := serveCSP(s.serveSearchJSON, httptest.NewRequest(http.MethodGet, "/api/search?q=secret", nil))
+	var results []search.Result
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Path != "public.md" {
+		t.Errorf("got results %+v, want only public.md", results)
+	}
+}
+
+// The preview server doesn't serve what other packages register on
+// http.DefaultServeMux, like expvar's /debug/vars.
+func TestPreviewRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "note.md")
+	if err := os.WriteFile(path, []byte("# Note\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &previewServer{previewFile: path}
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/", http.StatusOK},
+		{"/debug/vars", http.StatusNotFound},
+		{"/debug/pprof/", http.StatusNotFound},
+		{staticPrefix + "missing.css", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := serveCSP(s.mux().ServeHTTP, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.target, w.Code, tt.status)
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+staticPrefix+"{path...}", serveStatic(http.NotFound))
+	fingerprinted := assets.Fingerprint("css/layout.css")
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{staticPrefix + fingerprinted, http.StatusOK},
+		{staticPrefix + "css/layout.css", http.StatusNotFound},
+		{staticPrefix + "css/layout.0000000000000000.css", http.StatusNotFound}, // Stale
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.target, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Cache-Control"); got != cacheImmutable {
+			t.Errorf("%s: got Cache-Control %q, want %q", tt.target, got, cacheImmutable)
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/css") {
+			t.Errorf("%s: got Content-Type %q", tt.target, got)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flonle/mdbuddy/renderer"
+	"github.com/flonle/mdbuddy/server/auth"
+	"github.com/flonle/mdbuddy/server/csp"
+	"github.com/flonle/mdbuddy/server/share"
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/site"
+)
+
+// Serve a shared note or folder, or an attachment of one, to anyone with the
+// token. Shared pages only link to what the share covers: no search, and a
+// sidebar limited to the shared folder.
+func (s *vaultServer) serveShare(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	sh, err := s.shares.Lookup(token)
+	switch {
+	case errors.Is(err, share.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, share.ErrExpired):
+		s.renderSharePage(w, r, http.StatusGone, "message.html", renderer.Page{
+			Title: "Link expired",
+			Data:  "This share link has expired, or has been used too many times.",
+		}, time.Time{}, nil)
+		return
+	case err != nil:
+		log.Printf("Failed to look up share: %v", err)
+		http.Error(w, "failed to look up share", http.StatusInternalServerError)
+		return
+	}
+
+	l := s.shareLinker(r, sh, token)
+	relPath := strings.Trim(path.Clean("/"+r.PathValue("path")), "/")
+	if relPath == "" {
+		// The root of the share is whatever was shared
+		relPath = strings.TrimSuffix(strings.TrimSuffix(sh.Path, ".md"), "/")
+	}
+	if isHidden(relPath) {
+		s.serveShareNotFound(w, r, l)
+		return
+	}
+
+	if note := s.vault.Note(relPath + ".md"); l.CanView(note) {
+		backlinks := s.links.backlinks(note.Path)
+		page := site.NotePage(l, note, backlinks)
+		if err := site.RenderNote(l, note, &page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.Nav = s.shareNav(l, sh, path.Dir(note.Path), note)
+		s.renderSharePage(w, r, http.StatusOK, "note.html", page, s.noteModTime(l, note, backlinks), func() bool {
+			return s.viewShare(w, r, token, note.Path)
+		})
+		return
+	}
+
+	if sh.IsFolder() && l.InScope(relPath+"/") {
+		page, ok := site.FolderPage(l, relPath)
+		if !ok {
+			s.serveShareNotFound(w, r, l)
+			return
+		}
+		page.Nav = s.shareNav(l, sh, relPath, nil)
+		s.renderSharePage(w, r, http.StatusOK, "list.html", page, s.modTime(s.vault.Notes()...), func() bool {
+			return s.viewShare(w, r, token, relPath+"/")
+		})
+		return
+	}
+
+	if s.shareCoversAttachment(l, sh, relPath) {
+		http.ServeFile(w, r, s.vault.Abs(relPath))
+		return
+	}
+	s.serveShareNotFound(w, r, l)
+}
+
+// The linker for pages of a share: absolute URLs below /share/<token>, and
+// only the notes the share covers. Shared notes are shown even if they're
+// drafts or private; that's what sharing them is for. Folder shares only show
+// published notes that aren't private, and don't list unlisted ones.
+func (s *vaultServer) shareLinker(r *http.Request, sh share.Share, token string) *site.Linker {
+	return &site.Linker{
+		Vault:       s.vault,
+		Scope:       sh.Path,
+		Drafts:      !sh.IsFolder(),
+		LoggedIn:    !sh.IsFolder(),
+		BaseURL:     "/share/" + token,
+		Sanitizer:   s.sanitizer(r),
+		RenderCache: s.renders,
+	}
+}
+
+// The sidebar of a shared page: nothing for a note share, and the contents of
+// `dir` for a folder share, without a link above the shared folder.
+func (s *vaultServer) shareNav(l *site.Linker, sh share.Share, dir string, current *vault.Note) []renderer.NavLink {
+	if !sh.IsFolder() {
+		return nil
+	}
+	if dir == "." {
+		dir = ""
+	}
+	nav := site.FolderNav(l, dir, current)
+	if dir == "" || len(nav) == 0 {
+		return nav
+	}
+	if !l.InScope(site.FolderPath(path.Dir(dir))) {
+		nav = nav[1:] // The parent folder isn't shared
+	}
+	return nav
+}
+
+// Report whether `relPath` is an attachment the share gives access to: one
+// in the shared folder, or one linked from a shared note.
+func (s *vaultServer) shareCoversAttachment(l *site.Linker, sh share.Share, relPath string) bool {
+	if sh.IsFolder() && sh.Covers(relPath) && s.vault.HasAttachment(relPath) {
+		return true
+	}
+	return s.links.linksTo(l, relPath)
+}
+
+// Count a view of the share and log it. Renders an error and returns false
+// if the share can't be viewed (anymore).
+func (s *vaultServer) viewShare(w http.ResponseWriter, r *http.Request, token string, relPath string) bool {
+	_, err := s.shares.View(token, share.Access{
+		Time:      time.Now().UTC(),
+		Path:      relPath,
+		Addr:      auth.ClientAddr(r),
+		UserAgent: r.UserAgent(),
+	})
+	switch {
+	case errors.Is(err, share.ErrExpired):
+		s.renderSharePage(w, r, http.StatusGone, "message.html", renderer.Page{
+			Title: "Link expired",
+			Data:  "This share link has expired, or has been used too many times.",
+		}, time.Time{}, nil)
+		return false
+	case err != nil:
+		log.Printf("Failed to record share view: %v", err)
+		http.Error(w, "failed to record share view", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (s *vaultServer) serveShareNotFound(w http.ResponseWriter, r *http.Request, l *site.Linker) {
+	s.renderSharePage(w, r, http.StatusNotFound, "404.html", renderer.Page{
+		Title: "Not found",
+		Root:  l.RootURL(),
+	}, time.Time{}, nil)
+}
+
+// Render a page of a share. Unlike renderPage, no search, feeds or anything
+// else that's only for people with access to the whole vault. If `view`
+// isn't nil, it's called before the page is sent, but not when the browser
+// has it already, to count a view of the share; it returns false, and
+// responds itself, if it can't be viewed.
+func (s *vaultServer) renderSharePage(w http.ResponseWriter, r *http.Request, status int, name string, page renderer.Page, modTime time.Time, view func() bool) {
+	page.Nonce = csp.Nonce(r)
+	page.Static = staticPrefix
+	var buf bytes.Buffer
+	if err := renderer.RenderPage(&buf, name, page); err != nil {
+		log.Printf("Failed to render %s: %v", name, err)
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+	if view != nil && !cachedByClient(r, buf.Bytes(), modTime) && !view() {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Referrer-Policy", "no-referrer") // Keep the token out of other sites' logs
+	w.Header().Set("X-Robots-Tag", "noindex")
+	writeCached(w, r, status, buf.Bytes(), modTime, cachePrivatePage) // Nobody else should have the token
+}
+
+// Create a share link from the form in the header of note and folder pages.
+func (s *vaultServer) createShare(w http.ResponseWriter, r *http.Request) {
+	if !s.canShare(r) {
+		auth.LoginRequired(w, r)
+		return
+	}
+	if !auth.CheckCSRF(r) {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	target := r.PostFormValue("path")
+	l := s.linker(r, nil)
+	switch {
+	case strings.HasSuffix(target, "/"):
+		if _, ok := site.FolderPage(l, target); !ok {
+			http.Error(w, "no such folder", http.StatusBadRequest)
+			return
+		}
+	case !l.CanView(s.vault.Note(target)):
+		http.Error(w, "no such note", http.StatusBadRequest)
+		return
+	}
+
+	var expires time.Time
+	if days, _ := strconv.Atoi(r.PostFormValue("days")); days > 0 {
+		expires = time.Now().UTC().AddDate(0, 0, days).Truncate(time.Second)
+	}
+	views, _ := strconv.Atoi(r.PostFormValue("views"))
+	token, sh, err := s.shares.Create(target, expires, max(views, 0), auth.User(r))
+	if err != nil {
+		log.Printf("Failed to create share: %v", err)
+		http.Error(w, "failed to create share", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Created share %s for %s", sh.ID, sh.Path)
+
+	s.renderPage(w, r, http.StatusOK, "share.html", renderer.Page{
+		Title: "Share link created",
+		Data: struct {
+			URL   string
+			Share share.Share
+		}{fmt.Sprintf("%s/share/%s", baseURL(r), token), sh},
+	}, time.Time{})
+}
@@ -0,0 +1,333 @@
+// Package share implements share links: unguessable URLs that give anyone
+// who has them access to a single note or folder of a vault, optionally for a
+// limited time or number of views.
+package share
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flonle/mdbuddy/internal/atomicfile"
+
+	"golang.org/x/sys/unix"
+)
+
+// How many accesses the log of a share keeps; older ones are dropped.
+const maxLogLength = 100
+
+var (
+	ErrNotFound = errors.New("no such share")
+	ErrExpired  = errors.New("share expired")
+)
+
+// Where the shares of the vault at `root` are stored, unless told otherwise:
+// $XDG_CONFIG_HOME/mdbuddy/shares/<hash of root>.json, or the equivalent on
+// other platforms. Not in the vault, which may well be committed or synced
+// somewhere: the store logs who viewed what.
+func DefaultPath(root string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(root))
+	return filepath.Join(dir, "mdbuddy", "shares", hex.EncodeToString(hash[:8])+".json"), nil
+}
+
+// Where DefaultPath used to be, in the vault.
+const legacyName = ".mdbuddy-shares.json"
+
+// Open the share store of the vault at `root`: the one at `path`, or at
+// DefaultPath if it's "". Stores in the vault are refused. A store where
+// DefaultPath used to be is moved to where it is now.
+func Open(root string, path string) (*Store, error) {
+	if path == "" {
+		var err error
+		if path, err = DefaultPath(root); err != nil {
+			return nil, fmt.Errorf("failed to find a place for the share store: %w", err)
+		}
+		if err := moveLegacy(filepath.Join(root, legacyName), path); err != nil {
+			return nil, fmt.Errorf("failed to move the share store out of the vault: %w", err)
+		}
+	}
+	if inside(root, path) {
+		return nil, fmt.Errorf("the share store %s is in the vault; it logs who viewed what, so keep it elsewhere", path)
+	}
+	return NewStore(path), nil
+}
+
+// Move the store at `from`, if there is one, to `to`, unless there's one at
+// `to` already.
+func moveLegacy(from string, to string) error {
+	if _, err := os.Stat(from); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if _, err := os.Stat(to); err == nil {
+		log.Printf("Ignoring the share store %s: there's one at %s", from, to)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o700); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	// Not a rename: the vault may be on another file system
+	if err := os.WriteFile(to, b, 0o600); err != nil {
+		return err
+	}
+	os.Remove(from + ".lock")
+	log.Printf("Moved the share store from %s to %s", from, to)
+	return os.Remove(from)
+}
+
+// Report whether `path` is in the folder `root`.
+func inside(root string, path string) bool {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// A share link. Only a hash of its token is stored, so the store isn't
+// secret; the token itself is shown once, when the share is created.
+type Share struct {
+	ID        string    `json:"id"`   // Start of the token's hash, to refer to the share
+	Hash      string    `json:"hash"` // SHA-256 of the token, hex-encoded
+	Path      string    `json:"path"` // Vault-relative path of a note, or of a folder with a trailing slash
+	CreatedBy string    `json:"created_by,omitempty"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires,omitzero"`    // Zero for never
+	MaxViews  int       `json:"max_views,omitempty"` // 0 for unlimited
+	Views     int       `json:"views"`
+	Log       []Access  `json:"log,omitempty"` // Most recent last
+}
+
+// An access to a share link.
+type Access struct {
+	Time      time.Time `json:"time"`
+	Path      string    `json:"path"` // Vault-relative path of what was viewed
+	Addr      string    `json:"addr"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// Report whether the share is a folder share.
+func (s Share) IsFolder() bool {
+	return strings.HasSuffix(s.Path, "/")
+}
+
+// Report whether the share can still be used at `now`.
+func (s Share) Active(now time.Time) bool {
+	return (s.Expires.IsZero() || now.Before(s.Expires)) && (s.MaxViews == 0 || s.Views < s.MaxViews)
+}
+
+// Report whether the share gives access to the vault-relative `path`.
+func (s Share) Covers(path string) bool {
+	if s.IsFolder() {
+		return s.Path == "/" || strings.HasPrefix(path, s.Path)
+	}
+	return path == s.Path
+}
+
+// Shares are stored in a JSON file. The server and `mdbuddy share` may use
+// the same store at the same time; every change locks the file, reads it
+// again and replaces it atomically.
+type Store struct {
+	Path string
+	mx   sync.Mutex // Serializes changes within this process; the file lock does the rest
+}
+
+func NewStore(path string) *Store {
+	return &Store{Path: path}
+}
+
+// Create a share for the vault-relative `path`. Returns the token, which
+// isn't stored anywhere and can't be recovered.
+func (st *Store) Create(path string, expires time.Time, maxViews int, createdBy string) (string, Share, error) {
+	token := rand.Text()
+	hash := hashToken(token)
+	share := Share{
+		ID:        hash[:12],
+		Hash:      hash,
+		Path:      path,
+		CreatedBy: createdBy,
+		Created:   time.Now().UTC().Truncate(time.Second),
+		Expires:   expires,
+		MaxViews:  maxViews,
+	}
+	err := st.update(func(shares []Share) ([]Share, error) {
+		return append(shares, share), nil
+	})
+	return token, share, err
+}
+
+// All shares, oldest first.
+func (st *Store) List() ([]Share, error) {
+	return st.read()
+}
+
+// Find an active share by its token, without counting it as a view.
+func (st *Store) Lookup(token string) (Share, error) {
+	shares, err := st.read()
+	if err != nil {
+		return Share{}, err
+	}
+	hash := hashToken(token)
+	for _, share := range shares {
+		if share.Hash == hash {
+			if !share.Active(time.Now()) {
+				return share, ErrExpired
+			}
+			return share, nil
+		}
+	}
+	return Share{}, ErrNotFound
+}
+
+// Find an active share by its token, count a view and log `access`.
+func (st *Store) View(token string, access Access) (Share, error) {
+	var viewed Share
+	hash := hashToken(token)
+	err := st.update(func(shares []Share) ([]Share, error) {
+		i := slices.IndexFunc(shares, func(s Share) bool { return s.Hash == hash })
+		if i < 0 {
+			return nil, ErrNotFound
+		}
+		share := &shares[i]
+		if !share.Active(access.Time) {
+			viewed = *share
+			return nil, ErrExpired
+		}
+		share.Views++
+		share.Log = append(share.Log, access)
+		if len(share.Log) > maxLogLength {
+			share.Log = share.Log[len(share.Log)-maxLogLength:]
+		}
+		viewed = *share
+		return shares, nil
+	})
+	return viewed, err
+}
+
+// Delete the share with the given ID (or a unique prefix of it), or token.
+// Returns the share that was deleted.
+func (st *Store) Revoke(idOrToken string) (Share, error) {
+	var revoked Share
+	err := st.update(func(shares []Share) ([]Share, error) {
+		i, err := find(shares, idOrToken)
+		if err != nil {
+			return nil, err
+		}
+		revoked = shares[i]
+		return slices.Delete(shares, i, i+1), nil
+	})
+	return revoked, err
+}
+
+// Find a share by ID, unique ID prefix, or token.
+func (st *Store) Get(idOrToken string) (Share, error) {
+	shares, err := st.read()
+	if err != nil {
+		return Share{}, err
+	}
+	i, err := find(shares, idOrToken)
+	if err != nil {
+		return Share{}, err
+	}
+	return shares[i], nil
+}
+
+func find(shares []Share, idOrToken string) (int, error) {
+	hash := hashToken(idOrToken)
+	found := -1
+	for i, share := range shares {
+		if share.Hash == hash {
+			return i, nil
+		}
+		if idOrToken != "" && strings.HasPrefix(share.ID, idOrToken) {
+			if found >= 0 {
+				return -1, fmt.Errorf("ambiguous share ID: %s", idOrToken)
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return -1, ErrNotFound
+	}
+	return found, nil
+}
+
+func (st *Store) read() ([]Share, error) {
+	b, err := os.ReadFile(st.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shares: %w", err)
+	}
+	var shares []Share
+	if err := json.Unmarshal(b, &shares); err != nil {
+		return nil, fmt.Errorf("failed to read shares from %s: %w", st.Path, err)
+	}
+	return shares, nil
+}
+
+// Read the shares, let `fn` change them and write the result back, all while
+// holding a lock on the store. Nothing is written if `fn` fails.
+func (st *Store) update(fn func([]Share) ([]Share, error)) error {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(st.Path), 0o700); err != nil {
+		return fmt.Errorf("failed to save shares: %w", err)
+	}
+	lock, err := os.OpenFile(st.Path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to lock shares: %w", err)
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock shares: %w", err)
+	}
+	defer unix.Flock(int(lock.Fd()), unix.LOCK_UN)
+
+	shares, err := st.read()
+	if err != nil {
+		return err
+	}
+	if shares, err = fn(shares); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(shares, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.Write(st.Path, append(b, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to save shares: %w", err)
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
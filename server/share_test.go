@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flonle/mdbuddy/server/share"
+)
+
+func TestShareRevalidationIsNotAView(t *testing.T) {
+	s := newTestVaultServer(t, map[string]string{"shared.md": "# Shared\n\nHello.\n"})
+	var err error
+	if s.shares, err = share.Open(s.vault.Root, filepath.Join(t.TempDir(), "shares.json")); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := s.shares.Create("shared.md", time.Time{}, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /share/{token}", s.serveShare)
+	get := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/share/"+token, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		return serveCSP(mux.ServeHTTP, r)
+	}
+
+	w := get("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("first view: got status %d", w.Code)
+	}
+	for range 3 {
+		if w := get(w.Header().Get("ETag")); w.Code != http.StatusNotModified {
+			t.Fatalf("revalidation: got status %d, want %d", w.Code, http.StatusNotModified)
+		}
+	}
+	if w := get(""); w.Code != http.StatusOK {
+		t.Fatalf("second view: got status %d, want %d: revalidations were counted as views", w.Code, http.StatusOK)
+	}
+	if w := get(""); w.Code != http.StatusGone {
+		t.Errorf("third view: got status %d, want %d", w.Code, http.StatusGone)
+	}
+}
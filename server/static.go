@@ -0,0 +1,53 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/flonle/mdbuddy/assets"
+)
+
+// Where both servers serve the embedded stylesheets and scripts; see
+// serveStatic.
+const staticPrefix = "/static/"
+
+// Serve the embedded assets at their fingerprinted names (see
+// assets.Fingerprint), which the pages link to. What's at such a name never
+// changes, so caches may keep it forever. Anything else under staticPrefix,
+// even an asset under its plain name, is left to `fallback`, so that e.g. a
+// vault can still have a static folder.
+func serveStatic(fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("path")
+		b, ok := assets.ReadFingerprinted(name)
+		if !ok {
+			fallback(w, r)
+			return
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		writeCached(w, r, http.StatusOK, b, buildTime(), cacheImmutable)
+	}
+}
+
+// When the embedded assets were built, for Last-Modified: the modification
+// time of the executable, or when it started if that's unknown.
+var buildTime = sync.OnceValue(func() time.Time {
+	started := time.Now()
+	exe, err := os.Executable()
+	if err != nil {
+		return started
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return started
+	}
+	return info.ModTime()
+})
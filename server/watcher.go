@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// A recursive inotify watcher. Linux only.
+type watcher struct {
+	inotifyInstanceFD int            // The inotify instance file descriptor
+	mask              uint32         // The events every watch subscribes to
+	watches           map[int]string // watch descriptor : (absolute) path
+	watchesMx         sync.RWMutex   // Protects watches
+}
+
+// A single inotify event, with the watch descriptor already resolved.
+type watchEvent struct {
+	Path string // Absolute path of the file or directory the event is about
+	Mask uint32
+}
+
+func (e watchEvent) IsDir() bool {
+	return e.Mask&unix.IN_ISDIR != 0
+}
+
+// Initialize an inotify instance whose watches subscribe to `mask`.
+func newWatcher(mask uint32) (*watcher, error) {
+	inotifyfd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize inotify: %v", err)
+	}
+	return &watcher{
+		inotifyInstanceFD: inotifyfd,
+		mask:              mask,
+		watches:           map[int]string{},
+	}, nil
+}
+
+func (w *watcher) Close() error {
+	return unix.Close(w.inotifyInstanceFD)
+}
+
+// Read events from the inotify instance and hand each one to `handle`.
+// Blocking!
+func (w *watcher) run(handle func(watchEvent)) {
+	buf := make([]byte, unix.SizeofInotifyEvent*4096)
+	for {
+		n, err := unix.Read(w.inotifyInstanceFD, buf)
+		if err != nil {
+			log.Fatalf("Failed to read events: %v", err)
+		}
+
+		offset := 0
+		for offset < n {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			w.watchesMx.RLock()
+			path := w.watches[int(event.Wd)]
+			w.watchesMx.RUnlock()
+
+			// Extract filename if present
+			nameLen := int(event.Len)
+			if nameLen > 0 {
+				nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+nameLen]
+				path = filepath.Join(path, string(nameBytes[:clen(nameBytes)]))
+			}
+
+			handle(watchEvent{Path: path, Mask: event.Mask})
+
+			offset += unix.SizeofInotifyEvent + nameLen
+		}
+	}
+}
+
+func clen(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// Add a new inotify watch for the given path. If that path is a directory,
+// traverse it recursively to add a watch for all subdirectories, too.
+//
+// Ignores directories that start with '.' !
+func (w *watcher) addWatchRecursively(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Failed to stat %s: %v", path, err)
+	}
+
+	if info.IsDir() {
+		err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+
+			name := d.Name()
+			if len(name) > 0 && name[0] == '.' {
+				return fs.SkipDir // Skip directories starting with .
+			}
+			w.addWatch(path)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		w.addWatch(path)
+	}
+
+	return nil
+}
+
+// Add a new inotify watch for a single given path.
+func (w *watcher) addWatch(path string) error {
+	wd, err := unix.InotifyAddWatch(w.inotifyInstanceFD, path, w.mask)
+	if err != nil {
+		return err
+	}
+	w.watchesMx.Lock()
+	w.watches[wd] = path
+	w.watchesMx.Unlock()
+	return nil
+}
+
+// Return a []string with the absolute version of all paths in `paths`.
+func normalizePaths(paths []string) ([]string, error) {
+	absPaths := make([]string, len(paths))
+	for i, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return absPaths, fmt.Errorf("failed to get absolute path for %s: %v", path, err)
+		}
+		absPaths[i] = absPath
+	}
+	return absPaths, nil
+}
+
+// Remove the watches for `path` and everything below it, e.g. after the
+// directory was moved away.
+func (w *watcher) removeWatchesUnder(path string) {
+	w.watchesMx.Lock()
+	defer w.watchesMx.Unlock()
+	for wd, watched := range w.watches {
+		if watched == path || strings.HasPrefix(watched, path+string(filepath.Separator)) {
+			unix.InotifyRmWatch(w.inotifyInstanceFD, uint32(wd))
+			delete(w.watches, wd)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Name of the file that configures a folder and everything below it. It's
+// YAML, e.g.
+//
+//	visibility: private
+//	lint:
+//	  filename: warning
+const ConfigName = ".mdbuddy"
+
+// Who gets to see a note when it's served or built.
+type Visibility string
+
+const (
+	Public   Visibility = "public"   // Anyone
+	Private  Visibility = "private"  // Only logged-in users; left out of static builds
+	Unlisted Visibility = "unlisted" // Anyone with the URL, but left out of navigation, search, feeds, ...
+)
+
+// Parse a visibility. Anything but the known values counts as private, so
+// that a typo doesn't publish a note.
+func ParseVisibility(s string) Visibility {
+	switch v := Visibility(strings.ToLower(strings.TrimSpace(s))); v {
+	case "", Public, Private, Unlisted:
+		return v
+	}
+	return Private
+}
+
+// The configuration in a .mdbuddy file. Settings that are left out are
+// inherited from the parent folder.
+type Config struct {
+	Visibility Visibility `yaml:"visibility"` // Of the notes that don't set one themselves
+
+	// Severities of the `mdbuddy lint` rules for the files in the folder, by
+	// rule name; see package lint. Rules are inherited one by one.
+	Lint map[string]string `yaml:"lint"`
+}
+
+// Return the configuration of the folder `dir` ("" for the vault root),
+// merged with that of its parents. Config files are read once and cached;
+// see ForgetConfig.
+func (v *Vault) Config(dir string) Config {
+	if dir == "." {
+		dir = ""
+	}
+	v.configsMx.Lock()
+	defer v.configsMx.Unlock()
+	return v.config(dir)
+}
+
+// Caller must hold configsMx.
+func (v *Vault) config(dir string) Config {
+	if c, ok := v.configs[dir]; ok {
+		return c
+	}
+
+	var parent Config
+	if dir != "" {
+		parentDir := path.Dir(dir)
+		if parentDir == "." {
+			parentDir = ""
+		}
+		parent = v.config(parentDir)
+	}
+	c, err := readConfig(v.readFile, path.Join(dir, ConfigName))
+	if err != nil {
+		log.Printf("Ignoring %s: %v", path.Join(dir, ConfigName), err)
+	}
+	if c.Visibility == "" {
+		c.Visibility = parent.Visibility
+	}
+	for rule, severity := range parent.Lint {
+		if _, ok := c.Lint[rule]; !ok {
+			if c.Lint == nil {
+				c.Lint = map[string]string{}
+			}
+			c.Lint[rule] = severity
+		}
+	}
+	v.configs[dir] = c
+	return c
+}
+
+func readConfig(readFile func(string) ([]byte, error), name string) (Config, error) {
+	var c Config
+	b, err := readFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	var raw struct {
+		Visibility string            `yaml:"visibility"`
+		Lint       map[string]string `yaml:"lint"`
+	}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		// Fail closed: a broken config shouldn't publish what it was hiding
+		return Config{Visibility: Private}, fmt.Errorf("invalid YAML: %w", err)
+	}
+	c.Visibility = ParseVisibility(raw.Visibility)
+	c.Lint = raw.Lint
+	return c, nil
+}
+
+// Drop the cached configuration of `dir` and the folders below it, after its
+// .mdbuddy file changed.
+func (v *Vault) ForgetConfig(dir string) {
+	if dir == "." {
+		dir = ""
+	}
+	v.configsMx.Lock()
+	defer v.configsMx.Unlock()
+	for d := range v.configs {
+		if dir == "" || d == dir || strings.HasPrefix(d, dir+"/") {
+			delete(v.configs, d)
+		}
+	}
+}
+
+// The visibility of `note`: from its front matter, or else from the .mdbuddy
+// files of its folder and their parents. "" if none of them set one.
+func (v *Vault) Visibility(note *Note) Visibility {
+	if note.Visibility != "" {
+		return note.Visibility
+	}
+	return v.Config(path.Dir(note.Path)).Visibility
+}
@@ -0,0 +1,132 @@
+package vault
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A vault in a temporary folder with `files` (slash-separated path : content).
+func openTestVault(t *testing.T, files map[string]string) *Vault {
+	t.Helper()
+	root := t.TempDir()
+	for relPath, content := range files {
+		abs := filepath.Join(root, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestParseVisibility(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Visibility
+	}{
+		{"", ""},
+		{"public", Public},
+		{" Private ", Private},
+		{"UNLISTED", Unlisted},
+		{"privat", Private},
+		{"hidden", Private},
+		{"true", Private},
+	}
+	for _, tt := range tests {
+		if got := ParseVisibility(tt.s); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	v := openTestVault(t, map[string]string{
+		ConfigName:                       "lint:\n  filename: warning\n",
+		"private/" + ConfigName:          "visibility: private\n",
+		"private/sub/" + ConfigName:      "lint:\n  filename: error\n  callout: off\n",
+		"private/sub/open/" + ConfigName: "visibility: public\n",
+		"unlisted/" + ConfigName:         "visibility: Unlisted\n",
+		"typo/" + ConfigName:             "visibility: privat\n",
+		"broken/" + ConfigName:           "visibility: [public\n",
+		"broken/public/" + ConfigName:    "lint:\n  filename: off\n",
+	})
+	tests := []struct {
+		dir        string
+		visibility Visibility
+		lint       map[string]string
+	}{
+		{"", "", map[string]string{"filename": "warning"}},
+		{".", "", map[string]string{"filename": "warning"}},
+		{"other", "", map[string]string{"filename": "warning"}},
+		{"private", Private, map[string]string{"filename": "warning"}},
+		{"private/sub", Private, map[string]string{"filename": "error", "callout": "off"}},
+		{"private/sub/deeper", Private, map[string]string{"filename": "error", "callout": "off"}},
+		{"private/sub/open", Public, map[string]string{"filename": "error", "callout": "off"}},
+		{"unlisted", Unlisted, map[string]string{"filename": "warning"}},
+		// Fail closed
+		{"typo", Private, map[string]string{"filename": "warning"}},
+		{"broken", Private, map[string]string{"filename": "warning"}},
+		{"broken/public", Private, map[string]string{"filename": "off"}},
+	}
+	for _, tt := range tests {
+		c := v.Config(tt.dir)
+		if c.Visibility != tt.visibility {
+			t.Errorf("%q: got visibility %q, want %q", tt.dir, c.Visibility, tt.visibility)
+		}
+		if len(c.Lint) != len(tt.lint) {
+			t.Errorf("%q: got lint %v, want %v", tt.dir, c.Lint, tt.lint)
+			continue
+		}
+		for rule, severity := range tt.lint {
+			if c.Lint[rule] != severity {
+				t.Errorf("%q: got lint %v, want %v", tt.dir, c.Lint, tt.lint)
+				break
+			}
+		}
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	v := openTestVault(t, map[string]string{"private/" + ConfigName: "visibility: private\n"})
+	tests := []struct {
+		path, source string
+		want         Visibility
+	}{
+		{"note.md", "# Note\n", ""},
+		{"note.md", "---\nvisibility: unlisted\n---\n", Unlisted},
+		{"private/note.md", "# Note\n", Private},
+		{"private/sub/note.md", "# Note\n", Private},
+		{"private/note.md", "---\nvisibility: public\n---\n", Public},
+		{"note.md", "---\nvisibility: secret\n---\n", Private},
+	}
+	for _, tt := range tests {
+		if got := v.Visibility(NewNote(tt.path, []byte(tt.source), time.Time{})); got != tt.want {
+			t.Errorf("%s %q: got %q, want %q", tt.path, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestForgetConfig(t *testing.T) {
+	v := openTestVault(t, map[string]string{"dir/" + ConfigName: "visibility: private\n"})
+	if got := v.Config("dir/sub").Visibility; got != Private {
+		t.Fatalf("got visibility %q, want %q", got, Private)
+	}
+	if err := os.WriteFile(filepath.Join(v.Root, "dir", ConfigName), []byte("visibility: public\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Config("dir/sub").Visibility; got != Private {
+		t.Errorf("got visibility %q before forgetting the old config, want it cached", got)
+	}
+	v.ForgetConfig("dir")
+	if got := v.Config("dir/sub").Visibility; got != Public {
+		t.Errorf("got visibility %q after the config changed, want %q", got, Public)
+	}
+}
@@ -0,0 +1,74 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Commit dates of the files in a vault, from its git history.
+type history struct {
+	created map[string]time.Time // relative path : date of the first commit touching it
+	updated map[string]time.Time // relative path : date of the last commit touching it
+}
+
+// Read the git history of the vault at `root`. Results in an empty history
+// if the vault isn't in a git repository, or git isn't installed.
+func readHistory(root string) history {
+	h := history{created: map[string]time.Time{}, updated: map[string]time.Time{}}
+	cmd := exec.Command("git", "-c", "core.quotePath=false", "log",
+		"--format=%x00%cI", "--name-only", "--no-renames", "--relative", "--", ".")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return h
+	}
+
+	// Newest commit first, so the last date seen for a file is its creation
+	var date time.Time
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if commitDate, ok := strings.CutPrefix(line, "\x00"); ok {
+			date, _ = time.Parse(time.RFC3339, commitDate)
+			continue
+		}
+		if line == "" || date.IsZero() {
+			continue
+		}
+		if _, ok := h.updated[line]; !ok {
+			h.updated[line] = date
+		}
+		h.created[line] = date
+	}
+	return h
+}
+
+// Report when `note` was created and last updated. Dates come from the front
+// matter if it has them, and otherwise from the vault's git history, or the
+// file's modification time for notes that were never committed.
+//
+// The git history is read once, the first time it's needed.
+func (v *Vault) Dates(note *Note) (created, updated time.Time) {
+	h := v.history()
+	created, updated = note.Date, note.Updated
+	if created.IsZero() {
+		created = h.created[note.Path]
+	}
+	if updated.IsZero() {
+		updated = h.updated[note.Path]
+	}
+	if updated.IsZero() {
+		updated = note.ModTime
+	}
+	if created.IsZero() {
+		created = updated
+	}
+	// A note dated in the front matter can't have been updated before that
+	if updated.Before(created) {
+		updated = created
+	}
+	return created, updated
+}
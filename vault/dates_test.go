@@ -0,0 +1,38 @@
+package vault
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDates(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	h := history{
+		created: map[string]time.Time{"committed.md": day(2)},
+		updated: map[string]time.Time{"committed.md": day(4)},
+	}
+
+	tests := []struct {
+		name, path, source       string
+		wantCreated, wantUpdated time.Time
+	}{
+		{"front matter", "new.md", "---\ndate: 2024-01-10\nupdated: 2024-01-12\n---\n", day(10), day(12)},
+		{"git history", "committed.md", "# Committed\n", day(2), day(4)},
+		{"front matter date and git history", "committed.md", "---\ndate: 2024-01-03\n---\n", day(3), day(4)},
+		{"modification time", "new.md", "# New\n", day(20), day(20)},
+		{"only updated in front matter", "new.md", "---\nupdated: 2024-01-12\n---\n", day(12), day(12)},
+		{"only updated in front matter, with git history", "committed.md", "---\nupdated: 2024-01-12\n---\n", day(2), day(12)},
+		{"updated before date", "new.md", "---\ndate: 2024-01-10\nupdated: 2024-01-05\n---\n", day(10), day(10)},
+		{"git updated before date", "committed.md", "---\ndate: 2024-01-10\n---\n", day(10), day(10)},
+	}
+	for _, tt := range tests {
+		v := newVault(t.TempDir())
+		v.history = func() history { return h }
+		created, updated := v.Dates(NewNote(tt.path, []byte(tt.source), day(20)))
+		if !created.Equal(tt.wantCreated) || !updated.Equal(tt.wantUpdated) {
+			t.Errorf("%s: got created %s and updated %s, want %s and %s", tt.name,
+				created.Format(time.DateOnly), updated.Format(time.DateOnly),
+				tt.wantCreated.Format(time.DateOnly), tt.wantUpdated.Format(time.DateOnly))
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Open the vault at `root` as it's staged in the index of the git repository
+// it's in: with the content the next commit would have, rather than what's in
+// the working tree. Files that aren't staged don't exist in it, and partially
+// staged files have their staged content. Meant for checking commits, e.g. in
+// a pre-commit hook.
+//
+// The notes have no modification time, and loading notes from disk into it
+// mixes up the two versions, so don't.
+func OpenStaged(root string) (*Vault, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for %s: %v", root, err)
+	}
+	objects, err := stagedObjects(absRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only notes and config files are read; attachments only need to exist
+	var wanted []string
+	for relPath, object := range objects {
+		if IsNote(relPath) || path.Base(relPath) == ConfigName {
+			wanted = append(wanted, object)
+		}
+	}
+	blobs, err := readBlobs(absRoot, wanted)
+	if err != nil {
+		return nil, err
+	}
+
+	v := newVault(absRoot)
+	v.history = sync.OnceValue(func() history { return readHistory(absRoot) })
+	v.readFile = func(relPath string) ([]byte, error) {
+		if blob, ok := blobs[objects[relPath]]; ok {
+			return blob, nil
+		}
+		return nil, fs.ErrNotExist
+	}
+	for _, relPath := range slices.Sorted(maps.Keys(objects)) {
+		switch {
+		case isHiddenPath(relPath):
+		case IsNote(relPath):
+			v.Add(NewNote(relPath, blobs[objects[relPath]], time.Time{}))
+		default:
+			v.AddAttachment(relPath)
+		}
+	}
+	return v, nil
+}
+
+// The files staged below `root`, relative to it, mapped to the IDs of their
+// blobs. Submodules and unmerged files are left out.
+func stagedObjects(root string) (map[string]string, error) {
+	cmd := exec.Command("git", "ls-files", "--stage", "-z", "--", ".")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, gitError("failed to list staged files", err)
+	}
+
+	objects := map[string]string{}
+	for entry := range strings.SplitSeq(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		// <mode> SP <object> SP <stage> TAB <path>
+		info, relPath, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 || fields[0] == "160000" || fields[2] != "0" {
+			continue
+		}
+		objects[relPath] = fields[1]
+	}
+	return objects, nil
+}
+
+// Read the blobs with the given IDs from the repository at `dir`, in one go.
+func readBlobs(dir string, objects []string) (map[string][]byte, error) {
+	blobs := map[string][]byte{}
+	if len(objects) == 0 {
+		return blobs, nil
+	}
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(strings.Join(objects, "\n") + "\n")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, gitError("failed to read staged files", err)
+	}
+
+	// <object> SP <type> SP <size> LF <contents> LF, for every object
+	r := bufio.NewReader(bytes.NewReader(out))
+	for range objects {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read staged files: %w", err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			continue // "<object> missing"
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read staged files: invalid header %q", header)
+		}
+		blob := make([]byte, size+1) // And the LF
+		if _, err := io.ReadFull(r, blob); err != nil {
+			return nil, fmt.Errorf("failed to read staged files: %w", err)
+		}
+		blobs[fields[0]] = blob[:size]
+	}
+	return blobs, nil
+}
+
+// Wrap an error running git, with what git had to say about it.
+func gitError(msg string, err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%s: %s", msg, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// Report whether a file or folder on `relPath` starts with '.', so that Walk
+// would skip it.
+func isHiddenPath(relPath string) bool {
+	for segment := range strings.SplitSeq(relPath, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package vault
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A git repository in a temporary folder, for tests.
+type testRepo struct {
+	t    *testing.T
+	root string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	repo := &testRepo{t: t, root: t.TempDir()}
+	repo.git("init", "-q")
+	return repo
+}
+
+// Run git in the repository, with an identity and no user or system config.
+func (repo *testRepo) git(args ...string) {
+	repo.t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = repo.root
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "GIT_CONFIG_GLOBAL="+os.DevNull)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		repo.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// Write `content` to the file at `relPath` in the working tree.
+func (repo *testRepo) write(relPath, content string) {
+	repo.t.Helper()
+	abs := filepath.Join(repo.root, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+		repo.t.Fatal(err)
+	}
+	if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+		repo.t.Fatal(err)
+	}
+}
+
+func (repo *testRepo) remove(relPath string) {
+	repo.t.Helper()
+	if err := os.Remove(filepath.Join(repo.root, filepath.FromSlash(relPath))); err != nil {
+		repo.t.Fatal(err)
+	}
+}
+
+func notePaths(v *Vault) []string {
+	var paths []string
+	for _, note := range v.Notes() {
+		paths = append(paths, note.Path)
+	}
+	return paths
+}
+
+func TestOpenStaged(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("committed.md", "# Committed\n")
+	repo.write("partial.md", "# Partial\n")
+	repo.write("deleted.md", "# Deleted\n")
+	repo.write("private/.mdbuddy", "visibility: private\n")
+	repo.write("private/secret.md", "# Secret\n")
+	repo.git("add", "-A")
+	repo.git("commit", "-q", "-m", "Initial")
+
+	repo.write("partial.md", "# Partial\n\nStaged\n")
+	repo.write("added.md", "# Added\n")
+	repo.write("image.png", "not really a PNG")
+	repo.write(".hidden/note.md", "# Hidden\n")
+	repo.git("add", "partial.md", "added.md", "image.png", ".hidden/note.md")
+	repo.git("rm", "-q", "deleted.md")
+	repo.write("partial.md", "# Partial\n\nStaged\n\nNot staged\n")
+	repo.remove("added.md")                                // Staged, but gone from the working tree
+	repo.write("untracked.md", "# Untracked\n")            // Not staged
+	repo.write("private/.mdbuddy", "visibility: public\n") // Not staged
+
+	v, err := OpenStaged(repo.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"added.md", "committed.md", "partial.md", "private/secret.md"}
+	if got := notePaths(v); !slices.Equal(got, want) {
+		t.Errorf("notes are %q, want %q", got, want)
+	}
+	if got := string(v.Note("partial.md").Source); got != "# Partial\n\nStaged\n" {
+		t.Errorf("partially staged note has %q, want its staged content", got)
+	}
+	if got := string(v.Note("added.md").Source); got != "# Added\n" {
+		t.Errorf("note removed from the working tree has %q, want its staged content", got)
+	}
+	if !v.HasAttachment("image.png") {
+		t.Error("staged attachment is missing")
+	}
+	if got := v.Config("private").Visibility; got != Private {
+		t.Errorf("folder config has visibility %q, want the staged %q", got, Private)
+	}
+}
+
+func TestOpenStagedSubfolder(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("outside.md", "# Outside\n")
+	repo.write("notes/inside.md", "# Inside\n")
+	repo.write("notes/dir/deeper.md", "# Deeper\n")
+	repo.git("add", "-A")
+
+	v, err := OpenStaged(filepath.Join(repo.root, "notes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"dir/deeper.md", "inside.md"}
+	if got := notePaths(v); !slices.Equal(got, want) {
+		t.Errorf("notes are %q, want %q relative to the vault", got, want)
+	}
+	if got := string(v.Note("dir/deeper.md").Source); got != "# Deeper\n" {
+		t.Errorf("dir/deeper.md has %q", got)
+	}
+}
+
+func TestOpenStagedEmpty(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("untracked.md", "# Untracked\n")
+
+	v, err := OpenStaged(repo.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := notePaths(v); len(got) != 0 {
+		t.Errorf("notes are %q, want none", got)
+	}
+}
+
+func TestOpenStagedOutsideRepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	t.Setenv("GIT_CEILING_DIRECTORIES", os.TempDir())
+	if _, err := OpenStaged(t.TempDir()); err == nil {
+		t.Error("got no error outside a git repository")
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/flonle/mdbuddy/vault
+
+go 1.25.4
+
+require (
+	github.com/flonle/mdbuddy/renderer v0.0.0
+	github.com/hexops/gotextdiff v1.0.3
+	github.com/yuin/goldmark v1.7.13
+	go.abhg.dev/goldmark/wikilink v0.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
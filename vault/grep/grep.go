@@ -0,0 +1,344 @@
+// Package grep finds nodes of the notes of a vault by kind, like headings of
+// some level, code blocks in some language or unchecked tasks, and the
+// matches of a pattern in them. It works on the AST the vault parsed the notes
+// into, so markup in code blocks isn't mistaken for headings, and so on.
+package grep
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	customExtensions "github.com/flonle/mdbuddy/renderer/goldmark-extensions"
+	"github.com/flonle/mdbuddy/vault"
+
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// A kind of node a Selector selects.
+type Kind string
+
+const (
+	Headings Kind = "heading" // Headings; heading:2 for those of level 2, heading:1-3 for levels 1 to 3
+	Code     Kind = "code"    // Code blocks; code:go for those in Go
+	Tasks    Kind = "task"    // Task list items; task:checked or task:unchecked
+	Callouts Kind = "callout" // Callouts; callout:warning for those of type warning
+	Links    Kind = "link"    // Links, images and wikilinks
+	Hashtags Kind = "hashtag" // Hashtags; hashtag:idea for #idea and the tags nested in it, like #idea/app
+	Text     Kind = "text"    // Paragraphs, the text of list items other than tasks, and table cells
+)
+
+// All kinds, in the order they're documented in.
+var Kinds = []Kind{Headings, Code, Tasks, Callouts, Links, Hashtags, Text}
+
+// What a Query selects without selectors: everything with text of its own.
+var defaultSelectors = []Selector{{Kind: Headings}, {Kind: Code}, {Kind: Tasks}, {Kind: Text}}
+
+// A kind of node, and optionally what to narrow it down to.
+type Selector struct {
+	Kind Kind
+	Arg  string // See the Kinds; empty for all nodes of the kind
+}
+
+// Parse a selector, like "heading", "heading:2" or "callout:warning".
+func ParseSelector(s string) (Selector, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(s), ":")
+	sel := Selector{Kind: Kind(strings.ToLower(kind)), Arg: arg}
+	switch sel.Kind {
+	case Headings:
+		if arg == "" {
+			break
+		}
+		if _, _, ok := levels(arg); !ok {
+			return sel, fmt.Errorf("invalid selector %q: expected a level like heading:2, or levels like heading:1-3", s)
+		}
+	case Tasks:
+		switch arg {
+		case "", "checked", "unchecked":
+		default:
+			return sel, fmt.Errorf("invalid selector %q: expected task:checked or task:unchecked", s)
+		}
+	case Links, Text:
+		if arg != "" {
+			return sel, fmt.Errorf("invalid selector %q: %s takes no argument", s, kind)
+		}
+	case Code, Callouts, Hashtags:
+		sel.Arg = strings.TrimPrefix(arg, "#")
+	default:
+		return sel, fmt.Errorf("invalid selector %q: unknown kind %q", s, kind)
+	}
+	return sel, nil
+}
+
+func (s Selector) String() string {
+	if s.Arg == "" {
+		return string(s.Kind)
+	}
+	return string(s.Kind) + ":" + s.Arg
+}
+
+// The heading levels of the argument of a heading selector, "2" or "1-3".
+func levels(arg string) (from, to int, ok bool) {
+	first, last, isRange := strings.Cut(arg, "-")
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, false
+	}
+	to = from
+	if isRange {
+		if to, err = strconv.Atoi(last); err != nil {
+			return 0, 0, false
+		}
+	}
+	return from, to, 1 <= from && from <= to && to <= 6
+}
+
+// Report whether `s` selects the node `m` was found in.
+func (s Selector) selects(m Match) bool {
+	if s.Kind != m.Kind {
+		return false
+	}
+	if s.Arg == "" {
+		return true
+	}
+	switch s.Kind {
+	case Headings:
+		from, to, _ := levels(s.Arg)
+		return from <= m.Level && m.Level <= to
+	case Code:
+		return strings.EqualFold(s.Arg, m.Language)
+	case Tasks:
+		return *m.Checked == (s.Arg == "checked")
+	case Callouts:
+		return strings.EqualFold(s.Arg, m.Type)
+	case Hashtags:
+		tag := strings.ToLower(m.Text)
+		arg := strings.ToLower(s.Arg)
+		return tag == arg || strings.HasPrefix(tag, arg+"/")
+	}
+	return false
+}
+
+type Query struct {
+	// The nodes to look in, of any of these; headings, code, tasks and text
+	// if empty.
+	Select []Selector
+
+	// Only nodes with a match of this in their source, or all of them if
+	// it's nil. Each match is a Match of its own.
+	Pattern *regexp.Regexp
+
+	// Only nodes in the section of a heading with a match of this in its
+	// text, at any depth.
+	Under *regexp.Regexp
+}
+
+// A node Search found, or a match of the pattern in one.
+type Match struct {
+	Path    string   `json:"path"` // Vault-relative path of the note
+	Line    int      `json:"line"` // 1-based
+	Col     int      `json:"col"`  // 1-based, in bytes
+	Kind    Kind     `json:"kind"`
+	Text    string   `json:"text"`              // See below
+	Match   string   `json:"match,omitempty"`   // What the pattern matched, if there is one
+	Section []string `json:"section,omitempty"` // The headings the node is under, outermost first
+
+	// By kind: the text of headings, text and tasks, the code of code
+	// blocks, the title of callouts, the target of links (as written) and
+	// the name of hashtags, without the '#'.
+	Level    int    `json:"level,omitempty"`    // Of headings
+	Language string `json:"language,omitempty"` // Of code blocks
+	Checked  *bool  `json:"checked,omitempty"`  // Of tasks
+	Type     string `json:"type,omitempty"`     // Of callouts
+
+	line string // The line of the source the match is on
+}
+
+// The match as grep would print it: "path:line:col: " and the line it's on.
+func (m Match) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", m.Path, m.Line, m.Col, m.line)
+}
+
+// Find what `q` asks for in the notes of `v`, in order.
+func Search(v *vault.Vault, q Query) []Match {
+	var matches []Match
+	for _, note := range v.Notes() {
+		matches = append(matches, SearchNote(note, q)...)
+	}
+	return matches
+}
+
+// Find what `q` asks for in `note`, in order.
+func SearchNote(note *vault.Note, q Query) []Match {
+	selectors := q.Select
+	if len(selectors) == 0 {
+		selectors = defaultSelectors
+	}
+
+	var matches []Match
+	var section []string // The headings of the sections we're in
+	var sectionLevels []int
+	ast.Walk(note.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		heading, isHeading := n.(*ast.Heading)
+		if isHeading {
+			// A heading ends the sections of its level and deeper
+			for len(sectionLevels) > 0 && sectionLevels[len(sectionLevels)-1] >= heading.Level {
+				section = section[:len(section)-1]
+				sectionLevels = sectionLevels[:len(sectionLevels)-1]
+			}
+		}
+
+		found, offset, segments, ok := inspect(note, n)
+		if ok && slices.ContainsFunc(selectors, func(s Selector) bool { return s.selects(found) }) &&
+			(q.Under == nil || slices.ContainsFunc(section, q.Under.MatchString)) {
+			found.Path = note.Path
+			found.Section = slices.Clone(section)
+			matches = append(matches, matchesIn(note, found, offset, segments, q.Pattern)...)
+		}
+
+		if isHeading {
+			section = append(section, string(vault.NodeText(note.Source, heading)))
+			sectionLevels = append(sectionLevels, heading.Level)
+		}
+		return ast.WalkContinue, nil
+	})
+	return matches
+}
+
+// What the node `n` is to Search: the Match for it without a position, where
+// it starts, and the segments of the source the pattern is matched against.
+// False for nodes of none of the Kinds.
+func inspect(note *vault.Note, n ast.Node) (found Match, offset int, segments []text.Segment, ok bool) {
+	lines := func(n ast.Node) []text.Segment {
+		return n.Lines().Sliced(0, n.Lines().Len())
+	}
+	switch n := n.(type) {
+	case *ast.Heading:
+		found = Match{Kind: Headings, Text: string(vault.NodeText(note.Source, n)), Level: n.Level}
+		segments = lines(n)
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		found = Match{Kind: Code, Text: string(n.Lines().Value(note.Source))}
+		if fenced, ok := n.(*ast.FencedCodeBlock); ok {
+			found.Language = string(fenced.Language(note.Source))
+		}
+		segments = lines(n)
+	case *ast.Paragraph, *ast.TextBlock, *extast.TableCell:
+		if isTask(n) {
+			return found, 0, nil, false
+		}
+		found = Match{Kind: Text, Text: string(vault.NodeText(note.Source, n))}
+		segments = lines(n)
+		// The first line of a callout has its type and title, which make a
+		// Callouts match
+		if _, ok := n.Parent().(*customExtensions.CalloutNode); ok && n.PreviousSibling() == nil && len(segments) > 0 {
+			segments = segments[1:]
+		}
+	case *ast.ListItem:
+		if !isTask(n.FirstChild()) {
+			return found, 0, nil, false
+		}
+		checked := n.FirstChild().FirstChild().(*extast.TaskCheckBox).IsChecked
+		found = Match{Kind: Tasks, Text: string(vault.NodeText(note.Source, n.FirstChild())), Checked: &checked}
+		segments = lines(n.FirstChild())
+	case *customExtensions.CalloutNode:
+		para, ok := n.FirstChild().(*ast.Paragraph)
+		if !ok || para.Lines().Len() == 0 {
+			return found, 0, nil, false
+		}
+		match, ok := customExtensions.MatchCallout(note.Source, para.Lines().At(0))
+		if !ok {
+			return found, 0, nil, false
+		}
+		found = Match{Kind: Callouts, Text: string(match.Title.Value(note.Source)), Type: strings.ToLower(string(match.Type.Value(note.Source)))}
+		ast.Walk(n, func(child ast.Node, entering bool) (ast.WalkStatus, error) {
+			if entering && child.Type() == ast.TypeBlock {
+				segments = append(segments, lines(child)...)
+			}
+			return ast.WalkContinue, nil
+		})
+	case *ast.Link, *ast.Image:
+		span, ok := vault.FindLink(note.Source, n)
+		if !ok {
+			return found, 0, nil, false
+		}
+		found = Match{Kind: Links, Text: string(span.Dest.Value(note.Source))}
+		return found, span.Whole.Start, []text.Segment{span.Dest}, true
+	case *wikilink.Node:
+		span, ok := vault.FindWikilink(note.Source, n)
+		if !ok {
+			return found, 0, nil, false
+		}
+		target := span.Target
+		if span.Fragment.Len() > 0 {
+			target.Stop = span.Fragment.Stop
+		}
+		found = Match{Kind: Links, Text: string(target.Value(note.Source))}
+		return found, span.Whole.Start, []text.Segment{target}, true
+	case *customExtensions.Hashtag:
+		t, ok := n.FirstChild().(*ast.Text)
+		if !ok {
+			return found, 0, nil, false
+		}
+		found = Match{Kind: Hashtags, Text: string(t.Segment.Value(note.Source))}
+		return found, t.Segment.Start - len("#"), []text.Segment{t.Segment}, true
+	default:
+		return found, 0, nil, false
+	}
+	if len(segments) == 0 {
+		return found, 0, nil, false
+	}
+	return found, segments[0].Start, segments, true
+}
+
+// Report whether the block `n` is the text of a task list item: it starts
+// with a checkbox.
+func isTask(n ast.Node) bool {
+	if n == nil {
+		return false
+	}
+	_, ok := n.FirstChild().(*extast.TaskCheckBox)
+	return ok
+}
+
+// The matches of `pattern` in `segments` of the node `found` is for, or the
+// node itself, at `offset`, if `pattern` is nil.
+func matchesIn(note *vault.Note, found Match, offset int, segments []text.Segment, pattern *regexp.Regexp) []Match {
+	at := func(offset int) Match {
+		m := found
+		m.Line, m.Col = note.Position(offset)
+		m.line = lineAt(note.Source, offset)
+		return m
+	}
+	if pattern == nil {
+		return []Match{at(offset)}
+	}
+	var matches []Match
+	for _, s := range segments {
+		value := s.Value(note.Source)
+		for _, loc := range pattern.FindAllIndex(value, -1) {
+			m := at(s.Start + loc[0])
+			m.Match = string(value[loc[0]:loc[1]])
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// The line of `source` that `offset` is on, without its line ending.
+func lineAt(source []byte, offset int) string {
+	start := bytes.LastIndexByte(source[:offset], '\n') + 1
+	end := len(source)
+	if i := bytes.IndexByte(source[offset:], '\n'); i >= 0 {
+		end = offset + i
+	}
+	return string(bytes.TrimRight(source[start:end], "\r"))
+}
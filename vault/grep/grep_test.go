@@ -0,0 +1,125 @@
+package grep
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+var testNote = vault.NewNote("note.md", []byte(`# Top
+
+Intro with #idea, #ideas and a [link](dest.md).
+
+## Tasks
+
+- [ ] open task
+- [x] done task
+- plain item
+
+> [!WARNING] Careful now
+> callout body
+
+### Deeper
+
+`+"```go\nfunc main() {}\n```"+`
+
+# Other
+
+| a | b |
+|---|---|
+| cell | [[wiki#frag]] |
+
+    indented code
+`), time.Time{})
+
+// The matches of `q` in testNote, as "line:col kind text", and what the
+// pattern matched.
+func search(q Query) []string {
+	var got []string
+	for _, m := range SearchNote(testNote, q) {
+		s := fmt.Sprintf("%d:%d %s %s", m.Line, m.Col, m.Kind, m.Text)
+		if m.Match != "" {
+			s += " =" + m.Match
+		}
+		got = append(got, s)
+	}
+	return got
+}
+
+func TestSearchKinds(t *testing.T) {
+	tests := []struct {
+		selectors []string
+		want      []string
+	}{
+		{[]string{"heading"}, []string{"1:3 heading Top", "5:4 heading Tasks", "14:5 heading Deeper", "20:3 heading Other"}},
+		{[]string{"heading:2-3"}, []string{"5:4 heading Tasks", "14:5 heading Deeper"}},
+		{[]string{"code"}, []string{"17:1 code func main() {}\n", "26:5 code indented code\n"}},
+		{[]string{"code:Go"}, []string{"17:1 code func main() {}\n"}},
+		{[]string{"task"}, []string{"7:3 task open task", "8:3 task done task"}},
+		{[]string{"task:unchecked"}, []string{"7:3 task open task"}},
+		{[]string{"callout"}, []string{"11:3 callout Careful now"}},
+		{[]string{"callout:warning"}, []string{"11:3 callout Careful now"}},
+		{[]string{"callout:tip"}, nil},
+		{[]string{"link"}, []string{"3:32 link dest.md", "24:10 link wiki#frag"}},
+		{[]string{"hashtag"}, []string{"3:12 hashtag idea", "3:19 hashtag ideas"}},
+		{[]string{"hashtag:#idea"}, []string{"3:12 hashtag idea"}},
+		// Callouts' first lines are theirs, not text
+		{[]string{"text"}, []string{
+			"3:1 text Intro with idea, ideas and a link.", "9:3 text plain item", "12:3 text callout body",
+			"22:3 text a", "22:7 text b", "24:3 text cell", "24:10 text wiki#frag",
+		}},
+		{[]string{"task", "heading:1"}, []string{"1:3 heading Top", "7:3 task open task", "8:3 task done task", "20:3 heading Other"}},
+	}
+	for _, tt := range tests {
+		var q Query
+		for _, s := range tt.selectors {
+			sel, err := ParseSelector(s)
+			if err != nil {
+				t.Fatalf("%s: %v", s, err)
+			}
+			q.Select = append(q.Select, sel)
+		}
+		if got := search(q); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.selectors, got, tt.want)
+		}
+	}
+}
+
+func TestSearchPattern(t *testing.T) {
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"default kinds", Query{Pattern: regexp.MustCompile(`task|main`)},
+			[]string{"7:12 task open task =task", "8:12 task done task =task", "17:6 code func main() {}\n =main"}},
+		{"callout title isn't text", Query{Select: []Selector{{Kind: Text}}, Pattern: regexp.MustCompile(`Careful|WARNING`)}, nil},
+		{"callout title", Query{Select: []Selector{{Kind: Callouts}}, Pattern: regexp.MustCompile(`Careful|body`)},
+			[]string{"11:14 callout Careful now =Careful", "12:11 callout Careful now =body"}},
+		{"markup", Query{Select: []Selector{{Kind: Text}}, Pattern: regexp.MustCompile(`\[link\]`)},
+			[]string{"3:32 text Intro with idea, ideas and a link. =[link]"}},
+		{"under", Query{Pattern: regexp.MustCompile(`.+`), Under: regexp.MustCompile(`^Tasks$`)},
+			[]string{"7:3 task open task =[ ] open task", "8:3 task done task =[x] done task", "9:3 text plain item =plain item",
+				"12:3 text callout body =callout body", "14:5 heading Deeper =Deeper", "17:1 code func main() {}\n =func main() {}"}},
+	}
+	for _, tt := range tests {
+		if got := search(tt.q); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	for _, s := range []string{"heading:0", "heading:3-1", "heading:x", "task:maybe", "text:x", "link:x", "table"} {
+		if sel, err := ParseSelector(s); err == nil {
+			t.Errorf("%s: got %+v, want an error", s, sel)
+		}
+	}
+	if sel, err := ParseSelector(" Callout:Tip "); err != nil || sel != (Selector{Kind: Callouts, Arg: "Tip"}) {
+		t.Errorf("got %+v and error %v, want callout:Tip", sel, err)
+	}
+}
@@ -0,0 +1,236 @@
+package linkcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+const userAgent = "mdbuddy-linkcheck (+https://github.com/flonle/mdbuddy)"
+
+// Checks external links by requesting them, without hammering anyone: only
+// so many requests are in flight at once, requests to the same host are
+// spaced out, every URL is requested once however often it's linked, and
+// working URLs are remembered between runs. The zero value is ready to use,
+// with the defaults below.
+type ExternalChecker struct {
+	Client       *http.Client  // Defaults to one with a 10 second timeout
+	Concurrency  int           // Requests in flight at once; defaults to 8
+	HostInterval time.Duration // Between the starts of requests to the same host; defaults to 1 second
+
+	// Remember working URLs in this file, and skip them for CacheTTL
+	// (default: a day). Broken URLs are always checked again, as most
+	// breakage is temporary. No cache if empty.
+	CacheFile string
+	CacheTTL  time.Duration
+
+	mx   sync.Mutex
+	next map[string]time.Time // host : when the next request to it may start
+}
+
+// Where ExternalChecker remembers working URLs, unless told otherwise:
+// $XDG_CACHE_HOME/mdbuddy/links.json, or the equivalent on other platforms.
+func DefaultCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mdbuddy", "links.json")
+}
+
+// URL : when it last worked
+type linkCache map[string]time.Time
+
+// Check the http and https links in `notes`. Stops early, with what it found
+// so far, if `ctx` is done.
+func (c *ExternalChecker) Check(ctx context.Context, notes []*vault.Note) ([]Broken, error) {
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	concurrency := c.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+	ttl := c.CacheTTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	cache := c.loadCache()
+
+	// Every URL once, without its fragment
+	byURL := map[string][]external{}
+	var urls []string
+	for _, note := range notes {
+		for _, link := range externalLinks(note) {
+			u, _, _ := strings.Cut(link.url, "#")
+			if len(byURL[u]) == 0 && time.Since(cache[u]) > ttl {
+				urls = append(urls, u)
+			}
+			byURL[u] = append(byURL[u], link)
+		}
+	}
+
+	reasons := map[string]string{} // URL : why it's broken
+	var reasonsMx sync.Mutex
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Go(func() {
+			for u := range queue {
+				reason, ok := c.checkURL(ctx, client, u)
+				reasonsMx.Lock()
+				if reason != "" {
+					reasons[u] = reason
+				} else if ok {
+					cache[u] = time.Now()
+				}
+				reasonsMx.Unlock()
+			}
+		})
+	}
+feed:
+	for _, u := range urls {
+		select {
+		case queue <- u:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	var broken []Broken
+	for _, u := range urls {
+		if reason, ok := reasons[u]; ok {
+			for _, link := range byURL[u] {
+				broken = append(broken, link.broken(reason))
+			}
+		}
+	}
+	if err := c.saveCache(cache, ttl); err != nil {
+		return broken, err
+	}
+	return broken, ctx.Err()
+}
+
+// Request `u`. Returns why it's broken, or "" if it isn't, and whether that's
+// worth remembering.
+func (c *ExternalChecker) checkURL(ctx context.Context, client *http.Client, u string) (reason string, ok bool) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "invalid URL", false
+	}
+	if err := c.waitForHost(ctx, strings.ToLower(parsed.Host)); err != nil {
+		return "", false
+	}
+
+	status, err := request(ctx, client, http.MethodHead, u)
+	switch status {
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented, http.StatusForbidden:
+		// Servers that don't do HEAD, or only refuse it
+		status, err = request(ctx, client, http.MethodGet, u)
+	}
+	switch {
+	case ctx.Err() != nil:
+		return "", false
+	case err != nil:
+		return err.Error(), false
+	case status == http.StatusTooManyRequests:
+		return "", false // Can't tell, but it's there
+	case status >= 400:
+		return fmt.Sprintf("HTTP %d %s", status, http.StatusText(status)), false
+	}
+	return "", true
+}
+
+// Make a request and return the status, following redirects. The body isn't
+// read.
+func request(ctx context.Context, client *http.Client, method, u string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err // The URL is in the output already
+		}
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Wait until a request to `host` may start, and book the next slot.
+func (c *ExternalChecker) waitForHost(ctx context.Context, host string) error {
+	interval := c.HostInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	c.mx.Lock()
+	if c.next == nil {
+		c.next = map[string]time.Time{}
+	}
+	at := time.Now()
+	if next := c.next[host]; next.After(at) {
+		at = next
+	}
+	c.next[host] = at.Add(interval)
+	c.mx.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *ExternalChecker) loadCache() linkCache {
+	cache := linkCache{}
+	if c.CacheFile == "" {
+		return cache
+	}
+	if b, err := os.ReadFile(c.CacheFile); err == nil {
+		json.Unmarshal(b, &cache) // A broken cache is as good as none
+	}
+	return cache
+}
+
+// Save the cache, without the entries that expired.
+func (c *ExternalChecker) saveCache(cache linkCache, ttl time.Duration) error {
+	if c.CacheFile == "" {
+		return nil
+	}
+	for u, checked := range cache {
+		if time.Since(checked) > ttl {
+			delete(cache, u)
+		}
+	}
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.CacheFile), 0o755); err != nil {
+		return fmt.Errorf("failed to save link cache: %w", err)
+	}
+	if err := os.WriteFile(c.CacheFile, b, 0o644); err != nil {
+		return fmt.Errorf("failed to save link cache: %w", err)
+	}
+	return nil
+}
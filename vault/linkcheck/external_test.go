@@ -0,0 +1,233 @@
+package linkcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// A server for links to point at, which counts the requests for every path.
+type linkServer struct {
+	*httptest.Server
+	handler func(w http.ResponseWriter, r *http.Request) // Defaults to serveLink
+
+	mx       sync.Mutex
+	requests map[string]int // "METHOD path" : how many
+	started  []time.Time    // Of the requests, in order
+}
+
+func newLinkServer(t *testing.T) *linkServer {
+	s := &linkServer{requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mx.Lock()
+		s.requests[r.Method+" "+r.URL.Path]++
+		s.started = append(s.started, time.Now())
+		s.mx.Unlock()
+		if s.handler != nil {
+			s.handler(w, r)
+		} else {
+			serveLink(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// /ok works, /missing doesn't, and /get-only refuses HEAD.
+func serveLink(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/missing":
+		http.NotFound(w, r)
+	case r.URL.Path == "/get-only" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *linkServer) count(request string) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.requests[request]
+}
+
+// A note linking to `paths` on `s`, one per line.
+func linkNote(s *linkServer, paths ...string) *vault.Note {
+	var source strings.Builder
+	for _, p := range paths {
+		fmt.Fprintf(&source, "- [link](%s%s)\n", s.URL, p)
+	}
+	return vault.NewNote("links.md", []byte(source.String()), time.Time{})
+}
+
+func TestExternalChecker(t *testing.T) {
+	s := newLinkServer(t)
+	note := linkNote(s, "/ok", "/missing", "/ok#fragment", "/get-only")
+	c := &ExternalChecker{HostInterval: time.Millisecond}
+
+	broken, err := c.Check(context.Background(), []*vault.Note{note})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Broken{{Note: "links.md", Line: 2, Col: 10, Target: s.URL + "/missing", Reason: "HTTP 404 Not Found"}}
+	if !slices.Equal(broken, want) {
+		t.Errorf("got broken links %v, want %v", broken, want)
+	}
+	if got := s.count("HEAD /ok"); got != 1 {
+		t.Errorf("requested /ok %d times, want once for both links", got)
+	}
+	if got := s.count("GET /get-only"); got != 1 {
+		t.Errorf("requested /get-only with GET %d times, want once after HEAD was refused", got)
+	}
+}
+
+func TestExternalCheckerHostInterval(t *testing.T) {
+	s := newLinkServer(t)
+	note := linkNote(s, "/ok?1", "/ok?2", "/ok?3", "/ok?4")
+	const interval = 50 * time.Millisecond
+	c := &ExternalChecker{HostInterval: interval}
+
+	start := time.Now()
+	if _, err := c.Check(context.Background(), []*vault.Note{note}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.started) != 4 {
+		t.Fatalf("got %d requests, want 4", len(s.started))
+	}
+	// Not the gaps between requests as the server sees them, which a slow
+	// connection may shorten: every request has its slot from the start
+	for i, started := range s.started {
+		if got, want := started.Sub(start), time.Duration(i)*interval; got < want {
+			t.Errorf("request %d started %v after the check, want at least %v", i+1, got, want)
+		}
+	}
+}
+
+func TestExternalCheckerConcurrency(t *testing.T) {
+	s := newLinkServer(t)
+	var inFlight, most int
+	var mx sync.Mutex
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		inFlight++
+		most = max(most, inFlight)
+		mx.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mx.Lock()
+		inFlight--
+		mx.Unlock()
+	}
+	var paths []string
+	for i := range 12 {
+		paths = append(paths, fmt.Sprintf("/ok?%d", i))
+	}
+	c := &ExternalChecker{Concurrency: 3, HostInterval: time.Nanosecond}
+
+	if _, err := c.Check(context.Background(), []*vault.Note{linkNote(s, paths...)}); err != nil {
+		t.Fatal(err)
+	}
+	if most > 3 {
+		t.Errorf("had %d requests in flight at once, want at most 3", most)
+	}
+	if most < 2 {
+		t.Errorf("had at most %d requests in flight at once, want them to run concurrently", most)
+	}
+}
+
+func TestExternalCheckerCache(t *testing.T) {
+	s := newLinkServer(t)
+	note := linkNote(s, "/ok", "/missing")
+	cacheFile := filepath.Join(t.TempDir(), "mdbuddy", "links.json")
+	check := func(ttl time.Duration) {
+		t.Helper()
+		c := &ExternalChecker{HostInterval: time.Millisecond, CacheFile: cacheFile, CacheTTL: ttl}
+		broken, err := c.Check(context.Background(), []*vault.Note{note})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(broken) != 1 || broken[0].Target != s.URL+"/missing" {
+			t.Errorf("got broken links %v, want /missing", broken)
+		}
+	}
+
+	check(time.Hour)
+	check(time.Hour)
+	if got := s.count("HEAD /ok"); got != 1 {
+		t.Errorf("requested /ok %d times, want once, and then from the cache", got)
+	}
+	if got := s.count("HEAD /missing"); got != 2 {
+		t.Errorf("requested /missing %d times, want it checked every time", got)
+	}
+
+	// Once the entry is older than the TTL, /ok is checked again
+	var cache linkCache
+	b, err := os.ReadFile(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &cache); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache[s.URL+"/missing"]; ok {
+		t.Error("cached a broken link")
+	}
+	cache[s.URL+"/ok"] = time.Now().Add(-2 * time.Hour)
+	if b, err = json.Marshal(cache); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cacheFile, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	check(time.Hour)
+	if got := s.count("HEAD /ok"); got != 2 {
+		t.Errorf("requested /ok %d times, want it checked again after the TTL", got)
+	}
+}
+
+func TestExternalCheckerCancel(t *testing.T) {
+	s := newLinkServer(t)
+	arrived := make(chan struct{}, 1)
+	s.handler = func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done() // Hang until the checker gives up
+	}
+	// The second request would have to wait an hour for the first
+	c := &ExternalChecker{HostInterval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-arrived
+		cancel()
+	}()
+
+	done := make(chan struct{})
+	var broken []Broken
+	var err error
+	go func() {
+		broken, err = c.Check(ctx, []*vault.Note{linkNote(s, "/slow", "/waiting")})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Check didn't return after the context was canceled")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if len(broken) != 0 {
+		t.Errorf("reported links as broken when checking them was canceled: %v", broken)
+	}
+}
@@ -0,0 +1,161 @@
+// Package linkcheck finds broken links in the notes of a vault: wikilinks and
+// relative links to notes or attachments that don't exist, fragments that
+// don't match a heading, and optionally external URLs that don't work.
+package linkcheck
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/site"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// A link that doesn't work.
+type Broken struct {
+	Note   string `json:"note"` // Vault-relative path of the note containing the link
+	Line   int    `json:"line"`
+	Col    int    `json:"col"`
+	Target string `json:"target"` // As written, e.g. "note#Heading" or "https://example.com"
+	Wiki   bool   `json:"wiki,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (b Broken) String() string {
+	return fmt.Sprintf("%s:%d:%d: broken link to %s: %s", b.Note, b.Line, b.Col, b.Target, b.Reason)
+}
+
+// A link in a note, as found in its AST.
+type link struct {
+	vault.Link
+	note *vault.Note
+}
+
+func (l link) broken(reason string) Broken {
+	line, col := l.note.Position(l.Offset)
+	target := l.Target
+	if l.Fragment != "" {
+		target += "#" + l.Fragment
+	}
+	return Broken{Note: l.note.Path, Line: line, Col: col, Target: target, Wiki: l.Wiki, Reason: reason}
+}
+
+// Check the links in `note` that point into the vault: wikilinks, relative
+// links and images, and #fragments. External links are skipped; see
+// ExternalChecker.
+func CheckNote(v *vault.Vault, note *vault.Note) []Broken {
+	l := site.NewVaultLinker(v, note)
+	var broken []Broken
+	for _, vl := range note.Links {
+		nl := link{Link: vl, note: note}
+		if nl.IsExternal() {
+			continue
+		}
+		if reason := check(l, nl); reason != "" {
+			broken = append(broken, nl.broken(reason))
+		}
+	}
+	return broken
+}
+
+// Check every note in the vault with CheckNote.
+func Check(v *vault.Vault) []Broken {
+	var broken []Broken
+	for _, note := range v.Notes() {
+		broken = append(broken, CheckNote(v, note)...)
+	}
+	return broken
+}
+
+// Why `nl` is broken, or "" if it isn't.
+func check(l *site.Linker, nl link) string {
+	target := nl.note
+	if nl.Target != "" {
+		target = l.ResolveNote(nl.Link)
+		if target == nil {
+			if l.ResolveAttachment(nl.Link) != "" {
+				return "" // Fragments of attachments, like PDF pages, aren't ours to check
+			}
+			if nl.Embed && !nl.Wiki {
+				return "no such image or attachment"
+			}
+			return "no such note or attachment"
+		}
+	}
+	// Block references (note#^block) aren't headings
+	if nl.Fragment != "" && !strings.HasPrefix(nl.Fragment, "^") && !HasHeading(target, nl.Fragment) {
+		return "no such heading in " + target.Path
+	}
+	return ""
+}
+
+// Report whether `note` has a heading `fragment` refers to: by its ID, as
+// generated by parser.WithAutoHeadingID (#my-heading), or by its text, like
+// wikilinks do ([[note#My Heading]]).
+func HasHeading(note *vault.Note, fragment string) bool {
+	return slices.ContainsFunc(note.Headings, func(h vault.Heading) bool {
+		return h.ID == fragment || strings.EqualFold(h.Text, fragment)
+	})
+}
+
+// An external link in a note.
+type external struct {
+	url    string // As written, with its fragment
+	note   *vault.Note
+	offset int
+}
+
+func (e external) broken(reason string) Broken {
+	line, col := e.note.Position(e.offset)
+	return Broken{Note: e.note.Path, Line: line, Col: col, Target: e.url, Reason: reason}
+}
+
+// The http and https links in `note`, from its links, images and autolinks
+// (<https://example.com>). Taken from the AST rather than vault.Note.Links,
+// which has them unescaped.
+func externalLinks(note *vault.Note) []external {
+	var links []external
+	searched := map[ast.Node]int{} // Block : offset after the last link found in it
+	ast.Walk(note.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var url []byte
+		switch n := n.(type) {
+		case *ast.Link:
+			url = n.Destination
+		case *ast.Image:
+			url = n.Destination
+		case *ast.AutoLink:
+			if n.AutoLinkType == ast.AutoLinkURL {
+				url = n.URL(note.Source)
+			}
+		}
+		if lower := bytes.ToLower(url); bytes.HasPrefix(lower, []byte("http://")) || bytes.HasPrefix(lower, []byte("https://")) {
+			links = append(links, external{url: string(url), note: note, offset: linkOffset(note.Source, n, url, searched)})
+		}
+		return ast.WalkContinue, nil
+	})
+	return links
+}
+
+// The source offset of an inline node, which inline nodes don't record: where
+// `text` next appears in the paragraph (or other block) containing it, after
+// the links found in it before, as recorded in `searched`.
+func linkOffset(source []byte, n ast.Node, text []byte, searched map[ast.Node]int) int {
+	for block := n.Parent(); block != nil; block = block.Parent() {
+		if block.Type() == ast.TypeBlock && block.Lines().Len() > 0 {
+			start := max(block.Lines().At(0).Start, searched[block])
+			if i := bytes.Index(source[start:], text); i >= 0 {
+				searched[block] = start + i + len(text)
+				return start + i
+			}
+			return start
+		}
+	}
+	return 0
+}
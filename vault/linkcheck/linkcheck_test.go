@@ -0,0 +1,64 @@
+package linkcheck
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// A vault of `files`, by path : content.
+func openTestVault(t *testing.T, files map[string]string) *vault.Vault {
+	t.Helper()
+	root := t.TempDir()
+	for relPath, content := range files {
+		abs := filepath.Join(root, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := vault.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestCheckNote(t *testing.T) {
+	v := openTestVault(t, map[string]string{
+		"other.md":         "# Other\n\n## Some Heading\n\nText. ^block\n",
+		"dir/sibling.md":   "# Sibling\n",
+		"dir/image.png":    "png",
+		"files/report.pdf": "pdf",
+		"dir/note.md": "# Note\n" +
+			"\n" +
+			"[[other]] [[missing]] [[other#Some Heading]] [[other#Nothing]]\n" +
+			"[rel](sibling.md) [up](../other.md#some-heading) [bad](../missing.md) [frag](../other.md#nothing)\n" +
+			"[self](#note) [self bad](#elsewhere) [[other#^block]] [[other#^anything]]\n" +
+			"![img](image.png) ![gone](gone.png) ![[report.pdf]] ![[report.pdf#page=3]] [[gone.pdf]]\n" +
+			"[ext](https://example.com/missing) <https://example.com/>\n",
+	})
+
+	var got []string
+	for _, b := range CheckNote(v, v.Note("dir/note.md")) {
+		got = append(got, b.String())
+	}
+	// Where the target starts in wikilinks, and the text in other links
+	want := []string{
+		"dir/note.md:3:13: broken link to missing: no such note or attachment",
+		"dir/note.md:3:48: broken link to other#Nothing: no such heading in other.md",
+		"dir/note.md:4:51: broken link to ../missing.md: no such note or attachment",
+		"dir/note.md:4:72: broken link to ../other.md#nothing: no such heading in other.md",
+		"dir/note.md:5:16: broken link to #elsewhere: no such heading in dir/note.md",
+		"dir/note.md:6:21: broken link to gone.png: no such image or attachment",
+		"dir/note.md:6:78: broken link to gone.pdf: no such note or attachment",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,41 @@
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// Rename the files of the fixable problems (see Problem.Fix), once each.
+// Returns the problems that were fixed. Files are never overwritten: if
+// something took the new name since linting, the file is left alone.
+func Fix(v *vault.Vault, problems []Problem) ([]Problem, error) {
+	renamed := map[string]bool{}
+	var fixed []Problem
+	var errs []error
+	for _, p := range problems {
+		if p.Fix == "" {
+			continue
+		}
+		if !renamed[p.Path] {
+			if err := rename(v.Abs(p.Path), v.Abs(p.Fix)); err != nil {
+				errs = append(errs, fmt.Errorf("failed to rename %s to %s: %w", p.Path, p.Fix, err))
+				continue
+			}
+			renamed[p.Path] = true
+		}
+		fixed = append(fixed, p)
+	}
+	return fixed, errors.Join(errs...)
+}
+
+func rename(from, to string) error {
+	if _, err := os.Lstat(to); !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s already exists", filepath.Base(to))
+	}
+	return os.Rename(from, to)
+}
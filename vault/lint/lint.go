@@ -0,0 +1,289 @@
+// Package lint checks a vault against the rules todo.md lays down for it:
+// unique filenames, filenames that are safe in URLs, markdown files that end
+// in .md, and wikilinks that point somewhere.
+//
+// Every rule has a severity, which the lint section of .mdbuddy files can
+// change per folder (see vault.Config), and Options per run.
+package lint
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/linkcheck"
+	"github.com/flonle/mdbuddy/vault/site"
+)
+
+type Rule string
+
+const (
+	// Filenames must be unique within the vault, ignoring case and, for notes,
+	// the .md extension; wikilinks find notes by name.
+	UniqueName Rule = "unique-name"
+
+	// File and folder names may only contain [0-9a-zA-Z] and "-_.+".
+	Filename Rule = "filename"
+
+	// Markdown files must end in .md, or they aren't notes.
+	MarkdownExtension Rule = "markdown-extension"
+
+	// Wikilinks must point to a note or attachment that exists, and to a
+	// heading in it that exists.
+	Wikilink Rule = "wikilink"
+)
+
+// All rules, in the order they're documented in.
+var Rules = []Rule{UniqueName, Filename, MarkdownExtension, Wikilink}
+
+// What a rule is about, for humans.
+func (r Rule) Description() string {
+	switch r {
+	case UniqueName:
+		return "Filenames must be unique within the vault"
+	case Filename:
+		return "File and folder names may only contain 0-9, a-z, A-Z, '-', '_', '.' and '+'"
+	case MarkdownExtension:
+		return "Markdown files must end in .md"
+	case Wikilink:
+		return "Wikilinks must point to a note or attachment, and a heading, that exists"
+	}
+	return ""
+}
+
+type Severity string
+
+const (
+	Error   Severity = "error" // Fails the lint; the default for every rule
+	Warning Severity = "warning"
+	Off     Severity = "off"
+)
+
+// Parse a severity like "error" or "warn".
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "error":
+		return Error, nil
+	case "warning", "warn":
+		return Warning, nil
+	case "off", "ignore":
+		return Off, nil
+	}
+	return "", fmt.Errorf("invalid severity %q; expected error, warning or off", s)
+}
+
+type Options struct {
+	// Severities of rules for every file, overriding the .mdbuddy files.
+	Severities map[Rule]Severity
+}
+
+// A file that breaks a rule.
+type Problem struct {
+	Rule     Rule     `json:"rule"`
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path"`           // Vault-relative path of the file or folder
+	Line     int      `json:"line,omitempty"` // 1-based; 0 for problems with the file as a whole
+	Col      int      `json:"col,omitempty"`
+	Message  string   `json:"message"`
+
+	// For problems Fix can fix: the vault-relative path the file is renamed
+	// to. Only set when that's safe: nothing links to the file, and the new
+	// name is free.
+	Fix string `json:"fix,omitempty"`
+}
+
+func (p Problem) String() string {
+	pos := p.Path
+	if p.Line > 0 {
+		pos = fmt.Sprintf("%s:%d:%d", p.Path, p.Line, p.Col)
+	}
+	s := fmt.Sprintf("%s: %s: %s [%s]", pos, p.Severity, p.Message, p.Rule)
+	if p.Fix != "" {
+		s += " (fixable)"
+	}
+	return s
+}
+
+// Count the problems that are errors.
+func Errors(problems []Problem) int {
+	n := 0
+	for _, p := range problems {
+		if p.Severity == Error {
+			n++
+		}
+	}
+	return n
+}
+
+var validName = regexp.MustCompile(`^[0-9a-zA-Z\-_.+]+$`)
+
+// Extensions markdown files are commonly given instead of .md.
+var markdownExtensions = []string{".markdown", ".mdown", ".mkd", ".mkdn", ".mdwn", ".mdtxt", ".mdtext"}
+
+// Check every file in the vault. Problems are sorted by path and position.
+func Lint(v *vault.Vault, opts Options) []Problem {
+	files := v.Files("")
+	c := &checker{vault: v, opts: opts, linked: linkedFiles(v), taken: map[string]bool{}, fixes: map[string]string{}}
+	for _, file := range files {
+		c.taken[nameKey(file)] = true
+	}
+	c.checkNames(files)
+	c.checkExtensions(files)
+	c.checkWikilinks()
+
+	slices.SortFunc(c.problems, func(a, b Problem) int {
+		return cmp.Or(
+			strings.Compare(a.Path, b.Path),
+			cmp.Compare(a.Line, b.Line),
+			cmp.Compare(a.Col, b.Col),
+			strings.Compare(string(a.Rule), string(b.Rule)),
+		)
+	})
+	return c.problems
+}
+
+type checker struct {
+	vault    *vault.Vault
+	opts     Options
+	linked   map[string]bool   // Vault-relative paths of the files some note links to
+	taken    map[string]bool   // nameKey of every file, and of every file after its fix
+	fixes    map[string]string // File : what fix returned for it
+	problems []Problem
+}
+
+// Record a problem, unless its rule is off for `p.Path`.
+func (c *checker) report(p Problem) {
+	p.Severity = c.severity(p.Rule, p.Path)
+	if p.Severity != Off {
+		c.problems = append(c.problems, p)
+	}
+}
+
+// The severity of `rule` for the file or folder at `relPath`.
+func (c *checker) severity(rule Rule, relPath string) Severity {
+	if severity, ok := c.opts.Severities[rule]; ok {
+		return severity
+	}
+	dir := path.Dir(relPath)
+	if s, ok := c.vault.Config(dir).Lint[string(rule)]; ok {
+		severity, err := ParseSeverity(s)
+		if err == nil {
+			return severity
+		}
+		log.Printf("Ignoring lint.%s in %s: %v", rule, path.Join(dir, vault.ConfigName), err)
+	}
+	return Error
+}
+
+// Check that names are valid and unique.
+func (c *checker) checkNames(files []string) {
+	byName := map[string][]string{}
+	badDirs := map[string]bool{}
+	for _, file := range files {
+		byName[nameKey(file)] = append(byName[nameKey(file)], file)
+
+		dir := path.Dir(file)
+		for d := dir; d != "."; d = path.Dir(d) {
+			if !validName.MatchString(path.Base(d)) && !badDirs[d] {
+				badDirs[d] = true
+				c.report(Problem{Rule: Filename, Path: d, Message: fmt.Sprintf("folder name %q contains characters other than 0-9, a-z, A-Z and -_.+", path.Base(d))})
+			}
+		}
+		if name := path.Base(file); !validName.MatchString(name) {
+			c.report(Problem{Rule: Filename, Path: file, Message: fmt.Sprintf("filename %q contains characters other than 0-9, a-z, A-Z and -_.+", name), Fix: c.fix(file)})
+		}
+	}
+
+	for _, same := range byName {
+		if len(same) < 2 {
+			continue
+		}
+		for _, file := range same {
+			others := slices.DeleteFunc(slices.Clone(same), func(f string) bool { return f == file })
+			c.report(Problem{Rule: UniqueName, Path: file, Message: "same name as " + strings.Join(others, ", ")})
+		}
+	}
+}
+
+// Check that markdown files end in .md.
+func (c *checker) checkExtensions(files []string) {
+	for _, file := range files {
+		ext := path.Ext(file)
+		if vault.IsNote(file) || (!slices.Contains(markdownExtensions, strings.ToLower(ext)) && strings.ToLower(ext) != ".md") {
+			continue
+		}
+		c.report(Problem{Rule: MarkdownExtension, Path: file, Message: fmt.Sprintf("markdown file ends in %s instead of .md, so it isn't a note", ext), Fix: c.fix(file)})
+	}
+}
+
+// Check that wikilinks point to notes or attachments and headings that exist.
+func (c *checker) checkWikilinks() {
+	for _, note := range c.vault.Notes() {
+		for _, broken := range linkcheck.CheckNote(c.vault, note) {
+			if broken.Wiki {
+				c.report(Problem{Rule: Wikilink, Path: note.Path, Line: broken.Line, Col: broken.Col, Message: fmt.Sprintf("broken link to [[%s]]: %s", broken.Target, broken.Reason)})
+			}
+		}
+	}
+}
+
+// The path `file` can safely be renamed to to fix its name, or "" if there's
+// none: if it's linked to, the link would break, and a name that's taken would
+// only trade one problem for another.
+func (c *checker) fix(file string) string {
+	if fixed, ok := c.fixes[file]; ok {
+		return fixed
+	}
+	fixed := ""
+	if name := fixName(path.Base(file)); name != path.Base(file) && !c.linked[file] {
+		candidate := path.Join(path.Dir(file), name)
+		if validName.MatchString(name) && !c.taken[nameKey(candidate)] {
+			fixed = candidate
+			c.taken[nameKey(candidate)] = true
+		}
+	}
+	c.fixes[file] = fixed
+	return fixed
+}
+
+// Fix what's fixable about a filename: whitespace becomes '-', and markdown
+// extensions become .md. Anything else is left alone; there's no telling what
+// the author would have wanted instead.
+func fixName(name string) string {
+	ext := path.Ext(name)
+	if slices.Contains(markdownExtensions, strings.ToLower(ext)) || strings.ToLower(ext) == ".md" {
+		name = strings.TrimSuffix(name, ext) + ".md"
+	}
+	return strings.Join(strings.Fields(name), "-")
+}
+
+// The key files are considered the same by: their lowercase filename, without
+// .md for notes, which is how links find them.
+func nameKey(file string) string {
+	name := strings.ToLower(path.Base(file))
+	if vault.IsNote(file) {
+		return "note:" + strings.TrimSuffix(name, ".md")
+	}
+	return "file:" + name
+}
+
+// The vault-relative paths of all notes and attachments that a note links to.
+func linkedFiles(v *vault.Vault) map[string]bool {
+	linked := map[string]bool{}
+	for _, note := range v.Notes() {
+		l := site.NewVaultLinker(v, note)
+		for _, link := range note.Links {
+			if target := l.ResolveNote(link); target != nil {
+				linked[target.Path] = true
+			} else if attachment := l.ResolveAttachment(link); attachment != "" {
+				linked[attachment] = true
+			}
+		}
+	}
+	return linked
+}
@@ -0,0 +1,158 @@
+package lint
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// A vault in a temporary folder with the files in `files`, by slash-separated
+// path : content.
+func newTestVault(t *testing.T, files map[string]string) *vault.Vault {
+	t.Helper()
+	root := t.TempDir()
+	for relPath, content := range files {
+		abs := filepath.Join(root, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := vault.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// `problems` as strings.
+func strs(problems []Problem) []string {
+	var s []string
+	for _, p := range problems {
+		s = append(s, p.String())
+	}
+	return s
+}
+
+func TestLint(t *testing.T) {
+	v := newTestVault(t, map[string]string{
+		"a.md":          "# A\n\n[[b]] [[missing]] [[b#Nowhere]]\n",
+		"b.md":          "# B\n",
+		"dir/B.md":      "# Also B\n",
+		"my note.md":    "# Spaces\n",
+		"old.markdown":  "# Old\n",
+		"bad dir/ok.md": "# OK\n",
+	})
+	want := []string{
+		`a.md:3:9: error: broken link to [[missing]]: no such note or attachment [wikilink]`,
+		`a.md:3:21: error: broken link to [[b#Nowhere]]: no such heading in b.md [wikilink]`,
+		`b.md: error: same name as dir/B.md [unique-name]`,
+		`bad dir: error: folder name "bad dir" contains characters other than 0-9, a-z, A-Z and -_.+ [filename]`,
+		`dir/B.md: error: same name as b.md [unique-name]`,
+		`my note.md: error: filename "my note.md" contains characters other than 0-9, a-z, A-Z and -_.+ [filename] (fixable)`,
+		`old.markdown: error: markdown file ends in .markdown instead of .md, so it isn't a note [markdown-extension] (fixable)`,
+	}
+	if got := strs(Lint(v, Options{})); !slices.Equal(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSeverities(t *testing.T) {
+	files := map[string]string{
+		"top name.md":         "# Top\n",
+		"dir/.mdbuddy":        "lint:\n  filename: warn\n",
+		"dir/dir name.md":     "# Dir\n",
+		"dir/sub/.mdbuddy":    "lint:\n  filename: off\n",
+		"dir/sub/sub name.md": "# Sub\n",
+		"other/.mdbuddy":      "lint:\n  filename: sometimes\n",
+		"other/other name.md": "# Other\n",
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]Severity
+	}{
+		{"from .mdbuddy files", Options{},
+			map[string]Severity{"top name.md": Error, "dir/dir name.md": Warning, "other/other name.md": Error}},
+		{"overridden", Options{Severities: map[Rule]Severity{Filename: Warning}},
+			map[string]Severity{"top name.md": Warning, "dir/dir name.md": Warning, "dir/sub/sub name.md": Warning, "other/other name.md": Warning}},
+		{"turned off", Options{Severities: map[Rule]Severity{Filename: Off}}, map[string]Severity{}},
+	}
+	for _, tt := range tests {
+		got := map[string]Severity{}
+		for _, p := range Lint(newTestVault(t, files), tt.opts) {
+			got[p.Path] = p.Severity
+		}
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Severity
+	}{
+		{"error", Error}, {" Warn ", Warning}, {"WARNING", Warning}, {"off", Off}, {"ignore", Off}, {"never", ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseSeverity(tt.s)
+		if got != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("%q: got %q and error %v, want %q", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestFixes(t *testing.T) {
+	v := newTestVault(t, map[string]string{
+		"a.md":               "# A\n\n[[linked note]]\n",
+		"linked note.md":     "# Linked\n",
+		"my note.md":         "# Mine\n",
+		"my-note.md":         "# Taken\n",
+		"two words.md":       "# Two\n",
+		"two words.markdown": "# Also two\n",
+		"fine name.txt":      "text",
+	})
+	fixes := map[string]string{}
+	for _, p := range Lint(v, Options{}) {
+		if p.Fix != "" {
+			fixes[p.Path] = p.Fix
+		}
+	}
+	// A linked file and one whose name is taken aren't fixable, and of two
+	// files that would get the same name, only the first is
+	want := map[string]string{"two words.markdown": "two-words.md", "fine name.txt": "fine-name.txt"}
+	if !maps.Equal(fixes, want) {
+		t.Errorf("got fixes %v, want %v", fixes, want)
+	}
+}
+
+func TestFix(t *testing.T) {
+	v := newTestVault(t, map[string]string{"a b.md": "# A B\n", "c d.md": "# C D\n"})
+	problems := Lint(v, Options{})
+	// Taken since linting
+	if err := os.WriteFile(v.Abs("c-d.md"), []byte("someone else's"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fixed, err := Fix(v, problems)
+	if got := strs(fixed); len(got) != 1 || !strings.HasPrefix(got[0], "a b.md:") {
+		t.Errorf("got fixed %q, want a b.md", got)
+	}
+	if err == nil || !strings.Contains(err.Error(), "c-d.md already exists") {
+		t.Errorf("got error %v, want one about c-d.md", err)
+	}
+	for name, want := range map[string]string{"a-b.md": "# A B\n", "c d.md": "# C D\n", "c-d.md": "someone else's"} {
+		if b, err := os.ReadFile(v.Abs(name)); err != nil || string(b) != want {
+			t.Errorf("%s: got %q (%v), want %q", name, b, err, want)
+		}
+	}
+}
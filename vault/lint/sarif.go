@@ -0,0 +1,90 @@
+package lint
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+)
+
+// The parts of SARIF 2.1.0 (Static Analysis Results Interchange Format) that
+// WriteSARIF uses, for code scanning tools like GitHub's.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver struct {
+		Name           string      `json:"name"`
+		InformationURI string      `json:"informationUri,omitempty"`
+		Rules          []sarifRule `json:"rules"`
+	} `json:"driver"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// Write `problems` as a SARIF log. Paths stay relative to the vault root.
+func WriteSARIF(w io.Writer, problems []Problem) error {
+	run := sarifRun{Results: []sarifResult{}}
+	run.Tool.Driver.Name = "mdbuddy lint"
+	run.Tool.Driver.InformationURI = "https://github.com/flonle/mdbuddy"
+	for _, rule := range Rules {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: string(rule), ShortDescription: sarifMessage{rule.Description()}})
+	}
+
+	for _, p := range problems {
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation.URI = (&url.URL{Path: p.Path}).String()
+		if p.Line > 0 {
+			loc.PhysicalLocation.Region = &sarifRegion{StartLine: p.Line, StartColumn: p.Col}
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    string(p.Rule),
+			Level:     string(p.Severity), // "error" and "warning" mean the same in SARIF
+			Message:   sarifMessage{p.Message},
+			Locations: []sarifLocation{loc},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
@@ -0,0 +1,115 @@
+package lsp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	customExtensions "github.com/flonle/mdbuddy/renderer/goldmark-extensions"
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/linkcheck"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// Diagnostics for broken links and invalid callouts in `note`.
+func (s *server) diagnostics(note *vault.Note) []diagnostic {
+	diagnostics := []diagnostic{}
+	refs := links(note)
+	for _, broken := range linkcheck.CheckNote(s.vault, note) {
+		start, stop := lineColOffset(note.Source, broken.Line, broken.Col), -1
+		for _, ref := range refs {
+			if ref.whole.Start <= start && start < ref.whole.Stop {
+				start, stop = ref.whole.Start, ref.whole.Stop
+				break
+			}
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    s.textRange(note.Source, start, max(start, stop)),
+			Severity: severityError,
+			Code:     "broken-link",
+			Source:   "mdbuddy",
+			Message:  fmt.Sprintf("Broken link to %s: %s", broken.Target, broken.Reason),
+		})
+	}
+	for _, c := range checkCallouts(note) {
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    s.textRange(note.Source, c.start, c.stop),
+			Severity: severityWarning,
+			Code:     "callout",
+			Source:   "mdbuddy",
+			Message:  c.message,
+		})
+	}
+	return diagnostics
+}
+
+// The offset of the 1-based `line` and byte column `col` in `source`.
+func lineColOffset(source []byte, line, col int) int {
+	offset := 0
+	for ; line > 1; line-- {
+		i := bytes.IndexByte(source[offset:], '\n')
+		if i < 0 {
+			return len(source)
+		}
+		offset += i + 1
+	}
+	return min(offset+col-1, len(source))
+}
+
+// Send the diagnostics of every open document.
+func (s *server) publishDiagnostics() {
+	for relPath, doc := range s.docs {
+		note := s.vault.Note(relPath)
+		if note == nil {
+			continue
+		}
+		version := doc.version
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: doc.uri, Version: &version, Diagnostics: s.diagnostics(note)})
+	}
+}
+
+// Something wrong with a callout, and where.
+type calloutProblem struct {
+	start, stop int
+	message     string
+}
+
+// Check the first lines of callouts for what the renderer wouldn't make of
+// them what the author meant.
+func checkCallouts(note *vault.Note) []calloutProblem {
+	var problems []calloutProblem
+	ast.Walk(note.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		// Callouts after the first in a row stay blockquotes in the AST
+		switch n.(type) {
+		case *customExtensions.CalloutNode, *ast.Blockquote:
+		default:
+			return ast.WalkContinue, nil
+		}
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		para, ok := n.FirstChild().(*ast.Paragraph)
+		if !ok || para.Lines().Len() == 0 {
+			return ast.WalkContinue, nil
+		}
+		match, ok := customExtensions.MatchCallout(note.Source, para.Lines().At(0))
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+		calloutType := string(match.Type.Value(note.Source))
+		switch {
+		case !customExtensions.IsCalloutType(strings.ToLower(calloutType)):
+			problems = append(problems, calloutProblem{match.Type.Start, match.Type.Stop, fmt.Sprintf("unknown callout type %q; it looks like a note", calloutType)})
+		case strings.ToLower(calloutType) != calloutType:
+			problems = append(problems, calloutProblem{match.Type.Start, match.Type.Stop, fmt.Sprintf("callout type %q isn't lowercase, so it looks like a note; use %q", calloutType, strings.ToLower(calloutType))})
+		}
+		if !match.Fold.IsEmpty() {
+			problems = append(problems, calloutProblem{match.Fold.Start, match.Fold.Stop, "callouts can't be folded; the fold marker, and any title after it, is dropped"})
+		} else if match.Space.IsEmpty() && !match.Title.IsEmpty() {
+			problems = append(problems, calloutProblem{match.Title.Start, match.Title.Stop, "no space between the callout type and its title; the title is lost"})
+		}
+		return ast.WalkContinue, nil
+	})
+	return problems
+}
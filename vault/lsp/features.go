@@ -0,0 +1,413 @@
+package lsp
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/site"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// A link in a note, and where it is.
+type linkRef struct {
+	vault.Link
+	whole    text.Segment // The whole link, [[...]] or [...](...)
+	target   text.Segment // What to replace to point it elsewhere: the target of wikilinks, the destination of markdown links
+	fragment text.Segment // The #fragment, without the '#'; empty if there's none
+}
+
+// The wikilinks and markdown links in `note` that could be found in its
+// source.
+func links(note *vault.Note) []linkRef {
+	var refs []linkRef
+	ast.Walk(note.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *wikilink.Node:
+			if span, ok := vault.FindWikilink(note.Source, n); ok {
+				refs = append(refs, linkRef{
+					Link:     vault.Link{Target: string(n.Target), Fragment: string(n.Fragment), Wiki: true, Embed: n.Embed, Offset: span.Whole.Start},
+					whole:    span.Whole,
+					target:   span.Target,
+					fragment: span.Fragment,
+				})
+			}
+		case *ast.Link, *ast.Image:
+			span, ok := vault.FindLink(note.Source, n)
+			if !ok {
+				break
+			}
+			_, embed := n.(*ast.Image)
+			ref := linkRef{Link: vault.NewLink(span.Dest.Value(note.Source), embed, span.Whole.Start), whole: span.Whole, target: span.Dest}
+			if i := bytes.IndexByte(span.Dest.Value(note.Source), '#'); i >= 0 {
+				ref.fragment = text.NewSegment(span.Dest.Start+i+1, span.Dest.Stop)
+			}
+			if !ref.IsExternal() {
+				refs = append(refs, ref)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return refs
+}
+
+// The link in `note` at `offset`, if there's one.
+func linkAt(note *vault.Note, offset int) (linkRef, bool) {
+	for _, ref := range links(note) {
+		if ref.whole.Start <= offset && offset < ref.whole.Stop {
+			return ref, true
+		}
+	}
+	return linkRef{}, false
+}
+
+// The heading of `note` on the line `offset` is on, if there's one.
+func headingAt(note *vault.Note, offset int) (vault.Heading, bool) {
+	line, _ := note.Position(offset)
+	for _, h := range note.Headings {
+		if l, _ := note.Position(h.Offset); l == line {
+			return h, true
+		}
+	}
+	return vault.Heading{}, false
+}
+
+// What a link points to: a note, and maybe a heading in it, or an attachment.
+type destination struct {
+	note       *vault.Note
+	heading    *vault.Heading
+	attachment string
+}
+
+// Resolve `ref` in `note`. Links to nowhere have an empty destination.
+func (s *server) resolve(note *vault.Note, ref linkRef) destination {
+	l := site.NewVaultLinker(s.vault, note)
+	var d destination
+	switch {
+	case ref.Target == "":
+		d.note = note
+	default:
+		if d.note = l.ResolveNote(ref.Link); d.note == nil {
+			d.attachment = l.ResolveAttachment(ref.Link)
+			return d
+		}
+	}
+	if ref.Fragment != "" {
+		for _, h := range d.note.Headings {
+			if h.ID == ref.Fragment || strings.EqualFold(h.Text, ref.Fragment) {
+				d.heading = &h
+				break
+			}
+		}
+	}
+	return d
+}
+
+// The range of a heading's text, without the #'s around it.
+func headingText(source []byte, h vault.Heading) (start, stop int) {
+	stop = h.Offset
+	for stop < len(source) && source[stop] != '\n' {
+		stop++
+	}
+	line := bytes.TrimRight(source[h.Offset:stop], " \t\r")
+	// Closing sequence of an ATX heading, "# Heading ##"
+	if trimmed := bytes.TrimRight(line, "#"); len(trimmed) < len(line) && (len(trimmed) == 0 || trimmed[len(trimmed)-1] == ' ' || trimmed[len(trimmed)-1] == '\t') {
+		line = bytes.TrimRight(trimmed, " \t")
+	}
+	return h.Offset, h.Offset + len(line)
+}
+
+func (s *server) headingLocation(note *vault.Note, h vault.Heading) location {
+	start, stop := headingText(note.Source, h)
+	return location{URI: s.uri(note.Path), Range: s.textRange(note.Source, start, stop)}
+}
+
+// A tag written like a hashtag, up to the cursor.
+var partialHashtag = regexp.MustCompile(`(?:^|[\s(])#([\p{L}\p{N}_/-]*)$`)
+
+func (s *server) completion(params textDocumentPositionParams) (any, error) {
+	note := s.note(params.TextDocument.URI)
+	if note == nil {
+		return nil, nil
+	}
+	offset := s.offset(note.Source, params.Position)
+	lineStart := bytes.LastIndexByte(note.Source[:offset], '\n') + 1
+	before := note.Source[lineStart:offset]
+	list := completionList{Items: []completionItem{}}
+
+	// In a wikilink: [[partial
+	if open := bytes.LastIndex(before, []byte("[[")); open >= 0 && !bytes.Contains(before[open:], []byte("]]")) {
+		partial := string(before[open+2:])
+		if strings.Contains(partial, "|") {
+			return list, nil // The label is free text
+		}
+		if target, fragment, ok := strings.Cut(partial, "#"); ok {
+			linked := note
+			if target != "" {
+				linked = s.vault.Resolve(target)
+			}
+			if linked != nil {
+				r := s.textRange(note.Source, offset-len(fragment), offset)
+				for _, h := range linked.Headings {
+					list.Items = append(list.Items, completionItem{
+						Label:    h.Text,
+						Kind:     completionReference,
+						Detail:   strings.Repeat("#", h.Level) + " in " + linked.Path,
+						TextEdit: &textEdit{Range: r, NewText: h.Text},
+					})
+				}
+			}
+			return list, nil
+		}
+
+		r := s.textRange(note.Source, offset-len(partial), offset)
+		for _, n := range s.vault.Notes() {
+			name := n.Name()
+			if s.vault.Resolve(name) != n {
+				name = strings.TrimSuffix(n.Path, ".md") // Ambiguous
+			}
+			list.Items = append(list.Items, completionItem{Label: name, Kind: completionFile, Detail: n.Title, TextEdit: &textEdit{Range: r, NewText: name}})
+		}
+		for _, file := range s.vault.Files("") {
+			if !vault.IsNote(file) {
+				list.Items = append(list.Items, completionItem{Label: path.Base(file), Kind: completionFile, Detail: file, TextEdit: &textEdit{Range: r, NewText: path.Base(file)}})
+			}
+		}
+		return list, nil
+	}
+
+	// A hashtag: #partial
+	if match := partialHashtag.FindSubmatch(before); match != nil && !isHeadingStart(before) {
+		r := s.textRange(note.Source, offset-len(match[1]), offset)
+		// Not the tag being typed, which is a tag of this note already
+		seen := map[string]bool{strings.ToLower(string(match[1])): true}
+		for _, n := range s.vault.Notes() {
+			for _, tag := range n.Tags {
+				if key := strings.ToLower(tag); !seen[key] {
+					seen[key] = true
+					list.Items = append(list.Items, completionItem{Label: tag, Kind: completionText, TextEdit: &textEdit{Range: r, NewText: tag}})
+				}
+			}
+		}
+		slices.SortFunc(list.Items, func(a, b completionItem) int { return strings.Compare(a.Label, b.Label) })
+	}
+	return list, nil
+}
+
+// Report whether `line` so far is the start of an ATX heading, "## ", where a
+// '#' isn't a hashtag.
+func isHeadingStart(line []byte) bool {
+	trimmed := bytes.TrimLeft(line, " ")
+	return len(trimmed) > 0 && len(bytes.Trim(trimmed, "#")) == 0
+}
+
+func (s *server) definition(params textDocumentPositionParams) (any, error) {
+	note := s.note(params.TextDocument.URI)
+	if note == nil {
+		return nil, nil
+	}
+	ref, ok := linkAt(note, s.offset(note.Source, params.Position))
+	if !ok {
+		return nil, nil
+	}
+	d := s.resolve(note, ref)
+	switch {
+	case d.heading != nil:
+		return s.headingLocation(d.note, *d.heading), nil
+	case d.note != nil:
+		return location{URI: s.uri(d.note.Path)}, nil
+	case d.attachment != "":
+		return location{URI: s.uri(d.attachment)}, nil
+	}
+	return nil, nil
+}
+
+func (s *server) references(params referenceParams) (any, error) {
+	note := s.note(params.TextDocument.URI)
+	if note == nil {
+		return nil, nil
+	}
+	offset := s.offset(note.Source, params.Position)
+
+	// What's referenced: what the link under the cursor points to, or the
+	// heading under it, or else the note itself
+	d := destination{note: note}
+	if ref, ok := linkAt(note, offset); ok {
+		d = s.resolve(note, ref)
+	} else if h, ok := headingAt(note, offset); ok {
+		d.heading = &h
+	}
+
+	locations := []location{}
+	if params.Context.IncludeDeclaration {
+		switch {
+		case d.heading != nil:
+			locations = append(locations, s.headingLocation(d.note, *d.heading))
+		case d.note != nil:
+			locations = append(locations, location{URI: s.uri(d.note.Path)})
+		}
+	}
+	for _, n := range s.vault.Notes() {
+		for _, ref := range links(n) {
+			if s.points(n, ref, d) {
+				locations = append(locations, location{URI: s.uri(n.Path), Range: s.textRange(n.Source, ref.whole.Start, ref.whole.Stop)})
+			}
+		}
+	}
+	return locations, nil
+}
+
+// Report whether `ref` in `note` points to `d`: to its note, if it has no
+// heading, or to its heading.
+func (s *server) points(note *vault.Note, ref linkRef, d destination) bool {
+	to := s.resolve(note, ref)
+	switch {
+	case d.attachment != "":
+		return to.attachment == d.attachment
+	case d.note == nil || to.note != d.note:
+		return false
+	case d.heading != nil:
+		return to.heading != nil && to.heading.Offset == d.heading.Offset
+	}
+	return true
+}
+
+// How much of a note a hover shows.
+const previewSize = 2000
+
+func (s *server) hover(params textDocumentPositionParams) (any, error) {
+	note := s.note(params.TextDocument.URI)
+	if note == nil {
+		return nil, nil
+	}
+	ref, ok := linkAt(note, s.offset(note.Source, params.Position))
+	if !ok {
+		return nil, nil
+	}
+	r := s.textRange(note.Source, ref.whole.Start, ref.whole.Stop)
+	d := s.resolve(note, ref)
+	switch {
+	case d.note != nil:
+		return hover{Contents: markupContent{Kind: "markdown", Value: preview(d)}, Range: &r}, nil
+	case d.attachment != "":
+		return hover{Contents: markupContent{Kind: "markdown", Value: fmt.Sprintf("Attachment `%s`", d.attachment)}, Range: &r}, nil
+	}
+	return nil, nil
+}
+
+// A preview of the note or heading `d` points to: its section for headings,
+// and otherwise the note without its front matter, cut short if it's long.
+func preview(d destination) string {
+	source := d.note.Source
+	if len(d.note.Headings) > 0 || d.heading != nil {
+		start := 0
+		if d.heading != nil {
+			start = bytes.LastIndexByte(source[:d.heading.Offset], '\n') + 1
+		} else if first := firstBlock(d.note); first >= 0 {
+			start = first
+		}
+		source = source[start:]
+		if d.heading != nil {
+			// Up to the next heading that isn't below it
+			for _, h := range d.note.Headings {
+				if h.Offset > d.heading.Offset && h.Level <= d.heading.Level {
+					end := bytes.LastIndexByte(d.note.Source[:h.Offset], '\n') + 1
+					source = d.note.Source[start:end]
+					break
+				}
+			}
+		}
+	} else if first := firstBlock(d.note); first >= 0 {
+		source = source[first:]
+	}
+
+	if len(source) > previewSize {
+		cut := bytes.LastIndexByte(source[:previewSize], '\n')
+		source = append(bytes.Clone(source[:max(cut, 0)]), "\n\n…"...)
+	}
+	return fmt.Sprintf("`%s`\n\n---\n\n%s", d.note.Path, bytes.TrimSpace(source))
+}
+
+// The offset of the first block of `note`, after its front matter, or -1.
+func firstBlock(note *vault.Note) int {
+	for n := note.Doc.FirstChild(); n != nil; n = n.NextSibling() {
+		if n.Lines().Len() > 0 {
+			return bytes.LastIndexByte(note.Source[:n.Lines().At(0).Start], '\n') + 1
+		}
+		if offset := firstOffset(n); offset >= 0 {
+			return bytes.LastIndexByte(note.Source[:offset], '\n') + 1
+		}
+	}
+	return -1
+}
+
+// The source offset of the first line below `node`, or -1.
+func firstOffset(node ast.Node) int {
+	offset := -1
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+			offset = n.Lines().At(0).Start
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return offset
+}
+
+func (s *server) documentSymbols(params struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}) (any, error) {
+	note := s.note(params.TextDocument.URI)
+	if note == nil {
+		return nil, nil
+	}
+
+	// Every heading's section runs until the next heading of its level or
+	// higher; nested ones are its children
+	type open struct {
+		level  int
+		symbol *documentSymbol
+	}
+	root := &documentSymbol{}
+	stack := []open{{0, root}}
+	for i, h := range note.Headings {
+		end := len(note.Source)
+		for _, next := range note.Headings[i+1:] {
+			if next.Level <= h.Level {
+				end = bytes.LastIndexByte(note.Source[:next.Offset], '\n') + 1
+				break
+			}
+		}
+		lineStart := bytes.LastIndexByte(note.Source[:h.Offset], '\n') + 1
+		start, stop := headingText(note.Source, h)
+		symbol := documentSymbol{
+			Name:           cmp.Or(h.Text, " "),
+			Detail:         strings.Repeat("#", h.Level),
+			Kind:           symbolString,
+			Range:          s.textRange(note.Source, lineStart, end),
+			SelectionRange: s.textRange(note.Source, start, stop),
+		}
+
+		for stack[len(stack)-1].level >= h.Level {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1].symbol
+		parent.Children = append(parent.Children, symbol)
+		stack = append(stack, open{h.Level, &parent.Children[len(parent.Children)-1]})
+	}
+	if root.Children == nil {
+		return []documentSymbol{}, nil
+	}
+	return root.Children, nil
+}
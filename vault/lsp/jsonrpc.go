@@ -0,0 +1,121 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes used by LSP.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+	codeNotInitialized = -32002
+	codeRequestFailed  = -32803
+)
+
+// A request or notification from the client. Notifications have no ID.
+type request struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+}
+
+type errorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *rpcError       `json:"error"`
+}
+
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// An error to respond with, instead of a result.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// A base protocol connection: messages with a Content-Length header, and a
+// JSON body.
+type conn struct {
+	r   *textproto.Reader
+	w   io.Writer
+	wMx sync.Mutex
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: textproto.NewReader(bufio.NewReader(r)), w: w}
+}
+
+// Read the next message.
+func (c *conn) read() (*request, error) {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		return nil, err
+	}
+	req := &request{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, &rpcError{Code: codeParseError, Message: err.Error()}
+	}
+	return req, nil
+}
+
+// Write a message.
+func (c *conn) write(msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.wMx.Lock()
+	defer c.wMx.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(body)
+	return err
+}
+
+func (c *conn) reply(id json.RawMessage, result any, err error) error {
+	if err == nil {
+		return c.write(response{JSONRPC: "2.0", ID: id, Result: result})
+	}
+	rpcErr, ok := err.(*rpcError)
+	if !ok {
+		rpcErr = &rpcError{Code: codeRequestFailed, Message: err.Error()}
+	}
+	return c.write(errorResponse{JSONRPC: "2.0", ID: id, Error: rpcErr})
+}
+
+func (c *conn) notify(method string, params any) error {
+	return c.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
@@ -0,0 +1,166 @@
+package lsp
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/migrate"
+)
+
+// What a rename at a position renames: a note, or a heading in one, and the
+// text in the document that stands for it.
+type renameSubject struct {
+	destination
+	start, stop int
+	placeholder string
+}
+
+// The subject of a rename at `offset` in `note`: the note or heading the link
+// under it points to, or the heading on its line.
+func (s *server) renameSubject(note *vault.Note, offset int) (renameSubject, error) {
+	if ref, ok := linkAt(note, offset); ok {
+		d := s.resolve(note, ref)
+		switch {
+		case d.heading != nil && ref.fragment.Start <= offset && offset <= ref.fragment.Stop:
+			return renameSubject{d, ref.fragment.Start, ref.fragment.Stop, d.heading.Text}, nil
+		case d.note != nil && ref.Target != "":
+			d.heading = nil
+			return renameSubject{d, ref.target.Start, ref.target.Stop, d.note.Name()}, nil
+		case d.attachment != "":
+			return renameSubject{}, fmt.Errorf("can't rename attachments; rename %s on disk instead", d.attachment)
+		}
+		return renameSubject{}, fmt.Errorf("the link points nowhere")
+	}
+	if h, ok := headingAt(note, offset); ok {
+		start, stop := headingText(note.Source, h)
+		return renameSubject{destination{note: note, heading: &h}, start, stop, h.Text}, nil
+	}
+	return renameSubject{}, fmt.Errorf("nothing to rename here; put the cursor on a link or heading")
+}
+
+func (s *server) prepareRename(params textDocumentPositionParams) (any, error) {
+	note := s.note(params.TextDocument.URI)
+	if note == nil {
+		return nil, nil
+	}
+	subject, err := s.renameSubject(note, s.offset(note.Source, params.Position))
+	if err != nil {
+		return nil, err
+	}
+	return prepareRenameResult{Range: s.textRange(note.Source, subject.start, subject.stop), Placeholder: subject.placeholder}, nil
+}
+
+func (s *server) rename(params renameParams) (any, error) {
+	note := s.note(params.TextDocument.URI)
+	if note == nil {
+		return nil, nil
+	}
+	subject, err := s.renameSubject(note, s.offset(note.Source, params.Position))
+	if err != nil {
+		return nil, err
+	}
+	newName := strings.TrimSpace(params.NewName)
+	if newName == "" || newName == subject.placeholder {
+		return workspaceEdit{DocumentChanges: []any{}}, nil
+	}
+	if subject.heading != nil {
+		return s.renameHeading(subject.note, *subject.heading, newName), nil
+	}
+	return s.renameNote(subject.note, newName)
+}
+
+// Rename `note` to `newName`, which is a new name for it in its folder, or a
+// vault-relative path if it has a '/', and fix every link to it like mv does.
+func (s *server) renameNote(note *vault.Note, newName string) (any, error) {
+	to := path.Join(path.Dir(note.Path), newName)
+	if strings.Contains(newName, "/") {
+		to = newName
+	}
+	// Move migrates the vault in memory, to what it'd be once the client
+	// applies the edit; the client tells us when it has, or doesn't
+	var open []*vault.Note
+	for relPath := range s.docs {
+		open = append(open, s.vault.Note(relPath))
+	}
+	defer func() {
+		if err := s.reload(open); err != nil {
+			log.Printf("Failed to reload the vault: %v", err)
+		}
+	}()
+	plan, err := migrate.Move(s.vault, note.Path, to, migrate.MoveOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Edits first, to the files where they are, and then the renames
+	edit := workspaceEdit{DocumentChanges: []any{}}
+	var renames []any
+	for _, c := range plan.Changes {
+		if c.Old != nil && string(c.Old) != string(c.New) {
+			edit.DocumentChanges = append(edit.DocumentChanges, s.documentEdit(c.Path, textEdit{Range: s.textRange(c.Old, 0, len(c.Old)), NewText: string(c.New)}))
+		}
+		if c.NewPath != c.Path {
+			renames = append(renames, renameFile{Kind: "rename", OldURI: s.uri(c.Path), NewURI: pathURI(s.vault.Abs(c.NewPath))})
+		}
+	}
+	edit.DocumentChanges = append(edit.DocumentChanges, renames...)
+	return edit, nil
+}
+
+// Rename the heading `h` of `note` to `newName`, and fix the fragments of
+// every link to it: wikilinks that use its text get the new text, and other
+// links its new ID.
+func (s *server) renameHeading(note *vault.Note, h vault.Heading, newName string) any {
+	start, stop := headingText(note.Source, h)
+	edits := map[string][]textEdit{
+		note.Path: {{Range: s.textRange(note.Source, start, stop), NewText: newName}},
+	}
+
+	// The new ID, as the note will have it: IDs of headings with the same text
+	// get suffixes
+	source := slices.Concat(note.Source[:start], []byte(newName), note.Source[stop:])
+	i := slices.IndexFunc(note.Headings, func(other vault.Heading) bool { return other.Offset == h.Offset })
+	newID := vault.NewNote(note.Path, source, time.Now()).Headings[i].ID
+
+	d := destination{note: note, heading: &h}
+	for _, n := range s.vault.Notes() {
+		for _, ref := range links(n) {
+			if ref.fragment.Len() == 0 || !s.points(n, ref, d) {
+				continue
+			}
+			fragment := newID
+			if ref.Wiki && ref.Fragment != h.ID {
+				fragment = newName
+			}
+			edits[n.Path] = append(edits[n.Path], textEdit{Range: s.textRange(n.Source, ref.fragment.Start, ref.fragment.Stop), NewText: fragment})
+		}
+	}
+
+	edit := workspaceEdit{DocumentChanges: []any{}}
+	paths := make([]string, 0, len(edits))
+	for relPath := range edits {
+		paths = append(paths, relPath)
+	}
+	slices.Sort(paths)
+	for _, relPath := range paths {
+		edit.DocumentChanges = append(edit.DocumentChanges, s.documentEdit(relPath, edits[relPath]...))
+	}
+	return edit
+}
+
+// Edits to the document at `relPath`, for the version the client has open,
+// if it does.
+func (s *server) documentEdit(relPath string, edits ...textEdit) textDocumentEdit {
+	e := textDocumentEdit{Edits: edits}
+	e.TextDocument.URI = s.uri(relPath)
+	if doc, ok := s.docs[relPath]; ok {
+		version := doc.version
+		e.TextDocument.Version = &version
+	}
+	return e
+}
@@ -0,0 +1,401 @@
+// Package lsp is a language server for the notes of a vault, speaking the
+// Language Server Protocol over stdio: completion of wikilinks and hashtags,
+// go-to-definition, references, renames, hover previews, document symbols and
+// diagnostics, all on the same AST the rest of MDBuddy uses.
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+type Options struct {
+	// The vault; defaults to the workspace folder the client opens.
+	Root string
+}
+
+// Serve the client on the other end of `r` and `w` until it exits.
+func Serve(r io.Reader, w io.Writer, opts Options) error {
+	s := &server{conn: newConn(r, w), opts: opts, docs: map[string]*document{}, encoding: "utf-16"}
+	for {
+		req, err := s.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) {
+			s.reply(nil, nil, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exited without shutdown")
+			}
+			return nil
+		}
+
+		result, err := s.handle(req)
+		if req.ID != nil {
+			if err := s.reply(req.ID, result, err); err != nil {
+				return err
+			}
+		} else if err != nil {
+			log.Printf("Failed to handle %s: %v", req.Method, err)
+		}
+	}
+}
+
+type server struct {
+	*conn
+	opts     Options
+	vault    *vault.Vault
+	docs     map[string]*document // Vault-relative path : document open in the client
+	encoding string               // How positions count characters: "utf-16" or "utf-8"
+	shutdown bool
+}
+
+// A document the client has open. Its text, rather than what's on disk, is in
+// the vault.
+type document struct {
+	uri     string
+	version int
+}
+
+// Handle a request or notification, and return its result.
+func (s *server) handle(req *request) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic handling %s: %v\n%s", req.Method, r, debug.Stack())
+			err = &rpcError{Code: codeInternalError, Message: fmt.Sprint(r)}
+		}
+	}()
+
+	if s.vault == nil && req.Method != "initialize" {
+		if req.ID == nil {
+			return nil, nil // Notifications before initialize are dropped
+		}
+		return nil, &rpcError{Code: codeNotInitialized, Message: "not initialized"}
+	}
+
+	switch req.Method {
+	case "initialize":
+		return call(s, req, s.initialize)
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		return call(s, req, s.didOpen)
+	case "textDocument/didChange":
+		return call(s, req, s.didChange)
+	case "textDocument/didClose":
+		return call(s, req, s.didClose)
+	case "textDocument/didSave":
+		return nil, nil
+	case "workspace/didCreateFiles", "workspace/didDeleteFiles", "workspace/didRenameFiles":
+		return call(s, req, s.didChangeFiles)
+	case "textDocument/completion":
+		return call(s, req, s.completion)
+	case "textDocument/definition":
+		return call(s, req, s.definition)
+	case "textDocument/references":
+		return call(s, req, s.references)
+	case "textDocument/hover":
+		return call(s, req, s.hover)
+	case "textDocument/documentSymbol":
+		return call(s, req, s.documentSymbols)
+	case "textDocument/prepareRename":
+		return call(s, req, s.prepareRename)
+	case "textDocument/rename":
+		return call(s, req, s.rename)
+	}
+	if req.ID == nil || strings.HasPrefix(req.Method, "$/") {
+		return nil, nil // Notifications we don't care about may be ignored
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+// Decode the params of `req` and call `fn` with them.
+func call[P any](s *server, req *request, fn func(P) (any, error)) (any, error) {
+	var params P
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+	}
+	return fn(params)
+}
+
+func (s *server) initialize(params initializeParams) (any, error) {
+	root := s.opts.Root
+	if root == "" {
+		switch {
+		case len(params.WorkspaceFolders) > 0:
+			root = uriPath(params.WorkspaceFolders[0].URI)
+		case params.RootURI != "":
+			root = uriPath(params.RootURI)
+		default:
+			root = params.RootPath
+		}
+	}
+	if root == "" {
+		return nil, fmt.Errorf("no vault: open a folder, or pass one")
+	}
+	v, err := vault.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	s.vault = v
+	for _, encoding := range params.Capabilities.General.PositionEncodings {
+		if encoding == "utf-8" {
+			s.encoding = encoding
+		}
+	}
+	log.Printf("Serving the vault at %s", v.Root)
+
+	allFiles := map[string]any{"filters": []any{map[string]any{"pattern": map[string]any{"glob": "**/*"}}}}
+	return map[string]any{
+		"capabilities": map[string]any{
+			"positionEncoding": s.encoding,
+			"textDocumentSync": map[string]any{
+				"openClose": true,
+				"change":    1, // Full
+			},
+			"completionProvider":     map[string]any{"triggerCharacters": []string{"[", "#"}},
+			"definitionProvider":     true,
+			"referencesProvider":     true,
+			"hoverProvider":          true,
+			"documentSymbolProvider": true,
+			"renameProvider":         map[string]any{"prepareProvider": true},
+			"workspace": map[string]any{
+				"fileOperations": map[string]any{
+					"didCreate": allFiles,
+					"didDelete": allFiles,
+					"didRename": allFiles,
+				},
+			},
+		},
+		"serverInfo": map[string]any{"name": "mdbuddy"},
+	}, nil
+}
+
+func (s *server) didOpen(params didOpenParams) (any, error) {
+	relPath, ok := s.relPath(params.TextDocument.URI)
+	if !ok || !vault.IsNote(relPath) {
+		return nil, nil
+	}
+	s.docs[relPath] = &document{uri: params.TextDocument.URI, version: params.TextDocument.Version}
+	s.vault.Add(vault.NewNote(relPath, []byte(params.TextDocument.Text), time.Now()))
+	s.publishDiagnostics()
+	return nil, nil
+}
+
+func (s *server) didChange(params didChangeParams) (any, error) {
+	relPath, ok := s.relPath(params.TextDocument.URI)
+	doc := s.docs[relPath]
+	if !ok || doc == nil || len(params.ContentChanges) == 0 {
+		return nil, nil
+	}
+	// Full sync: the last change has the whole text
+	doc.version = params.TextDocument.Version
+	s.vault.Add(vault.NewNote(relPath, []byte(params.ContentChanges[len(params.ContentChanges)-1].Text), time.Now()))
+	s.publishDiagnostics()
+	return nil, nil
+}
+
+func (s *server) didClose(params didCloseParams) (any, error) {
+	relPath, ok := s.relPath(params.TextDocument.URI)
+	if !ok {
+		return nil, nil
+	}
+	delete(s.docs, relPath)
+	s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []diagnostic{}})
+	s.reloadFile(relPath) // Back to what's on disk
+	s.publishDiagnostics()
+	return nil, nil
+}
+
+// Keep up with files the client creates, deletes and renames.
+func (s *server) didChangeFiles(params fileOperationParams) (any, error) {
+	for _, f := range params.Files {
+		for _, uri := range []string{f.URI, f.OldURI, f.NewURI} {
+			if relPath, ok := s.relPath(uri); ok && uri != "" {
+				s.reloadFile(relPath)
+			}
+		}
+	}
+	s.publishDiagnostics()
+	return nil, nil
+}
+
+// Update the vault with the file or folder at `relPath` as it is on disk.
+// Open documents are left alone.
+func (s *server) reloadFile(relPath string) {
+	if _, open := s.docs[relPath]; open {
+		return
+	}
+	info, err := os.Stat(s.vault.Abs(relPath))
+	switch {
+	case err != nil:
+		s.vault.Remove(relPath)
+		for _, file := range s.vault.Files(relPath) {
+			s.vault.Remove(file)
+		}
+	case info.IsDir():
+		s.vault.Walk(func(file string, d os.DirEntry) error {
+			if strings.HasPrefix(file, relPath+"/") {
+				s.reloadFile(file)
+			}
+			return nil
+		})
+	case vault.IsNote(relPath):
+		if _, err := s.vault.Load(relPath); err != nil {
+			log.Print(err)
+		}
+	default:
+		s.vault.AddAttachment(relPath)
+	}
+}
+
+// Reopen the vault from disk, with `open`, the open documents as the client
+// has them. For after changes that went behind the vault's back.
+func (s *server) reload(open []*vault.Note) error {
+	v, err := vault.Open(s.vault.Root)
+	if err != nil {
+		return err
+	}
+	for _, note := range open {
+		if note != nil {
+			v.Add(note)
+		}
+	}
+	s.vault = v
+	return nil
+}
+
+// The note at `uri`, as the client has it if it's open. Nil for files outside
+// the vault, and for attachments.
+func (s *server) note(uri string) *vault.Note {
+	relPath, ok := s.relPath(uri)
+	if !ok {
+		return nil
+	}
+	return s.vault.Note(relPath)
+}
+
+// The vault-relative path of the file at `uri`, and whether it's in the
+// vault at all.
+func (s *server) relPath(uri string) (string, bool) {
+	p := uriPath(uri)
+	if p == "" {
+		return "", false
+	}
+	return s.vault.Rel(p)
+}
+
+// The URI of the vault-relative `relPath`, as the client has it open if it
+// does.
+func (s *server) uri(relPath string) string {
+	if doc, ok := s.docs[relPath]; ok {
+		return doc.uri
+	}
+	return pathURI(s.vault.Abs(relPath))
+}
+
+// The filesystem path of a file:// URI, or "" for other URIs.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	p := u.Path
+	// file:///C:/dir on Windows
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+func pathURI(p string) string {
+	p = filepath.ToSlash(p)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// The position of the byte at `offset` in `source`.
+func (s *server) position(source []byte, offset int) position {
+	offset = min(max(offset, 0), len(source))
+	lineStart := 0
+	line := 0
+	for i, b := range source[:offset] {
+		if b == '\n' {
+			line++
+			lineStart = i + 1
+		}
+	}
+	return position{Line: line, Character: s.width(source[lineStart:offset])}
+}
+
+// The byte offset of `pos` in `source`. Positions past the end of a line are
+// at its end.
+func (s *server) offset(source []byte, pos position) int {
+	start := 0
+	for line := 0; line < pos.Line; line++ {
+		i := bytes.IndexByte(source[start:], '\n')
+		if i < 0 {
+			return len(source)
+		}
+		start += i + 1
+	}
+	end := start
+	for end < len(source) && source[end] != '\n' {
+		end++
+	}
+	i, width := start, 0
+	for i < end && width < pos.Character {
+		r, size := utf8.DecodeRune(source[i:])
+		if s.encoding == "utf-8" {
+			width += size
+		} else {
+			width += utf16.RuneLen(r)
+		}
+		i += size
+	}
+	return i
+}
+
+// The width of `b` in the position encoding.
+func (s *server) width(b []byte) int {
+	if s.encoding == "utf-8" {
+		return len(b)
+	}
+	n := 0
+	for _, r := range string(b) {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+func (s *server) textRange(source []byte, start, stop int) textRange {
+	return textRange{Start: s.position(source, start), End: s.position(source, stop)}
+}
@@ -0,0 +1,399 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testVault = map[string]string{
+	"a.md":        "# A\n\nSee [[b#Part two]], [[b]], ![[pic.png]] and [c](c.md).\n\n#tag\n",
+	"b.md":        "# B\n\n## Part one\n\nOne.\n\n## Part two\n\nTwo.\n\n### Deeper\n\nThree.\n\n# After\n",
+	"c.md":        "---\ntitle: C\n---\n\nBack to [[b]] and [[b#part-two]]. #topic\n",
+	"pic.png":     "image",
+	"dir/b.md":    "# Another B\n",
+	"dir/note.md": "[[dir/b]]\n",
+}
+
+// A server, initialized with a vault in a temporary folder with the files in
+// `files`, by slash-separated path : content. What it sends is written to
+// `out`.
+func newTestServer(t *testing.T, files map[string]string, out io.Writer) *server {
+	t.Helper()
+	root := t.TempDir()
+	for relPath, content := range files {
+		abs := filepath.Join(root, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out == nil {
+		out = io.Discard
+	}
+	s := &server{conn: newConn(strings.NewReader(""), out), docs: map[string]*document{}, encoding: "utf-16"}
+	if _, err := s.initialize(initializeParams{RootURI: pathURI(root)}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Handle the request `method` with `params`, and return its result as JSON.
+func send(t *testing.T, s *server, method string, params any) string {
+	t.Helper()
+	b, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.handle(&request{ID: json.RawMessage("1"), Method: method, Params: b})
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	b, err = json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Shorter, and the same on every machine
+	return strings.ReplaceAll(string(b), pathURI(s.vault.Root)+"/", "")
+}
+
+// The position `line`:`char` in the file at `relPath`, both 0-based.
+func at(s *server, relPath string, line, char int) textDocumentPositionParams {
+	return textDocumentPositionParams{TextDocument: textDocumentIdentifier{URI: pathURI(s.vault.Abs(relPath))}, Position: position{line, char}}
+}
+
+// A message the server sent.
+type message struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// Frame `msgs` like a client would.
+func frame(msgs ...string) string {
+	var b strings.Builder
+	for _, msg := range msgs {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	return b.String()
+}
+
+// The messages in `out`.
+func readMessages(t *testing.T, out []byte) []message {
+	t.Helper()
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(out)))
+	var msgs []message
+	for {
+		header, err := r.ReadMIMEHeader()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r.R, body); err != nil {
+			t.Fatal(err)
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestServe(t *testing.T) {
+	root := t.TempDir()
+	in := frame(
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"initialize","params":{"rootUri":"`+pathURI(root)+`","capabilities":{"general":{"positionEncodings":["utf-8","utf-16"]}}}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/unknown","params":{}}`,
+		`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":3}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/hover","params":{"position":"nowhere"}}`,
+		`not JSON`,
+		`{"jsonrpc":"2.0","id":5,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	var out bytes.Buffer
+	if err := Serve(strings.NewReader(in), &out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, msg := range readMessages(t, out.Bytes()) {
+		s := string(msg.ID) + " "
+		if msg.Error != nil {
+			s += strconv.Itoa(msg.Error.Code)
+		} else {
+			s += "ok"
+		}
+		got = append(got, s)
+	}
+	want := []string{"1 -32002", "2 ok", "3 -32601", "4 -32602", "null -32700", "5 ok"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got replies %q, want %q", got, want)
+	}
+	if !strings.Contains(out.String(), `"positionEncoding":"utf-8"`) {
+		t.Errorf("didn't pick utf-8 positions:\n%s", out.String())
+	}
+
+	in = frame(`{"jsonrpc":"2.0","method":"exit"}`)
+	if err := Serve(strings.NewReader(in), io.Discard, Options{Root: root}); err == nil {
+		t.Error("got no error exiting without a shutdown")
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	s := newTestServer(t, testVault, nil)
+	tests := []struct {
+		name       string
+		line, char int
+		want       string
+	}{
+		{"heading", 2, 6, `{"uri":"b.md","range":{"start":{"line":6,"character":3},"end":{"line":6,"character":11}}}`},
+		{"note", 2, 22, `{"uri":"b.md","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":0}}}`},
+		{"attachment", 2, 29, `{"uri":"pic.png","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":0}}}`},
+		{"markdown link", 2, 46, `{"uri":"c.md","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":0}}}`},
+		{"not a link", 2, 1, `null`},
+	}
+	for _, tt := range tests {
+		if got := send(t, s, "textDocument/definition", at(s, "a.md", tt.line, tt.char)); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	s := newTestServer(t, testVault, nil)
+	tests := []struct {
+		name               string
+		relPath            string
+		line, char         int
+		includeDeclaration bool
+		want               string
+	}{
+		{"note", "b.md", 4, 0, false,
+			`[{"uri":"a.md","range":{"start":{"line":2,"character":4},"end":{"line":2,"character":18}}},` +
+				`{"uri":"a.md","range":{"start":{"line":2,"character":20},"end":{"line":2,"character":25}}},` +
+				`{"uri":"c.md","range":{"start":{"line":4,"character":8},"end":{"line":4,"character":13}}},` +
+				`{"uri":"c.md","range":{"start":{"line":4,"character":18},"end":{"line":4,"character":32}}}]`},
+		{"heading", "b.md", 6, 5, true,
+			`[{"uri":"b.md","range":{"start":{"line":6,"character":3},"end":{"line":6,"character":11}}},` +
+				`{"uri":"a.md","range":{"start":{"line":2,"character":4},"end":{"line":2,"character":18}}},` +
+				`{"uri":"c.md","range":{"start":{"line":4,"character":18},"end":{"line":4,"character":32}}}]`},
+		{"by path", "dir/note.md", 0, 3, false,
+			`[{"uri":"dir/note.md","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":9}}}]`},
+	}
+	for _, tt := range tests {
+		params := referenceParams{textDocumentPositionParams: at(s, tt.relPath, tt.line, tt.char)}
+		params.Context.IncludeDeclaration = tt.includeDeclaration
+		if got := send(t, s, "textDocument/references", params); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"a.md":     "# A\n\n[[\n[[b#\n[[x|\n#t\n## \n",
+		"b.md":     "# B\n\n## Part\n\n#topic #tag\n",
+		"dir/b.md": "# Other\n",
+		"pic.png":  "image",
+	}, nil)
+	tests := []struct {
+		name       string
+		line, char int
+		want       []string
+	}{
+		{"notes and attachments", 2, 2, []string{"a", "b", "dir/b", "pic.png"}},
+		{"headings", 3, 4, []string{"B", "Part"}},
+		{"label", 4, 4, nil},
+		{"hashtags", 5, 2, []string{"tag", "topic"}},
+		{"heading, not a hashtag", 6, 3, nil},
+	}
+	for _, tt := range tests {
+		var list completionList
+		if err := json.Unmarshal([]byte(send(t, s, "textDocument/completion", at(s, "a.md", tt.line, tt.char))), &list); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, item := range list.Items {
+			got = append(got, item.Label)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	s := newTestServer(t, testVault, nil)
+	tests := []struct {
+		name       string
+		line, char int
+		want       string
+	}{
+		{"heading", 2, 6, "`b.md`\n\n---\n\n## Part two\n\nTwo.\n\n### Deeper\n\nThree."},
+		{"note without front matter", 2, 46, "`c.md`\n\n---\n\nBack to [[b]] and [[b#part-two]]. #topic"},
+		{"attachment", 2, 29, "Attachment `pic.png`"},
+	}
+	for _, tt := range tests {
+		var h hover
+		if err := json.Unmarshal([]byte(send(t, s, "textDocument/hover", at(s, "a.md", tt.line, tt.char))), &h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Contents.Value != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, h.Contents.Value, tt.want)
+		}
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	s := newTestServer(t, testVault, nil)
+	var symbols []documentSymbol
+	if err := json.Unmarshal([]byte(send(t, s, "textDocument/documentSymbol", at(s, "b.md", 0, 0))), &symbols); err != nil {
+		t.Fatal(err)
+	}
+	// As "name (first line-last line) [children]"
+	var tree func(symbols []documentSymbol) string
+	tree = func(symbols []documentSymbol) string {
+		var parts []string
+		for _, sym := range symbols {
+			part := fmt.Sprintf("%s (%d-%d)", sym.Name, sym.Range.Start.Line, sym.Range.End.Line)
+			if len(sym.Children) > 0 {
+				part += " [" + tree(sym.Children) + "]"
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, ", ")
+	}
+	want := "B (0-14) [Part one (2-6), Part two (6-14) [Deeper (10-14)]], After (14-15)"
+	if got := tree(symbols); got != want {
+		t.Errorf("got symbols %s, want %s", got, want)
+	}
+}
+
+func TestRename(t *testing.T) {
+	tests := []struct {
+		name       string
+		relPath    string
+		line, char int
+		newName    string
+		want       string
+	}{
+		{"heading", "b.md", 6, 5, "Second part",
+			`{"documentChanges":[` +
+				`{"textDocument":{"uri":"a.md","version":null},"edits":[{"range":{"start":{"line":2,"character":8},"end":{"line":2,"character":16}},"newText":"Second part"}]},` +
+				`{"textDocument":{"uri":"b.md","version":null},"edits":[{"range":{"start":{"line":6,"character":3},"end":{"line":6,"character":11}},"newText":"Second part"}]},` +
+				`{"textDocument":{"uri":"c.md","version":null},"edits":[{"range":{"start":{"line":4,"character":22},"end":{"line":4,"character":30}},"newText":"second-part"}]}]}`},
+		{"note from a link", "c.md", 4, 11, "bee",
+			`{"documentChanges":[` +
+				`{"textDocument":{"uri":"a.md","version":null},"edits":[{"range":{"start":{"line":0,"character":0},"end":{"line":5,"character":0}},"newText":"# A\n\nSee [[bee#Part two]], [[bee]], ![[pic.png]] and [c](c.md).\n\n#tag\n"}]},` +
+				`{"textDocument":{"uri":"c.md","version":null},"edits":[{"range":{"start":{"line":0,"character":0},"end":{"line":5,"character":0}},"newText":"---\ntitle: C\n---\n\nBack to [[bee]] and [[bee#part-two]]. #topic\n"}]},` +
+				`{"kind":"rename","oldUri":"b.md","newUri":"bee.md"}]}`},
+		{"same name", "b.md", 0, 3, "B", `{"documentChanges":[]}`},
+		{"attachment", "a.md", 2, 29, "other.png", "error: can't rename attachments; rename pic.png on disk instead"},
+		{"taken name", "c.md", 4, 11, "../a", "error: can't move b.md to a.md: it exists"},
+		{"nothing", "b.md", 4, 0, "x", "error: nothing to rename here; put the cursor on a link or heading"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, testVault, nil)
+		params := renameParams{textDocumentPositionParams: at(s, tt.relPath, tt.line, tt.char), NewName: tt.newName}
+		if got := send(t, s, "textDocument/rename", params); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		// Nothing changes until the client applies the edit
+		if s.vault.Note("b.md") == nil {
+			t.Errorf("%s: b.md is gone from the vault", tt.name)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestServer(t, testVault, &out)
+	var params didOpenParams
+	params.TextDocument.URI = pathURI(s.vault.Abs("new.md"))
+	params.TextDocument.Version = 3
+	params.TextDocument.Text = "[[missing]] [[b#Nowhere]]\n\n> [!Tip]- Folded\n\n> [!bogus]Glued\n"
+	send(t, s, "textDocument/didOpen", params)
+
+	msgs := readMessages(t, out.Bytes())
+	if len(msgs) != 1 || msgs[0].Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("got messages %+v, want diagnostics", msgs)
+	}
+	var published publishDiagnosticsParams
+	if err := json.Unmarshal(msgs[0].Params, &published); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range published.Diagnostics {
+		got = append(got, fmt.Sprintf("%d:%d-%d:%d %s: %s", d.Range.Start.Line, d.Range.Start.Character, d.Range.End.Line, d.Range.End.Character, d.Code, d.Message))
+	}
+	want := []string{
+		"0:0-0:11 broken-link: Broken link to missing: no such note or attachment",
+		"0:12-0:25 broken-link: Broken link to b#Nowhere: no such heading in b.md",
+		`2:4-2:7 callout: callout type "Tip" isn't lowercase, so it looks like a note; use "tip"`,
+		"2:8-2:9 callout: callouts can't be folded; the fold marker, and any title after it, is dropped",
+		`4:4-4:9 callout: unknown callout type "bogus"; it looks like a note`,
+		"4:10-4:15 callout: no space between the callout type and its title; the title is lost",
+	}
+	if !slices.Equal(got, want) || *published.Version != 3 {
+		t.Errorf("got diagnostics for version %d\n%s\nwant\n%s", *published.Version, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Open documents are what the vault has
+	if note := s.vault.Note("new.md"); note == nil || !strings.HasPrefix(string(note.Source), "[[missing]]") {
+		t.Errorf("got %v for the open document", note)
+	}
+	send(t, s, "textDocument/didClose", map[string]any{"textDocument": map[string]string{"uri": params.TextDocument.URI}})
+	if note := s.vault.Note("new.md"); note != nil {
+		t.Errorf("the closed document is still in the vault, though it isn't on disk")
+	}
+}
+
+func TestPositions(t *testing.T) {
+	source := []byte("a\n😀é x\n")
+	tests := []struct {
+		encoding string
+		offset   int
+		pos      position
+	}{
+		{"utf-16", 0, position{0, 0}},
+		{"utf-16", 2, position{1, 0}},
+		{"utf-16", 6, position{1, 2}},
+		{"utf-16", 9, position{1, 4}},
+		{"utf-8", 9, position{1, 7}},
+		{"utf-8", len(source), position{2, 0}},
+	}
+	for _, tt := range tests {
+		s := &server{encoding: tt.encoding}
+		if got := s.position(source, tt.offset); got != tt.pos {
+			t.Errorf("%s: offset %d: got %v, want %v", tt.encoding, tt.offset, got, tt.pos)
+		}
+		if got := s.offset(source, tt.pos); got != tt.offset {
+			t.Errorf("%s: %v: got offset %d, want %d", tt.encoding, tt.pos, got, tt.offset)
+		}
+	}
+	// Past the end of the line
+	if got := (&server{encoding: "utf-16"}).offset(source, position{0, 10}); got != 1 {
+		t.Errorf("got offset %d past the end of the first line, want 1", got)
+	}
+}
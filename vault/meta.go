@@ -0,0 +1,97 @@
+package vault
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Date layouts accepted in front matter, on top of whatever the YAML or TOML
+// decoder already turns into a time.Time.
+var metaTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Return the first of `keys` in the front matter that holds a date.
+func metaTime(meta map[string]any, keys ...string) time.Time {
+	for _, key := range keys {
+		switch value := meta[key].(type) {
+		case nil:
+			continue
+		case time.Time:
+			return value
+		default:
+			s := strings.TrimSpace(fmt.Sprint(value))
+			for _, layout := range metaTimeLayouts {
+				if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+					return t
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// Report whether the first of `keys` in the front matter that's set is true.
+func metaBool(meta map[string]any, keys ...string) bool {
+	for _, key := range keys {
+		switch value := meta[key].(type) {
+		case nil:
+			continue
+		case bool:
+			return value
+		default:
+			switch strings.ToLower(strings.TrimSpace(fmt.Sprint(value))) {
+			case "true", "yes", "on", "1":
+				return true
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// Return the first of `keys` in the front matter that's set, as a list of
+// strings: either a list, or a string of values separated by runes for which
+// `isSep` is true. Values are trimmed of whitespace and of leading `cutset`,
+// and empty values and duplicates are left out.
+func metaList(meta map[string]any, isSep func(rune) bool, cutset string, keys ...string) []string {
+	for _, key := range keys {
+		var values []string
+		switch value := meta[key].(type) {
+		case nil:
+			continue
+		case []any:
+			for _, v := range value {
+				values = append(values, fmt.Sprint(v))
+			}
+		default:
+			values = strings.FieldsFunc(fmt.Sprint(value), isSep)
+		}
+		var result []string
+		for _, v := range values {
+			if v = strings.TrimLeft(strings.TrimSpace(v), cutset); v != "" && !slices.Contains(result, v) {
+				result = append(result, v)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// Tags in front matter: a list, or comma- or space-separated (tags: a, b).
+// Leading '#'es are dropped, so they can be written like hashtags.
+func metaTags(meta map[string]any, keys ...string) []string {
+	return metaList(meta, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }, "#", keys...)
+}
+
+// Names in front matter: a list, or comma-separated, as names have spaces.
+func metaNames(meta map[string]any, keys ...string) []string {
+	return metaList(meta, func(r rune) bool { return r == ',' }, "", keys...)
+}
@@ -0,0 +1,181 @@
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/flonle/mdbuddy/internal/atomicfile"
+
+	"github.com/hexops/gotextdiff"
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+)
+
+// The change as a unified diff with git's headers, including those for
+// renames, so that the diffs of several changes can be concatenated and fed
+// to git apply.
+func (c Change) Diff() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n", c.Path, c.NewPath)
+	if c.NewPath != c.Path {
+		fmt.Fprintf(&b, "rename from %s\nrename to %s\n", c.Path, c.NewPath)
+	}
+	if !bytes.Equal(c.Old, c.New) {
+		edits := myers.ComputeEdits(span.URIFromPath(c.Path), string(c.Old), string(c.New))
+		diff := gotextdiff.ToUnified("a/"+c.Path, "b/"+c.NewPath, string(c.Old), edits)
+		// ToUnified loses count of the unchanged lines between the edits of
+		// a hunk, so hunks after those start on the wrong line of the new file
+		offset := 0
+		for _, h := range diff.Hunks {
+			h.ToLine = h.FromLine + offset
+			for _, l := range h.Lines {
+				switch l.Kind {
+				case gotextdiff.Insert:
+					offset++
+				case gotextdiff.Delete:
+					offset--
+				}
+			}
+		}
+		fmt.Fprint(&b, diff)
+	}
+	return b.String()
+}
+
+// Write the migration to disk, all or nothing: the new content of every
+// changed note is written next to it first, and only when that all worked,
+// and nothing changed on disk since the plan was made, are the files moved
+// into place. If that fails halfway, the files that were already moved are
+// restored.
+func (p *Plan) Apply() error {
+	abs := func(relPath string) string { return filepath.Join(p.Root, filepath.FromSlash(relPath)) }
+
+	// Stage the new content
+	staged := map[string]string{}     // Path : temporary file with its new content
+	modes := map[string]fs.FileMode{} // Path : its permissions, for restore
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	for _, c := range p.Changes {
+		if err := p.check(c); err != nil {
+			return err
+		}
+		if c.New == nil {
+			continue
+		}
+		tmp, mode, err := stage(abs(c.Path), abs(c.NewPath), c.New)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", c.NewPath, err)
+		}
+		staged[c.Path] = tmp
+		modes[c.Path] = mode
+	}
+
+	// Move everything into place
+	var done []Change
+	for _, c := range p.Changes {
+		var err error
+		if tmp, ok := staged[c.Path]; ok {
+			err = os.Rename(tmp, abs(c.NewPath))
+			delete(staged, c.Path)
+			if err == nil && c.NewPath != c.Path {
+				err = os.Remove(abs(c.Path))
+			}
+		} else if err = os.MkdirAll(filepath.Dir(abs(c.NewPath)), 0o755); err == nil {
+			err = os.Rename(abs(c.Path), abs(c.NewPath))
+		}
+		if err != nil {
+			return errors.Join(fmt.Errorf("failed to migrate %s: %w", c.Path, err), p.restore(append(done, c), modes))
+		}
+		done = append(done, c)
+	}
+
+	// Folders that were renamed are empty now
+	for _, c := range p.Changes {
+		for dir := filepath.Dir(abs(c.Path)); dir != p.Root && strings.HasPrefix(dir, p.Root); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Check that `c` can be applied: the file is still as it was when the plan
+// was made, and it doesn't move onto another.
+func (p *Plan) check(c Change) error {
+	abs := filepath.Join(p.Root, filepath.FromSlash(c.Path))
+	if c.Old != nil {
+		current, err := os.ReadFile(abs)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, c.Old) {
+			return fmt.Errorf("%s changed since the migration was planned", c.Path)
+		}
+	}
+	if c.NewPath != c.Path {
+		if _, err := os.Stat(filepath.Join(p.Root, filepath.FromSlash(c.NewPath))); err == nil {
+			return fmt.Errorf("can't rename %s to %s: it exists", c.Path, c.NewPath)
+		}
+	}
+	return nil
+}
+
+// Write `content` to a hidden temporary file in the folder of `to`, which is
+// created if needed, with the permissions of `from`. Returns its path, and
+// those permissions.
+func stage(from, to string, content []byte) (string, fs.FileMode, error) {
+	info, err := os.Stat(from)
+	if err != nil {
+		return "", 0, err
+	}
+	mode := info.Mode().Perm()
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := atomicfile.Temp(filepath.Dir(to), ".mdbuddy-migrate-*", content, mode)
+	if err != nil {
+		return "", 0, err
+	}
+	return tmp, mode, nil
+}
+
+// Undo the changes in `done`, as far as they got. `modes` are the original
+// permissions of the files whose content changed, by path.
+func (p *Plan) restore(done []Change, modes map[string]fs.FileMode) error {
+	abs := func(relPath string) string { return filepath.Join(p.Root, filepath.FromSlash(relPath)) }
+	var errs []error
+	for _, c := range slices.Backward(done) {
+		if c.Old == nil {
+			if _, err := os.Stat(abs(c.NewPath)); err == nil {
+				errs = append(errs, os.Rename(abs(c.NewPath), abs(c.Path)))
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(abs(c.Path)), 0o755); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.WriteFile(abs(c.Path), c.Old, modes[c.Path]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, os.Chmod(abs(c.Path), modes[c.Path]))
+		if c.NewPath != c.Path {
+			os.Remove(abs(c.NewPath))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to restore the files migrated so far: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// How many applied plans a journal remembers.
+const journalSize = 20
+
+// A journal of applied plans, so that they can be undone: a folder of JSON
+// files, one per plan, with the content of every file before and after.
+type Journal struct {
+	Dir string
+}
+
+// The journal of the vault at `root`, in the user's cache folder, so that it
+// doesn't end up in the vault's git repository:
+// $XDG_CACHE_HOME/mdbuddy/journal/<hash of root>.
+func DefaultJournal(root string) (*Journal, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(root))
+	return &Journal{Dir: filepath.Join(dir, "mdbuddy", "journal", hex.EncodeToString(hash[:8]))}, nil
+}
+
+// A plan in a journal.
+type JournalEntry struct {
+	Time    time.Time       `json:"time"`
+	Command string          `json:"command"` // What applied it, e.g. "mv a.md b.md"
+	Root    string          `json:"root"`
+	Changes []journalChange `json:"changes"`
+
+	file string
+}
+
+type journalChange struct {
+	Path    string `json:"path"`
+	NewPath string `json:"newPath"`
+	Old     []byte `json:"old,omitempty"`
+	New     []byte `json:"new,omitempty"`
+}
+
+// Record the applied `p` in the journal, and forget the oldest entries.
+func (j *Journal) Record(p *Plan, command string) error {
+	entry := JournalEntry{Time: time.Now(), Command: command, Root: p.Root}
+	for _, c := range p.Changes {
+		entry.Changes = append(entry.Changes, journalChange{Path: c.Path, NewPath: c.NewPath, Old: c.Old, New: c.New})
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(j.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	name := filepath.Join(j.Dir, fmt.Sprintf("%d.json", entry.Time.UnixNano()))
+	if err := os.WriteFile(name, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	files, _ := j.files()
+	for _, old := range files[:max(len(files)-journalSize, 0)] {
+		os.Remove(old)
+	}
+	return nil
+}
+
+// The most recent entry in the journal. Returns an error wrapping
+// fs.ErrNotExist if there's none.
+func (j *Journal) Last() (*JournalEntry, error) {
+	files, err := j.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("nothing to undo: %w", fs.ErrNotExist)
+	}
+	last := files[len(files)-1]
+	b, err := os.ReadFile(last)
+	if err != nil {
+		return nil, err
+	}
+	entry := &JournalEntry{file: last}
+	if err := json.Unmarshal(b, entry); err != nil {
+		return nil, fmt.Errorf("invalid journal entry %s: %w", last, err)
+	}
+	return entry, nil
+}
+
+// The journal's entries, oldest first.
+func (j *Journal) files() ([]string, error) {
+	entries, err := os.ReadDir(j.Dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".json") {
+			files = append(files, filepath.Join(j.Dir, e.Name()))
+		}
+	}
+	// Same length, as long as the clock doesn't go back to before 2001
+	slices.Sort(files)
+	return files, nil
+}
+
+// The plan that undoes the entry: every file back where it was, with the
+// content it had. Applying it fails if anything changed since.
+func (e *JournalEntry) Undo() *Plan {
+	p := &Plan{Root: e.Root}
+	for _, c := range slices.Backward(e.Changes) {
+		p.Changes = append(p.Changes, Change{Path: c.NewPath, NewPath: c.Path, Old: c.New, New: c.Old})
+	}
+	return p
+}
+
+// Remove the entry from its journal, once it's undone.
+func (e *JournalEntry) Forget() error {
+	return os.Remove(e.file)
+}
@@ -0,0 +1,238 @@
+// Package migrate rewrites a vault to follow the rules todo.md lays down for
+// its syntax and structure: valid filenames, wikilinks between notes, one way
+// of writing callouts, and tags in the front matter.
+//
+// Every rule works on the AST of the notes, so that code blocks and the like
+// are left alone, and only replaces the bytes it has to: the rest of a note
+// stays as it was written. A migration is planned in memory first, so that it
+// can be previewed as a diff, and then applied as a whole.
+package migrate
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+type Rule string
+
+const (
+	// Rename files and folders to valid names: whitespace and other
+	// characters outside [0-9a-zA-Z-_.+] become '-', and markdown extensions
+	// become .md. Every link to them is rewritten.
+	Filenames Rule = "filenames"
+
+	// Turn markdown links to notes, [text](note.md), into wikilinks,
+	// [[note|text]].
+	Wikilinks Rule = "wikilinks"
+
+	// Write callouts as `> [!type] Title`: a lowercase type, which is what
+	// the renderer looks up, and no fold marker, which it doesn't support.
+	Callouts Rule = "callouts"
+
+	// Move `tags: a, b` lines to the front matter.
+	Tags Rule = "tags"
+)
+
+// All rules, in the order they're applied in.
+var Rules = []Rule{Filenames, Wikilinks, Callouts, Tags}
+
+// What a rule does, for humans.
+func (r Rule) Description() string {
+	switch r {
+	case Filenames:
+		return "Rename files and folders to valid names, and rewrite every link to them"
+	case Wikilinks:
+		return "Turn markdown links to notes, [text](note.md), into wikilinks, [[note|text]]"
+	case Callouts:
+		return "Write callouts as > [!type] Title, with a lowercase type and no fold marker"
+	case Tags:
+		return "Move tags: lines to the front matter"
+	}
+	return ""
+}
+
+// A file the migration changes.
+type Change struct {
+	Path    string `json:"path"`    // Vault-relative path of the file before the migration
+	NewPath string `json:"newPath"` // And after; the same as Path if it isn't renamed
+	Rules   []Rule `json:"rules"`   // The rules that changed it, in the order they were applied
+
+	// The content before and after the migration. Nil for attachments, which
+	// are only ever renamed.
+	Old []byte `json:"-"`
+	New []byte `json:"-"`
+}
+
+// Something a rule should have changed, but left alone.
+type Skipped struct {
+	Rule   Rule   `json:"rule"`
+	Path   string `json:"path"` // Vault-relative path, after the rules before Rule
+	Line   int    `json:"line,omitempty"`
+	Col    int    `json:"col,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (s Skipped) String() string {
+	pos := s.Path
+	if s.Line > 0 {
+		pos = fmt.Sprintf("%s:%d:%d", s.Path, s.Line, s.Col)
+	}
+	return fmt.Sprintf("%s: %s [%s]", pos, s.Reason, s.Rule)
+}
+
+// A migration, ready to be previewed or applied.
+type Plan struct {
+	Root    string    // Absolute path of the vault
+	Changes []Change  // Sorted by Path
+	Skipped []Skipped // Sorted by rule, then path and position
+}
+
+// Plan the migration of `v` by `rules`, which are applied in the order of
+// Rules. Nothing is written to disk, but `v` itself is migrated: afterwards,
+// it holds the notes as they'd be after Apply.
+func New(v *vault.Vault, rules []Rule) *Plan {
+	m := newMigration(v)
+	for _, rule := range Rules {
+		if !slices.Contains(rules, rule) {
+			continue
+		}
+		switch rule {
+		case Filenames:
+			m.fixFilenames()
+		case Wikilinks:
+			m.fixWikilinks()
+		case Callouts:
+			m.fixCallouts()
+		case Tags:
+			m.fixTags()
+		}
+	}
+	return m.plan()
+}
+
+// The state of a migration while it's being planned.
+type migration struct {
+	vault   *vault.Vault
+	origin  map[string]string // Current path : path before the migration
+	sources map[string][]byte // Path before the migration : note source
+	rules   map[string][]Rule // Path before the migration : rules that changed it
+	skipped []Skipped
+}
+
+func newMigration(v *vault.Vault) *migration {
+	m := &migration{
+		vault:   v,
+		origin:  map[string]string{},
+		sources: map[string][]byte{},
+		rules:   map[string][]Rule{},
+	}
+	for _, file := range v.Files("") {
+		m.origin[file] = file
+		if note := v.Note(file); note != nil {
+			m.sources[file] = note.Source
+		}
+	}
+	return m
+}
+
+// A replacement of source[start:stop] by text.
+type edit struct {
+	start, stop int
+	text        string
+}
+
+// Apply `edits` to the note at `relPath` on behalf of `rule`. Insertions go
+// before replacements at the same offset, and edits that overlap an earlier
+// one are dropped.
+func (m *migration) rewrite(rule Rule, relPath string, edits []edit) {
+	note := m.vault.Note(relPath)
+	if note == nil || len(edits) == 0 {
+		return
+	}
+	slices.SortStableFunc(edits, func(a, b edit) int { return cmp.Or(cmp.Compare(a.start, b.start), cmp.Compare(a.stop, b.stop)) })
+
+	var buf bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		if e.start < pos {
+			continue
+		}
+		buf.Write(note.Source[pos:e.start])
+		buf.WriteString(e.text)
+		pos = e.stop
+	}
+	buf.Write(note.Source[pos:])
+	if bytes.Equal(buf.Bytes(), note.Source) {
+		return
+	}
+
+	m.vault.Add(vault.NewNote(relPath, buf.Bytes(), note.ModTime))
+	m.changed(rule, relPath)
+}
+
+// Move the note or attachment at `from` to `to`, on behalf of `rule`.
+// `converted` is the attachment at `from` read as a note, for markdown files
+// that become notes by the move, like "note.markdown"; nil otherwise.
+func (m *migration) rename(rule Rule, from, to string, converted *vault.Note) {
+	note := m.vault.Note(from)
+	if note == nil {
+		note = converted
+	}
+	m.vault.Remove(from)
+	if note != nil {
+		m.vault.Add(vault.NewNote(to, note.Source, note.ModTime))
+	} else {
+		m.vault.AddAttachment(to)
+	}
+	m.origin[to] = m.origin[from]
+	delete(m.origin, from)
+	m.changed(rule, to)
+}
+
+// Record that `rule` changed the file now at `relPath`.
+func (m *migration) changed(rule Rule, relPath string) {
+	origin := m.origin[relPath]
+	if !slices.Contains(m.rules[origin], rule) {
+		m.rules[origin] = append(m.rules[origin], rule)
+	}
+}
+
+// Record that `rule` left something in `note` alone.
+func (m *migration) skip(rule Rule, note *vault.Note, offset int, reason string) {
+	s := Skipped{Rule: rule, Path: note.Path, Reason: reason}
+	if offset >= 0 {
+		s.Line, s.Col = note.Position(offset)
+	}
+	m.skipped = append(m.skipped, s)
+}
+
+func (m *migration) plan() *Plan {
+	p := &Plan{Root: m.vault.Root, Skipped: m.skipped}
+	for current, origin := range m.origin {
+		if len(m.rules[origin]) == 0 {
+			continue
+		}
+		c := Change{Path: origin, NewPath: current, Rules: m.rules[origin]}
+		if note := m.vault.Note(current); note != nil {
+			c.Old, c.New = m.sources[origin], note.Source
+		}
+		if c.Path != c.NewPath || !bytes.Equal(c.Old, c.New) {
+			p.Changes = append(p.Changes, c)
+		}
+	}
+	slices.SortFunc(p.Changes, func(a, b Change) int { return strings.Compare(a.Path, b.Path) })
+	slices.SortStableFunc(p.Skipped, func(a, b Skipped) int {
+		return cmp.Or(
+			cmp.Compare(slices.Index(Rules, a.Rule), slices.Index(Rules, b.Rule)),
+			strings.Compare(a.Path, b.Path),
+			cmp.Compare(a.Line, b.Line),
+			cmp.Compare(a.Col, b.Col),
+		)
+	})
+	return p
+}
@@ -0,0 +1,119 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// What the changes of Move are recorded as. Not one of Rules, which every
+// vault should follow: moving is something a vault is asked to do.
+const Moved Rule = "move"
+
+type MoveOptions struct {
+	// Add the old name to the front matter aliases of notes whose name
+	// changes, so that wikilinks from outside the vault keep working too.
+	Alias bool
+}
+
+// Plan moving the note, attachment or folder at the vault-relative `from` to
+// `to`, and rewriting every link to what moves, and the relative links in it,
+// to match. Like mv, if `to` is a folder, `from` moves into it. Notes moved to
+// a path without .md get it, so that they stay notes.
+//
+// Like New, this migrates `v` in memory.
+func Move(v *vault.Vault, from, to string, opts MoveOptions) (*Plan, error) {
+	from = strings.Trim(path.Clean("/"+from), "/")
+	to = strings.Trim(path.Clean("/"+to), "/")
+	if from == "" {
+		return nil, fmt.Errorf("can't move the vault itself")
+	}
+
+	isFile := v.Note(from) != nil || v.HasAttachment(from)
+	files := []string{from}
+	if !isFile {
+		if files = v.Files(from); len(files) == 0 {
+			return nil, fmt.Errorf("no note, attachment or folder %s in the vault", from)
+		}
+	}
+	if info, err := os.Stat(v.Abs(to)); err == nil && info.IsDir() {
+		to = path.Join(to, path.Base(from))
+	}
+	if isFile && vault.IsNote(from) && !vault.IsNote(to) {
+		to += ".md"
+	}
+	if to == from {
+		return nil, fmt.Errorf("%s is at %s already", from, to)
+	}
+	if !isFile && strings.HasPrefix(to+"/", from+"/") {
+		return nil, fmt.Errorf("can't move %s into itself", from)
+	}
+
+	renames := map[string]string{}
+	for _, file := range files {
+		newPath := to
+		if !isFile {
+			newPath = to + strings.TrimPrefix(file, from)
+		}
+		if _, err := os.Stat(v.Abs(newPath)); err == nil {
+			return nil, fmt.Errorf("can't move %s to %s: it exists", file, newPath)
+		}
+		if note := v.Note(file); note != nil && !strings.EqualFold(path.Base(file), path.Base(newPath)) {
+			name := strings.TrimSuffix(path.Base(newPath), ".md")
+			for _, other := range v.NotesNamed(name) {
+				if !slices.Contains(files, other.Path) {
+					return nil, fmt.Errorf("can't move %s to %s: %s has that name already, and wikilinks couldn't tell them apart", file, newPath, other.Path)
+				}
+			}
+		}
+		renames[file] = newPath
+	}
+
+	m := newMigration(v)
+	m.renameFiles(Moved, renames)
+	if opts.Alias {
+		for file, newPath := range renames {
+			note := v.Note(newPath)
+			if note == nil || !vault.IsNote(file) || path.Base(file) == path.Base(newPath) {
+				continue
+			}
+			name := strings.TrimSuffix(path.Base(file), ".md")
+			if _, ok := note.Meta["aliases"]; ok {
+				m.skip(Moved, note, -1, fmt.Sprintf("the front matter has aliases already; add %q by hand", name))
+				continue
+			}
+			if _, ok := note.Meta["alias"]; ok {
+				m.skip(Moved, note, -1, fmt.Sprintf("the front matter has an alias already; add %q by hand", name))
+				continue
+			}
+			m.rewrite(Moved, newPath, []edit{frontMatterList(note.Source, "aliases", []string{name})})
+		}
+	}
+	p := m.plan()
+
+	// What the vault leaves out of a folder moves along with it, like .mdbuddy
+	// files
+	if !isFile {
+		err := filepath.WalkDir(v.Abs(from), func(absPath string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			relPath, _ := v.Rel(absPath)
+			if _, ok := renames[relPath]; !ok {
+				p.Changes = append(p.Changes, Change{Path: relPath, NewPath: to + strings.TrimPrefix(relPath, from), Rules: []Rule{Moved}})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		slices.SortFunc(p.Changes, func(a, b Change) int { return strings.Compare(a.Path, b.Path) })
+	}
+	return p, nil
+}
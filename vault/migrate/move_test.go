@@ -0,0 +1,179 @@
+package migrate
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The files under `root`, by slash-separated path : content.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		relPath, _ := filepath.Rel(root, p)
+		files[filepath.ToSlash(relPath)] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+var moveVault = map[string]string{
+	"a.md":         "[[b]] [[dir/c|C]] [b](b.md#x) ![pic](dir/pic.png) [[b#Heading]]\n",
+	"b.md":         "# B\n\n[a](a.md) [c](dir/c.md)\n",
+	"dir/c.md":     "[up](../a.md) [[b]] ![[pic.png]]\n",
+	"dir/pic.png":  "image",
+	"dir/.mdbuddy": "visibility: private\n",
+}
+
+func TestMove(t *testing.T) {
+	tests := []struct {
+		name, from, to string
+		opts           MoveOptions
+		want           map[string]string
+	}{
+		{"rename a note", "b.md", "new name", MoveOptions{}, map[string]string{
+			"a.md":        "[[new name]] [[dir/c|C]] [b](new%20name.md#x) ![pic](dir/pic.png) [[new name#Heading]]\n",
+			"new name.md": "# B\n\n[a](a.md) [c](dir/c.md)\n",
+			"dir/c.md":    "[up](../a.md) [[new name]] ![[pic.png]]\n",
+		}},
+		{"into a folder", "b.md", "dir", MoveOptions{}, map[string]string{
+			"a.md":     "[[b]] [[dir/c|C]] [b](dir/b.md#x) ![pic](dir/pic.png) [[b#Heading]]\n",
+			"dir/b.md": "# B\n\n[a](../a.md) [c](c.md)\n",
+		}},
+		{"with an alias", "b.md", "other.md", MoveOptions{Alias: true}, map[string]string{
+			"a.md":     "[[other]] [[dir/c|C]] [b](other.md#x) ![pic](dir/pic.png) [[other#Heading]]\n",
+			"other.md": "---\naliases: [b]\n---\n\n# B\n\n[a](a.md) [c](dir/c.md)\n",
+			"dir/c.md": "[up](../a.md) [[other]] ![[pic.png]]\n",
+		}},
+		{"an attachment", "dir/pic.png", "img/pic.png", MoveOptions{}, map[string]string{
+			"a.md":        "[[b]] [[dir/c|C]] [b](b.md#x) ![pic](img/pic.png) [[b#Heading]]\n",
+			"img/pic.png": "renamed from dir/pic.png",
+		}},
+		{"a folder", "dir", "sub/dir", MoveOptions{}, map[string]string{
+			"a.md":             "[[b]] [[sub/dir/c|C]] [b](b.md#x) ![pic](sub/dir/pic.png) [[b#Heading]]\n",
+			"b.md":             "# B\n\n[a](a.md) [c](sub/dir/c.md)\n",
+			"sub/dir/c.md":     "[up](../../a.md) [[b]] ![[pic.png]]\n",
+			"sub/dir/pic.png":  "renamed from dir/pic.png",
+			"sub/dir/.mdbuddy": "renamed from dir/.mdbuddy",
+		}},
+	}
+	for _, tt := range tests {
+		v := newTestVault(t, moveVault)
+		p, err := Move(v, tt.from, tt.to, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := changes(p); !maps.Equal(got, tt.want) {
+			t.Errorf("%s: got changes\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMoveErrors(t *testing.T) {
+	tests := []struct {
+		from, to, want string
+	}{
+		{"", "x", "can't move the vault itself"},
+		{"missing.md", "x.md", "no note, attachment or folder missing.md"},
+		{"b.md", "b", "b.md is at b.md already"},
+		{"dir", "dir/sub", "can't move dir into itself"},
+		{"b.md", "a.md", "can't move b.md to a.md: it exists"},
+		{"a.md", "other/c.md", "dir/c.md has that name already"},
+	}
+	for _, tt := range tests {
+		_, err := Move(newTestVault(t, moveVault), tt.from, tt.to, MoveOptions{})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s to %s: got error %v, want one containing %q", tt.from, tt.to, err, tt.want)
+		}
+	}
+}
+
+func TestMoveUndo(t *testing.T) {
+	v := newTestVault(t, moveVault)
+	before := readTree(t, v.Root)
+	j := &Journal{Dir: t.TempDir()}
+
+	p, err := Move(v, "dir", "sub/moved", MoveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Record(p, "mv dir sub/moved"); err != nil {
+		t.Fatal(err)
+	}
+	after := readTree(t, v.Root)
+	if _, err := os.Stat(filepath.Join(v.Root, "dir")); !os.IsNotExist(err) {
+		t.Errorf("the moved folder is still there: %v", err)
+	}
+	if after["sub/moved/c.md"] != "[up](../../a.md) [[b]] ![[pic.png]]\n" || after["sub/moved/.mdbuddy"] != before["dir/.mdbuddy"] {
+		t.Errorf("got files after the move\n%q", after)
+	}
+
+	entry, err := j.Last()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Command != "mv dir sub/moved" {
+		t.Errorf("got command %q", entry.Command)
+	}
+	if err := entry.Undo().Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTree(t, v.Root); !maps.Equal(got, before) {
+		t.Errorf("got files after undoing\n%q\nwant\n%q", got, before)
+	}
+	if _, err := os.Stat(filepath.Join(v.Root, "sub")); !os.IsNotExist(err) {
+		t.Errorf("the folder moved into is still there after undoing: %v", err)
+	}
+	if err := entry.Forget(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Last(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v after forgetting the only entry, want fs.ErrNotExist", err)
+	}
+}
+
+func TestUndoAfterEdits(t *testing.T) {
+	v := newTestVault(t, moveVault)
+	p, err := Move(v, "b.md", "c2.md", MoveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	j := &Journal{Dir: t.TempDir()}
+	if err := j.Record(p, "mv b.md c2.md"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(v.Root, "a.md"), []byte("edited since\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	moved := readTree(t, v.Root)
+
+	entry, err := j.Last()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Undo().Apply(); err == nil || !strings.Contains(err.Error(), "a.md changed since") {
+		t.Errorf("got error %v undoing over an edit, want one about a.md", err)
+	}
+	if got := readTree(t, v.Root); !maps.Equal(got, moved) {
+		t.Errorf("undoing over an edit changed files: got\n%q\nwant\n%q", got, moved)
+	}
+}
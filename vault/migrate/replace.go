@@ -0,0 +1,281 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	customExtensions "github.com/flonle/mdbuddy/renderer/goldmark-extensions"
+	"github.com/flonle/mdbuddy/vault"
+
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// What the changes of Replace are recorded as. Like Moved, not one of Rules.
+const Replaced Rule = "replace"
+
+// A kind of node Replace may change the text of.
+type Scope string
+
+const (
+	InText       Scope = "text"        // Text of paragraphs, lists, tables and block quotes
+	InHeadings   Scope = "headings"    // Text of headings
+	InLinkText   Scope = "link-text"   // Labels of links and wikilinks, and the alt text of images
+	InLinkTarget Scope = "link-target" // Destinations of links and images, and targets of wikilinks
+	InCode       Scope = "code"        // Code spans and code blocks
+)
+
+// All scopes, in the order they're documented in.
+var Scopes = []Scope{InText, InHeadings, InLinkText, InLinkTarget, InCode}
+
+type ReplaceOptions struct {
+	// Where to replace; only InText if empty.
+	Scopes []Scope
+
+	// Match the pattern as is, and insert the replacement as is, rather than
+	// as a regular expression and a template with $1 or ${name} for its
+	// submatches. Markup is escaped either way; see Replace.
+	Literal bool
+
+	// Match letters regardless of case.
+	IgnoreCase bool
+}
+
+// Plan replacing the matches of `pattern` with `replacement` in every note of
+// `v`, in the text of the nodes opts.Scopes selects only: everything else,
+// like front matter, raw HTML, math, hashtags, autolinked URLs and the markup
+// itself, is left alone. Matches don't cross from one node into the next,
+// like from one line of a paragraph to the next.
+//
+// In text, callout titles, headings and the labels of markdown links, the
+// characters of inline markup in `replacement`, like '*' and '[', are
+// backslash-escaped, so that the text reads as the replacement is written. Submatches are inserted
+// as they are in the source. Code, link targets and the labels of wikilinks
+// have no escapes, so they get the replacement as is, which may change what
+// they are: a '`' may end a code span, and a "|" a wikilink's target.
+//
+// Like New, this migrates `v` in memory.
+func Replace(v *vault.Vault, pattern, replacement string, opts ReplaceOptions) (*Plan, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	scopes := opts.Scopes
+	if len(scopes) == 0 {
+		scopes = []Scope{InText}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if opts.Literal {
+		pattern = regexp.QuoteMeta(pattern)
+		replacement = strings.ReplaceAll(replacement, "$", "$$")
+	}
+	if opts.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	escaped := escapeTemplate(replacement)
+
+	m := newMigration(v)
+	for _, note := range v.Notes() {
+		var edits []edit
+		for _, s := range scopeSegments(note, scopes) {
+			template := replacement
+			if s.escapes {
+				template = escaped
+			}
+			value := s.Value(note.Source)
+			for _, match := range re.FindAllSubmatchIndex(value, -1) {
+				replaced := re.Expand(nil, []byte(template), value, match)
+				edits = append(edits, edit{s.Start + match[0], s.Start + match[1], string(replaced)})
+			}
+		}
+		m.rewrite(Replaced, note.Path, edits)
+	}
+	return m.plan(), nil
+}
+
+// The characters of inline markup, which Replace escapes in text.
+const markupChars = "\\`*_[]<>&~$|#"
+
+// Backslash-escape the characters of markupChars in the replacement
+// `template`, but not in its $1 or ${name} references to submatches.
+func escapeTemplate(template string) string {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c == '$' && i+1 < len(template) {
+			// Copy references as they are; "$$" is a literal '$'
+			ref := i + 1
+			switch next := template[ref]; {
+			case next == '$':
+				ref++
+			case next == '{':
+				if end := strings.IndexByte(template[ref:], '}'); end >= 0 {
+					ref += end + 1
+				}
+			default:
+				for ref < len(template) && isNameChar(template[ref]) {
+					ref++
+				}
+			}
+			if ref > i+1 {
+				if template[i+1] == '$' {
+					b.WriteByte('\\')
+				}
+				b.WriteString(template[i:ref])
+				i = ref - 1
+				continue
+			}
+		}
+		if strings.IndexByte(markupChars, c) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Report whether `c` may be in the name of a submatch in a template, like
+// regexp.Regexp.Expand reads them.
+func isNameChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// A segment of the source that Replace replaces in.
+type scopeSegment struct {
+	text.Segment
+	escapes bool // Whether backslash escapes work in it, as in text
+}
+
+// The segments of the source of `note` that hold the text of nodes in
+// `scopes`, in order. Adjacent segments of the same scope are joined: goldmark
+// splits text at characters that could have been markup, like the '_'s of
+// "snake_case".
+func scopeSegments(note *vault.Note, scopes []Scope) []scopeSegment {
+	var segments []scopeSegment
+	last := Scope("")
+	add := func(scope Scope, s text.Segment, escapes bool) {
+		if !slices.Contains(scopes, scope) {
+			last = ""
+			return
+		}
+		if n := len(segments); n > 0 && last == scope && segments[n-1].Stop == s.Start {
+			segments[n-1].Stop = s.Stop
+		} else {
+			segments = append(segments, scopeSegment{s, escapes})
+		}
+		last = scope
+	}
+
+	ast.Walk(note.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			// Destinations come after the label in the source
+			switch n.(type) {
+			case *ast.Link, *ast.Image:
+				if span, ok := vault.FindLink(note.Source, n); ok {
+					add(InLinkTarget, span.Dest, false)
+				}
+			}
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			for i := range n.Lines().Len() {
+				add(InCode, n.Lines().At(i), false)
+			}
+			return ast.WalkSkipChildren, nil
+		case *wikilink.Node:
+			span, ok := vault.FindWikilink(note.Source, n)
+			if !ok {
+				last = ""
+				return ast.WalkSkipChildren, nil
+			}
+			target := span.Target
+			if span.Fragment.Len() > 0 {
+				target.Stop = span.Fragment.Stop
+			}
+			add(InLinkTarget, target, false)
+			if label, ok := n.FirstChild().(*ast.Text); ok && label.Segment.Start > 0 && note.Source[label.Segment.Start-1] == '|' {
+				add(InLinkText, label.Segment, false)
+			}
+			last = ""
+			return ast.WalkSkipChildren, nil
+		case *customExtensions.CalloutNode:
+			// Titles are text, though not in the inline nodes of the callout
+			if para, ok := n.FirstChild().(*ast.Paragraph); ok && para.Lines().Len() > 0 {
+				if match, ok := customExtensions.MatchCallout(note.Source, para.Lines().At(0)); ok && !match.Title.IsEmpty() {
+					add(InText, match.Title, true)
+				}
+			}
+			last = ""
+		case *ast.Text:
+			if scope, ok := textScope(n); ok && inLines(n) {
+				add(scope, n.Segment, scope != InCode)
+			} else {
+				last = ""
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return segments
+}
+
+// The scope of the text node `t`, by what it's in; false for text in nodes
+// Replace leaves alone.
+func textScope(t *ast.Text) (Scope, bool) {
+	scope := Scope("")
+	for n := t.Parent(); n != nil; n = n.Parent() {
+		switch n.(type) {
+		case *ast.CodeSpan:
+			scope = innermost(scope, InCode)
+		case *ast.Link, *ast.Image:
+			scope = innermost(scope, InLinkText)
+		case *ast.Heading:
+			scope = innermost(scope, InHeadings)
+		case *ast.Emphasis, *extast.Strikethrough:
+		default:
+			if n.Type() == ast.TypeInline {
+				return "", false // Hashtags, math and the like
+			}
+		}
+	}
+	return innermost(scope, InText), true
+}
+
+// `inner` if it's set, or else `outer`.
+func innermost(inner, outer Scope) Scope {
+	if inner != "" {
+		return inner
+	}
+	return outer
+}
+
+// Report whether the text node `t` is in the lines of its block. Text that
+// extensions make up, like the math extension after formulas at the end of a
+// paragraph, may point anywhere.
+func inLines(t *ast.Text) bool {
+	block := t.Parent()
+	for block != nil && block.Type() == ast.TypeInline {
+		block = block.Parent()
+	}
+	if block == nil {
+		return false
+	}
+	for i := range block.Lines().Len() {
+		if line := block.Lines().At(i); line.Start <= t.Segment.Start && t.Segment.Stop <= line.Stop {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package migrate
+
+import (
+	"testing"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// The source of the note at `relPath` after `p`, or before if `p` leaves it
+// alone.
+func newSource(p *Plan, v *vault.Vault, relPath string) string {
+	for _, c := range p.Changes {
+		if c.Path == relPath {
+			return string(c.New)
+		}
+	}
+	return string(v.Note(relPath).Source)
+}
+
+func TestReplace(t *testing.T) {
+	tests := []struct {
+		name, source, pattern, replacement string
+		opts                               ReplaceOptions
+		want                               string
+	}{
+		{"text", "# Old\n\nOld *old* text\n", "old", "new", ReplaceOptions{},
+			"# Old\n\nOld *new* text\n"},
+		{"ignore case", "Old old\n", "old", "new", ReplaceOptions{IgnoreCase: true},
+			"new new\n"},
+		{"escaped in text", "a b\n", "b", "*b* [c]", ReplaceOptions{},
+			"a \\*b\\* \\[c\\]\n"},
+		{"submatches as they are", "a_b c\n", `(\w+) c`, "$1 *", ReplaceOptions{},
+			"a_b \\*\n"},
+		{"literal", "a $1\n", "$1", "$2 *", ReplaceOptions{Literal: true},
+			"a \\$2 \\*\n"},
+		{"callout title", "> [!note] Old title\n> old body\n", "(?i)old", "*new*", ReplaceOptions{},
+			"> [!note] \\*new\\* title\n> \\*new\\* body\n"},
+		{"folded callout title", "> [!tip]- Old\n", "Old", "[new]", ReplaceOptions{},
+			"> [!tip]- \\[new\\]\n"},
+		{"callout type", "> [!note] A note\n", "note", "x", ReplaceOptions{},
+			"> [!note] A x\n"},
+		{"headings only", "# a\n\na\n", "a", "*", ReplaceOptions{Scopes: []Scope{InHeadings}},
+			"# \\*\n\na\n"},
+		{"code as is", "`a` a\n", "a", "`", ReplaceOptions{Scopes: []Scope{InCode}},
+			"``` a\n"},
+		{"wikilinks", "[[a|a]] and [a](a.md)\n", "a", "b", ReplaceOptions{Scopes: []Scope{InLinkTarget}},
+			"[[b|a]] and [a](b.md)\n"},
+		{"wikilink labels as they are", "[[a|a]]\n", "a", "*", ReplaceOptions{Scopes: []Scope{InLinkText}},
+			"[[a|*]]\n"},
+	}
+	for _, tt := range tests {
+		v := newTestVault(t, map[string]string{"note.md": tt.source})
+		p, err := Replace(v, tt.pattern, tt.replacement, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := newSource(p, v, "note.md"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeTemplate(t *testing.T) {
+	tests := []struct{ template, want string }{
+		{"plain", "plain"},
+		{"*a* _b_", "\\*a\\* \\_b\\_"},
+		{"$1 ${name} $name", "$1 ${name} $name"},
+		{"$$1", "\\$$1"},
+		{"a$", "a\\$"},
+		{"[$1](x)", "\\[$1\\](x)"},
+	}
+	for _, tt := range tests {
+		if got := escapeTemplate(tt.template); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package migrate
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	customExtensions "github.com/flonle/mdbuddy/renderer/goldmark-extensions"
+	"github.com/flonle/mdbuddy/vault"
+	"github.com/flonle/mdbuddy/vault/site"
+
+	"github.com/yuin/goldmark/ast"
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// Extensions markdown files are commonly given instead of .md.
+var markdownExtensions = []string{".markdown", ".mdown", ".mkd", ".mkdn", ".mdwn", ".mdtxt", ".mdtext"}
+
+// Rename files and folders with invalid names.
+func (m *migration) fixFilenames() {
+	files := m.vault.Files("")
+	taken := map[string]bool{} // nameKey of every file
+	paths := map[string]bool{} // Lowercase path of every file
+	for _, file := range files {
+		taken[nameKey(file)] = true
+		paths[strings.ToLower(file)] = true
+	}
+
+	renames := map[string]string{}
+	for _, file := range files {
+		fixed := fixPath(file)
+		if fixed == file {
+			continue
+		}
+		to := fixed
+		for i := 2; (taken[nameKey(to)] && nameKey(to) != nameKey(file)) || (paths[strings.ToLower(to)] && !strings.EqualFold(to, file)); i++ {
+			ext := path.Ext(fixed)
+			to = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(fixed, ext), i, ext)
+		}
+		taken[nameKey(to)] = true
+		paths[strings.ToLower(to)] = true
+		renames[file] = to
+	}
+	m.renameFiles(Filenames, renames)
+}
+
+// Rename files, file : new path, on behalf of `rule`, and rewrite the links to
+// them and the relative links in them.
+func (m *migration) renameFiles(rule Rule, renames map[string]string) {
+	if len(renames) == 0 {
+		return
+	}
+
+	// Markdown files that become notes by their rename are read as notes, so
+	// that their links are rewritten too, and the rules after this one see
+	// them as notes
+	converted := map[string]*vault.Note{}
+	for from, to := range renames {
+		if !vault.IsNote(to) || vault.IsNote(from) {
+			continue
+		}
+		source, err := os.ReadFile(m.vault.Abs(from))
+		if err != nil {
+			m.skipped = append(m.skipped, Skipped{Rule: rule, Path: from, Reason: "couldn't read it to migrate it as a note: " + err.Error()})
+			continue
+		}
+		converted[from] = vault.NewNote(from, source, time.Time{})
+		m.sources[m.origin[from]] = source
+	}
+
+	// Rewrite links while the vault still has the old names to resolve them
+	edits := map[string][]edit{}
+	for _, note := range append(m.vault.Notes(), slices.Collect(maps.Values(converted))...) {
+		edits[cmp.Or(renames[note.Path], note.Path)] = m.renameLinks(rule, note, renames)
+	}
+	for _, from := range slices.Sorted(maps.Keys(renames)) {
+		m.rename(rule, from, renames[from], converted[from])
+	}
+	for relPath, e := range edits {
+		m.rewrite(rule, relPath, e)
+	}
+}
+
+// The edits to the links in `note` for `renames`: links to renamed files, and
+// relative links in notes that move to another folder.
+func (m *migration) renameLinks(rule Rule, note *vault.Note, renames map[string]string) []edit {
+	l := site.NewVaultLinker(m.vault, note)
+	newPath := cmp.Or(renames[note.Path], note.Path)
+	byName := map[string]string{} // nameKey of renamed markdown files : their path before
+	for from, to := range renames {
+		if vault.IsNote(to) && !vault.IsNote(from) {
+			byName[nameKey(to)] = from
+		}
+	}
+	moved := path.Dir(newPath) != path.Dir(note.Path)
+
+	var edits []edit
+	ast.Walk(note.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *wikilink.Node:
+			link := vault.Link{Target: string(n.Target), Fragment: string(n.Fragment), Wiki: true, Embed: n.Embed}
+			target := resolve(l, link)
+			if target == "" && link.Target != "" {
+				// Links to markdown files that only become notes by their
+				// rename, like [[note]] for "note.markdown"
+				target = byName[nameKey(fixName(path.Base(link.Target)+".md", true))]
+			}
+			renamed, ok := renames[target]
+			if target == "" || (!ok && !(moved && strings.Contains(link.Target, "/"))) {
+				return ast.WalkContinue, nil
+			}
+			span, ok := vault.FindWikilink(note.Source, n)
+			if !ok {
+				m.skip(rule, note, firstOffset(n), "couldn't find the link to "+target+" to rewrite it")
+				return ast.WalkContinue, nil
+			}
+			// Wikilinks with a path have it from the vault root, for notes
+			newTarget := cmp.Or(renamed, target)
+			if !strings.Contains(link.Target, "/") {
+				newTarget = path.Base(newTarget)
+			}
+			if path.Ext(link.Target) == "" {
+				newTarget = strings.TrimSuffix(newTarget, ".md")
+			}
+			edits = append(edits, edit{span.Target.Start, span.Target.Stop, newTarget})
+
+		case *ast.Link, *ast.Image:
+			span, ok := vault.FindLink(note.Source, n)
+			if !ok {
+				return ast.WalkContinue, nil
+			}
+			dest := string(span.Dest.Value(note.Source))
+			link := vault.NewLink([]byte(dest), false, 0)
+			target := resolve(l, link)
+			renamed, ok := renames[target]
+			if target == "" || (!ok && !(moved && !strings.HasPrefix(link.Target, "/"))) {
+				return ast.WalkContinue, nil
+			}
+			newTarget := cmp.Or(renamed, target)
+			if path.Ext(link.Target) == "" {
+				newTarget = strings.TrimSuffix(newTarget, ".md")
+			}
+			if strings.HasPrefix(link.Target, "/") {
+				newTarget = "/" + newTarget
+			} else {
+				newTarget = relativePath(path.Dir(newPath), newTarget)
+			}
+			newDest := (&url.URL{Path: newTarget}).EscapedPath()
+			if _, frag, ok := strings.Cut(dest, "#"); ok {
+				newDest += "#" + frag
+			}
+			edits = append(edits, edit{span.Dest.Start, span.Dest.Stop, newDest})
+		}
+		return ast.WalkContinue, nil
+	})
+	return edits
+}
+
+// The vault-relative path of the note or attachment `link` points to, or "".
+func resolve(l *site.Linker, link vault.Link) string {
+	if link.IsExternal() {
+		return ""
+	}
+	if note := l.ResolveNote(link); note != nil {
+		return note.Path
+	}
+	return l.ResolveAttachment(link)
+}
+
+var invalidRun = regexp.MustCompile(`[^0-9a-zA-Z\-_.+]+`)
+
+// Fix every part of the vault-relative `relPath`, see fixName.
+func fixPath(relPath string) string {
+	parts := strings.Split(relPath, "/")
+	for i, part := range parts {
+		parts[i] = fixName(part, i == len(parts)-1)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Fix a file or folder name: runs of characters that aren't allowed become a
+// single '-', and markdown extensions of files become .md.
+func fixName(name string, file bool) string {
+	ext := ""
+	if file {
+		ext = path.Ext(name)
+		name = strings.TrimSuffix(name, ext)
+		if slices.Contains(markdownExtensions, strings.ToLower(ext)) || strings.ToLower(ext) == ".md" {
+			ext = ".md"
+		}
+		ext = invalidRun.ReplaceAllString(ext, "")
+	}
+	fixed := strings.Trim(invalidRun.ReplaceAllString(name, "-"), "-")
+	if fixed == "" {
+		fixed = "untitled"
+	}
+	return fixed + ext
+}
+
+// The key files are considered the same by: their lowercase filename, without
+// .md for notes, which is how links find them.
+func nameKey(file string) string {
+	name := strings.ToLower(path.Base(file))
+	if vault.IsNote(file) {
+		return "note:" + strings.TrimSuffix(name, ".md")
+	}
+	return "file:" + name
+}
+
+// The path of `target` relative to the folder `dir`, both vault-relative.
+func relativePath(dir, target string) string {
+	if dir == "." {
+		return target
+	}
+	from := strings.Split(dir, "/")
+	to := strings.Split(target, "/")
+	i := 0
+	for i < len(from) && i < len(to)-1 && from[i] == to[i] {
+		i++
+	}
+	return strings.Repeat("../", len(from)-i) + strings.Join(to[i:], "/")
+}
+
+// Turn markdown links to notes into wikilinks. Links with a title or a label
+// wikilinks can't hold are left alone.
+func (m *migration) fixWikilinks() {
+	for _, note := range m.vault.Notes() {
+		l := site.NewVaultLinker(m.vault, note)
+		var edits []edit
+		ast.Walk(note.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			link, ok := n.(*ast.Link)
+			if !entering || !ok {
+				return ast.WalkContinue, nil
+			}
+			vl := vault.NewLink(link.Destination, false, 0)
+			if vl.IsExternal() || vl.Target == "" {
+				return ast.WalkContinue, nil
+			}
+			target := l.ResolveNote(vl)
+			if target == nil {
+				return ast.WalkContinue, nil
+			}
+
+			span, ok := vault.FindLink(note.Source, link)
+			switch label := string(span.Label.Value(note.Source)); {
+			case !ok:
+				m.skip(Wikilinks, note, firstOffset(link), "couldn't find the link to "+target.Path+" to rewrite it")
+			case span.Title:
+				m.skip(Wikilinks, note, span.Whole.Start, "link to "+target.Path+" has a title, which wikilinks can't have")
+			case strings.ContainsAny(label, "[]|\n") || !plainText(link):
+				m.skip(Wikilinks, note, span.Whole.Start, "link to "+target.Path+" has a label wikilinks can't hold")
+			default:
+				edits = append(edits, edit{span.Whole.Start, span.Whole.Stop, m.wikilink(target, vl.Fragment, label)})
+			}
+			return ast.WalkContinue, nil
+		})
+		m.rewrite(Wikilinks, note.Path, edits)
+	}
+}
+
+// Report whether `n` only holds text; wikilink labels can't be formatted.
+func plainText(n ast.Node) bool {
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if _, ok := c.(*ast.Text); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// A wikilink to `target`: by name if that's unambiguous, by path otherwise.
+// Heading IDs become the heading's text, which is what wikilinks use.
+func (m *migration) wikilink(target *vault.Note, fragment, label string) string {
+	name := target.Name()
+	if m.vault.Resolve(name) != target {
+		name = strings.TrimSuffix(target.Path, ".md")
+	}
+	if fragment != "" {
+		for _, h := range target.Headings {
+			if h.ID == fragment && !strings.ContainsAny(h.Text, "#|[]") {
+				fragment = h.Text
+				break
+			}
+		}
+		name += "#" + fragment
+	}
+	if label == name {
+		return "[[" + name + "]]"
+	}
+	return "[[" + name + "|" + label + "]]"
+}
+
+// Rewrite callouts' first lines to `[!type] Title`.
+func (m *migration) fixCallouts() {
+	for _, note := range m.vault.Notes() {
+		var edits []edit
+		ast.Walk(note.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			// Blockquotes too: of callouts next to each other, the renderer only
+			// converts the first
+			switch n.(type) {
+			case *customExtensions.CalloutNode, *ast.Blockquote:
+			default:
+				return ast.WalkContinue, nil
+			}
+			if !entering {
+				return ast.WalkContinue, nil
+			}
+			para, ok := n.FirstChild().(*ast.Paragraph)
+			if !ok || para.Lines().Len() == 0 {
+				return ast.WalkContinue, nil
+			}
+			line := para.Lines().At(0)
+			match, ok := customExtensions.MatchCallout(note.Source, line)
+			if !ok {
+				return ast.WalkContinue, nil
+			}
+			prefix := "[!" + strings.ToLower(string(match.Type.Value(note.Source))) + "]"
+			if !match.Title.IsEmpty() {
+				prefix += " "
+			}
+			if prefix != string(note.Source[line.Start:match.Title.Start]) {
+				edits = append(edits, edit{line.Start, match.Title.Start, prefix})
+			}
+			return ast.WalkContinue, nil
+		})
+		m.rewrite(Callouts, note.Path, edits)
+	}
+}
+
+// A line of tags, e.g. "tags: a, b" or "Tags:: #a #b" (Dataview style).
+var tagsLine = regexp.MustCompile(`(?i)^tags::?[ \t]*(.*?)\s*$`)
+
+// Move lines of tags at the top level of notes to their front matter.
+func (m *migration) fixTags() {
+	for _, note := range m.vault.Notes() {
+		var tags []string
+		var edits []edit
+		first := -1 // Offset of the first line of tags
+		for n := note.Doc.FirstChild(); n != nil; n = n.NextSibling() {
+			para, ok := n.(*ast.Paragraph)
+			if !ok {
+				continue
+			}
+			lines := para.Lines()
+			for i := range lines.Len() {
+				line := lines.At(i)
+				match := tagsLine.FindSubmatch(line.Value(note.Source))
+				if match == nil {
+					continue
+				}
+				if first < 0 {
+					first = line.Start
+				}
+				for _, tag := range strings.FieldsFunc(string(match[1]), func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+					if tag = strings.TrimLeft(tag, "#"); tag != "" && !slices.Contains(tags, tag) {
+						tags = append(tags, tag)
+					}
+				}
+				start, stop := wholeLine(note.Source, line.Start)
+				if lines.Len() == 1 {
+					// And the blank line after it, or else before it, so
+					// that no two are left
+					if next, nextStop := wholeLine(note.Source, stop); next < len(note.Source) && isBlank(note.Source[next:nextStop]) {
+						stop = nextStop
+					} else if start > 0 {
+						if prev, _ := wholeLine(note.Source, start-1); isBlank(note.Source[prev:start]) {
+							start = prev
+						}
+					}
+				}
+				edits = append(edits, edit{start, stop, ""})
+			}
+		}
+		if len(edits) == 0 {
+			continue
+		}
+		if _, ok := note.Meta["tags"]; ok {
+			m.skip(Tags, note, first, "the front matter has tags already; merge them by hand")
+			continue
+		}
+		m.rewrite(Tags, note.Path, append(edits, frontMatterList(note.Source, "tags", tags)))
+	}
+}
+
+// The start of the line `offset` is in, and the start of the next.
+func wholeLine(source []byte, offset int) (start, stop int) {
+	start = bytes.LastIndexByte(source[:offset], '\n') + 1
+	if i := bytes.IndexByte(source[offset:], '\n'); i >= 0 {
+		return start, offset + i + 1
+	}
+	return start, len(source)
+}
+
+func isBlank(line []byte) bool {
+	return len(bytes.TrimSpace(line)) == 0
+}
+
+var plainItem = regexp.MustCompile(`^[\p{L}\p{N}_][\p{L}\p{N}_/+.-]*$`)
+
+// The edit that adds `key` with `values` to the front matter of `source`, or
+// adds front matter with it. It goes at the top, which is safe in TOML too,
+// where the end may be in a [table].
+func frontMatterList(source []byte, key string, values []string) edit {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+
+	for _, delim := range []string{"---", "+++"} {
+		for _, newline := range []string{"\n", "\r\n"} {
+			open := delim + newline
+			if !bytes.HasPrefix(source, []byte(open)) {
+				continue
+			}
+			if delim == "+++" {
+				return edit{len(open), len(open), key + " = [" + strings.Join(quoted, ", ") + "]" + newline}
+			}
+			return edit{len(open), len(open), key + ": [" + strings.Join(yamlItems(values, quoted), ", ") + "]" + newline}
+		}
+	}
+	return edit{0, 0, "---\n" + key + ": [" + strings.Join(yamlItems(values, quoted), ", ") + "]\n---\n\n"}
+}
+
+// Values as YAML flow sequence items: as they are if that's safe, quoted if
+// not.
+func yamlItems(values, quoted []string) []string {
+	items := make([]string, len(values))
+	for i, value := range values {
+		items[i] = value
+		if !plainItem.MatchString(value) {
+			items[i] = quoted[i]
+		}
+	}
+	return items
+}
+
+// Return the source offset of the first text below `node`, like
+// vault.firstOffset.
+func firstOffset(node ast.Node) int {
+	offset := -1
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := n.(*ast.Text); ok && entering {
+			offset = t.Segment.Start
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return offset
+}
@@ -0,0 +1,126 @@
+package migrate
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// A vault in a temporary folder with the files in `files`, by slash-separated
+// path : content.
+func newTestVault(t *testing.T, files map[string]string) *vault.Vault {
+	t.Helper()
+	root := t.TempDir()
+	for relPath, content := range files {
+		abs := filepath.Join(root, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := vault.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// What `p` changes, by path after the migration : the new content, or
+// "renamed from <path>" for attachments.
+func changes(p *Plan) map[string]string {
+	got := map[string]string{}
+	for _, c := range p.Changes {
+		if c.New == nil {
+			got[c.NewPath] = "renamed from " + c.Path
+		} else {
+			got[c.NewPath] = string(c.New)
+		}
+	}
+	return got
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		files map[string]string
+		want  map[string]string
+	}{
+		{"filenames", Filenames, map[string]string{
+			"a.md":             "[[my note]] [[my note#Part|label]] ![pic](my%20dir/pic%201.png) [n](my%20note.md)\n",
+			"my note.md":       "# My note\n\n[a](a.md)\n",
+			"my dir/pic 1.png": "image",
+			"my dir/n.md":      "[up](../a.md) [[a]]\n",
+		}, map[string]string{
+			"a.md":             "[[my-note]] [[my-note#Part|label]] ![pic](my-dir/pic-1.png) [n](my-note.md)\n",
+			"my-note.md":       "# My note\n\n[a](a.md)\n",
+			"my-dir/pic-1.png": "renamed from my dir/pic 1.png",
+			"my-dir/n.md":      "[up](../a.md) [[a]]\n",
+		}},
+		{"filenames taken", Filenames, map[string]string{
+			"a b.md": "# 1\n",
+			"a-b.md": "# 2\n",
+			"A_B.md": "# 3\n",
+		}, map[string]string{"a-b-2.md": "# 1\n"}},
+		{"markdown extensions", Filenames, map[string]string{
+			"a.md":         "[[old]] [o](old.markdown)\n",
+			"old.markdown": "[a](a.md)\n",
+		}, map[string]string{
+			"a.md":   "[[old]] [o](old.md)\n",
+			"old.md": "[a](a.md)\n",
+		}},
+		{"wikilinks", Wikilinks, map[string]string{
+			"a.md":     "[b](b.md) [B](b.md#part-two) [b](dir/b.md) [*b*](b.md) [b](b.md \"title\") [x](https://x.org)\n",
+			"b.md":     "# B\n\n## Part two\n",
+			"dir/b.md": "# Other B\n",
+		}, map[string]string{
+			"a.md": "[[b]] [[b#Part two|B]] [[dir/b|b]] [*b*](b.md) [b](b.md \"title\") [x](https://x.org)\n",
+		}},
+		{"callouts", Callouts, map[string]string{
+			"a.md": "> [!NOTE] Title\n> body\n\n> [!Tip]- Folded\n\n> [!warning]+\n\n> [!note] Fine\n",
+		}, map[string]string{
+			"a.md": "> [!note] Title\n> body\n\n> [!tip] Folded\n\n> [!warning]\n\n> [!note] Fine\n",
+		}},
+		{"tags", Tags, map[string]string{
+			"a.md":      "# A\n\ntags: one, #two three\n\nText\n",
+			"b.md":      "---\ntitle: B\n---\n\nTags:: #x\n",
+			"c.md":      "+++\ntitle = \"C\"\n+++\n\ntags: \"y z\"\n",
+			"tagged.md": "---\ntags: [a]\n---\n\ntags: b\n",
+		}, map[string]string{
+			"a.md": "---\ntags: [one, two, three]\n---\n\n# A\n\nText\n",
+			"b.md": "---\ntags: [x]\ntitle: B\n---\n",
+			"c.md": "+++\ntags = [\"\\\"y\", \"z\\\"\"]\ntitle = \"C\"\n+++\n",
+		}},
+	}
+	for _, tt := range tests {
+		p := New(newTestVault(t, tt.files), []Rule{tt.rule})
+		if got := changes(p); !maps.Equal(got, tt.want) {
+			t.Errorf("%s: got changes\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRulesSkip(t *testing.T) {
+	v := newTestVault(t, map[string]string{
+		"a.md":      "[b](b.md \"title\")\n",
+		"b.md":      "# B\n",
+		"tagged.md": "---\ntags: [a]\n---\n\ntags: b\n",
+	})
+	var got []string
+	for _, s := range New(v, Rules).Skipped {
+		got = append(got, s.String())
+	}
+	want := []string{
+		"a.md:1:1: link to b.md has a title, which wikilinks can't have [wikilinks]",
+		"tagged.md:5:1: the front matter has tags already; merge them by hand [tags]",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got skipped %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,220 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/flonle/mdbuddy/renderer"
+	customExtensions "github.com/flonle/mdbuddy/renderer/goldmark-extensions"
+
+	"github.com/yuin/goldmark/ast"
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// A single markdown note, parsed once on load.
+type Note struct {
+	Path     string    // Slash-separated path relative to the vault root, e.g. "dir/note.md"
+	Source   []byte    // Raw markdown
+	ModTime  time.Time // Modification time of the file
+	Doc      ast.Node  // Goldmark AST of Source
+	Title    string    // Text of the first level 1 heading, or the filename
+	Tags     []string  // Front matter "tags", then the hashtags used in the note; deduplicated
+	Links    []Link    // Outgoing links, in order of appearance
+	Headings []Heading // All headings, in order of appearance
+
+	Meta    map[string]any // Front matter; nil if there is none
+	Date    time.Time      // Front matter "date" or "created"; zero if missing
+	Updated time.Time      // Front matter "updated", "modified" or "lastmod"; zero if missing
+	Aliases []string       // Front matter "aliases" or "alias": other names wikilinks may use
+	Draft   bool           // Front matter "draft"
+	Publish time.Time      // Front matter "publish"; zero if missing
+
+	// Front matter "visibility"; "" if missing. See Vault.Visibility for the
+	// visibility that applies.
+	Visibility Visibility
+}
+
+// An outgoing link from a note: either a [[wikilink]] or a regular markdown
+// link/image. External URLs are included too; see Link.IsExternal.
+type Link struct {
+	Target   string // "note" for [[note#Heading]], "../note.md" for [text](../note.md#heading)
+	Fragment string // "Heading" or "heading" in the examples above
+	Wiki     bool   // Whether this is a [[wikilink]]
+	Embed    bool   // Whether this is an embed (![[...]] or ![...](...))
+	Offset   int    // Byte offset in the source of (roughly) where the link starts
+}
+
+// Report whether the link points outside the vault (has a URL scheme).
+func (l Link) IsExternal() bool {
+	if l.Wiki {
+		return false
+	}
+	u, err := url.Parse(l.Target)
+	return err == nil && u.Scheme != ""
+}
+
+type Heading struct {
+	Level  int
+	Text   string
+	ID     string // As generated by parser.WithAutoHeadingID
+	Offset int    // Byte offset of the heading text in the source
+}
+
+// Parse `source` into a Note. Doesn't touch the filesystem.
+func NewNote(relPath string, source []byte, modTime time.Time) *Note {
+	note := &Note{
+		Path:    relPath,
+		Source:  source,
+		ModTime: modTime,
+		Doc:     renderer.Parse(source),
+	}
+	if doc, ok := note.Doc.(*ast.Document); ok {
+		note.Meta = doc.Meta()
+		note.Date = metaTime(note.Meta, "date", "created")
+		note.Updated = metaTime(note.Meta, "updated", "modified", "lastmod")
+		note.Draft = metaBool(note.Meta, "draft")
+		note.Publish = metaTime(note.Meta, "publish")
+		note.Tags = metaTags(note.Meta, "tags")
+		note.Aliases = metaNames(note.Meta, "aliases", "alias")
+		if visibility, ok := note.Meta["visibility"]; ok && visibility != nil {
+			note.Visibility = ParseVisibility(fmt.Sprint(visibility))
+		}
+	}
+	note.inspect()
+	if note.Title == "" {
+		note.Title = note.Name()
+	}
+	return note
+}
+
+// The note's filename without the .md extension.
+func (n *Note) Name() string {
+	return strings.TrimSuffix(path.Base(n.Path), ".md")
+}
+
+// The path the note is served at, e.g. "/dir/note" for "dir/note.md".
+func (n *Note) URL() string {
+	return (&url.URL{Path: "/" + strings.TrimSuffix(n.Path, ".md")}).EscapedPath()
+}
+
+// Report whether the note is published at `now`: it's not a draft, and not
+// scheduled to be published later.
+func (n *Note) IsPublished(now time.Time) bool {
+	return !n.Draft && !n.Publish.After(now)
+}
+
+// Return the 1-based line and column of the byte at `offset` in the source.
+func (n *Note) Position(offset int) (line, col int) {
+	offset = min(max(offset, 0), len(n.Source))
+	before := n.Source[:offset]
+	line = bytes.Count(before, []byte{'\n'}) + 1
+	col = offset - (bytes.LastIndexByte(before, '\n') + 1) + 1
+	return line, col
+}
+
+// Fill in the fields derived from the AST.
+func (n *Note) inspect() {
+	ast.Walk(n.Doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch node := node.(type) {
+		case *ast.Heading:
+			heading := Heading{
+				Level:  node.Level,
+				Text:   string(NodeText(n.Source, node)),
+				Offset: firstOffset(node),
+			}
+			if id, ok := node.AttributeString("id"); ok {
+				if id, ok := id.([]byte); ok {
+					heading.ID = string(id)
+				}
+			}
+			if heading.Level == 1 && n.Title == "" {
+				n.Title = heading.Text
+			}
+			n.Headings = append(n.Headings, heading)
+		case *wikilink.Node:
+			n.Links = append(n.Links, Link{
+				Target:   string(node.Target),
+				Fragment: string(node.Fragment),
+				Wiki:     true,
+				Embed:    node.Embed,
+				Offset:   firstOffset(node),
+			})
+		case *ast.Link:
+			n.Links = append(n.Links, NewLink(node.Destination, false, firstOffset(node)))
+		case *ast.Image:
+			n.Links = append(n.Links, NewLink(node.Destination, true, firstOffset(node)))
+		case *customExtensions.Hashtag:
+			tag := string(NodeText(n.Source, node))
+			if !slices.Contains(n.Tags, tag) {
+				n.Tags = append(n.Tags, tag)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// Create a Link from the destination of a markdown link or image.
+func NewLink(destination []byte, embed bool, offset int) Link {
+	target, fragment, _ := strings.Cut(string(destination), "#")
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	return Link{Target: target, Fragment: fragment, Embed: embed, Offset: offset}
+}
+
+// Return the concatenated text of all Text and String nodes below `node`.
+func NodeText(source []byte, node ast.Node) []byte {
+	var buf bytes.Buffer
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			buf.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			buf.Write(n.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return bytes.TrimSpace(buf.Bytes())
+}
+
+// Return the source offset of the first text below `node`, or of its first
+// line for block nodes. Inline nodes don't carry a position themselves.
+func firstOffset(node ast.Node) int {
+	if node.Type() == ast.TypeBlock && node.Lines().Len() > 0 {
+		return node.Lines().At(0).Start
+	}
+	offset := -1
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if t, ok := n.(*ast.Text); ok {
+			offset = t.Segment.Start
+			return ast.WalkStop, nil
+		}
+		if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+			offset = n.Lines().At(0).Start
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if offset < 0 && node.Parent() != nil {
+		return firstOffset(node.Parent())
+	}
+	return max(offset, 0)
+}
@@ -0,0 +1,370 @@
+// Package search implements an in-memory full-text index over the notes of a
+// vault. Notes are tokenised from their goldmark AST rather than from the raw
+// markdown, so that headings, body text and code can be weighted differently
+// and markup never ends up in the index.
+package search
+
+import (
+	"cmp"
+	"iter"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/flonle/mdbuddy/vault"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// How much a single occurrence of a term counts, depending on where it occurs.
+const (
+	weightHeading = 3.0
+	weightText    = 1.0
+	weightCode    = 0.5
+)
+
+// BM25 tuning parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Positions of consecutive blocks are this far apart, so phrases never match
+// across e.g. two paragraphs.
+const blockGap = 2
+
+// An inverted index of notes. Safe for concurrent use; notes can be added and
+// removed at any time, e.g. from the watcher.
+type Index struct {
+	docs     map[string]*document           // note path : document
+	postings map[string]map[string]*posting // term : note path : posting
+	totalLen int                            // Sum of all document lengths, for BM25
+	mx       sync.RWMutex                   // Protects all of the above
+}
+
+type document struct {
+	path   string
+	url    string
+	title  string
+	tags   []string
+	text   string   // Plain text of the note, for snippets
+	terms  []string // Distinct terms, to clean up postings on removal
+	length int      // Number of tokens
+}
+
+type posting struct {
+	weight    float64 // Sum of the weights of all occurrences
+	positions []int   // Token positions, for phrase queries
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     map[string]*document{},
+		postings: map[string]map[string]*posting{},
+	}
+}
+
+// Create an index containing all notes of the given vault.
+func IndexVault(v *vault.Vault) *Index {
+	ix := NewIndex()
+	for _, note := range v.Notes() {
+		ix.Add(note)
+	}
+	return ix
+}
+
+// Add a note to the index, replacing any previous version with the same path.
+func (ix *Index) Add(note *vault.Note) {
+	tokens, text := tokenizeNote(note)
+
+	doc := &document{
+		path:   note.Path,
+		url:    note.URL(),
+		title:  note.Title,
+		tags:   note.Tags,
+		text:   text,
+		length: len(tokens),
+	}
+	postings := map[string]*posting{}
+	for _, tok := range tokens {
+		p, ok := postings[tok.term]
+		if !ok {
+			p = &posting{}
+			postings[tok.term] = p
+			doc.terms = append(doc.terms, tok.term)
+		}
+		p.weight += tok.weight
+		p.positions = append(p.positions, tok.position)
+	}
+
+	ix.mx.Lock()
+	defer ix.mx.Unlock()
+	ix.remove(note.Path)
+	ix.docs[note.Path] = doc
+	ix.totalLen += doc.length
+	for term, p := range postings {
+		if ix.postings[term] == nil {
+			ix.postings[term] = map[string]*posting{}
+		}
+		ix.postings[term][note.Path] = p
+	}
+}
+
+// Remove the note at `path` from the index, if present.
+func (ix *Index) Remove(path string) {
+	ix.mx.Lock()
+	defer ix.mx.Unlock()
+	ix.remove(path)
+}
+
+// Caller must hold mx.
+func (ix *Index) remove(path string) {
+	doc, ok := ix.docs[path]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		delete(ix.postings[term], path)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	ix.totalLen -= doc.length
+	delete(ix.docs, path)
+}
+
+// Number of indexed notes.
+func (ix *Index) Len() int {
+	ix.mx.RLock()
+	defer ix.mx.RUnlock()
+	return len(ix.docs)
+}
+
+type Result struct {
+	Path    string   `json:"path"`
+	URL     string   `json:"url"`
+	Title   string   `json:"title"`
+	Tags    []string `json:"tags"`
+	Score   float64  `json:"score"`
+	Snippet string   `json:"snippet"`
+}
+
+// Run `query` against the index and return at most `limit` results, best
+// first. A limit <= 0 means no limit.
+func (ix *Index) Search(query Query, limit int) []Result {
+	ix.mx.RLock()
+	defer ix.mx.RUnlock()
+
+	highlight := query.highlighter()
+	var results []Result
+	for path := range ix.candidates(query) {
+		doc := ix.docs[path]
+		if !query.matchesFilters(doc) {
+			continue
+		}
+		score, ok := ix.score(query, path, doc)
+		if !ok {
+			continue
+		}
+		results = append(results, Result{
+			Path:    doc.path,
+			URL:     doc.url,
+			Title:   doc.title,
+			Tags:    doc.tags,
+			Score:   score,
+			Snippet: snippet(doc.text, highlight),
+		})
+	}
+
+	slices.SortFunc(results, func(a, b Result) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// The paths of the notes that have the rarest term of `query`, which every
+// match must have, or of all notes if the query has no terms.
+//
+// Caller must hold mx.
+func (ix *Index) candidates(query Query) iter.Seq[string] {
+	terms := slices.Clone(query.Terms)
+	for _, phrase := range query.Phrases {
+		terms = append(terms, phrase...)
+	}
+	if len(terms) == 0 {
+		return maps.Keys(ix.docs)
+	}
+	rarest := slices.MinFunc(terms, func(a, b string) int {
+		return cmp.Compare(len(ix.postings[a]), len(ix.postings[b]))
+	})
+	return maps.Keys(ix.postings[rarest])
+}
+
+// Score a single document. Every term and phrase of the query must match;
+// a query with only filters matches everything with a score of 0.
+//
+// Caller must hold mx.
+func (ix *Index) score(query Query, path string, doc *document) (float64, bool) {
+	var score float64
+	for _, term := range query.Terms {
+		p, ok := ix.postings[term][path]
+		if !ok {
+			return 0, false
+		}
+		score += ix.bm25(term, p.weight, doc)
+	}
+	for _, phrase := range query.Phrases {
+		if !ix.hasPhrase(path, phrase) {
+			return 0, false
+		}
+		for _, term := range phrase {
+			score += ix.bm25(term, ix.postings[term][path].weight, doc)
+		}
+	}
+	return score, true
+}
+
+// Caller must hold mx.
+func (ix *Index) bm25(term string, weight float64, doc *document) float64 {
+	n := float64(len(ix.docs))
+	df := float64(len(ix.postings[term]))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	avgLen := float64(ix.totalLen) / n
+	norm := bm25K1 * (1 - bm25B + bm25B*float64(doc.length)/max(avgLen, 1))
+	return idf * weight * (bm25K1 + 1) / (weight + norm)
+}
+
+// Report whether the terms of `phrase` occur consecutively in the document.
+//
+// Caller must hold mx.
+func (ix *Index) hasPhrase(path string, phrase []string) bool {
+	var postings []*posting
+	for _, term := range phrase {
+		p, ok := ix.postings[term][path]
+		if !ok {
+			return false
+		}
+		postings = append(postings, p)
+	}
+
+	for _, start := range postings[0].positions {
+		found := true
+		for i, p := range postings[1:] {
+			if _, ok := slices.BinarySearch(p.positions, start+i+1); !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+type token struct {
+	term     string
+	weight   float64
+	position int
+}
+
+// Tokenise a note's AST. Also returns the note's plain text.
+func tokenizeNote(note *vault.Note) ([]token, string) {
+	var tokens []token
+	var text strings.Builder
+	position := 0
+
+	add := func(value []byte, weight float64) {
+		text.Write(value)
+		for _, term := range Tokenize(string(value)) {
+			tokens = append(tokens, token{term, weight, position})
+			position++
+		}
+	}
+
+	var walk func(node ast.Node, weight float64)
+	walk = func(node ast.Node, weight float64) {
+		switch n := node.(type) {
+		case *ast.Heading:
+			weight = weightHeading
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			lines := n.Lines()
+			for i := range lines.Len() {
+				line := lines.At(i)
+				add(line.Value(note.Source), weightCode)
+			}
+			text.WriteByte(' ')
+			position += blockGap
+			return
+		case *ast.CodeSpan:
+			weight = weightCode
+		case *ast.Text:
+			add(n.Segment.Value(note.Source), weight)
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				text.WriteByte(' ')
+			}
+		case *ast.String:
+			add(n.Value, weight)
+		case *ast.AutoLink:
+			add(n.Label(note.Source), weight)
+		}
+
+		for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+			walk(child, weight)
+		}
+		if node.Type() == ast.TypeBlock {
+			text.WriteByte(' ')
+			position += blockGap
+		}
+	}
+	walk(note.Doc, weightText)
+
+	return tokens, strings.Join(strings.Fields(text.String()), " ")
+}
+
+// Split `s` into lowercase terms on anything that isn't a letter or digit.
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Return roughly `snippetLen` characters of `text` around the first match of
+// `terms`, or the start of `text` if there is none. `terms` may be nil.
+func snippet(text string, terms *regexp.Regexp) string {
+	const snippetLen = 160
+
+	// On `text` itself, not a lowercase copy: lowercasing can change its
+	// length, and with that the offsets
+	start := 0
+	if terms != nil {
+		if loc := terms.FindStringIndex(text); loc != nil {
+			start = max(utf8.RuneCountInString(text[:loc[0]])-snippetLen/4, 0)
+		}
+	}
+
+	runes := []rune(text)
+	end := min(start+snippetLen, len(runes))
+	s := string(runes[start:end])
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
@@ -0,0 +1,161 @@
+package search
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// An index of notes, by path : source.
+func newTestIndex(notes map[string]string) *Index {
+	ix := NewIndex()
+	for path, source := range notes {
+		ix.Add(vault.NewNote(path, []byte(source), time.Time{}))
+	}
+	return ix
+}
+
+// The paths of the results of `query`, sorted.
+func searchPaths(ix *Index, query string) []string {
+	var paths []string
+	for _, r := range ix.Search(ParseQuery(query), 0) {
+		paths = append(paths, r.Path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func TestSearch(t *testing.T) {
+	ix := newTestIndex(map[string]string{
+		"fox.md":        "# Foxes\n\nThe quick brown fox jumps over the lazy dog.\n",
+		"dog.md":        "# Dogs\n\nA brown dog, and a quick one. #animals\n",
+		"split.md":      "# Split\n\nThe end is quick\n\nbrown is the start.\n",
+		"code.md":       "# Code\n\n```go\nquick := brown()\n```\n",
+		"dir/nested.md": "# Nested\n\nQuick brown things. #animals\n",
+		"dir/other.md":  "# Other\n\nNothing to see here.\n",
+	})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`quick brown`, []string{"code.md", "dir/nested.md", "dog.md", "fox.md", "split.md"}},
+		{`QUICK`, []string{"code.md", "dir/nested.md", "dog.md", "fox.md", "split.md"}},
+		{`"quick brown"`, []string{"code.md", "dir/nested.md", "fox.md"}}, // Not across paragraphs
+		{`"brown quick"`, nil},
+		{`"brown fox" lazy`, []string{"fox.md"}},
+		{`quick lazy`, []string{"fox.md"}}, // Through the postings of the rarer term
+		{`"fox"`, []string{"fox.md"}},
+		{`tag:animals`, []string{"dir/nested.md", "dog.md"}},
+		{`tag:animals quick`, []string{"dir/nested.md", "dog.md"}},
+		{`path:dir/`, []string{"dir/nested.md", "dir/other.md"}},
+		{`path:/dir/ quick`, []string{"dir/nested.md"}},
+		{`path:*/o*.md`, []string{"dir/other.md"}},
+		{`path:dir/ path:fox`, []string{"dir/nested.md", "dir/other.md", "fox.md"}},
+		{`missing`, nil},
+		{`quick missing`, nil},
+	}
+	for _, tt := range tests {
+		if got := searchPaths(ix, tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	ix := newTestIndex(map[string]string{
+		"heading.md": "# Otter\n\nSomething else, for a while longer.\n",
+		"text.md":    "# Something\n\nElse, an otter for a while longer.\n",
+		"code.md":    "# Something\n\nElse, for a while longer.\n\n```\notter\n```\n",
+	})
+	var got []string
+	for _, r := range ix.Search(ParseQuery("otter"), 0) {
+		got = append(got, r.Path)
+	}
+	if want := []string{"heading.md", "text.md", "code.md"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want headings before text before code, %q", got, want)
+	}
+	if got := ix.Search(ParseQuery("otter"), 2); len(got) != 2 {
+		t.Errorf("got %d results with a limit of 2", len(got))
+	}
+}
+
+func TestIndexRemove(t *testing.T) {
+	ix := newTestIndex(map[string]string{
+		"a.md": "# A\n\nShared words, and apples.\n",
+		"b.md": "# B\n\nShared words, and bananas.\n",
+	})
+
+	ix.Remove("a.md")
+	ix.Remove("missing.md")
+	if ix.Len() != 1 {
+		t.Errorf("got %d notes after removing one of two", ix.Len())
+	}
+	if got := searchPaths(ix, "shared"); !slices.Equal(got, []string{"b.md"}) {
+		t.Errorf("shared: got %q, want only b.md", got)
+	}
+	if got := searchPaths(ix, "apples"); got != nil {
+		t.Errorf("apples: got %q after removing the note", got)
+	}
+	if _, ok := ix.postings["apples"]; ok {
+		t.Error("kept the postings of a term only the removed note had")
+	}
+
+	// Adding a note again replaces it
+	ix.Add(vault.NewNote("b.md", []byte("# B\n\nCherries now.\n"), time.Time{}))
+	if got := searchPaths(ix, "bananas"); got != nil {
+		t.Errorf("bananas: got %q after replacing the note", got)
+	}
+	if got := searchPaths(ix, "cherries"); !slices.Equal(got, []string{"b.md"}) {
+		t.Errorf("cherries: got %q, want b.md", got)
+	}
+	if ix.totalLen != ix.docs["b.md"].length {
+		t.Errorf("total length is %d, want that of the only note, %d", ix.totalLen, ix.docs["b.md"].length)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	q := ParseQuery(`Foo "Bar  baz" "one" tag:#Idea path:/notes/ qux-quux`)
+	want := Query{
+		Terms:   []string{"foo", "one", "qux", "quux"},
+		Phrases: [][]string{{"bar", "baz"}},
+		Tags:    []string{"Idea"},
+		Paths:   []string{"notes/"},
+	}
+	if !slices.Equal(q.Terms, want.Terms) || len(q.Phrases) != 1 || !slices.Equal(q.Phrases[0], want.Phrases[0]) ||
+		!slices.Equal(q.Tags, want.Tags) || !slices.Equal(q.Paths, want.Paths) {
+		t.Errorf("got %+v, want %+v", q, want)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("filler ", 30)
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+		want  string
+	}{
+		{"short", "A short note.", []string{"short"}, "A short note."},
+		{"no match", long + "end", []string{"missing"}, long[:160] + "…"},
+		{"case", long + "Target here", []string{"target"}, "…" + long[len(long)-40:] + "Target here"},
+		{"phrase", long + "the Quick brown fox", []string{"quick brown"}, "…" + long[len(long)-36:] + "the Quick brown fox"},
+		// "İ" lowercases to two bytes more, and "ẞ" to one byte less, which
+		// threw the offsets off when they were taken from the lowercase text
+		{"lowercase changes length", strings.Repeat("İẞ", 60) + "ünïcode target", []string{"target"},
+			"…" + strings.Repeat("İẞ", 16) + "ünïcode target"},
+	}
+	for _, tt := range tests {
+		got := snippet(tt.text, Query{Terms: tt.terms}.highlighter())
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: got invalid UTF-8 %q", tt.name, got)
+		}
+	}
+}
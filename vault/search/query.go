@@ -0,0 +1,108 @@
+package search
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A parsed search query. See ParseQuery for the syntax.
+type Query struct {
+	Terms   []string   // Terms that must all occur somewhere in the note
+	Phrases [][]string // Term sequences that must occur consecutively
+	Tags    []string   // Tags the note must have (without the '#')
+	Paths   []string   // Path prefixes or globs, the note must match at least one
+
+	// Leaves out notes for which it returns false. Not part of the syntax;
+	// callers set it to hide notes, e.g. drafts.
+	Filter func(path string) bool
+}
+
+// Parse a query string. Supported syntax:
+//
+//	foo bar          notes containing both "foo" and "bar"
+//	"foo bar"        notes containing the phrase "foo bar"
+//	tag:foo          notes tagged #foo
+//	path:dir/        notes whose path starts with "dir/"
+//	path:*/draft*.md notes whose path matches the glob
+func ParseQuery(s string) Query {
+	var q Query
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " \t\n")
+		if s == "" {
+			break
+		}
+
+		// Quoted phrase
+		if s[0] == '"' {
+			phrase, rest, _ := strings.Cut(s[1:], `"`)
+			s = rest
+			terms := Tokenize(phrase)
+			switch len(terms) {
+			case 0:
+			case 1:
+				q.Terms = append(q.Terms, terms[0])
+			default:
+				q.Phrases = append(q.Phrases, terms)
+			}
+			continue
+		}
+
+		word, rest, _ := strings.Cut(s, " ")
+		s = rest
+		switch {
+		case strings.HasPrefix(word, "tag:") && len(word) > len("tag:"):
+			q.Tags = append(q.Tags, strings.TrimPrefix(word[len("tag:"):], "#"))
+		case strings.HasPrefix(word, "path:") && len(word) > len("path:"):
+			q.Paths = append(q.Paths, strings.TrimPrefix(word[len("path:"):], "/"))
+		default:
+			q.Terms = append(q.Terms, Tokenize(word)...)
+		}
+	}
+	return q
+}
+
+// Report whether the query has nothing to search or filter for.
+func (q Query) IsEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && len(q.Tags) == 0 && len(q.Paths) == 0
+}
+
+func (q Query) matchesFilters(doc *document) bool {
+	if q.Filter != nil && !q.Filter(doc.path) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !slices.ContainsFunc(doc.tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return false
+		}
+	}
+	if len(q.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range q.Paths {
+		if strings.HasPrefix(doc.path, pattern) {
+			return true
+		}
+		if ok, _ := path.Match(pattern, doc.path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches the terms worth highlighting in a snippet, case-insensitively, or
+// nil if there are none.
+func (q Query) highlighter() *regexp.Regexp {
+	var quoted []string
+	for _, term := range q.Terms {
+		quoted = append(quoted, regexp.QuoteMeta(term))
+	}
+	for _, phrase := range q.Phrases {
+		quoted = append(quoted, regexp.QuoteMeta(strings.Join(phrase, " ")))
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
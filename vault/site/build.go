@@ -0,0 +1,407 @@
+package site
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/flonle/mdbuddy/assets"
+	"github.com/flonle/mdbuddy/internal/atomicfile"
+	"github.com/flonle/mdbuddy/renderer"
+	"github.com/flonle/mdbuddy/vault"
+)
+
+type BuildOptions struct {
+	Jobs  int  // Number of pages rendered in parallel; <= 0 means one per CPU
+	Force bool // Rebuild everything, even outputs the previous build made from the same inputs
+
+	// Include drafts and notes scheduled to be published later
+	Drafts bool
+
+	// URL the site will be hosted at, e.g. "https://example.com/notes". Feeds
+	// and the sitemap need absolute URLs, so they're only generated if set.
+	BaseURL string
+}
+
+type BuildResult struct {
+	Pages       int          // Number of HTML pages written
+	Attachments int          // Number of attachments copied
+	Unchanged   int          // Number of pages and attachments left as they were
+	Removed     int          // Number of stale outputs deleted
+	BrokenLinks []BrokenLink // Links to notes or attachments that don't exist
+}
+
+// A link to a note or attachment that doesn't exist.
+type BrokenLink struct {
+	Note   string // Path of the note containing the link
+	Line   int
+	Col    int
+	Target string
+}
+
+func (b BrokenLink) String() string {
+	return fmt.Sprintf("%s:%d:%d: broken link to %s", b.Note, b.Line, b.Col, b.Target)
+}
+
+// A file the build produces.
+type buildTarget struct {
+	file       string // Slash-separated path relative to the output directory
+	attachment bool
+	digest     digest
+	deps       []string
+	write      func(path string) error // Writes the file to `path`
+}
+
+// Render the whole vault into `outDir` as a static website: a page per note,
+// folder and tag, a 404 page, and a copy of every attachment a note links to.
+// The site is public, so private notes are left out, and unlisted notes get a
+// page but aren't listed anywhere; see vault.Visibility.
+// With a BaseURL, there's also an Atom and RSS feed for the whole vault and
+// for every tag, and a sitemap.
+// Links between pages are relative, so the output can be hosted anywhere;
+// see the package documentation for the URL layout.
+//
+// Builds are incremental: a manifest in `outDir` records what every output
+// was made from, and only outputs whose inputs or dependencies changed since
+// the previous build are written again. Outputs of notes that no longer exist
+// are deleted.
+//
+// Broken links don't fail the build; they're rendered as plain text and
+// listed in the result.
+func Build(v *vault.Vault, outDir string, opts BuildOptions) (*BuildResult, error) {
+	result := &BuildResult{}
+	prev := readManifest(outDir)
+	next := newManifest(assets.Hash())
+
+	for _, note := range v.Notes() {
+		next.Notes[note.Path] = hashBytes(note.Source)
+	}
+
+	targets, broken, err := buildTargets(v, next, opts)
+	if err != nil {
+		return result, err
+	}
+	result.BrokenLinks = broken
+
+	var jobs []func() error
+	for _, target := range targets {
+		digest := target.digest.String()
+		next.Outputs[target.file] = output{Digest: digest, Deps: target.deps}
+		if !opts.Force && prev.upToDate(next, outDir, target.file, digest) {
+			result.Unchanged++
+			continue
+		}
+
+		if target.attachment {
+			result.Attachments++
+		} else {
+			result.Pages++
+		}
+		jobs = append(jobs, func() error {
+			return target.write(filepath.Join(outDir, filepath.FromSlash(target.file)))
+		})
+	}
+	if err := runJobs(jobs, opts.Jobs); err != nil {
+		// Without a manifest update, the next build retries everything
+		return result, err
+	}
+
+	// Delete whatever the previous build made that this one didn't
+	for file := range prev.Outputs {
+		if _, ok := next.Outputs[file]; ok {
+			continue
+		}
+		if err := removeOutput(outDir, file); err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+
+	slices.SortFunc(result.BrokenLinks, func(a, b BrokenLink) int {
+		if a.Note != b.Note {
+			return strings.Compare(a.Note, b.Note)
+		}
+		return a.Line - b.Line
+	})
+	return result, next.write(outDir)
+}
+
+// Work out every file the build should produce, and what goes into it.
+// Records attachment hashes in `m`.
+func buildTargets(v *vault.Vault, m *manifest, opts BuildOptions) ([]buildTarget, []BrokenLink, error) {
+	var targets []buildTarget
+	var brokenLinks []BrokenLink
+	graph := NewGraph(v)
+	page := func(sitePath string, name string, page renderer.Page, deps []string, render func(*renderer.Page) error) buildTarget {
+		t := buildTarget{
+			file: path.Join(sitePath, "index.html"),
+			deps: deps,
+			write: func(path string) error {
+				if render != nil {
+					if err := render(&page); err != nil {
+						return err
+					}
+				}
+				return writePage(path, name, page)
+			},
+		}
+		t.digest.add(m.Assets, strconv.Itoa(renderer.RenderVersion), name)
+		t.digest.addPage(page)
+		return t
+	}
+	feedURL := func(l *Linker, tag string) string {
+		if opts.BaseURL == "" {
+			return ""
+		}
+		return l.URL(FeedPath(tag, Atom))
+	}
+
+	// Notes, and whatever they link to
+	attachments := map[string]struct{}{}
+	visible := &Linker{Vault: v, Drafts: opts.Drafts}
+	for _, note := range visible.ViewableNotes() {
+		l := NewLinker(v, note, true)
+		l.Drafts = opts.Drafts
+		broken, used := CheckLinks(l)
+		brokenLinks = append(brokenLinks, broken...)
+		for _, attachment := range used {
+			attachments[attachment] = struct{}{}
+		}
+
+		backlinks := graph.Backlinks(note.Path)
+		// Not the attachments: the page only links to them
+		deps := append([]string{note.Path}, graph.Links(note.Path)...)
+		deps = append(deps, backlinks...)
+
+		notePage := NotePage(l, note, backlinks)
+		notePage.Feed = feedURL(l, "")
+		t := page(NotePath(note), "note.html", notePage, deps, func(p *renderer.Page) error {
+			return RenderNote(l, note, p)
+		})
+		t.digest.add(note.Path, m.Notes[note.Path])
+		t.digest.addLinks(l, note)
+		targets = append(targets, t)
+	}
+
+	// Folders
+	for _, dir := range folders(v) {
+		if dir != "" && visible.CanView(v.Note(dir+".md")) {
+			continue // dir.md ends up at the same URL, and wins
+		}
+		l := &Linker{Vault: v, Relative: true, Base: FolderPath(dir), Drafts: opts.Drafts}
+		if p, ok := FolderPage(l, dir); ok {
+			p.Feed = feedURL(l, "")
+			targets = append(targets, page(FolderPath(dir), "list.html", p, nil, nil))
+		}
+	}
+
+	// Tags
+	tags := visible.Tags()
+	for tag, notes := range tags {
+		l := &Linker{Vault: v, Relative: true, Base: TagPath(tag), Drafts: opts.Drafts}
+		if p, ok := TagPage(l, tag); ok {
+			p.Feed = feedURL(l, tag)
+			var deps []string
+			for _, note := range notes {
+				deps = append(deps, note.Path)
+			}
+			targets = append(targets, page(TagPath(tag), "list.html", p, deps, nil))
+		}
+	}
+	if len(tags) > 0 {
+		l := &Linker{Vault: v, Relative: true, Base: "tags/", Drafts: opts.Drafts}
+		p := TagsPage(l)
+		p.Feed = feedURL(l, "")
+		targets = append(targets, page("tags/", "list.html", p, nil, nil))
+	}
+
+	// Feeds and the sitemap. They're cheap to generate, and their content is
+	// what decides whether they need to be written.
+	if opts.BaseURL != "" {
+		l := &Linker{Vault: v, BaseURL: opts.BaseURL, Drafts: opts.Drafts}
+		generated := map[string]func(io.Writer) error{
+			SitemapPath: func(w io.Writer) error { return WriteSitemap(w, l) },
+		}
+		for _, tag := range append([]string{""}, slices.Collect(maps.Keys(tags))...) {
+			for _, format := range []string{Atom, RSS} {
+				feed, ok := NewFeed(l, tag, format)
+				if ok {
+					generated[FeedPath(tag, format)] = func(w io.Writer) error { return feed.Write(w, format) }
+				}
+			}
+		}
+		for file, generate := range generated {
+			var buf bytes.Buffer
+			if err := generate(&buf); err != nil {
+				return nil, nil, fmt.Errorf("failed to generate %s: %w", file, err)
+			}
+			t := buildTarget{
+				file: file,
+				write: func(path string) error {
+					return writeFile(path, buf.Bytes())
+				},
+			}
+			t.digest.add(hashBytes(buf.Bytes()))
+			targets = append(targets, t)
+		}
+	}
+
+	// The 404 page can be shown at any URL, so relative links won't do
+	notFound := page("", "404.html", NotFoundPage(&Linker{Vault: v, Drafts: opts.Drafts}), nil, nil)
+	notFound.file = "404.html"
+	targets = append(targets, notFound)
+
+	// Attachments
+	for attachment := range attachments {
+		src := v.Abs(attachment)
+		b, err := os.ReadFile(src)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read attachment: %w", err)
+		}
+		m.Attachments[attachment] = hashBytes(b)
+
+		t := buildTarget{
+			file:       attachment,
+			attachment: true,
+			deps:       []string{attachment},
+			write: func(dst string) error {
+				return copyFile(src, dst)
+			},
+		}
+		t.digest.add(m.Attachments[attachment])
+		targets = append(targets, t)
+	}
+
+	return targets, brokenLinks, nil
+}
+
+// Check every link in the linker's note. Returns the links that don't
+// resolve, and the vault-relative paths of all attachments that do. Links to
+// notes the linker doesn't show aren't broken; they become plain text.
+func CheckLinks(l *Linker) (broken []BrokenLink, attachments []string) {
+	for _, link := range l.Note.Links {
+		if link.IsExternal() || l.IsHidden(link) {
+			continue
+		}
+		if _, ok := l.Resolve(link); !ok {
+			line, col := l.Note.Position(link.Offset)
+			broken = append(broken, BrokenLink{
+				Note:   l.Note.Path,
+				Line:   line,
+				Col:    col,
+				Target: link.Target + fragment(link.Fragment),
+			})
+			continue
+		}
+		if l.ResolveNote(link) == nil {
+			if attachment := l.ResolveAttachment(link); attachment != "" {
+				attachments = append(attachments, attachment)
+			}
+		}
+	}
+	return broken, attachments
+}
+
+// Run `jobs` on a pool of `n` workers and return all errors they produced.
+func runJobs(jobs []func() error, n int) error {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+
+	queue := make(chan func() error)
+	var errs []error
+	var errsMx sync.Mutex
+	var wg sync.WaitGroup
+	for range n {
+		wg.Go(func() {
+			for job := range queue {
+				if err := job(); err != nil {
+					errsMx.Lock()
+					errs = append(errs, err)
+					errsMx.Unlock()
+				}
+			}
+		})
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Every folder that (recursively) contains a note, including the root ("").
+func folders(v *vault.Vault) []string {
+	seen := map[string]struct{}{"": {}}
+	for _, note := range v.Notes() {
+		for dir := path.Dir(note.Path); dir != "."; dir = path.Dir(dir) {
+			seen[dir] = struct{}{}
+		}
+	}
+	dirs := make([]string, 0, len(seen))
+	for dir := range seen {
+		dirs = append(dirs, dir)
+	}
+	slices.Sort(dirs)
+	return dirs
+}
+
+// Render a page template to the file `name`.
+func writePage(name string, tmpl string, page renderer.Page) error {
+	var buf bytes.Buffer
+	if err := renderer.RenderPage(&buf, tmpl, page); err != nil {
+		return fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return writeFile(name, buf.Bytes())
+}
+
+// Delete the output `file`, and any directories that leaves empty. Fails for
+// files outside `outDir`: the manifest they come from may have been edited.
+func removeOutput(outDir string, file string) error {
+	if !filepath.IsLocal(filepath.FromSlash(file)) {
+		return fmt.Errorf("refusing to delete %q: the manifest lists it, but it's not in %s", file, outDir)
+	}
+	name := filepath.Join(outDir, filepath.FromSlash(file))
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(name); dir != filepath.Clean(outDir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break // Not empty
+		}
+	}
+	return nil
+}
+
+// Write `data` to the file `name`, so that an interrupted build can't leave
+// a half-written file behind for the next one to take as up to date.
+func writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	return atomicfile.Write(name, data, 0o644)
+}
+
+func copyFile(src string, dst string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(dst, b); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return nil
+}
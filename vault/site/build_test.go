@@ -0,0 +1,335 @@
+package site
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/flonle/mdbuddy/renderer"
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// Write the files in `files`, by slash-separated path : content, to `root`.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for relPath, content := range files {
+		abs := filepath.Join(root, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func openVault(t *testing.T, root string) *vault.Vault {
+	t.Helper()
+	v, err := vault.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func build(t *testing.T, root, outDir string, opts BuildOptions) *BuildResult {
+	t.Helper()
+	result, err := Build(openVault(t, root), outDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// The slash-separated paths of the files in `dir`, sorted.
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, p)
+		files = append(files, filepath.ToSlash(relPath))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	return files
+}
+
+var testVault = map[string]string{
+	"a.md":              "# A\n\nLinks to [[b]], ![](image.png), [[secret]] and [[missing]].\n",
+	"b.md":              "# B\n\nText. #tag\n",
+	"dir/c.md":          "# C\n",
+	"image.png":         "not really a PNG",
+	"unused.png":        "not linked to",
+	"private/.mdbuddy":  "visibility: private\n",
+	"private/secret.md": "# Secret\n",
+}
+
+func TestBuild(t *testing.T) {
+	root, outDir := t.TempDir(), t.TempDir()
+	writeFiles(t, root, testVault)
+
+	result := build(t, root, outDir, BuildOptions{})
+	want := []string{
+		manifestName,
+		"404.html",
+		"a/index.html",
+		"b/index.html",
+		"dir/c/index.html",
+		"dir/index.html",
+		"image.png",
+		"index.html",
+		"tags/index.html",
+		"tags/tag/index.html",
+	}
+	if got := listFiles(t, outDir); !slices.Equal(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+	if result.Pages != len(want)-2 || result.Attachments != 1 || result.Unchanged != 0 || result.Removed != 0 {
+		t.Errorf("got %+v, want %d pages and an attachment", result, len(want)-2)
+	}
+
+	var broken []string
+	for _, link := range result.BrokenLinks {
+		broken = append(broken, link.String())
+	}
+	if want := []string{"a.md:3:50: broken link to missing"}; !slices.Equal(broken, want) {
+		t.Errorf("got broken links %q, want %q", broken, want)
+	}
+
+	page, err := os.ReadFile(filepath.Join(outDir, "a", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(page), "secret/") || !strings.Contains(string(page), `href="../b/"`) {
+		t.Errorf("a/index.html should link to b relatively, and not to the private note:\n%s", page)
+	}
+}
+
+func TestBuildDrafts(t *testing.T) {
+	files := map[string]string{
+		"a.md":     "# A\n\nSee [[draft]] and [[later]]. #tag\n",
+		"draft.md": "---\ndraft: true\n---\n# Draft\n\nSecret plans. #tag\n",
+		"later.md": "---\npublish: 2999-01-01\n---\n# Later\n\n#later\n",
+	}
+	root := t.TempDir()
+	writeFiles(t, root, files)
+
+	outDir := t.TempDir()
+	result := build(t, root, outDir, BuildOptions{BaseURL: "https://example.com"})
+	want := []string{
+		manifestName,
+		"404.html",
+		"a/index.html",
+		"feed.atom",
+		"feed.rss",
+		"index.html",
+		"sitemap.xml",
+		"tags/index.html",
+		"tags/tag/feed.atom",
+		"tags/tag/feed.rss",
+		"tags/tag/index.html",
+	}
+	if got := listFiles(t, outDir); !slices.Equal(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+	if len(result.BrokenLinks) != 0 {
+		t.Errorf("links to drafts are plain text, not broken: %v", result.BrokenLinks)
+	}
+	for _, file := range want[1:] {
+		b, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := string(b)
+		if strings.Contains(page, "draft/") || strings.Contains(page, "later/") || strings.Contains(page, "Secret plans") {
+			t.Errorf("%s links to or shows a draft:\n%s", file, page)
+		}
+		if file == "a/index.html" && !strings.Contains(page, "draft") {
+			t.Errorf("%s left out the text of the link to the draft:\n%s", file, page)
+		}
+	}
+
+	// Unless they're asked for
+	outDir = t.TempDir()
+	build(t, root, outDir, BuildOptions{Drafts: true})
+	page, err := os.ReadFile(filepath.Join(outDir, "a", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), `href="../draft/"`) || !strings.Contains(string(page), `href="../later/"`) {
+		t.Errorf("a/index.html should link to the drafts when they're built:\n%s", page)
+	}
+}
+
+func TestBuildVisibility(t *testing.T) {
+	files := map[string]string{
+		"a.md":              "# A\n\nSee [[secret]] and [[hidden]]. #tag\n",
+		"private/.mdbuddy":  "visibility: private\n",
+		"private/secret.md": "# Secret\n\nLinks to [[a]]. #tag #secrettag\n",
+		"hidden.md":         "---\nvisibility: unlisted\n---\n# Unlisted Note\n\nLinks to [[a]]. #tag #unlistedtag\n",
+		// Fails closed
+		"typo/.mdbuddy": "visibility: publik\n",
+		"typo/typo.md":  "# Typo\n\nLinks to [[a]]. #tag\n",
+	}
+	root := t.TempDir()
+	writeFiles(t, root, files)
+
+	outDir := t.TempDir()
+	build(t, root, outDir, BuildOptions{BaseURL: "https://example.com"})
+	want := []string{
+		manifestName,
+		"404.html",
+		"a/index.html",
+		"feed.atom",
+		"feed.rss",
+		"hidden/index.html", // Unlisted notes can be viewed
+		"index.html",
+		"sitemap.xml",
+		"tags/index.html",
+		"tags/tag/feed.atom",
+		"tags/tag/feed.rss",
+		"tags/tag/index.html",
+	}
+	if got := listFiles(t, outDir); !slices.Equal(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+	for _, file := range want[1:] {
+		b, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := string(b)
+		for _, private := range []string{"Secret", "secret/", "secrettag", "Typo", "typo/"} {
+			if strings.Contains(page, private) {
+				t.Errorf("%s shows %q of a private note:\n%s", file, private, page)
+			}
+		}
+		// Not even as a backlink of a.md
+		if file != "hidden/index.html" && (strings.Contains(page, "Unlisted Note") || strings.Contains(page, "unlistedtag")) {
+			t.Errorf("%s lists the unlisted note:\n%s", file, page)
+		}
+	}
+
+	// What anonymous viewers of the server are shown is decided the same way
+	l := &Linker{Vault: openVault(t, root)}
+	var listed []string
+	for _, note := range l.Notes() {
+		listed = append(listed, note.Path)
+	}
+	if want := []string{"a.md"}; !slices.Equal(listed, want) {
+		t.Errorf("got listed notes %q, want %q", listed, want)
+	}
+	if tagged := l.Tags()["tag"]; len(tagged) != 1 || len(l.Tags()) != 1 {
+		t.Errorf("got tags %v, want only #tag of a.md", l.Tags())
+	}
+	a := l.Vault.Note("a.md")
+	backlinks := NewGraph(l.Vault).Backlinks("a.md")
+	if len(backlinks) != 3 {
+		t.Errorf("got backlinks %q in the graph, want all three", backlinks)
+	}
+	if nav := NotePage(l, a, backlinks).Data.([]renderer.NavLink); len(nav) != 0 {
+		t.Errorf("got backlinks %v on the page, want none that are shown", nav)
+	}
+	for _, relPath := range []string{"private/secret.md", "typo/typo.md"} {
+		if l.CanView(l.Vault.Note(relPath)) {
+			t.Errorf("%s can be viewed anonymously", relPath)
+		}
+	}
+	if l.LoggedIn = true; !l.Shows(l.Vault.Note("private/secret.md")) || !l.Shows(l.Vault.Note("hidden.md")) {
+		t.Error("logged-in users aren't shown every note")
+	}
+}
+
+func TestBuildIncremental(t *testing.T) {
+	root, outDir := t.TempDir(), t.TempDir()
+	writeFiles(t, root, testVault)
+	first := build(t, root, outDir, BuildOptions{})
+	built := first.Pages + first.Attachments
+
+	tests := []struct {
+		name   string
+		change func()
+		opts   BuildOptions
+		want   BuildResult
+	}{
+		{"nothing changed", func() {}, BuildOptions{},
+			BuildResult{Unchanged: built}},
+		{"forced", func() {}, BuildOptions{Force: true},
+			BuildResult{Pages: first.Pages, Attachments: 1}},
+		{"note changed", func() {
+			writeFiles(t, root, map[string]string{"dir/c.md": "# C\n\nNew text.\n"})
+		}, BuildOptions{}, BuildResult{Pages: 1, Unchanged: built - 1}},
+		// The folder's page lists it by its title
+		{"title changed", func() {
+			writeFiles(t, root, map[string]string{"dir/c.md": "# Renamed\n"})
+		}, BuildOptions{}, BuildResult{Pages: 2, Unchanged: built - 2}},
+		// a.md links to b.md, which has the only #tag
+		{"linked note changed", func() {
+			writeFiles(t, root, map[string]string{"b.md": "# B\n\nOther text. #tag\n"})
+		}, BuildOptions{}, BuildResult{Pages: 3, Unchanged: built - 3}},
+		// a.md links to image.png
+		{"attachment changed", func() {
+			writeFiles(t, root, map[string]string{"image.png": "a different image"})
+		}, BuildOptions{}, BuildResult{Attachments: 1, Unchanged: built - 1}},
+	}
+	for _, tt := range tests {
+		tt.change()
+		result := build(t, root, outDir, tt.opts)
+		result.BrokenLinks = nil
+		if !reflect.DeepEqual(*result, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *result, tt.want)
+		}
+	}
+}
+
+func TestBuildRemovesStaleOutputs(t *testing.T) {
+	for _, force := range []bool{false, true} {
+		root, outDir := t.TempDir(), t.TempDir()
+		writeFiles(t, root, testVault)
+		build(t, root, outDir, BuildOptions{})
+
+		if err := os.RemoveAll(filepath.Join(root, "dir")); err != nil {
+			t.Fatal(err)
+		}
+		result := build(t, root, outDir, BuildOptions{Force: force})
+		if result.Removed != 2 {
+			t.Errorf("force %t: removed %d outputs, want dir/c/index.html and dir/index.html", force, result.Removed)
+		}
+		if _, err := os.Stat(filepath.Join(outDir, "dir")); !os.IsNotExist(err) {
+			t.Errorf("force %t: kept the folder of removed outputs: %v", force, err)
+		}
+	}
+}
+
+func TestBuildKeepsFilesOutsideOutput(t *testing.T) {
+	root, outDir := t.TempDir(), t.TempDir()
+	writeFiles(t, root, testVault)
+	build(t, root, outDir, BuildOptions{})
+
+	// A manifest edited to list a file next to the output folder
+	outside := filepath.Join(filepath.Dir(outDir), filepath.Base(outDir)+"-outside.txt")
+	writeFiles(t, filepath.Dir(outside), map[string]string{filepath.Base(outside): "keep me"})
+	t.Cleanup(func() { os.Remove(outside) })
+	m := readManifest(outDir)
+	m.Outputs["../"+filepath.Base(outside)] = output{Digest: "edited"}
+	if err := m.write(outDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Build(openVault(t, root), outDir, BuildOptions{}); err == nil {
+		t.Error("got no error for a manifest listing a file outside the output folder")
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("deleted a file outside the output folder: %v", err)
+	}
+}
@@ -0,0 +1,296 @@
+package site
+
+import (
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/flonle/mdbuddy/vault"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// How many notes a feed lists.
+const feedLength = 20
+
+// How many characters of a note's first paragraph end up in its feed entry.
+const summaryLength = 300
+
+// Feed formats, named after the extension of the feed's file.
+const (
+	Atom = "atom"
+	RSS  = "rss"
+)
+
+// Site path of the feed of all notes, or of the notes tagged `tag` if it's
+// not empty. `format` is Atom or RSS.
+func FeedPath(tag string, format string) string {
+	if tag == "" {
+		return "feed." + format
+	}
+	return TagPath(tag) + "feed." + format
+}
+
+// Site path of the sitemap.
+const SitemapPath = "sitemap.xml"
+
+// A feed of the most recently created or updated notes.
+type Feed struct {
+	Title   string
+	URL     string // The page the feed belongs to
+	Self    string // The feed itself
+	Author  string
+	Updated time.Time // Of the most recently updated entry
+	Entries []FeedEntry
+}
+
+type FeedEntry struct {
+	Title     string
+	URL       string
+	Summary   string // Start of the note's first paragraph
+	Published time.Time
+	Updated   time.Time
+}
+
+// The feed of all notes, or of the notes tagged `tag` if it's not empty.
+// Reports false if there's no such tag. `l` should produce absolute URLs
+// with a BaseURL, since feeds are read outside the site.
+func NewFeed(l *Linker, tag string, format string) (Feed, bool) {
+	notes := l.Notes()
+	name := filepath.Base(l.Vault.Root)
+	title, sitePath := name, ""
+	if tag != "" {
+		notes = l.Tags()[strings.ToLower(tag)]
+		if len(notes) == 0 {
+			return Feed{}, false
+		}
+		title, sitePath = title+" #"+strings.ToLower(tag), TagPath(tag)
+	}
+
+	feed := Feed{
+		Title:  title,
+		URL:    l.URL(sitePath),
+		Self:   l.URL(FeedPath(tag, format)),
+		Author: name,
+	}
+	for _, note := range notes {
+		created, updated := l.Vault.Dates(note)
+		feed.Entries = append(feed.Entries, FeedEntry{
+			Title:     note.Title,
+			URL:       l.NoteURL(note),
+			Summary:   summary(note),
+			Published: created,
+			Updated:   updated,
+		})
+	}
+	slices.SortFunc(feed.Entries, func(a, b FeedEntry) int {
+		return cmp.Or(b.Updated.Compare(a.Updated), strings.Compare(a.URL, b.URL))
+	})
+	feed.Entries = feed.Entries[:min(len(feed.Entries), feedLength)]
+	if len(feed.Entries) > 0 {
+		feed.Updated = feed.Entries[0].Updated
+	}
+	return feed, true
+}
+
+// Write the feed in the given format.
+func (f Feed) Write(w io.Writer, format string) error {
+	switch format {
+	case Atom:
+		return writeXML(w, f.atom())
+	case RSS:
+		return writeXML(w, f.rss())
+	}
+	return fmt.Errorf("unknown feed format: %s", format)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string   `xml:"title"`
+	ID        string   `xml:"id"`
+	Link      atomLink `xml:"link"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Summary   string   `xml:"summary,omitempty"`
+}
+
+func (f Feed) atom() atomFeed {
+	feed := atomFeed{
+		Title:   f.Title,
+		ID:      f.URL,
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Href: f.URL}, {Rel: "self", Href: f.Self}},
+		Author:  atomAuthor{Name: f.Author},
+	}
+	for _, entry := range f.Entries {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     entry.Title,
+			ID:        entry.URL,
+			Link:      atomLink{Href: entry.URL},
+			Published: entry.Published.UTC().Format(time.RFC3339),
+			Updated:   entry.Updated.UTC().Format(time.RFC3339),
+			Summary:   entry.Summary,
+		})
+	}
+	return feed
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (f Feed) rss() rssFeed {
+	channel := rssChannel{
+		Title:       f.Title,
+		Link:        f.URL,
+		Description: "Recently created or updated notes in " + f.Title,
+	}
+	if !f.Updated.IsZero() {
+		channel.LastBuildDate = f.Updated.Format(time.RFC1123Z)
+	}
+	for _, entry := range f.Entries {
+		// RSS has no notion of updates, so updated notes show up as new
+		// items with the same GUID.
+		channel.Items = append(channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.URL,
+			GUID:        rssGUID{IsPermaLink: true, Value: entry.URL},
+			PubDate:     entry.Updated.Format(time.RFC1123Z),
+			Description: entry.Summary,
+		})
+	}
+	return rssFeed{Version: "2.0", Channel: channel}
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Write a sitemap listing every note, folder and tag page, with the date
+// their notes were last updated. `l` should produce absolute URLs with a
+// BaseURL.
+func WriteSitemap(w io.Writer, l *Linker) error {
+	lastMod := map[string]time.Time{} // site path : most recent update of a note on the page
+	touch := func(sitePath string, t time.Time) {
+		if t.After(lastMod[sitePath]) {
+			lastMod[sitePath] = t
+		}
+	}
+
+	for _, note := range l.Notes() {
+		_, updated := l.Vault.Dates(note)
+		touch(NotePath(note), updated)
+		for dir := path.Dir(note.Path); ; dir = path.Dir(dir) {
+			// A folder's page is replaced by the note with the same name
+			if dir == "." || !l.Shows(l.Vault.Note(dir+".md")) {
+				touch(FolderPath(dir), updated)
+			}
+			if dir == "." {
+				break
+			}
+		}
+		for _, tag := range note.Tags {
+			touch(TagPath(tag), updated)
+			touch("tags/", updated)
+		}
+	}
+
+	sitePaths := make([]string, 0, len(lastMod))
+	for sitePath := range lastMod {
+		sitePaths = append(sitePaths, sitePath)
+	}
+	slices.Sort(sitePaths)
+
+	var urlSet sitemapURLSet
+	for _, sitePath := range sitePaths {
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{
+			Loc:     l.URL(sitePath),
+			LastMod: lastMod[sitePath].UTC().Format(time.RFC3339),
+		})
+	}
+	return writeXML(w, urlSet)
+}
+
+func writeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// The start of the first paragraph of `note`, as plain text.
+func summary(note *vault.Note) string {
+	var text string
+	ast.Walk(note.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if p, ok := n.(*ast.Paragraph); ok && entering {
+			text = string(vault.NodeText(note.Source, p))
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if utf8.RuneCountInString(text) <= summaryLength {
+		return text
+	}
+	runes := []rune(text)[:summaryLength]
+	return strings.TrimSpace(string(runes)) + "…"
+}
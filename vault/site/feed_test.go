@@ -0,0 +1,168 @@
+package site
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+const testBaseURL = "https://example.com/notes"
+
+// A vault of 22 notes, n01.md to n22.md, updated a day apart in that order,
+// and some that feeds leave out. The first three are tagged #tagged.
+func feedVault(t *testing.T) *Linker {
+	t.Helper()
+	files := map[string]string{
+		"draft.md":          "---\ndraft: true\nupdated: 2024-03-01\n---\n# Draft\n",
+		"later.md":          "---\npublish: 2999-01-01\nupdated: 2024-03-01\n---\n# Later\n",
+		"private/.mdbuddy":  "visibility: private\n",
+		"private/secret.md": "---\nupdated: 2024-03-01\n---\n# Secret\n",
+		// Created when it was updated
+		"n01.md": "---\nupdated: 2024-02-01\n---\n# Note 01\n\nThe first paragraph. #tagged\n\nThe second.\n",
+	}
+	for i := 2; i <= 22; i++ {
+		source := fmt.Sprintf("---\ndate: 2024-01-%02d\nupdated: 2024-02-%02d\n---\n# Note %02d\n", i, i, i)
+		if i <= 3 {
+			source += "\n#tagged\n"
+		}
+		files[fmt.Sprintf("n%02d.md", i)] = source
+	}
+	root := t.TempDir()
+	writeFiles(t, root, files)
+	return &Linker{Vault: openVault(t, root), BaseURL: testBaseURL}
+}
+
+// Write `feed` in `format`, and parse it back into `v`.
+func roundTrip(t *testing.T, feed Feed, format string, v any) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := feed.Write(&buf, format); err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(buf.Bytes(), v); err != nil {
+		t.Fatalf("%s feed isn't valid XML: %v\n%s", format, err, buf.Bytes())
+	}
+}
+
+func TestFeed(t *testing.T) {
+	l := feedVault(t)
+	feed, ok := NewFeed(l, "", Atom)
+	if !ok {
+		t.Fatal("no feed of all notes")
+	}
+
+	var atom atomFeed
+	roundTrip(t, feed, Atom, &atom)
+	if atom.ID != testBaseURL+"/" || atom.Updated != "2024-02-22T00:00:00Z" {
+		t.Errorf("got feed ID %q updated %q, want %q updated at the latest entry", atom.ID, atom.Updated, testBaseURL+"/")
+	}
+	if want := []atomLink{{Href: testBaseURL + "/"}, {Rel: "self", Href: testBaseURL + "/feed.atom"}}; !slices.Equal(atom.Links, want) {
+		t.Errorf("got links %+v, want %+v", atom.Links, want)
+	}
+	// The 20 most recently updated, leaving out drafts, notes published
+	// later and private notes
+	if len(atom.Entries) != feedLength {
+		t.Fatalf("got %d entries, want %d", len(atom.Entries), feedLength)
+	}
+	for i, entry := range atom.Entries {
+		n := 22 - i
+		want := atomEntry{
+			Title:     fmt.Sprintf("Note %02d", n),
+			ID:        fmt.Sprintf("%s/n%02d", testBaseURL, n),
+			Link:      atomLink{Href: fmt.Sprintf("%s/n%02d", testBaseURL, n)},
+			Published: fmt.Sprintf("2024-01-%02dT00:00:00Z", n),
+			Updated:   fmt.Sprintf("2024-02-%02dT00:00:00Z", n),
+		}
+		if n <= 3 {
+			want.Summary = "tagged" // The text of the tag
+		}
+		if entry != want {
+			t.Errorf("entry %d: got %+v, want %+v", i, entry, want)
+		}
+	}
+
+	var rss rssFeed
+	roundTrip(t, feed, RSS, &rss)
+	channel := rss.Channel
+	if channel.Link != testBaseURL+"/" || channel.LastBuildDate != "Thu, 22 Feb 2024 00:00:00 +0000" || len(channel.Items) != feedLength {
+		t.Errorf("got RSS channel link %q, last built %q, with %d items", channel.Link, channel.LastBuildDate, len(channel.Items))
+	}
+	want := rssItem{
+		Title:   "Note 22",
+		Link:    testBaseURL + "/n22",
+		GUID:    rssGUID{IsPermaLink: true, Value: testBaseURL + "/n22"},
+		PubDate: "Thu, 22 Feb 2024 00:00:00 +0000",
+	}
+	if len(channel.Items) > 0 && channel.Items[0] != want {
+		t.Errorf("got first item %+v, want %+v", channel.Items[0], want)
+	}
+}
+
+func TestTagFeed(t *testing.T) {
+	l := feedVault(t)
+	if _, ok := NewFeed(l, "missing", Atom); ok {
+		t.Error("got a feed for a tag no note has")
+	}
+	feed, ok := NewFeed(l, "Tagged", Atom)
+	if !ok {
+		t.Fatal("no feed of #tagged")
+	}
+
+	var atom atomFeed
+	roundTrip(t, feed, Atom, &atom)
+	if atom.ID != testBaseURL+"/tags/tagged" || atom.Links[1].Href != testBaseURL+"/tags/tagged/feed.atom" {
+		t.Errorf("got feed ID %q and links %+v, want the tag's page and feed", atom.ID, atom.Links)
+	}
+	var got []string
+	for _, entry := range atom.Entries {
+		got = append(got, entry.Title+" "+entry.Published+" "+entry.Updated+" "+entry.Summary)
+	}
+	want := []string{
+		"Note 03 2024-01-03T00:00:00Z 2024-02-03T00:00:00Z tagged",
+		"Note 02 2024-01-02T00:00:00Z 2024-02-02T00:00:00Z tagged",
+		"Note 01 2024-02-01T00:00:00Z 2024-02-01T00:00:00Z The first paragraph. tagged",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got entries\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	l := feedVault(t)
+	var buf bytes.Buffer
+	if err := WriteSitemap(&buf, l); err != nil {
+		t.Fatal(err)
+	}
+	var urlSet sitemapURLSet
+	if err := xml.Unmarshal(buf.Bytes(), &urlSet); err != nil {
+		t.Fatalf("sitemap isn't valid XML: %v\n%s", err, buf.Bytes())
+	}
+
+	got := map[string]string{}
+	for _, u := range urlSet.URLs {
+		got[u.Loc] = u.LastMod
+	}
+	want := map[string]string{
+		testBaseURL + "/":            "2024-02-22T00:00:00Z",
+		testBaseURL + "/tags":        "2024-02-03T00:00:00Z",
+		testBaseURL + "/tags/tagged": "2024-02-03T00:00:00Z",
+	}
+	for i := 1; i <= 22; i++ {
+		want[fmt.Sprintf("%s/n%02d", testBaseURL, i)] = fmt.Sprintf("2024-02-%02dT00:00:00Z", i)
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d URLs, want %d:\n%s", len(got), len(want), buf.Bytes())
+	}
+	for loc, lastMod := range want {
+		if got[loc] != lastMod {
+			t.Errorf("%s: got last modified %q, want %q", loc, got[loc], lastMod)
+		}
+	}
+	for _, hidden := range []string{"/draft", "/later", "/private", "/private/secret"} {
+		if _, ok := got[testBaseURL+hidden]; ok {
+			t.Errorf("sitemap lists %s", hidden)
+		}
+	}
+}
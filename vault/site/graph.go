@@ -0,0 +1,47 @@
+package site
+
+import (
+	"slices"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// The links between the notes of a vault, in both directions. Links to
+// attachments and to notes that don't exist are left out. Drafts and private
+// notes are included; leaving them out is up to whoever shows the links.
+type Graph struct {
+	links     map[string][]string // note path : paths of the notes it links to or embeds
+	backlinks map[string][]string // note path : paths of the notes linking to it
+}
+
+func NewGraph(v *vault.Vault) *Graph {
+	g := &Graph{
+		links:     map[string][]string{},
+		backlinks: map[string][]string{},
+	}
+	for _, note := range v.Notes() {
+		l := NewVaultLinker(v, note)
+		for _, link := range note.Links {
+			target := l.ResolveNote(link)
+			if target == nil || target == note || slices.Contains(g.links[note.Path], target.Path) {
+				continue
+			}
+			g.links[note.Path] = append(g.links[note.Path], target.Path)
+			g.backlinks[target.Path] = append(g.backlinks[target.Path], note.Path)
+		}
+	}
+	for _, paths := range g.backlinks {
+		slices.Sort(paths)
+	}
+	return g
+}
+
+// Paths of the notes the note at `notePath` links to, in order of appearance.
+func (g *Graph) Links(notePath string) []string {
+	return g.links[notePath]
+}
+
+// Paths of the notes linking to the note at `notePath`, sorted.
+func (g *Graph) Backlinks(notePath string) []string {
+	return g.backlinks[notePath]
+}
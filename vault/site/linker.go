@@ -0,0 +1,355 @@
+// Package site lays out a vault as a website: which URL every note, folder,
+// tag and attachment ends up at, how links between them are resolved, and how
+// the whole thing is rendered to a directory of static files.
+//
+// Site paths are slash-separated and relative to the site root. Notes, folders
+// and tags get directory-style paths, so that static builds have clean URLs:
+//
+//	dir/note.md   →  dir/note/   (dir/note/index.html in a static build)
+//	dir/          →  dir/
+//	#tag          →  tags/tag/
+//	dir/image.png →  dir/image.png
+package site
+
+import (
+	"cmp"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/flonle/mdbuddy/renderer"
+	"github.com/flonle/mdbuddy/vault"
+
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// A Linker turns link targets found on a page into URLs.
+//
+// When served, URLs are absolute and without trailing slashes ("/dir/note").
+// Static builds use relative URLs ("../dir/note/"), so that the output works
+// no matter where it's hosted.
+type Linker struct {
+	Vault *vault.Vault
+	Note  *vault.Note // The note the links appear in; nil for generated pages
+
+	// Produce URLs relative to the site path Base instead of absolute ones.
+	Relative bool
+	Base     string
+
+	// Show drafts and notes scheduled to be published later. Links to notes
+	// that can't be viewed are rendered as plain text.
+	Drafts bool
+
+	// Whether the viewer is logged in. Anonymous viewers can only view public
+	// and unlisted notes, and only public ones are listed anywhere; see
+	// vault.Visibility.
+	LoggedIn bool
+
+	// Visibility of notes that don't have one, e.g. vault.Private on a server
+	// that requires logging in. Defaults to vault.Public.
+	DefaultVisibility vault.Visibility
+
+	// Only show the note at this vault-relative path, or the notes below it
+	// if it ends in a slash ("/" for all of them). Used for share links. No
+	// restriction if empty.
+	Scope string
+
+	// Prefix for absolute URLs, e.g. "https://example.com/notes". Needed for
+	// URLs that are used outside the site, like the ones in feeds.
+	BaseURL string
+
+	// Strip the raw HTML in notes that isn't on this allowlist, for notes
+	// whose authors aren't trusted with it. See renderer.Sanitizer.
+	Sanitizer *renderer.Sanitizer
+
+	// Reuse earlier renders of notes that haven't changed, and whose links
+	// still resolve the same. See renderer.Cache.
+	RenderCache *renderer.Cache
+}
+
+// Create a linker for the links in `note`. See Linker.
+func NewLinker(v *vault.Vault, note *vault.Note, relative bool) *Linker {
+	l := &Linker{Vault: v, Note: note, Relative: relative}
+	if note != nil {
+		l.Base = NotePath(note)
+	}
+	return l
+}
+
+// Create a linker for the links in `note` that sees every note, drafts and
+// private ones included: for tools that work on the vault, like checks and
+// refactorings, rather than on what some visitor sees.
+func NewVaultLinker(v *vault.Vault, note *vault.Note) *Linker {
+	l := NewLinker(v, note, false)
+	l.Drafts = true
+	l.LoggedIn = true
+	return l
+}
+
+// Report whether `note` can be viewed at its URL, and links to it work; see
+// Linker.Drafts, Linker.LoggedIn and Linker.Scope.
+func (l *Linker) CanView(note *vault.Note) bool {
+	if note == nil || !l.InScope(note.Path) || !l.Drafts && !note.IsPublished(time.Now()) {
+		return false
+	}
+	return l.LoggedIn || l.visibility(note) != vault.Private
+}
+
+// Report whether `note` is listed on the site: in navigation, search results,
+// tag pages, backlinks, feeds and so on. Unlisted notes can be viewed, but
+// aren't listed for anonymous viewers.
+func (l *Linker) Shows(note *vault.Note) bool {
+	return l.CanView(note) && (l.LoggedIn || l.visibility(note) == vault.Public)
+}
+
+func (l *Linker) visibility(note *vault.Note) vault.Visibility {
+	return cmp.Or(l.Vault.Visibility(note), l.DefaultVisibility, vault.Public)
+}
+
+// Report whether the vault-relative `path` is within the linker's Scope.
+func (l *Linker) InScope(path string) bool {
+	switch {
+	case l.Scope == "" || l.Scope == "/":
+		return true
+	case strings.HasSuffix(l.Scope, "/"):
+		return strings.HasPrefix(path, l.Scope)
+	}
+	return path == l.Scope
+}
+
+// All notes listed on the site, sorted like vault.Vault.Notes.
+func (l *Linker) Notes() []*vault.Note {
+	return l.filter(l.Shows)
+}
+
+// All notes that can be viewed on the site, listed or not.
+func (l *Linker) ViewableNotes() []*vault.Note {
+	return l.filter(l.CanView)
+}
+
+func (l *Linker) filter(keep func(*vault.Note) bool) []*vault.Note {
+	var notes []*vault.Note
+	for _, note := range l.Vault.Notes() {
+		if keep(note) {
+			notes = append(notes, note)
+		}
+	}
+	return notes
+}
+
+// Like vault.Vault.Folder, for the notes listed on the site.
+func (l *Linker) Folder(dir string) (folders []string, notes []*vault.Note) {
+	return vault.Folder(l.Notes(), dir)
+}
+
+// Like vault.Vault.Tags, for the notes listed on the site.
+func (l *Linker) Tags() map[string][]*vault.Note {
+	return vault.Tags(l.Notes())
+}
+
+// Site path of a note.
+func NotePath(note *vault.Note) string {
+	return strings.TrimSuffix(note.Path, ".md") + "/"
+}
+
+// Site path of a folder; "" for the vault root.
+func FolderPath(dir string) string {
+	if dir = strings.Trim(dir, "/"); dir == "" || dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+// Site path of a tag's page. Tags are case-insensitive.
+func TagPath(tag string) string {
+	return "tags/" + strings.ToLower(tag) + "/"
+}
+
+// Turn a site path into a URL for the page Base.
+func (l *Linker) URL(sitePath string) string {
+	if !l.Relative {
+		return strings.TrimSuffix(l.BaseURL, "/") + (&url.URL{Path: "/" + strings.TrimSuffix(sitePath, "/")}).EscapedPath()
+	}
+
+	from := strings.Split(strings.TrimSuffix(l.Base, "/"), "/")
+	to := strings.Split(sitePath, "/")
+	if l.Base == "" {
+		from = nil
+	}
+	common := 0
+	for common < len(from) && common < len(to)-1 && from[common] == to[common] {
+		common++
+	}
+	rel := strings.Repeat("../", len(from)-common) + strings.Join(to[common:], "/")
+	if rel == "" {
+		rel = "./"
+	}
+	return (&url.URL{Path: rel}).EscapedPath()
+}
+
+func (l *Linker) RootURL() string {
+	return l.URL("")
+}
+
+func (l *Linker) NoteURL(note *vault.Note) string {
+	return l.URL(NotePath(note))
+}
+
+func (l *Linker) TagURL(tag string) string {
+	return l.URL(TagPath(tag))
+}
+
+// The folder of the note the links appear in.
+func (l *Linker) dir() string {
+	if l.Note == nil {
+		return ""
+	}
+	return path.Dir(l.Note.Path)
+}
+
+// Resolve a link to the URL it should point to. Returns false if the link
+// points to a note or attachment that doesn't exist. External links and
+// same-page fragments resolve to themselves.
+func (l *Linker) Resolve(link vault.Link) (string, bool) {
+	if link.IsExternal() {
+		return link.Target + fragment(link.Fragment), true
+	}
+	if link.Target == "" {
+		return fragment(l.headingID(l.Note, link.Fragment)), true
+	}
+
+	if note := l.ResolveNote(link); note != nil {
+		return l.NoteURL(note) + fragment(l.headingID(note, link.Fragment)), true
+	}
+	if attachment := l.ResolveAttachment(link); attachment != "" {
+		return l.URL(attachment), true
+	}
+	return "", false
+}
+
+// Find the note `link` points to, or nil. Notes that can't be viewed are
+// treated as if they didn't exist.
+func (l *Linker) ResolveNote(link vault.Link) *vault.Note {
+	if note := l.findNote(link); l.CanView(note) {
+		return note
+	}
+	return nil
+}
+
+// Report whether `link` points to a note that exists, but can't be viewed.
+func (l *Linker) IsHidden(link vault.Link) bool {
+	note := l.findNote(link)
+	return note != nil && !l.CanView(note)
+}
+
+func (l *Linker) findNote(link vault.Link) *vault.Note {
+	if link.IsExternal() || link.Target == "" {
+		return nil
+	}
+	if link.Wiki {
+		return l.Vault.Resolve(link.Target)
+	}
+
+	// Markdown links are relative to the note, or to the vault root if they
+	// start with a slash.
+	target := link.Target
+	if !strings.HasPrefix(target, "/") {
+		target = path.Join(l.dir(), target)
+	}
+	target = strings.TrimPrefix(path.Clean("/"+target), "/")
+	if vault.IsNote(target) {
+		return l.Vault.Note(target)
+	}
+	return l.Vault.Note(target + ".md")
+}
+
+// Find the vault-relative path of the attachment `link` points to, or "".
+func (l *Linker) ResolveAttachment(link vault.Link) string {
+	if link.IsExternal() || link.Target == "" || vault.IsNote(link.Target) {
+		return ""
+	}
+	if link.Wiki {
+		return l.Vault.ResolveAttachment(link.Target, l.dir())
+	}
+
+	// Same rules as for notes in ResolveNote
+	target := link.Target
+	if !strings.HasPrefix(target, "/") {
+		target = path.Join(l.dir(), target)
+	}
+	target = strings.TrimPrefix(path.Clean("/"+target), "/")
+	if !l.Vault.HasAttachment(target) {
+		return ""
+	}
+	return target
+}
+
+// Map the fragment of a link to the ID of the heading it refers to. Wikilinks
+// refer to headings by their text ([[note#My Heading]]), while the rendered
+// page uses generated IDs (#my-heading). Unknown fragments are kept as-is.
+func (l *Linker) headingID(note *vault.Note, frag string) string {
+	if note == nil || frag == "" {
+		return frag
+	}
+	for _, heading := range note.Headings {
+		if heading.ID == frag {
+			return frag
+		}
+	}
+	for _, heading := range note.Headings {
+		if strings.EqualFold(heading.Text, frag) {
+			return heading.ID
+		}
+	}
+	return frag
+}
+
+func fragment(frag string) string {
+	if frag == "" {
+		return ""
+	}
+	return "#" + frag
+}
+
+// Implements wikilink.Resolver. Links to notes that don't exist or aren't
+// shown are rendered as plain text.
+func (l *Linker) ResolveWikilink(n *wikilink.Node) ([]byte, error) {
+	dest, ok := l.Resolve(vault.Link{
+		Target:   string(n.Target),
+		Fragment: string(n.Fragment),
+		Wiki:     true,
+		Embed:    n.Embed,
+	})
+	if !ok {
+		return nil, nil
+	}
+	return []byte(dest), nil
+}
+
+// Rewrite the destination of a markdown link or image. Destinations that
+// don't resolve are left alone, and links to notes that aren't shown become
+// plain text.
+func (l *Linker) ResolveLink(destination []byte) []byte {
+	link := vault.NewLink(destination, false, 0)
+	if link.IsExternal() {
+		return destination
+	}
+	dest, ok := l.Resolve(link)
+	if !ok {
+		if l.IsHidden(link) {
+			return nil
+		}
+		return destination
+	}
+	return []byte(dest)
+}
+
+// The URL of a hashtag's tag page. There are no tag pages within a Scope, so
+// hashtags aren't linked there.
+func (l *Linker) HashtagURL(tag []byte) []byte {
+	if l.Scope != "" {
+		return nil
+	}
+	return []byte(l.TagURL(string(tag)))
+}
@@ -0,0 +1,156 @@
+package site
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/flonle/mdbuddy/renderer"
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// Name of the build manifest, stored in the output directory.
+const manifestName = ".mdbuddy-manifest.json"
+
+// Bump whenever the output for the same inputs changes in a way neither the
+// assets hash nor renderer.RenderVersion capture, e.g. a new kind of page.
+const manifestVersion = 1
+
+// The build manifest records how every output file of the last build was
+// made, so that the next build can skip everything that would come out the
+// same.
+type manifest struct {
+	Version     int               `json:"version"`
+	Assets      string            `json:"assets"`      // Hash of the embedded templates and assets
+	Notes       map[string]string `json:"notes"`       // note path : content hash
+	Attachments map[string]string `json:"attachments"` // attachment path : content hash
+	Outputs     map[string]output `json:"outputs"`     // output file : how it was made
+}
+
+type output struct {
+	// Hash over everything that went into the file: the assets, the renderer
+	// version, the content hashes of the notes it was made from, and the parts
+	// of other notes that show up on the page (titles in the sidebar,
+	// backlinks, ...).
+	Digest string `json:"digest"`
+
+	// Notes and attachments the file depends on, e.g. the note itself, the
+	// notes it links to or embeds, and the notes linking to it. The file is
+	// made again when any of them changed.
+	Deps []string `json:"deps,omitempty"`
+}
+
+func newManifest(assetsHash string) *manifest {
+	return &manifest{
+		Version:     manifestVersion,
+		Assets:      assetsHash,
+		Notes:       map[string]string{},
+		Attachments: map[string]string{},
+		Outputs:     map[string]output{},
+	}
+}
+
+// Read the manifest in `outDir`. A missing, unreadable or outdated manifest
+// results in an empty one, so that everything gets rebuilt.
+func readManifest(outDir string) *manifest {
+	m := newManifest("")
+	b, err := os.ReadFile(filepath.Join(outDir, manifestName))
+	if err != nil {
+		return m
+	}
+	if err := json.Unmarshal(b, m); err != nil || m.Version != manifestVersion {
+		return newManifest("")
+	}
+	return m
+}
+
+func (m *manifest) write(outDir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(outDir, manifestName), b)
+}
+
+// Report whether `file` can be kept as-is: it was made from the same inputs
+// last time, none of its dependencies changed since, going by `next`, and it's
+// still there.
+func (m *manifest) upToDate(next *manifest, outDir string, file string, digest string) bool {
+	if m.Outputs[file].Digest != digest {
+		return false
+	}
+	for _, dep := range m.Outputs[file].Deps {
+		if m.hash(dep) != next.hash(dep) {
+			return false
+		}
+	}
+	_, err := os.Stat(filepath.Join(outDir, filepath.FromSlash(file)))
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// The content hash of the note or attachment at `relPath`, or "" if there's
+// none.
+func (m *manifest) hash(relPath string) string {
+	if h, ok := m.Notes[relPath]; ok {
+		return h
+	}
+	return m.Attachments[relPath]
+}
+
+// Accumulates everything an output file is made from.
+type digest struct {
+	h []byte
+}
+
+func (d *digest) add(parts ...string) {
+	h := sha256.New()
+	h.Write(d.h)
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	d.h = h.Sum(nil)
+}
+
+// Add the parts of a page that don't come from the note itself.
+func (d *digest) addPage(page renderer.Page) {
+	d.add(page.Title, page.Root, page.Feed)
+	for _, link := range page.Nav {
+		d.add("nav", link.Title, link.URL, boolString(link.Current))
+	}
+	if links, ok := page.Data.([]renderer.NavLink); ok {
+		for _, link := range links {
+			d.add("data", link.Title, link.URL)
+		}
+	}
+}
+
+// Add how the links in `note` resolve, as `l` resolves them. That depends on
+// other notes: whether they exist, where they are and what their headings are
+// called.
+func (d *digest) addLinks(l *Linker, note *vault.Note) {
+	for _, link := range note.Links {
+		url, ok := l.Resolve(link)
+		d.add("link", link.Target, link.Fragment, url, boolString(ok), boolString(l.IsHidden(link)))
+	}
+}
+
+func (d *digest) String() string {
+	return hex.EncodeToString(d.h)
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
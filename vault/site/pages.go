@@ -0,0 +1,181 @@
+package site
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/flonle/mdbuddy/renderer"
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// Renderer options that resolve every kind of link through `l`.
+func (l *Linker) RenderOptions() renderer.Options {
+	return renderer.Options{
+		WikilinkResolver: l,
+		LinkResolver:     l.ResolveLink,
+		HashtagURL:       l.HashtagURL,
+		Sanitizer:        l.Sanitizer,
+	}
+}
+
+// Data for note.html, except for the note itself; see RenderNote. `l` must be
+// the linker for `note`, `backlinks` the paths of the notes linking to it.
+// Backlinks from notes the linker doesn't show are left out.
+func NotePage(l *Linker, note *vault.Note, backlinks []string) renderer.Page {
+	var backlinkNav []renderer.NavLink
+	for _, backlink := range backlinks {
+		if other := l.Vault.Note(backlink); l.Shows(other) {
+			backlinkNav = append(backlinkNav, renderer.NavLink{Title: other.Title, URL: l.NoteURL(other)})
+		}
+	}
+	return renderer.Page{
+		Title: note.Title,
+		Nav:   FolderNav(l, path.Dir(note.Path), note),
+		Root:  l.RootURL(),
+		Data:  backlinkNav,
+	}
+}
+
+// Render `note` into the Content and TOC of its page.
+func RenderNote(l *Linker, note *vault.Note, page *renderer.Page) error {
+	opts := l.RenderOptions()
+	if l.RenderCache != nil {
+		opts.Cache = l.RenderCache
+		opts.CacheKey = l.renderKey(note)
+	}
+	content, toc, err := renderer.RenderNoteContent(note.Source, opts)
+	if err != nil {
+		return fmt.Errorf("failed to render %s: %w", note.Path, err)
+	}
+	page.Content = content
+	page.TOC = toc
+	return nil
+}
+
+// Identifies everything `l` renders `note` with apart from its source: where
+// its links point, and which ones are left as plain text. See
+// renderer.Options.CacheKey.
+func (l *Linker) renderKey(note *vault.Note) string {
+	var d digest
+	d.add(boolString(l.Relative), l.Base, l.BaseURL, l.Scope)
+	d.addLinks(l, note)
+	return d.String()
+}
+
+// Data for list.html, listing the contents of the folder `dir`. Reports false
+// if the folder doesn't contain any notes.
+func FolderPage(l *Linker, dir string) (renderer.Page, bool) {
+	if dir = strings.Trim(dir, "/"); dir == "." {
+		dir = ""
+	}
+	nav := FolderNav(l, dir, nil)
+	if len(nav) == 0 {
+		return renderer.Page{}, false
+	}
+
+	title, listing := dir, nav
+	if dir == "" {
+		title = "Notes"
+	} else {
+		listing = nav[1:] // Drop the link to the parent folder
+	}
+	return renderer.Page{
+		Title: title,
+		Nav:   nav,
+		Root:  l.RootURL(),
+		Data:  listing,
+	}, true
+}
+
+// Data for list.html, listing all notes tagged `tag`. Reports false if there
+// are none.
+func TagPage(l *Linker, tag string) (renderer.Page, bool) {
+	notes := l.Tags()[strings.ToLower(tag)]
+	if len(notes) == 0 {
+		return renderer.Page{}, false
+	}
+	var links []renderer.NavLink
+	for _, note := range notes {
+		links = append(links, renderer.NavLink{Title: note.Title, URL: l.NoteURL(note)})
+	}
+	return renderer.Page{
+		Title: "#" + strings.ToLower(tag),
+		Nav:   TagsNav(l, tag),
+		Root:  l.RootURL(),
+		Data:  links,
+	}, true
+}
+
+// Data for list.html, listing all tags in the vault.
+func TagsPage(l *Linker) renderer.Page {
+	nav := TagsNav(l, "")
+	return renderer.Page{
+		Title: "Tags",
+		Nav:   nav,
+		Root:  l.RootURL(),
+		Data:  nav,
+	}
+}
+
+// Data for 404.html.
+func NotFoundPage(l *Linker) renderer.Page {
+	return renderer.Page{
+		Title: "Not found",
+		Nav:   FolderNav(l, "", nil),
+		Root:  l.RootURL(),
+	}
+}
+
+// Links to the parent folder, subfolders and notes of `dir`. The link to
+// `current` (if any) is marked as current.
+func FolderNav(l *Linker, dir string, current *vault.Note) []renderer.NavLink {
+	if dir == "." {
+		dir = ""
+	}
+	folders, notes := l.Folder(dir)
+	if len(folders) == 0 && len(notes) == 0 {
+		return nil
+	}
+
+	var nav []renderer.NavLink
+	if dir != "" {
+		nav = append(nav, renderer.NavLink{Title: "../", URL: l.URL(FolderPath(path.Dir(dir)))})
+	}
+	for _, folder := range folders {
+		nav = append(nav, renderer.NavLink{
+			Title: folder + "/",
+			URL:   l.URL(FolderPath(path.Join(dir, folder))),
+		})
+	}
+	for _, note := range notes {
+		nav = append(nav, renderer.NavLink{
+			Title:   note.Title,
+			URL:     l.NoteURL(note),
+			Current: note == current,
+		})
+	}
+	return nav
+}
+
+// Links to the pages of all tags, with the number of notes using them. The
+// link to the tag `current` (if any) is marked as current.
+func TagsNav(l *Linker, current string) []renderer.NavLink {
+	tags := l.Tags()
+	names := make([]string, 0, len(tags))
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	slices.Sort(names)
+
+	nav := make([]renderer.NavLink, 0, len(names))
+	for _, tag := range names {
+		nav = append(nav, renderer.NavLink{
+			Title:   fmt.Sprintf("#%s (%d)", tag, len(tags[tag])),
+			URL:     l.TagURL(tag),
+			Current: strings.EqualFold(tag, current),
+		})
+	}
+	return nav
+}
@@ -0,0 +1,182 @@
+package vault
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// Where a markdown link or image is in the source.
+type LinkSpan struct {
+	Whole text.Segment // From its '[' (or the '!' of an image) up to and including its ')'
+	Label text.Segment // Between the brackets
+	Dest  text.Segment // The destination, without <angle brackets>
+	Title bool         // Whether it has a title, [text](dest "title")
+}
+
+// Markup that may surround the text of a link, [*text*](dest).
+const inlineMarkup = "*_~`"
+
+// Find the markdown link or image `n` in the source. Inline nodes don't
+// record their position, so it's pieced together from that of their text.
+// Returns false for links it can't find: reference links, which have their
+// destination elsewhere, links without text, and destinations with escapes.
+func FindLink(source []byte, n ast.Node) (LinkSpan, bool) {
+	var span LinkSpan
+	var destination []byte
+	switch n := n.(type) {
+	case *ast.Link:
+		destination = n.Destination
+	case *ast.Image:
+		destination = n.Destination
+	default:
+		return span, false
+	}
+
+	start, stop := -1, -1
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := c.(*ast.Text); ok && entering {
+			if start < 0 {
+				start = t.Segment.Start
+			}
+			stop = max(stop, t.Segment.Stop)
+		}
+		return ast.WalkContinue, nil
+	})
+	if start < 0 {
+		return span, false
+	}
+
+	// [*text*](
+	open := bytes.LastIndexByte(source[:start], '[')
+	if open < 0 || strings.Trim(string(source[open+1:start]), inlineMarkup) != "" {
+		return span, false
+	}
+	if _, ok := n.(*ast.Image); ok {
+		if open == 0 || source[open-1] != '!' {
+			return span, false
+		}
+		span.Whole.Start = open - 1
+	} else {
+		span.Whole.Start = open
+	}
+	for stop < len(source) && strings.IndexByte(inlineMarkup, source[stop]) >= 0 {
+		stop++
+	}
+	if !bytes.HasPrefix(source[stop:], []byte("](")) {
+		return span, false
+	}
+	span.Label = text.NewSegment(open+1, stop)
+
+	// (<dest> "title")
+	i := skipSpace(source, stop+2)
+	if i < len(source) && source[i] == '<' {
+		end := bytes.IndexByte(source[i:], '>')
+		if end < 0 {
+			return span, false
+		}
+		span.Dest = text.NewSegment(i+1, i+end)
+		i += end + 1
+	} else {
+		depth := 0
+		j := i
+	dest:
+		for ; j < len(source); j++ {
+			switch c := source[j]; {
+			case c == '\\':
+				j++
+			case c == '(':
+				depth++
+			case c == ')' && depth == 0, c == ' ', c == '\t', c == '\n':
+				break dest
+			case c == ')':
+				depth--
+			}
+		}
+		span.Dest = text.NewSegment(i, min(j, len(source)))
+		i = j
+	}
+	if !bytes.Equal(span.Dest.Value(source), destination) {
+		return span, false
+	}
+
+	i = skipSpace(source, i)
+	if i < len(source) && source[i] != ')' {
+		closing := map[byte]byte{'"': '"', '\'': '\'', '(': ')'}[source[i]]
+		if closing == 0 {
+			return span, false
+		}
+		span.Title = true
+		for i++; i < len(source) && source[i] != closing; i++ {
+			if source[i] == '\\' {
+				i++
+			}
+		}
+		i = skipSpace(source, i+1)
+	}
+	if i >= len(source) || source[i] != ')' {
+		return span, false
+	}
+	span.Whole.Stop = i + 1
+	return span, true
+}
+
+// Skip spaces, tabs and at most one newline, as allowed around a link
+// destination.
+func skipSpace(source []byte, i int) int {
+	newline := false
+	for ; i < len(source); i++ {
+		switch source[i] {
+		case ' ', '\t':
+		case '\n':
+			if newline {
+				return i
+			}
+			newline = true
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// Where a wikilink is in the source.
+type WikilinkSpan struct {
+	Whole    text.Segment // From its "[[" (or the '!' of an embed) up to and including its "]]"
+	Target   text.Segment // The target, without the #fragment and |label
+	Fragment text.Segment // The fragment, without the '#'; empty if there's none
+}
+
+// Find the wikilink `n` in the source, from the position of its label (or
+// target, without one), which is all it records.
+func FindWikilink(source []byte, n *wikilink.Node) (WikilinkSpan, bool) {
+	var span WikilinkSpan
+	label, ok := n.FirstChild().(*ast.Text)
+	if !ok {
+		return span, false
+	}
+	open := bytes.LastIndex(source[:label.Segment.Start], []byte("[["))
+	closing := bytes.Index(source[label.Segment.Stop:], []byte("]]"))
+	if open < 0 || closing < 0 {
+		return span, false
+	}
+	span.Whole = text.NewSegment(open, label.Segment.Stop+closing+2)
+	if n.Embed {
+		span.Whole.Start--
+	}
+	span.Target = text.NewSegment(open+2, open+2+len(n.Target))
+	if !bytes.Equal(span.Target.Value(source), n.Target) {
+		return span, false
+	}
+	if n.Fragment != nil {
+		start := span.Target.Stop + 1
+		span.Fragment = text.NewSegment(start, start+len(n.Fragment))
+		if !bytes.Equal(span.Fragment.Value(source), n.Fragment) {
+			return span, false
+		}
+	}
+	return span, true
+}
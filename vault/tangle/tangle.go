@@ -0,0 +1,425 @@
+// Package tangle extracts code blocks from the notes of a vault into files,
+// for literate programming. Attributes after the language of a fenced code
+// block say where its code goes:
+//
+//	```sh file=scripts/setup.sh mode=755
+//	set -e
+//	<<install packages>>
+//	```
+//
+//	```sh name="install packages"
+//	apt-get install -y git
+//	```
+//
+// With file=path, the code goes into the file at path, relative to the folder
+// files are tangled into. The blocks for the same file are appended to one
+// another, in the order of the notes, and of the blocks in a note, unless
+// order=n says otherwise: blocks with a lower n come first, and the default is
+// 0. With mode=755, the file gets those permissions, in octal; 644 by default.
+//
+// With name=chunk, the block is a named chunk, which a line of just <<chunk>>
+// in other blocks refers to: it's replaced by the code of all blocks of that
+// name, appended the same way, at the indentation of the reference. Chunks
+// may refer to other chunks, but not to themselves.
+//
+// Values with spaces are quoted, name="two words". Blocks without a file or
+// name are left out, and so are other attributes, which editors may use.
+package tangle
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/flonle/mdbuddy/internal/atomicfile"
+	"github.com/flonle/mdbuddy/vault"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// The permissions of tangled files without a mode attribute.
+const DefaultMode fs.FileMode = 0o644
+
+// A file tangled from code blocks.
+type File struct {
+	Path    string // Slash-separated, relative to the folder files are tangled into
+	Content []byte
+	Mode    fs.FileMode
+	Sources []string // Where its blocks are, "path:line" of their opening fence, in order
+}
+
+// A code block with a file or name attribute.
+type block struct {
+	path  string // Of the note it's in
+	line  int    // Of its opening fence
+	file  string
+	name  string
+	order int
+	mode  fs.FileMode
+	code  []byte
+}
+
+func (b *block) String() string {
+	return fmt.Sprintf("%s:%d", b.path, b.line)
+}
+
+// Tangle the code blocks of the notes of `v` into files, sorted by path.
+// Fails if any block is invalid, like one with a file outside the folder it's
+// tangled into or a reference to a chunk that doesn't exist, naming them all.
+func Tangle(v *vault.Vault) ([]File, error) {
+	var errs []error
+	var blocks []*block
+	for _, note := range v.Notes() {
+		found, err := noteBlocks(note)
+		blocks = append(blocks, found...)
+		errs = append(errs, err)
+	}
+	slices.SortStableFunc(blocks, func(a, b *block) int { return cmp.Compare(a.order, b.order) })
+
+	chunks := map[string][]*block{}
+	files := map[string]*File{}
+	for _, b := range blocks {
+		if b.name != "" {
+			chunks[b.name] = append(chunks[b.name], b)
+		}
+	}
+	for _, b := range blocks {
+		if b.file == "" {
+			continue
+		}
+		f := files[b.file]
+		if f == nil {
+			f = &File{Path: b.file}
+			files[b.file] = f
+		}
+		if b.mode != 0 {
+			if f.Mode != 0 && f.Mode != b.mode {
+				errs = append(errs, fmt.Errorf("%s: mode %o conflicts with mode %o of an earlier block for %s", b, b.mode, f.Mode, b.file))
+			}
+			f.Mode = b.mode
+		}
+		code, err := expand(chunks, b, nil)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		f.Content = append(f.Content, code...)
+		f.Sources = append(f.Sources, b.String())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	tangled := make([]File, 0, len(files))
+	for _, f := range files {
+		if f.Mode == 0 {
+			f.Mode = DefaultMode
+		}
+		tangled = append(tangled, *f)
+	}
+	slices.SortFunc(tangled, func(a, b File) int { return cmp.Compare(a.Path, b.Path) })
+	return tangled, nil
+}
+
+// The code blocks of `note` with a file or name attribute, in order.
+func noteBlocks(note *vault.Note) ([]*block, error) {
+	var blocks []*block
+	var errs []error
+	ast.Walk(note.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		fenced, ok := n.(*ast.FencedCodeBlock)
+		if !entering || !ok || fenced.Info == nil {
+			return ast.WalkContinue, nil
+		}
+		attrs := attributes(fenced.Info.Segment.Value(note.Source))
+		if attrs["file"] == "" && attrs["name"] == "" {
+			return ast.WalkSkipChildren, nil
+		}
+		b, err := newBlock(note, fenced, attrs)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			blocks = append(blocks, b)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	return blocks, errors.Join(errs...)
+}
+
+// The block for the code block `fenced` in `note`, with the attributes
+// `attrs`.
+func newBlock(note *vault.Note, fenced *ast.FencedCodeBlock, attrs map[string]string) (*block, error) {
+	b := &block{path: note.Path, name: attrs["name"], code: fenced.Lines().Value(note.Source)}
+	b.line, _ = note.Position(fenced.Info.Segment.Start)
+
+	if file := attrs["file"]; file != "" {
+		b.file = path.Clean(file)
+		if !filepath.IsLocal(filepath.FromSlash(b.file)) {
+			return nil, fmt.Errorf("%s: file %q is outside the folder files are tangled into", b, file)
+		}
+	}
+	if order := attrs["order"]; order != "" {
+		var err error
+		if b.order, err = strconv.Atoi(order); err != nil {
+			return nil, fmt.Errorf("%s: order %q isn't a number", b, order)
+		}
+	}
+	if mode := attrs["mode"]; mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > 0o777 {
+			return nil, fmt.Errorf("%s: mode %q isn't permissions in octal, like 755", b, mode)
+		}
+		b.mode = fs.FileMode(perm)
+	}
+	return b, nil
+}
+
+// A key=value attribute in the info string of a code block; the value may be
+// "quoted" or 'quoted'.
+var attribute = regexp.MustCompile(`(?:^|[\s{])([\w-]+)=(?:"([^"]*)"|'([^']*)'|([^\s"'{}]+))`)
+
+// The attributes in the info string `info`.
+func attributes(info []byte) map[string]string {
+	attrs := map[string]string{}
+	for _, match := range attribute.FindAllSubmatch(info, -1) {
+		attrs[string(match[1])] = string(match[2]) + string(match[3]) + string(match[4])
+	}
+	return attrs
+}
+
+// A line that refers to a chunk: <<name>>, and whitespace.
+var reference = regexp.MustCompile(`^([ \t]*)<<([^<>]+)>>[ \t]*\r?$`)
+
+// The code of `b`, with the references to chunks replaced by their code.
+// `refs` are the chunks that are being expanded already, by the blocks that
+// led here.
+func expand(chunks map[string][]*block, b *block, refs []string) ([]byte, error) {
+	var out []byte
+	for i, line := range bytes.SplitAfter(b.code, []byte("\n")) {
+		match := reference.FindSubmatch(bytes.TrimSuffix(line, []byte("\n")))
+		if match == nil {
+			out = append(out, line...)
+			continue
+		}
+		indent, name := match[1], string(match[2])
+		if slices.Contains(refs, name) {
+			return nil, fmt.Errorf("%s:%d: chunk %q refers to itself", b.path, b.line+1+i, name)
+		}
+		if len(chunks[name]) == 0 {
+			return nil, fmt.Errorf("%s:%d: there's no chunk named %q", b.path, b.line+1+i, name)
+		}
+		for _, chunk := range chunks[name] {
+			code, err := expand(chunks, chunk, append(refs, name))
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range bytes.SplitAfter(code, []byte("\n")) {
+				if len(bytes.TrimSpace(line)) > 0 {
+					out = append(out, indent...)
+				}
+				out = append(out, line...)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Report whether the file at f.Path in `root` has the content and the
+// permissions of `f`.
+func (f File) Check(root string) (bool, error) {
+	abs := filepath.Join(root, filepath.FromSlash(f.Path))
+	info, err := os.Stat(abs)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	case err != nil:
+		return false, err
+	case info.Mode().Perm() != f.Mode:
+		return false, nil
+	}
+	return f.hasContent(abs)
+}
+
+// Report whether the file at `abs` has the content of `f`.
+func (f File) hasContent(abs string) (bool, error) {
+	current, err := os.ReadFile(abs)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return bytes.Equal(current, f.Content), err
+}
+
+// Name of the file in the folder files are tangled into that lists what was
+// tangled there, so that later runs may overwrite those files, and only
+// those. Hidden, so that vaults leave it alone.
+const manifestName = ".mdbuddy-tangled.json"
+
+// Write `files` to `root`, the folder they're tangled into, and report which
+// ones changed; see File.Write. Nothing is written if any of them would
+// overwrite a file that wasn't tangled there before, would end up outside
+// `root` through a symlink, or would be a note of the vault `v` or replace an
+// attachment of it. Those are all named in the error.
+func Write(v *vault.Vault, root string, files []File) ([]File, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	tangled := readManifest(absRoot)
+
+	var errs []error
+	for _, f := range files {
+		if err := f.checkTarget(v, absRoot, tangled); err != nil {
+			errs = append(errs, fmt.Errorf("can't tangle %s (from %s): %w", f.Path, strings.Join(f.Sources, ", "), err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	var written []File
+	for _, f := range files {
+		changed, err := f.Write(absRoot)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to write %s: %w", f.Path, err))
+			continue
+		}
+		tangled[f.Path] = true
+		if changed {
+			written = append(written, f)
+		}
+	}
+	// Files that are no longer tangled are still ours while they're there
+	for file := range tangled {
+		if _, err := os.Lstat(filepath.Join(absRoot, filepath.FromSlash(file))); errors.Is(err, fs.ErrNotExist) {
+			delete(tangled, file)
+		}
+	}
+	if err := writeManifest(absRoot, tangled); err != nil {
+		errs = append(errs, err)
+	}
+	return written, errors.Join(errs...)
+}
+
+// Check that `f` may be written to `absRoot`; see Write. `tangled` are the
+// files that were tangled there before.
+func (f File) checkTarget(v *vault.Vault, absRoot string, tangled map[string]bool) error {
+	abs := filepath.Join(absRoot, filepath.FromSlash(f.Path))
+	// The folders it's in may be symlinks to anywhere
+	dir, err := resolve(filepath.Dir(abs))
+	if err != nil {
+		return err
+	}
+	real := filepath.Join(dir, filepath.Base(abs))
+	realRoot, err := resolve(absRoot)
+	if err != nil {
+		return err
+	}
+	if !within(realRoot, real) {
+		return fmt.Errorf("it would be outside %s, at %s", absRoot, real)
+	}
+	realVault, err := resolve(v.Root)
+	if err != nil {
+		return err
+	}
+	_, inVault := v.Rel(abs)
+	inVault = inVault || within(realVault, real)
+	if inVault && vault.IsNote(abs) {
+		return fmt.Errorf("it would be a note in the vault")
+	}
+	if tangled[f.Path] {
+		return nil
+	}
+	info, err := os.Lstat(abs)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	case !info.Mode().IsRegular():
+		return fmt.Errorf("it exists, and isn't a regular file")
+	}
+	// A file that has the content already loses nothing
+	upToDate, err := f.hasContent(abs)
+	switch {
+	case err != nil:
+		return err
+	case upToDate:
+		return nil
+	case inVault:
+		return fmt.Errorf("it's a file in the vault that wasn't tangled; remove it to tangle it")
+	}
+	return fmt.Errorf("it exists, and wasn't tangled; remove it to tangle it")
+}
+
+// Write `f` to its path in `root`, creating the folders it's in, unless it
+// has the content and permissions of `f` already. Reports whether it wrote
+// anything. The file is never half-written.
+func (f File) Write(root string) (bool, error) {
+	abs := filepath.Join(root, filepath.FromSlash(f.Path))
+	upToDate, err := f.Check(root)
+	if err != nil || upToDate {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+		return false, err
+	}
+	return true, atomicfile.Write(abs, f.Content, f.Mode)
+}
+
+// Read the manifest in `root`: the paths of the files tangled there before.
+// Empty if there's none.
+func readManifest(root string) map[string]bool {
+	tangled := map[string]bool{}
+	b, err := os.ReadFile(filepath.Join(root, manifestName))
+	if err != nil {
+		return tangled
+	}
+	var files []string
+	if err := json.Unmarshal(b, &files); err != nil {
+		return tangled
+	}
+	for _, file := range files {
+		if filepath.IsLocal(filepath.FromSlash(file)) { // It may have been edited
+			tangled[path.Clean(file)] = true
+		}
+	}
+	return tangled
+}
+
+func writeManifest(root string, tangled map[string]bool) error {
+	b, err := json.MarshalIndent(slices.Sorted(maps.Keys(tangled)), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.Write(filepath.Join(root, manifestName), append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to record the tangled files: %w", err)
+	}
+	return nil
+}
+
+// `p` with its symlinks resolved. Of the folders that don't exist yet, which
+// are created as real folders, only the names are added.
+func resolve(p string) (string, error) {
+	real, err := filepath.EvalSymlinks(p)
+	if errors.Is(err, fs.ErrNotExist) && filepath.Dir(p) != p {
+		dir, err := resolve(filepath.Dir(p))
+		return filepath.Join(dir, filepath.Base(p)), err
+	}
+	return real, err
+}
+
+// Report whether the path `p` is in the folder `dir`, or is `dir`.
+func within(dir, p string) bool {
+	relPath, err := filepath.Rel(dir, p)
+	return err == nil && (relPath == "." || filepath.IsLocal(relPath))
+}
@@ -0,0 +1,257 @@
+package tangle
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/flonle/mdbuddy/vault"
+)
+
+// A vault in a temporary folder with the notes in `notes`, by path : source.
+func newTestVault(t *testing.T, notes map[string]string) *vault.Vault {
+	t.Helper()
+	root := t.TempDir()
+	for relPath, source := range notes {
+		writeFile(t, filepath.Join(root, filepath.FromSlash(relPath)), source)
+	}
+	v, err := vault.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const setupNote = "# Setup\n\n" +
+	"```sh file=bin/setup.sh mode=755\nset -e\n<<install>>\n```\n\n" +
+	"```sh name=install\napt-get install -y git\n```\n\n" +
+	"```sh file=bin/setup.sh order=-1\n#!/bin/sh\n```\n\n" +
+	"```sh name=\"two words\"\nignored, as nothing refers to it\n```\n\n" +
+	"```sh\nleft out\n```\n"
+
+func TestTangle(t *testing.T) {
+	v := newTestVault(t, map[string]string{
+		"setup.md": setupNote,
+		"more.md": "# More\n\n```sh name=install\nif true; then\n  <<nested>>\nfi\n```\n\n" +
+			"```sh name=nested\necho one\n\necho two\n```\n\n" +
+			"```ini file='config/a b.ini' {.ini}\n[section]\n```\n",
+	})
+	files, err := Tangle(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []File{
+		{"bin/setup.sh", []byte("#!/bin/sh\nset -e\nif true; then\n  echo one\n\n  echo two\nfi\napt-get install -y git\n"),
+			0o755, []string{"setup.md:12", "setup.md:3"}},
+		{"config/a b.ini", []byte("[section]\n"), DefaultMode, []string{"more.md:15"}},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("got %d files, want %d: %+v", len(files), len(want), files)
+	}
+	for i, f := range files {
+		if f.Path != want[i].Path || string(f.Content) != string(want[i].Content) ||
+			f.Mode != want[i].Mode || !slices.Equal(f.Sources, want[i].Sources) {
+			t.Errorf("got file\n%+v\nwith content\n%s\nwant\n%+v\nwith content\n%s", f, f.Content, want[i], want[i].Content)
+		}
+	}
+}
+
+func TestTangleErrors(t *testing.T) {
+	tests := []struct {
+		name, source, want string
+	}{
+		{"outside", "```sh file=../escape.sh\n```\n", `file "../escape.sh" is outside`},
+		{"absolute", "```sh file=/etc/passwd\n```\n", `file "/etc/passwd" is outside`},
+		{"order", "```sh file=a.sh order=first\n```\n", `order "first" isn't a number`},
+		{"mode", "```sh file=a.sh mode=999\n```\n", `mode "999" isn't permissions`},
+		{"conflicting modes", "```sh file=a.sh mode=755\n```\n\n```sh file=a.sh mode=700\n```\n", "mode 700 conflicts with mode 755"},
+		{"missing chunk", "```sh file=a.sh\n<<missing>>\n```\n", `note.md:2: there's no chunk named "missing"`},
+		{"recursive chunk", "```sh file=a.sh\n<<loop>>\n```\n\n```sh name=loop\n<<loop>>\n```\n", `chunk "loop" refers to itself`},
+	}
+	for _, tt := range tests {
+		_, err := Tangle(newTestVault(t, map[string]string{"note.md": tt.source}))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// The paths of `files`.
+func paths(files []File) []string {
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+func TestWrite(t *testing.T) {
+	v := newTestVault(t, map[string]string{"setup.md": setupNote})
+	files, err := Tangle(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+
+	written, err := Write(v, out, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(written); !slices.Equal(got, []string{"bin/setup.sh"}) {
+		t.Errorf("got written files %q, want bin/setup.sh", got)
+	}
+	info, err := os.Stat(filepath.Join(out, "bin", "setup.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Errorf("got permissions %o, want 755", info.Mode().Perm())
+	}
+	if tangled := readManifest(out); !tangled["bin/setup.sh"] || len(tangled) != 1 {
+		t.Errorf("got tangled files %v in the manifest, want bin/setup.sh", tangled)
+	}
+
+	// Unchanged files aren't written again
+	if written, err := Write(v, out, files); err != nil || len(written) != 0 {
+		t.Errorf("writing again: got written files %q and error %v, want none", paths(written), err)
+	}
+
+	// Files tangled before are overwritten, permissions included
+	writeFile(t, filepath.Join(out, "bin", "setup.sh"), "edited\n")
+	if written, err := Write(v, out, files); err != nil || len(written) != 1 {
+		t.Errorf("writing over an edited file: got written files %q and error %v", paths(written), err)
+	}
+	if ok, err := files[0].Check(out); !ok || err != nil {
+		t.Errorf("got check %t and error %v after writing over an edited file", ok, err)
+	}
+}
+
+func TestWriteThroughSymlink(t *testing.T) {
+	v := newTestVault(t, map[string]string{"note.md": "# Note\n"})
+	out := t.TempDir()
+	if err := os.Mkdir(filepath.Join(out, "real"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// A symlink that stays in the output folder is fine
+	if err := os.Symlink("real", filepath.Join(out, "link")); err != nil {
+		t.Fatal(err)
+	}
+	files := []File{{Path: "link/a.sh", Content: []byte("tangled\n"), Mode: DefaultMode}}
+	if _, err := Write(v, out, files); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "real", "a.sh")); err != nil || string(b) != "tangled\n" {
+		t.Errorf("got %q (%v) through the symlink", b, err)
+	}
+}
+
+func TestWriteRefuses(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		setup func(t *testing.T, v *vault.Vault, out string)
+		want  string
+	}{
+		{"untangled file", "a.sh", func(t *testing.T, v *vault.Vault, out string) {
+			writeFile(t, filepath.Join(out, "a.sh"), "someone else's\n")
+		}, "wasn't tangled"},
+		{"folder", "a.sh", func(t *testing.T, v *vault.Vault, out string) {
+			if err := os.Mkdir(filepath.Join(out, "a.sh"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}, "isn't a regular file"},
+		{"symlink to a folder outside", "link/a.sh", func(t *testing.T, v *vault.Vault, out string) {
+			if err := os.Symlink(t.TempDir(), filepath.Join(out, "link")); err != nil {
+				t.Fatal(err)
+			}
+		}, "would be outside"},
+		{"symlink to the vault", "link/note.md", func(t *testing.T, v *vault.Vault, out string) {
+			if err := os.Symlink(v.Root, filepath.Join(out, "link")); err != nil {
+				t.Fatal(err)
+			}
+		}, "would be outside"},
+	}
+	for _, tt := range tests {
+		v := newTestVault(t, map[string]string{"note.md": "# Note\n"})
+		out := t.TempDir()
+		tt.setup(t, v, out)
+		files := []File{{Path: tt.file, Content: []byte("tangled\n"), Mode: DefaultMode, Sources: []string{"note.md:3"}}}
+
+		_, err := Write(v, out, files)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.want)
+		}
+		if b, err := os.ReadFile(filepath.Join(v.Root, "note.md")); err != nil || string(b) != "# Note\n" {
+			t.Errorf("%s: the note is now %q (%v)", tt.name, b, err)
+		}
+		if _, err := os.Stat(filepath.Join(out, manifestName)); !os.IsNotExist(err) {
+			t.Errorf("%s: wrote a manifest, though nothing was tangled", tt.name)
+		}
+	}
+}
+
+func TestWriteIntoVault(t *testing.T) {
+	v := newTestVault(t, map[string]string{"note.md": "# Note\n", "image.png": "an attachment"})
+	files := []File{
+		{Path: "note.md", Content: []byte("tangled\n"), Mode: DefaultMode, Sources: []string{"a.md:1"}},
+		{Path: "image.png", Content: []byte("tangled\n"), Mode: DefaultMode, Sources: []string{"a.md:5"}},
+		{Path: "new/other.md", Content: []byte("tangled\n"), Mode: DefaultMode, Sources: []string{"a.md:9"}},
+	}
+	_, err := Write(v, v.Root, files)
+	if err == nil {
+		t.Fatal("got no error tangling over notes and attachments")
+	}
+	for _, want := range []string{
+		"note.md (from a.md:1): it would be a note",
+		"image.png (from a.md:5): it's a file in the vault",
+		"new/other.md (from a.md:9): it would be a note",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error\n%v\nwant it to contain %q", err, want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	out := t.TempDir()
+	f := File{Path: "dir/a.sh", Content: []byte("echo a\n"), Mode: 0o755}
+
+	tests := []struct {
+		name    string
+		content string
+		mode    fs.FileMode
+		want    bool
+	}{
+		{"missing", "", 0, false},
+		{"same", "echo a\n", 0o755, true},
+		{"other content", "echo b\n", 0o755, false},
+		{"other mode", "echo a\n", 0o644, false},
+	}
+	for _, tt := range tests {
+		abs := filepath.Join(out, "dir", "a.sh")
+		os.Remove(abs)
+		if tt.mode != 0 {
+			writeFile(t, abs, tt.content)
+			if err := os.Chmod(abs, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, err := f.Check(out); got != tt.want || err != nil {
+			t.Errorf("%s: got %t and error %v, want %t", tt.name, got, err, tt.want)
+		}
+	}
+}
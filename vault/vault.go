@@ -0,0 +1,385 @@
+package vault
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// A vault is a directory tree of markdown notes. Notes are identified by
+// their slash-separated path relative to the vault root, e.g. "dir/note.md".
+//
+// Like the watcher, the vault ignores directories that start with '.'
+type Vault struct {
+	Root        string              // Absolute path to the vault directory
+	notes       map[string]*Note    // relative path : note
+	byName      map[string][]*Note  // lowercase filename without .md : notes
+	aliases     map[string][]*Note  // lowercase alias : notes, see Note.Aliases
+	attachments map[string][]string // lowercase filename : relative paths of non-note files
+	notesMx     sync.RWMutex        // Protects notes, byName, aliases and attachments
+	history     func() history      // Git history of the vault, read on first use
+	configs     map[string]Config   // folder : merged configuration, see Config
+	configsMx   sync.Mutex          // Protects configs
+
+	// Reads the vault-relative `relPath` for what isn't a note, like config
+	// files: from disk, or from the git index for staged vaults.
+	readFile func(relPath string) ([]byte, error)
+}
+
+// Open the vault at `root` and load every markdown note in it.
+func Open(root string) (*Vault, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for %s: %v", root, err)
+	}
+	info, err := os.Stat(absRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vault: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("vault is not a directory: %s", root)
+	}
+
+	v := newVault(absRoot)
+	v.history = sync.OnceValue(func() history { return readHistory(absRoot) })
+	v.readFile = func(relPath string) ([]byte, error) { return os.ReadFile(v.Abs(relPath)) }
+	err = v.Walk(func(relPath string, d fs.DirEntry) error {
+		if !IsNote(relPath) {
+			v.AddAttachment(relPath)
+			return nil
+		}
+		_, err := v.Load(relPath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func newVault(absRoot string) *Vault {
+	return &Vault{
+		Root:        absRoot,
+		notes:       map[string]*Note{},
+		byName:      map[string][]*Note{},
+		aliases:     map[string][]*Note{},
+		attachments: map[string][]string{},
+		configs:     map[string]Config{},
+	}
+}
+
+// Call `fn` for every file in the vault, in lexical order, skipping files and
+// directories that start with '.'. The path handed to `fn` is relative to the
+// vault root and slash-separated.
+func (v *Vault) Walk(fn func(relPath string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(v.Root, func(absPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if absPath != v.Root && len(name) > 0 && name[0] == '.' {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(v.Root, absPath)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(relPath), d)
+	})
+}
+
+// Report whether the file at `p` is a markdown note, judging by its name.
+func IsNote(p string) bool {
+	return strings.HasSuffix(p, ".md")
+}
+
+// Return the vault-relative, slash-separated version of `absPath`, and
+// whether that path lies inside the vault at all.
+func (v *Vault) Rel(absPath string) (string, bool) {
+	relPath, err := filepath.Rel(v.Root, absPath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(relPath), true
+}
+
+// Return the absolute filesystem path of the vault-relative `relPath`.
+func (v *Vault) Abs(relPath string) string {
+	return filepath.Join(v.Root, filepath.FromSlash(relPath))
+}
+
+// (Re)read and parse the note at `relPath`, replacing any previous version.
+func (v *Vault) Load(relPath string) (*Note, error) {
+	absPath := v.Abs(relPath)
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", relPath, err)
+	}
+	source, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", relPath, err)
+	}
+
+	note := NewNote(relPath, source, info.ModTime())
+	v.Add(note)
+	return note, nil
+}
+
+// Add `note`, replacing any previous version at its path. Doesn't touch the
+// disk; see Load for that.
+func (v *Vault) Add(note *Note) {
+	v.notesMx.Lock()
+	defer v.notesMx.Unlock()
+	v.remove(note.Path)
+	v.notes[note.Path] = note
+	key := strings.ToLower(note.Name())
+	v.byName[key] = append(v.byName[key], note)
+	slices.SortFunc(v.byName[key], compareNotes)
+	for _, alias := range note.Aliases {
+		key := strings.ToLower(alias)
+		v.aliases[key] = append(v.aliases[key], note)
+		slices.SortFunc(v.aliases[key], compareNotes)
+	}
+}
+
+// Register the non-note file at `relPath`, so that links to it resolve.
+func (v *Vault) AddAttachment(relPath string) {
+	key := strings.ToLower(path.Base(relPath))
+
+	v.notesMx.Lock()
+	defer v.notesMx.Unlock()
+	if !slices.Contains(v.attachments[key], relPath) {
+		v.attachments[key] = append(v.attachments[key], relPath)
+		slices.SortFunc(v.attachments[key], comparePaths)
+	}
+}
+
+// Forget the note or attachment at `relPath`. Does nothing if there is no
+// such file.
+func (v *Vault) Remove(relPath string) {
+	v.notesMx.Lock()
+	defer v.notesMx.Unlock()
+	v.remove(relPath)
+}
+
+// Caller must hold notesMx.
+func (v *Vault) remove(relPath string) {
+	note, ok := v.notes[relPath]
+	if !ok {
+		key := strings.ToLower(path.Base(relPath))
+		v.attachments[key] = slices.DeleteFunc(v.attachments[key], func(p string) bool { return p == relPath })
+		if len(v.attachments[key]) == 0 {
+			delete(v.attachments, key)
+		}
+		return
+	}
+	delete(v.notes, relPath)
+	key := strings.ToLower(note.Name())
+	v.byName[key] = slices.DeleteFunc(v.byName[key], func(n *Note) bool { return n == note })
+	if len(v.byName[key]) == 0 {
+		delete(v.byName, key)
+	}
+	for _, alias := range note.Aliases {
+		key := strings.ToLower(alias)
+		v.aliases[key] = slices.DeleteFunc(v.aliases[key], func(n *Note) bool { return n == note })
+		if len(v.aliases[key]) == 0 {
+			delete(v.aliases, key)
+		}
+	}
+}
+
+// Return the note at `relPath`, or nil.
+func (v *Vault) Note(relPath string) *Note {
+	v.notesMx.RLock()
+	defer v.notesMx.RUnlock()
+	return v.notes[relPath]
+}
+
+// Return all notes in the vault, sorted by path.
+func (v *Vault) Notes() []*Note {
+	v.notesMx.RLock()
+	notes := make([]*Note, 0, len(v.notes))
+	for _, note := range v.notes {
+		notes = append(notes, note)
+	}
+	v.notesMx.RUnlock()
+
+	slices.SortFunc(notes, func(a, b *Note) int { return strings.Compare(a.Path, b.Path) })
+	return notes
+}
+
+// Return all notes with the given filename (without the .md extension),
+// compared case-insensitively. Filenames are supposed to be unique within a
+// vault, so anything longer than one element is a rule violation.
+func (v *Vault) NotesNamed(name string) []*Note {
+	v.notesMx.RLock()
+	defer v.notesMx.RUnlock()
+	return slices.Clone(v.byName[strings.ToLower(name)])
+}
+
+// Find the note a link target like "note", "note.md" or "dir/note" refers to.
+// Returns nil if there's no such note.
+//
+// Targets containing a slash are looked up by path, everything else by
+// filename, and then by alias (see Note.Aliases), so that links to a note
+// that was renamed keep working. If a filename is ambiguous (which todo.md
+// forbids), the note with the shortest path wins, so that the outcome at
+// least is deterministic.
+func (v *Vault) Resolve(target string) *Note {
+	target = strings.TrimPrefix(path.Clean("/"+target), "/")
+	if !IsNote(target) {
+		target += ".md"
+	}
+
+	v.notesMx.RLock()
+	defer v.notesMx.RUnlock()
+
+	if note, ok := v.notes[target]; ok {
+		return note
+	}
+	if strings.Contains(target, "/") {
+		return nil
+	}
+	name := strings.ToLower(strings.TrimSuffix(target, ".md"))
+	if candidates := v.byName[name]; len(candidates) > 0 {
+		return candidates[0]
+	}
+	if candidates := v.aliases[name]; len(candidates) > 0 {
+		return candidates[0]
+	}
+	return nil
+}
+
+// Find the attachment a link target like "image.png" or "../img/image.png"
+// refers to, and return its vault-relative path. `dir` is the folder of the
+// linking note, against which relative targets are resolved first. Returns ""
+// if there's no such attachment.
+//
+// Like with Resolve, bare filenames are looked up anywhere in the vault.
+func (v *Vault) ResolveAttachment(target string, dir string) string {
+	candidates := []string{
+		strings.TrimPrefix(path.Join("/", dir, target), "/"),
+		strings.TrimPrefix(path.Clean("/"+target), "/"),
+	}
+
+	v.notesMx.RLock()
+	defer v.notesMx.RUnlock()
+
+	for _, candidate := range candidates {
+		if slices.Contains(v.attachments[strings.ToLower(path.Base(candidate))], candidate) {
+			return candidate
+		}
+	}
+	if !strings.Contains(target, "/") {
+		if matches := v.attachments[strings.ToLower(target)]; len(matches) > 0 {
+			return matches[0]
+		}
+	}
+	return ""
+}
+
+// Return the paths of all notes and attachments below the folder `dir` (""
+// for all of them), sorted.
+func (v *Vault) Files(dir string) []string {
+	prefix := strings.Trim(dir, "/") + "/"
+	if prefix == "/" {
+		prefix = ""
+	}
+
+	v.notesMx.RLock()
+	defer v.notesMx.RUnlock()
+	var files []string
+	for relPath := range v.notes {
+		if strings.HasPrefix(relPath, prefix) {
+			files = append(files, relPath)
+		}
+	}
+	for _, paths := range v.attachments {
+		for _, relPath := range paths {
+			if strings.HasPrefix(relPath, prefix) {
+				files = append(files, relPath)
+			}
+		}
+	}
+	slices.Sort(files)
+	return files
+}
+
+// Report whether there's an attachment at the vault-relative `relPath`.
+func (v *Vault) HasAttachment(relPath string) bool {
+	v.notesMx.RLock()
+	defer v.notesMx.RUnlock()
+	return slices.Contains(v.attachments[strings.ToLower(path.Base(relPath))], relPath)
+}
+
+// Return every tag used in the vault, lowercased, mapped to the notes using
+// it. Notes are sorted by path.
+func (v *Vault) Tags() map[string][]*Note {
+	return Tags(v.Notes())
+}
+
+// Like Vault.Tags, for a subset of the notes in a vault.
+func Tags(notes []*Note) map[string][]*Note {
+	tags := map[string][]*Note{}
+	for _, note := range notes {
+		for _, tag := range note.Tags {
+			tag = strings.ToLower(tag)
+			if !slices.Contains(tags[tag], note) {
+				tags[tag] = append(tags[tag], note)
+			}
+		}
+	}
+	return tags
+}
+
+func compareNotes(a, b *Note) int {
+	return comparePaths(a.Path, b.Path)
+}
+
+// Shortest path first, then lexical.
+func comparePaths(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+// List the direct contents of the folder `dir` ("" for the vault root): the
+// names of subfolders that contain notes, and the notes themselves.
+func (v *Vault) Folder(dir string) (folders []string, notes []*Note) {
+	return Folder(v.Notes(), dir)
+}
+
+// Like Vault.Folder, for a subset of the notes in a vault. `all` must be
+// sorted like Vault.Notes.
+func Folder(all []*Note, dir string) (folders []string, notes []*Note) {
+	prefix := ""
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		prefix = dir + "/"
+	}
+	for _, note := range all {
+		rest, ok := strings.CutPrefix(note.Path, prefix)
+		if !ok {
+			continue
+		}
+		if folder, _, nested := strings.Cut(rest, "/"); nested {
+			if !slices.Contains(folders, folder) {
+				folders = append(folders, folder)
+			}
+		} else {
+			notes = append(notes, note)
+		}
+	}
+	return folders, notes
+}